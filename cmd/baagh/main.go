@@ -2,14 +2,26 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"fmt"
 	"log"
+	"math"
 	"os"
+	"time"
 
 	"github.com/warthog618/gpiod"
 
 	"github.com/AliRostami1/baagh/internal/application"
+	"github.com/AliRostami1/baagh/pkg/config"
+	"github.com/AliRostami1/baagh/pkg/controller/access"
 	"github.com/AliRostami1/baagh/pkg/controller/core"
 	"github.com/AliRostami1/baagh/pkg/controller/general"
+	"github.com/AliRostami1/baagh/pkg/controller/history"
+	"github.com/AliRostami1/baagh/pkg/controller/input"
+	"github.com/AliRostami1/baagh/pkg/controller/scheduler"
+	"github.com/AliRostami1/baagh/pkg/controller/store"
+	"github.com/AliRostami1/baagh/pkg/controller/vacation"
+	"github.com/AliRostami1/baagh/pkg/logy"
 )
 
 func main() {
@@ -19,20 +31,126 @@ func main() {
 	}
 	defer app.Cleanup()
 
-	chipName := gpiod.Chips()[0]
-
+	db := store.New(app.DB)
 	core.SetLogger(app.Log)
-	_, err = core.RegisterChip(app.Ctx, core.WithName(chipName), core.WithConsumer("baagh"))
+	core.SetStore(db)
+	general.SetLogger(app.Log)
+	general.SetStore(db)
+	scheduler.SetLogger(app.Log)
+	history.SetLogger(app.Log)
+	vacation.SetLogger(app.Log)
+	input.SetLogger(app.Log)
+	access.SetLogger(app.Log)
+	chipName, err := registerChips(app.Ctx, app.Config)
 	if err != nil {
 		app.Log.Fatal(err)
 	}
 	defer core.Cleanup()
 
+	hist := history.New(app.Ctx, db, 28*24*time.Hour)
+	core.Subscribe(hist.Record)
+
+	err = registerExpanders(app.Ctx, app.Config, chipName)
+	if err != nil {
+		app.Log.Fatal(err)
+	}
+
+	err = registerShiftRegisters(app.Config, chipName)
+	if err != nil {
+		app.Log.Fatal(err)
+	}
+
+	err = registerInterlocks(app.Config, chipName)
+	if err != nil {
+		app.Log.Fatal(err)
+	}
+
 	alarm, err := general.Register("security-system", general.AsAlarm(), general.WithConfig(chipName, []int{9}, []int{10}))
 	if err != nil {
 		return
 	}
 
+	err = registerVirtuals(app.Config)
+	if err != nil {
+		app.Log.Fatal(err)
+	}
+
+	err = registerPWMs(app.Config, chipName)
+	if err != nil {
+		app.Log.Fatal(err)
+	}
+
+	err = registerEncoders(app.Config, chipName, app.Log)
+	if err != nil {
+		app.Log.Fatal(err)
+	}
+
+	err = registerBlinkers(app.Config, chipName)
+	if err != nil {
+		app.Log.Fatal(err)
+	}
+
+	err = registerMeters(app.Ctx, app.Config, chipName)
+	if err != nil {
+		app.Log.Fatal(err)
+	}
+
+	err = registerDistanceSensors(app.Ctx, app.Config, chipName)
+	if err != nil {
+		app.Log.Fatal(err)
+	}
+
+	err = registerGenerals(app.Config, chipName)
+	if err != nil {
+		app.Log.Fatal(err)
+	}
+
+	err = registerCounters(app.Config, chipName)
+	if err != nil {
+		app.Log.Fatal(err)
+	}
+
+	schedOpts := []scheduler.Option{scheduler.WithStore(db)}
+	site, err := app.Config.Site()
+	if err != nil {
+		app.Log.Fatal(err)
+	}
+	if site != nil {
+		loc, err := time.LoadLocation(site.Timezone)
+		if err != nil {
+			app.Log.Fatal(err)
+		}
+		schedOpts = append(schedOpts, scheduler.WithSite(site.Latitude, site.Longitude, loc))
+	}
+	schedules, err := declaredSchedules(app.Config)
+	if err != nil {
+		app.Log.Fatal(err)
+	}
+	schedOpts = append(schedOpts, scheduler.WithSchedules(schedules...))
+	_, err = scheduler.New(app.Ctx, schedOpts...)
+	if err != nil {
+		app.Log.Fatal(err)
+	}
+
+	err = startVacation(app.Ctx, app.Config, hist, chipName)
+	if err != nil {
+		app.Log.Fatal(err)
+	}
+
+	a, err := access.New(db)
+	if err != nil {
+		app.Log.Fatal(err)
+	}
+	err = startAccess(app.Ctx, app.Config, a, chipName)
+	if err != nil {
+		app.Log.Fatal(err)
+	}
+
+	err = startRemotes(app.Config, chipName, app.Log)
+	if err != nil {
+		app.Log.Fatal(err)
+	}
+
 	go func() {
 		input := bufio.NewScanner(os.Stdin)
 		for input.Scan() {
@@ -45,3 +163,641 @@ func main() {
 	<-app.Ctx.Done()
 
 }
+
+// registerChips registers the chips declared in the config, or the first
+// chip of the device if there are none, and returns the name of the first
+// one. it's the chip lines are on when the config doesn't name one
+func registerChips(ctx context.Context, c *config.Config) (string, error) {
+	chips, err := c.Chips()
+	if err != nil {
+		return "", err
+	}
+	if len(chips) == 0 {
+		devices := gpiod.Chips()
+		if len(devices) == 0 {
+			return "", fmt.Errorf("there is no gpio chip on this device, declare one under chips")
+		}
+		chips = []config.Chip{{Name: devices[0]}}
+	}
+	for _, chip := range chips {
+		opts := []core.ChipOption{core.WithName(chip.Name), core.WithConsumer("baagh")}
+		switch chip.Backend {
+		case "", "gpiod":
+		case "sysfs":
+			if chip.Root != "" {
+				opts = append(opts, core.WithSysfsRoot(chip.Root))
+			} else {
+				opts = append(opts, core.AsSysfs())
+			}
+		default:
+			return "", fmt.Errorf("chip %s can't use the %q backend", chip.Name, chip.Backend)
+		}
+		if _, err = core.RegisterChip(ctx, opts...); err != nil {
+			return "", fmt.Errorf("couldn't register chip %s: %w", chip.Name, err)
+		}
+	}
+	return chips[0].Name, nil
+}
+
+// registerExpanders registers the I2C gpio expanders declared in the config
+// as chips of their own, they come first so anything can use their lines
+func registerExpanders(ctx context.Context, c *config.Config, defaultChip string) error {
+	expanders, err := c.Expanders()
+	if err != nil {
+		return err
+	}
+	for _, e := range expanders {
+		opts := []core.ChipOption{core.WithName(e.Name), core.WithConsumer("baagh")}
+		switch e.Kind {
+		case core.MCP23017:
+			opts = append(opts, core.AsMCP23017(e.Bus, e.Address))
+		case core.PCF8574:
+			opts = append(opts, core.AsPCF8574(e.Bus, e.Address))
+		default:
+			return fmt.Errorf("expander %s can't be a %q", e.Name, e.Kind)
+		}
+		if e.Interrupt != nil {
+			if e.Interrupt.Chip == "" {
+				e.Interrupt.Chip = defaultChip
+			}
+			opts = append(opts, core.WithInterrupt(e.Interrupt.Chip, e.Interrupt.Offset))
+		}
+		if e.Polling != 0 {
+			opts = append(opts, core.WithPolling(e.Polling))
+		}
+		if _, err = core.RegisterChip(ctx, opts...); err != nil {
+			return fmt.Errorf("couldn't register expander %s: %w", e.Name, err)
+		}
+	}
+	return nil
+}
+
+// registerShiftRegisters registers the shift registers declared in the
+// config as chips of their own, everything after them can use their outputs
+func registerShiftRegisters(c *config.Config, defaultChip string) error {
+	registers, err := c.ShiftRegisters()
+	if err != nil {
+		return err
+	}
+	for _, r := range registers {
+		if r.Chip == "" {
+			r.Chip = defaultChip
+		}
+		if r.Count == 0 {
+			r.Count = 1
+		}
+		if _, err = core.RegisterShiftRegister(r.Name, r.Chip, r.Data, r.Clock, r.Latch, r.Count); err != nil {
+			return fmt.Errorf("couldn't register shift register %s: %w", r.Name, err)
+		}
+	}
+	return nil
+}
+
+// registerInterlocks registers the interlocks declared in the config, they
+// have to be registered before any general gets to drive their outputs
+func registerInterlocks(c *config.Config, defaultChip string) error {
+	interlocks, err := c.Interlocks()
+	if err != nil {
+		return err
+	}
+	for _, il := range interlocks {
+		opts := []core.InterlockOption{core.AsRefusing(il.DeadTime)}
+		if il.Policy == core.Sequence {
+			opts = []core.InterlockOption{core.AsSequencing(il.DeadTime)}
+		} else if il.Policy != "" && il.Policy != core.Refuse {
+			return fmt.Errorf("interlock %s has unknown policy %s", il.Name, il.Policy)
+		}
+		for _, l := range il.Lines {
+			if l.Chip == "" {
+				l.Chip = defaultChip
+			}
+			opts = append(opts, core.WithLines(l.Chip, l.Offset))
+		}
+		if _, err = core.RegisterInterlock(il.Name, opts...); err != nil {
+			return fmt.Errorf("couldn't register interlock %s: %w", il.Name, err)
+		}
+	}
+	return nil
+}
+
+// registerCounters registers the pulse counters declared in the config,
+// their totals are saved by core.Cleanup
+func registerCounters(c *config.Config, defaultChip string) error {
+	counters, err := c.Counters()
+	if err != nil {
+		return err
+	}
+	for _, cnt := range counters {
+		if cnt.Chip == "" {
+			cnt.Chip = defaultChip
+		}
+		opts := []core.CounterOption{}
+		if cnt.Pulses != 0 {
+			opts = append(opts, core.WithScale(cnt.Pulses, cnt.Unit))
+		}
+		if cnt.Debounce != 0 || cnt.Timeout != 0 {
+			opts = append(opts, core.WithTiming(cnt.Debounce, cnt.Timeout))
+		}
+		if _, err = core.RegisterCounter(cnt.Name, cnt.Chip, cnt.Offset, opts...); err != nil {
+			return fmt.Errorf("couldn't register counter %s: %w", cnt.Name, err)
+		}
+	}
+	return nil
+}
+
+// registerPWMs registers the pwm outputs declared in the config, they're
+// turned off by core.Cleanup
+func registerPWMs(c *config.Config, defaultChip string) error {
+	pwms, err := c.PWMs()
+	if err != nil {
+		return err
+	}
+	for _, p := range pwms {
+		if p.Chip == "" {
+			p.Chip = defaultChip
+		}
+		opts := []core.PWMOption{core.WithDuty(p.Duty)}
+		if p.Frequency != 0 {
+			opts = append(opts, core.WithFrequency(p.Frequency))
+		}
+		if _, err = core.RegisterPWM(p.Name, p.Chip, p.Offset, opts...); err != nil {
+			return fmt.Errorf("couldn't register pwm %s: %w", p.Name, err)
+		}
+	}
+	return nil
+}
+
+// registerEncoders registers the rotary encoders declared in the config,
+// the ones with a pwm output dim it and failing to is logged to l
+func registerEncoders(c *config.Config, defaultChip string, l logy.Logger) error {
+	encoders, err := c.Encoders()
+	if err != nil {
+		return err
+	}
+	for _, enc := range encoders {
+		if enc.Chip == "" {
+			enc.Chip = defaultChip
+		}
+		opts := []core.EncoderOption{}
+		if enc.StepsPerDetent != 0 {
+			opts = append(opts, core.WithStepsPerDetent(enc.StepsPerDetent))
+		}
+		if enc.Threshold != 0 {
+			opts = append(opts, core.WithAcceleration(enc.Threshold, enc.Acceleration))
+		}
+		var pwm *core.PWM
+		if enc.PWM != "" {
+			if pwm, err = core.GetPWM(enc.PWM); err != nil {
+				return fmt.Errorf("encoder %s: %w", enc.Name, err)
+			}
+			if enc.Step == 0 {
+				enc.Step = 5
+			}
+		}
+		e, err := core.RegisterEncoder(enc.Name, enc.Chip, enc.A, enc.B, opts...)
+		if err != nil {
+			return fmt.Errorf("couldn't register encoder %s: %w", enc.Name, err)
+		}
+		if pwm == nil {
+			continue
+		}
+		step := enc.Step
+		e.AddEventListener(func(event *core.EncoderEvent) {
+			duty := math.Max(0, math.Min(100, pwm.Duty()+float64(event.Steps)*step))
+			if err := pwm.SetDuty(duty); err != nil {
+				l.Errorf("encoder %s: %v", event.Encoder.Name(), err)
+			}
+		})
+	}
+	return nil
+}
+
+// registerBlinkers registers the custom patterns and then the blinkers
+// declared in the config, generals refer to both by name
+func registerBlinkers(c *config.Config, defaultChip string) error {
+	patterns, err := c.Patterns()
+	if err != nil {
+		return err
+	}
+	for _, p := range patterns {
+		pattern := core.Custom(p.Repeat, p.Steps...)
+		if p.Morse != "" {
+			if p.Unit == 0 {
+				p.Unit = 150 * time.Millisecond
+			}
+			pattern = core.Morse(p.Morse, p.Unit)
+			pattern.Repeat = p.Repeat
+		}
+		if err = core.RegisterPattern(p.Name, pattern); err != nil {
+			return fmt.Errorf("couldn't register pattern %s: %w", p.Name, err)
+		}
+	}
+	blinkers, err := c.Blinkers()
+	if err != nil {
+		return err
+	}
+	for _, b := range blinkers {
+		if b.Chip == "" {
+			b.Chip = defaultChip
+		}
+		if _, err = core.RegisterBlinker(b.Name, b.Chip, b.Offset); err != nil {
+			return fmt.Errorf("couldn't register blinker %s: %w", b.Name, err)
+		}
+	}
+	return nil
+}
+
+// registerMeters registers the meters declared in the config, they come
+// before the generals which may use their thresholds as sensors
+func registerMeters(ctx context.Context, c *config.Config, defaultChip string) error {
+	meters, err := c.Meters()
+	if err != nil {
+		return err
+	}
+	for _, m := range meters {
+		if m.Chip == "" {
+			m.Chip = defaultChip
+		}
+		opts := []core.MeterOption{}
+		if m.Window != 0 {
+			opts = append(opts, core.WithWindow(m.Window))
+		}
+		if m.Threshold != "" {
+			opts = append(opts, core.WithThreshold(m.Threshold, m.Below, m.Above))
+		}
+		if _, err = core.RegisterMeter(ctx, m.Name, m.Chip, m.Offset, opts...); err != nil {
+			return fmt.Errorf("couldn't register meter %s: %w", m.Name, err)
+		}
+	}
+	return nil
+}
+
+// registerDistanceSensors registers the ultrasonic distance sensors
+// declared in the config, before the generals that use their thresholds
+func registerDistanceSensors(ctx context.Context, c *config.Config, defaultChip string) error {
+	sensors, err := c.DistanceSensors()
+	if err != nil {
+		return err
+	}
+	for _, s := range sensors {
+		if s.Chip == "" {
+			s.Chip = defaultChip
+		}
+		opts := []core.DistanceOption{}
+		if s.Interval != 0 || s.Samples != 0 {
+			if s.Interval == 0 {
+				s.Interval = 100 * time.Millisecond
+			}
+			if s.Samples == 0 {
+				s.Samples = 5
+			}
+			opts = append(opts, core.WithSampling(s.Interval, s.Samples))
+		}
+		if s.Temperature != nil {
+			opts = append(opts, core.WithTemperature(*s.Temperature))
+		}
+		if s.Range != 0 {
+			opts = append(opts, core.WithRange(s.Range))
+		}
+		if s.Threshold != "" {
+			opts = append(opts, core.WithThreshold(s.Threshold, s.Below, s.Above))
+		}
+		_, err = core.RegisterDistanceSensor(ctx, s.Name, s.Chip, s.Trigger, s.Echo, opts...)
+		if err != nil {
+			return fmt.Errorf("couldn't register distance sensor %s: %w", s.Name, err)
+		}
+	}
+	return nil
+}
+
+// registerVirtuals registers the virtual items declared in the config,
+// they are owned by the config for as long as the application runs
+func registerVirtuals(c *config.Config) error {
+	virtuals, err := c.Virtuals()
+	if err != nil {
+		return err
+	}
+	for _, v := range virtuals {
+		state := core.Inactive
+		if v.Active {
+			state = core.Active
+		}
+		if _, err = core.RegisterVirtualItem(v.Name, core.WithState(state), core.WithOwner("config")); err != nil {
+			return fmt.Errorf("couldn't register virtual item %s: %w", v.Name, err)
+		}
+	}
+	return nil
+}
+
+// registerGenerals registers the generals declared in the config in order,
+// so a general can only refer to the ones declared before it
+func registerGenerals(c *config.Config, defaultChip string) error {
+	generals, err := c.Generals()
+	if err != nil {
+		return err
+	}
+	for _, g := range generals {
+		chip := g.Chip
+		if chip == "" {
+			chip = defaultChip
+		}
+		var opts []general.Option
+		if g.Kind == general.Expression {
+			opts = append(opts, general.WithExpression(g.Expression))
+		} else if g.Kind == general.Cover {
+			if g.Cover == nil {
+				return fmt.Errorf("cover %s needs its relays and travel time", g.Tag)
+			}
+			opts = append(opts, general.AsCover(chip, g.Cover.Up, g.Cover.Down, g.Cover.Travel))
+			if g.Cover.Reverse != 0 {
+				opts = append(opts, general.WithReverseDelay(g.Cover.Reverse))
+			}
+			for _, stop := range g.Cover.EndStops {
+				if stop.Chip == "" {
+					stop.Chip = defaultChip
+				}
+				opts = append(opts, general.WithEndStop(stop.Chip, stop.Offset, stop.Position))
+			}
+		} else if g.Kind == general.Garage {
+			if g.Garage == nil {
+				return fmt.Errorf("garage door %s needs its relay, limit switches and timeout", g.Tag)
+			}
+			opts = append(opts, general.AsGarageDoor(chip, g.Garage.Relay, g.Garage.Opened, g.Garage.Closed, g.Garage.Timeout))
+			if g.Garage.Pulse != 0 {
+				opts = append(opts, general.WithPulse(g.Garage.Pulse))
+			}
+			if a := g.Garage.AutoClose; a != nil {
+				opts = append(opts, general.WithAutoClose(a.After, a.From, a.To))
+			}
+		} else if g.Kind == general.Irrigation {
+			if g.Irrigation == nil {
+				return fmt.Errorf("irrigation %s needs its zones", g.Tag)
+			}
+			max := g.Irrigation.Max
+			if max == 0 {
+				max = 1
+			}
+			opts = append(opts, general.AsIrrigation(max))
+			for _, z := range g.Irrigation.Zones {
+				if z.Chip == "" {
+					z.Chip = defaultChip
+				}
+				opts = append(opts, general.WithZone(z.Chip, z.Offset, z.Duration))
+			}
+			if r := g.Irrigation.Rain; r != nil {
+				if r.Chip == "" {
+					r.Chip = defaultChip
+				}
+				opts = append(opts, general.WithRainSensor(r.Chip, r.Offset, r.Policy))
+			}
+			if g.Irrigation.Retention != 0 {
+				opts = append(opts, general.WithRunRetention(g.Irrigation.Retention))
+			}
+		} else if g.Kind == general.Pump {
+			if g.Pump == nil {
+				return fmt.Errorf("pump %s needs its motor and level switch", g.Tag)
+			}
+			opts = append(opts, general.AsPump(chip, g.Pump.Motor, g.Pump.Low), general.WithRuntime(g.Pump.MaxRun, g.Pump.MinRest))
+			if g.Pump.High != nil {
+				opts = append(opts, general.WithHighLevel(*g.Pump.High))
+			}
+			if g.Pump.Flow != nil {
+				opts = append(opts, general.WithDryRunProtection(*g.Pump.Flow, g.Pump.DryRun))
+			}
+		} else if g.Kind == general.Door {
+			if g.Door == nil {
+				return fmt.Errorf("door %s needs its strike and door contact", g.Tag)
+			}
+			opts = append(opts, general.AsDoor(chip, g.Door.Strike, g.Door.Contact, g.Door.UnlockTime, g.Door.HeldOpen))
+			if g.Door.Exit != nil {
+				opts = append(opts, general.WithExitButton(*g.Door.Exit))
+			}
+		} else {
+			opts = append(opts, general.WithKind(g.Kind, g.Strategy))
+		}
+		for _, line := range g.Named {
+			if line.Chip == "" {
+				line.Chip = defaultChip
+			}
+			opts = append(opts, general.WithNamedSensor(line.Name, line.Chip, line.Offset))
+		}
+		if len(g.VirtualSensors) > 0 || len(g.VirtualActuators) > 0 {
+			opts = append(opts, general.WithVirtual(g.VirtualSensors, g.VirtualActuators))
+		}
+		if len(g.Generals) > 0 {
+			opts = append(opts, general.WithGeneralSensors(g.Generals...))
+		}
+		for _, d := range g.Dimmers {
+			opts = append(opts, general.WithDimmer(d.PWM, d.Level, d.Fade))
+		}
+		for _, i := range g.Indicators {
+			opts = append(opts, general.WithIndicator(i.Blinker, i.Active, i.Inactive))
+		}
+		if g.Pull != "" || g.ActiveLow {
+			pull := core.PullDown
+			switch g.Pull {
+			case "", "down":
+			case "up":
+				pull = core.PullUp
+			case "disabled":
+				pull = core.PullDisabled
+			default:
+				return fmt.Errorf("general %s can't pull its inputs %q", g.Tag, g.Pull)
+			}
+			opts = append(opts, general.WithInputs(pull, g.ActiveLow))
+		}
+		if len(g.Sensors) > 0 || len(g.Actuators) > 0 {
+			opts = append(opts, general.WithConfig(chip, append([]int{}, g.Sensors...), append([]int{}, g.Actuators...)))
+		}
+		if _, err = general.Register(g.Tag, opts...); err != nil {
+			return fmt.Errorf("couldn't register general %s: %w", g.Tag, err)
+		}
+	}
+	return nil
+}
+
+// declaredSchedules returns the schedules declared in the config, the
+// scheduler deletes the ones that were removed from it and restores the
+// ones created at runtime by itself
+func declaredSchedules(c *config.Config) ([]scheduler.Schedule, error) {
+	declared, err := c.Schedules()
+	if err != nil {
+		return nil, err
+	}
+	schedules := make([]scheduler.Schedule, 0, len(declared))
+	for _, s := range declared {
+		var sun *scheduler.Sun
+		if s.Sun != nil {
+			sun = &scheduler.Sun{
+				Event:    s.Sun.Event,
+				Offset:   s.Sun.Offset,
+				Earliest: s.Sun.Earliest,
+				Latest:   s.Sun.Latest,
+			}
+		}
+		schedules = append(schedules, scheduler.Schedule{
+			ID:     s.ID,
+			Cron:   s.Cron,
+			Every:  s.Every,
+			Sun:    sun,
+			Missed: s.Missed,
+			Action: action(s.Action),
+		})
+	}
+	return schedules, nil
+}
+
+// startVacation sets up the presence simulation if there is one in the config
+func startVacation(ctx context.Context, c *config.Config, hist *history.History, defaultChip string) error {
+	v, err := c.Vacation()
+	if err != nil || v == nil {
+		return err
+	}
+	lines := func(ls []config.Line) (out []vacation.Line) {
+		for _, l := range ls {
+			if l.Chip == "" {
+				l.Chip = defaultChip
+			}
+			out = append(out, vacation.Line{Chip: l.Chip, Offset: l.Offset})
+		}
+		return
+	}
+	opts := []vacation.Option{
+		vacation.WithSwitch(v.Switch),
+		vacation.WithActuators(lines(v.Actuators), v.Generals),
+		vacation.WithExclude(lines(v.Exclude)...),
+	}
+	switch v.Mode {
+	case vacation.Random:
+		opts = append(opts, vacation.AsRandom(vacation.Window{
+			From:   v.Window.From,
+			To:     v.Window.To,
+			MinOn:  v.Window.MinOn,
+			MaxOn:  v.Window.MaxOn,
+			MinOff: v.Window.MinOff,
+			MaxOff: v.Window.MaxOff,
+		}))
+	default:
+		opts = append(opts, vacation.AsReplay(hist, v.Lookback, v.Jitter))
+	}
+	_, err = vacation.New(ctx, opts...)
+	return err
+}
+
+// startAccess replaces the users in the store with the ones declared in the
+// config and starts checking the credentials presented to every reader and
+// the pins typed on every keypad
+func startAccess(ctx context.Context, c *config.Config, a *access.Access, defaultChip string) error {
+	declared, err := c.Users()
+	if err != nil {
+		return err
+	}
+	// the config is the only source of users, so removing a user or one of
+	// their credentials from it revokes their access
+	users := make([]access.User, 0, len(declared))
+	for _, u := range declared {
+		users = append(users, access.User{Name: u.Name, Credentials: u.Credentials, Access: u.Access})
+	}
+	if err = a.SetUsers(users); err != nil {
+		return err
+	}
+	readers, err := c.Readers()
+	if err != nil {
+		return err
+	}
+	for _, r := range readers {
+		if r.Chip == "" {
+			r.Chip = defaultChip
+		}
+		w, err := input.NewWiegand(r.Name, r.Chip, r.D0, r.D1)
+		if err != nil {
+			return fmt.Errorf("couldn't start reader %s: %w", r.Name, err)
+		}
+		var targets []access.Target
+		for _, tag := range r.Doors {
+			targets = append(targets, access.UnlockDoor(tag))
+		}
+		for _, tag := range r.Disarm {
+			targets = append(targets, access.DisarmAlarm(tag))
+		}
+		a.Guard(w, targets...)
+	}
+	keypads, err := c.Keypads()
+	if err != nil {
+		return err
+	}
+	for _, kp := range keypads {
+		if kp.Chip == "" {
+			kp.Chip = defaultChip
+		}
+		k, err := input.NewKeypad(ctx, kp.Name, kp.Chip, kp.Rows, kp.Columns, kp.Keys)
+		if err != nil {
+			return fmt.Errorf("couldn't start keypad %s: %w", kp.Name, err)
+		}
+		var targets []access.Target
+		for _, tag := range kp.Doors {
+			targets = append(targets, access.UnlockDoor(tag))
+		}
+		for _, tag := range kp.Arm {
+			targets = append(targets, access.ArmAlarm(tag))
+		}
+		for _, tag := range kp.Disarm {
+			targets = append(targets, access.DisarmAlarm(tag))
+		}
+		for _, tag := range kp.Toggle {
+			targets = append(targets, access.ToggleAlarm(tag))
+		}
+		a.Guard(k, targets...)
+	}
+	return nil
+}
+
+// startRemotes starts decoding the IR receivers declared in the config and
+// runs the actions their buttons are mapped to, failed actions are logged to l
+func startRemotes(c *config.Config, defaultChip string, l logy.Logger) error {
+	remotes, err := c.Remotes()
+	if err != nil {
+		return err
+	}
+	for _, r := range remotes {
+		if r.Chip == "" {
+			r.Chip = defaultChip
+		}
+		buttons := map[string]config.Button{}
+		for _, b := range r.Buttons {
+			if err = action(b.Action).Check(); err != nil {
+				return fmt.Errorf("remote %s button %s: %w", r.Name, b.Button, err)
+			}
+			buttons[b.Button] = b
+		}
+		ir, err := input.NewIR(r.Name, r.Chip, r.Offset)
+		if err != nil {
+			return fmt.Errorf("couldn't start remote %s: %w", r.Name, err)
+		}
+		ir.AddEventListener(func(event *input.IREvent) {
+			b, ok := buttons[event.Button()]
+			if !ok || (event.Repeat && !b.Repeat) {
+				return
+			}
+			if err := action(b.Action).Run(); err != nil {
+				l.Errorf("remote %s button %s: %v", event.Receiver, b.Button, err)
+			}
+		})
+	}
+	return nil
+}
+
+func action(a config.Action) scheduler.Action {
+	return scheduler.Action{
+		Kind:     a.Kind,
+		Tag:      a.Tag,
+		Chip:     a.Chip,
+		Offset:   a.Offset,
+		Virtual:  a.Virtual,
+		Active:   a.Active,
+		Position: a.Position,
+		PWM:      a.PWM,
+		Level:    a.Level,
+		Fade:     a.Fade,
+	}
+}
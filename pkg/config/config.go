@@ -1,6 +1,8 @@
 package config
 
 import (
+	"time"
+
 	"github.com/spf13/viper"
 )
 
@@ -31,3 +33,504 @@ func New(options *ConfigOptions) (*Config, error) {
 
 	return &Config{*v}, nil
 }
+
+// Line points to a single line of a chip, Name is how other parts of
+// the config refer to it
+type Line struct {
+	Name   string `mapstructure:"name"`
+	Chip   string `mapstructure:"chip"`
+	Offset int    `mapstructure:"offset"`
+}
+
+// Chip is a gpio chip declared under the "chips" key, Backend is "gpiod"
+// for the character device, which is the default, or "sysfs" for kernels
+// that only have /sys/class/gpio. Root is only used by the sysfs backend
+// and replaces /sys/class/gpio
+type Chip struct {
+	Name    string `mapstructure:"name"`
+	Backend string `mapstructure:"backend"`
+	Root    string `mapstructure:"root"`
+}
+
+func (c *Config) Chips() (chips []Chip, err error) {
+	err = c.UnmarshalKey("chips", &chips)
+	return
+}
+
+// Expander is an I2C gpio expander declared under the "expanders" key, its
+// lines are lines of the chip called Name. Kind is "mcp23017" or
+// "pcf8574", its inputs are polled every Polling, which is how often
+// they're read in case an interrupt is missed if there's an Interrupt line
+type Expander struct {
+	Name      string        `mapstructure:"name"`
+	Kind      string        `mapstructure:"kind"`
+	Bus       int           `mapstructure:"bus"`
+	Address   uint16        `mapstructure:"address"`
+	Interrupt *Line         `mapstructure:"interrupt"`
+	Polling   time.Duration `mapstructure:"polling"`
+}
+
+func (c *Config) Expanders() (expanders []Expander, err error) {
+	err = c.UnmarshalKey("expanders", &expanders)
+	return
+}
+
+// ShiftRegister is a chain of Count 74HC595s declared under the
+// "shift-registers" key, its outputs are lines of the chip called Name
+type ShiftRegister struct {
+	Name  string `mapstructure:"name"`
+	Chip  string `mapstructure:"chip"`
+	Data  int    `mapstructure:"data"`
+	Clock int    `mapstructure:"clock"`
+	Latch int    `mapstructure:"latch"`
+	Count int    `mapstructure:"count"`
+}
+
+func (c *Config) ShiftRegisters() (registers []ShiftRegister, err error) {
+	err = c.UnmarshalKey("shift-registers", &registers)
+	return
+}
+
+// Virtual is how a virtual item is declared under the "virtual" key, Active
+// is only used the first time, after that the persisted state is restored
+type Virtual struct {
+	Name   string `mapstructure:"name"`
+	Active bool   `mapstructure:"active"`
+}
+
+func (c *Config) Virtuals() (virtuals []Virtual, err error) {
+	err = c.UnmarshalKey("virtual", &virtuals)
+	return
+}
+
+// Counter is a pulse counter declared under the "counters" key, Pulses is
+// how many pulses make a Unit, e.g. 1000 for an S0 meter counting kWh
+type Counter struct {
+	Name     string        `mapstructure:"name"`
+	Chip     string        `mapstructure:"chip"`
+	Offset   int           `mapstructure:"offset"`
+	Pulses   float64       `mapstructure:"pulses"`
+	Unit     string        `mapstructure:"unit"`
+	Debounce time.Duration `mapstructure:"debounce"`
+	Timeout  time.Duration `mapstructure:"timeout"`
+}
+
+func (c *Config) Counters() (counters []Counter, err error) {
+	err = c.UnmarshalKey("counters", &counters)
+	return
+}
+
+// Meter measures the frequency of an input and is declared under the
+// "meters" key. if Threshold is set it names a virtual item that's active
+// while the frequency is below Below or above Above Hz
+type Meter struct {
+	Name      string        `mapstructure:"name"`
+	Chip      string        `mapstructure:"chip"`
+	Offset    int           `mapstructure:"offset"`
+	Window    time.Duration `mapstructure:"window"`
+	Threshold string        `mapstructure:"threshold"`
+	Below     float64       `mapstructure:"below"`
+	Above     float64       `mapstructure:"above"`
+}
+
+func (c *Config) Meters() (meters []Meter, err error) {
+	err = c.UnmarshalKey("meters", &meters)
+	return
+}
+
+// DistanceSensor is an HC-SR04 declared under the "distance-sensors" key.
+// Temperature is in °C and Below and Above are in meters, if Threshold is
+// set it names a virtual item that's active while the distance is outside them
+type DistanceSensor struct {
+	Name        string        `mapstructure:"name"`
+	Chip        string        `mapstructure:"chip"`
+	Trigger     int           `mapstructure:"trigger"`
+	Echo        int           `mapstructure:"echo"`
+	Interval    time.Duration `mapstructure:"interval"`
+	Samples     int           `mapstructure:"samples"`
+	Temperature *float64      `mapstructure:"temperature"`
+	Range       float64       `mapstructure:"range"`
+	Threshold   string        `mapstructure:"threshold"`
+	Below       float64       `mapstructure:"below"`
+	Above       float64       `mapstructure:"above"`
+}
+
+func (c *Config) DistanceSensors() (sensors []DistanceSensor, err error) {
+	err = c.UnmarshalKey("distance-sensors", &sensors)
+	return
+}
+
+// PWM is a pwm output declared under the "pwm" key, Chip is either a gpio
+// chip switched in software or a pwm chip of the kernel like "pwmchip0"
+// in which case Offset is the channel
+type PWM struct {
+	Name      string  `mapstructure:"name"`
+	Chip      string  `mapstructure:"chip"`
+	Offset    int     `mapstructure:"offset"`
+	Frequency float64 `mapstructure:"frequency"`
+	Duty      float64 `mapstructure:"duty"`
+}
+
+func (c *Config) PWMs() (pwms []PWM, err error) {
+	err = c.UnmarshalKey("pwm", &pwms)
+	return
+}
+
+// Encoder is a rotary encoder declared under the "encoders" key with its
+// A and B pins on lines A and B. StepsPerDetent is 4 if it's not set and
+// detents less than Threshold apart count for up to Acceleration steps.
+// turning it changes the duty cycle of PWM by Step percent per step if PWM is set
+type Encoder struct {
+	Name           string        `mapstructure:"name"`
+	Chip           string        `mapstructure:"chip"`
+	A              int           `mapstructure:"a"`
+	B              int           `mapstructure:"b"`
+	StepsPerDetent int           `mapstructure:"steps-per-detent"`
+	Threshold      time.Duration `mapstructure:"threshold"`
+	Acceleration   int           `mapstructure:"acceleration"`
+	PWM            string        `mapstructure:"pwm"`
+	Step           float64       `mapstructure:"step"`
+}
+
+func (c *Config) Encoders() (encoders []Encoder, err error) {
+	err = c.UnmarshalKey("encoders", &encoders)
+	return
+}
+
+// Pattern is a custom pattern declared under the "patterns" key, it's
+// either the on and off durations in Steps or the text in Morse spelled
+// with a dot of Unit
+type Pattern struct {
+	Name   string          `mapstructure:"name"`
+	Steps  []time.Duration `mapstructure:"steps"`
+	Repeat int             `mapstructure:"repeat"`
+	Morse  string          `mapstructure:"morse"`
+	Unit   time.Duration   `mapstructure:"unit"`
+}
+
+func (c *Config) Patterns() (patterns []Pattern, err error) {
+	err = c.UnmarshalKey("patterns", &patterns)
+	return
+}
+
+// Blinker is an output that plays patterns, declared under the "blinkers" key
+type Blinker struct {
+	Name   string `mapstructure:"name"`
+	Chip   string `mapstructure:"chip"`
+	Offset int    `mapstructure:"offset"`
+}
+
+func (c *Config) Blinkers() (blinkers []Blinker, err error) {
+	err = c.UnmarshalKey("blinkers", &blinkers)
+	return
+}
+
+// General is how a general is declared under the "generals" key
+type General struct {
+	Tag      string `mapstructure:"tag"`
+	Kind     string `mapstructure:"kind"`
+	Strategy string `mapstructure:"strategy"`
+	// Expression is only used if Kind is "expression"
+	Expression string `mapstructure:"expression"`
+	Named      []Line `mapstructure:"named"`
+	Chip       string `mapstructure:"chip"`
+	Sensors    []int  `mapstructure:"sensors"`
+	Actuators  []int  `mapstructure:"actuators"`
+	// Generals are the tags of previously declared generals used as sensors
+	Generals []string `mapstructure:"generals"`
+	// VirtualSensors and VirtualActuators are names of virtual items
+	VirtualSensors   []string `mapstructure:"virtual-sensors"`
+	VirtualActuators []string `mapstructure:"virtual-actuators"`
+	// Cover is only used if Kind is "cover"
+	Cover *Cover `mapstructure:"cover"`
+	// Garage is only used if Kind is "garage"
+	Garage *Garage `mapstructure:"garage"`
+	// Irrigation is only used if Kind is "irrigation"
+	Irrigation *Irrigation `mapstructure:"irrigation"`
+	// Pump is only used if Kind is "pump"
+	Pump *Pump `mapstructure:"pump"`
+	// Door is only used if Kind is "door"
+	Door *Door `mapstructure:"door"`
+	// Dimmers are pwm outputs faded to their level while the general is active
+	Dimmers []Dimmer `mapstructure:"dimmers"`
+	// Indicators are blinkers playing a pattern for the state of the general
+	Indicators []Indicator `mapstructure:"indicators"`
+	// Pull is how every input of the general is pulled, "up", "down" or
+	// "disabled", it's "down" if it's not set. ActiveLow inputs are active
+	// while they read low, for switches to ground
+	Pull      string `mapstructure:"pull"`
+	ActiveLow bool   `mapstructure:"active-low"`
+}
+
+type Indicator struct {
+	Blinker  string `mapstructure:"blinker"`
+	Active   string `mapstructure:"active"`
+	Inactive string `mapstructure:"inactive"`
+}
+
+type Dimmer struct {
+	PWM   string        `mapstructure:"pwm"`
+	Level float64       `mapstructure:"level"`
+	Fade  time.Duration `mapstructure:"fade"`
+}
+
+// Door is the strike and inputs of a door general, Exit is optional
+type Door struct {
+	Strike     int           `mapstructure:"strike"`
+	Contact    int           `mapstructure:"contact"`
+	Exit       *int          `mapstructure:"exit"`
+	UnlockTime time.Duration `mapstructure:"unlock-time"`
+	HeldOpen   time.Duration `mapstructure:"held-open"`
+}
+
+// Pump is the motor and switches of a pump general, High and Flow are optional
+type Pump struct {
+	Motor   int           `mapstructure:"motor"`
+	Low     int           `mapstructure:"low"`
+	High    *int          `mapstructure:"high"`
+	Flow    *int          `mapstructure:"flow"`
+	DryRun  time.Duration `mapstructure:"dry-run"`
+	MaxRun  time.Duration `mapstructure:"max-run"`
+	MinRest time.Duration `mapstructure:"min-rest"`
+}
+
+// Irrigation are the zones of an irrigation general, the program is run
+// by scheduling the "turn-on" action for its tag
+type Irrigation struct {
+	Max   int    `mapstructure:"max"`
+	Zones []Zone `mapstructure:"zones"`
+	Rain  *Rain  `mapstructure:"rain"`
+	// Retention is how long runs are kept, 90 days if it isn't set
+	Retention time.Duration `mapstructure:"retention"`
+}
+
+type Zone struct {
+	Chip     string        `mapstructure:"chip"`
+	Offset   int           `mapstructure:"offset"`
+	Duration time.Duration `mapstructure:"duration"`
+}
+
+// Rain is the rain sensor of an irrigation general, Policy is "skip" or "pause"
+type Rain struct {
+	Chip   string `mapstructure:"chip"`
+	Offset int    `mapstructure:"offset"`
+	Policy string `mapstructure:"policy"`
+}
+
+// Garage is the opener relay and limit switches of a garage door general
+type Garage struct {
+	Relay     int           `mapstructure:"relay"`
+	Opened    int           `mapstructure:"opened"`
+	Closed    int           `mapstructure:"closed"`
+	Timeout   time.Duration `mapstructure:"timeout"`
+	Pulse     time.Duration `mapstructure:"pulse"`
+	AutoClose *AutoClose    `mapstructure:"auto-close"`
+}
+
+// AutoClose closes a door left open for longer than After between From and To
+type AutoClose struct {
+	After time.Duration `mapstructure:"after"`
+	From  string        `mapstructure:"from"`
+	To    string        `mapstructure:"to"`
+}
+
+// Cover are the relays and travel time of a cover general, EndStops are
+// optional switches at position 0 (closed) or 100 (open)
+type Cover struct {
+	Up       int           `mapstructure:"up"`
+	Down     int           `mapstructure:"down"`
+	Travel   time.Duration `mapstructure:"travel"`
+	Reverse  time.Duration `mapstructure:"reverse"`
+	EndStops []EndStop     `mapstructure:"end-stops"`
+}
+
+type EndStop struct {
+	Chip     string  `mapstructure:"chip"`
+	Offset   int     `mapstructure:"offset"`
+	Position float64 `mapstructure:"position"`
+}
+
+func (c *Config) Generals() (generals []General, err error) {
+	err = c.UnmarshalKey("generals", &generals)
+	return
+}
+
+// Action is what a schedule does, see the scheduler package for the kinds
+type Action struct {
+	Kind    string `mapstructure:"kind"`
+	Tag     string `mapstructure:"tag"`
+	Chip    string `mapstructure:"chip"`
+	Offset  int    `mapstructure:"offset"`
+	Virtual string `mapstructure:"virtual"`
+	Active  bool   `mapstructure:"active"`
+	// Position is only used by the "position" kind
+	Position float64 `mapstructure:"position"`
+	// PWM, Level and Fade are only used by the "dim" kind
+	PWM   string        `mapstructure:"pwm"`
+	Level float64       `mapstructure:"level"`
+	Fade  time.Duration `mapstructure:"fade"`
+}
+
+// Sun is a schedule relative to a solar event: sunrise, sunset, dawn or dusk
+type Sun struct {
+	Event    string        `mapstructure:"event"`
+	Offset   time.Duration `mapstructure:"offset"`
+	Earliest string        `mapstructure:"earliest"`
+	Latest   string        `mapstructure:"latest"`
+}
+
+// Schedule is how a schedule is declared under the "schedules" key,
+// only one of Cron, Every and Sun should be set
+type Schedule struct {
+	ID     string        `mapstructure:"id"`
+	Cron   string        `mapstructure:"cron"`
+	Every  time.Duration `mapstructure:"every"`
+	Sun    *Sun          `mapstructure:"sun"`
+	Missed string        `mapstructure:"missed"`
+	Action Action        `mapstructure:"action"`
+}
+
+func (c *Config) Schedules() (schedules []Schedule, err error) {
+	err = c.UnmarshalKey("schedules", &schedules)
+	return
+}
+
+// Site is where baagh is installed, declared under the "site" key
+type Site struct {
+	Latitude  float64 `mapstructure:"latitude"`
+	Longitude float64 `mapstructure:"longitude"`
+	Timezone  string  `mapstructure:"timezone"`
+}
+
+// Site returns nil if there is no site in the config
+func (c *Config) Site() (site *Site, err error) {
+	if !c.IsSet("site") {
+		return nil, nil
+	}
+	site = &Site{}
+	err = c.UnmarshalKey("site", site)
+	return
+}
+
+// Window is the part of the day a random vacation simulation runs in
+type Window struct {
+	From   string        `mapstructure:"from"`
+	To     string        `mapstructure:"to"`
+	MinOn  time.Duration `mapstructure:"min-on"`
+	MaxOn  time.Duration `mapstructure:"max-on"`
+	MinOff time.Duration `mapstructure:"min-off"`
+	MaxOff time.Duration `mapstructure:"max-off"`
+}
+
+// Vacation is the presence simulation declared under the "vacation" key,
+// Mode is either "replay" or "random"
+type Vacation struct {
+	Switch    string        `mapstructure:"switch"`
+	Mode      string        `mapstructure:"mode"`
+	Lookback  time.Duration `mapstructure:"lookback"`
+	Jitter    time.Duration `mapstructure:"jitter"`
+	Window    Window        `mapstructure:"window"`
+	Actuators []Line        `mapstructure:"actuators"`
+	Generals  []string      `mapstructure:"generals"`
+	Exclude   []Line        `mapstructure:"exclude"`
+}
+
+// Vacation returns nil if there is no vacation simulation in the config
+func (c *Config) Vacation() (vacation *Vacation, err error) {
+	if !c.IsSet("vacation") {
+		return nil, nil
+	}
+	vacation = &Vacation{
+		Switch:   "vacation",
+		Lookback: 7 * 24 * time.Hour,
+		Jitter:   15 * time.Minute,
+	}
+	err = c.UnmarshalKey("vacation", vacation)
+	return
+}
+
+// Interlock is a group of mutually exclusive outputs declared under the
+// "interlocks" key, Policy is either "refuse" or "sequence"
+type Interlock struct {
+	Name     string        `mapstructure:"name"`
+	Policy   string        `mapstructure:"policy"`
+	DeadTime time.Duration `mapstructure:"dead-time"`
+	Lines    []Line        `mapstructure:"lines"`
+}
+
+func (c *Config) Interlocks() (interlocks []Interlock, err error) {
+	err = c.UnmarshalKey("interlocks", &interlocks)
+	return
+}
+
+// Reader is a Wiegand card or keypad reader declared under the "readers"
+// key, credentials presented to it unlock Doors and disarm Disarm
+type Reader struct {
+	Name   string   `mapstructure:"name"`
+	Chip   string   `mapstructure:"chip"`
+	D0     int      `mapstructure:"d0"`
+	D1     int      `mapstructure:"d1"`
+	Doors  []string `mapstructure:"doors"`
+	Disarm []string `mapstructure:"disarm"`
+}
+
+func (c *Config) Readers() (readers []Reader, err error) {
+	err = c.UnmarshalKey("readers", &readers)
+	return
+}
+
+// Remote is an IR receiver declared under the "remotes" key, every button
+// pressed on a remote it receives runs the action it's mapped to
+type Remote struct {
+	Name    string   `mapstructure:"name"`
+	Chip    string   `mapstructure:"chip"`
+	Offset  int      `mapstructure:"offset"`
+	Buttons []Button `mapstructure:"buttons"`
+}
+
+// Button maps a button of a remote, like "nec:4:8" or "rc5:5:12", to an
+// action. buttons only repeat their action while held if Repeat is set
+type Button struct {
+	Button string `mapstructure:"button"`
+	Repeat bool   `mapstructure:"repeat"`
+	Action Action `mapstructure:"action"`
+}
+
+func (c *Config) Remotes() (remotes []Remote, err error) {
+	err = c.UnmarshalKey("remotes", &remotes)
+	return
+}
+
+// Keypad is a matrix keypad declared under the "keypads" key, Rows and
+// Columns are the offsets of its lines and Keys its layout, one string per
+// row. pins typed on it unlock Doors, arm Arm, disarm Disarm and arm or
+// disarm Toggle depending on whether it's armed
+type Keypad struct {
+	Name    string   `mapstructure:"name"`
+	Chip    string   `mapstructure:"chip"`
+	Rows    []int    `mapstructure:"rows"`
+	Columns []int    `mapstructure:"columns"`
+	Keys    []string `mapstructure:"keys"`
+	Doors   []string `mapstructure:"doors"`
+	Arm     []string `mapstructure:"arm"`
+	Disarm  []string `mapstructure:"disarm"`
+	Toggle  []string `mapstructure:"toggle"`
+}
+
+func (c *Config) Keypads() (keypads []Keypad, err error) {
+	err = c.UnmarshalKey("keypads", &keypads)
+	return
+}
+
+// User is declared under the "users" key, Credentials look like
+// "wiegand26:12:3456" or "pin:1234" and Access are tags of doors and alarms
+type User struct {
+	Name        string   `mapstructure:"name"`
+	Credentials []string `mapstructure:"credentials"`
+	Access      []string `mapstructure:"access"`
+}
+
+func (c *Config) Users() (users []User, err error) {
+	err = c.UnmarshalKey("users", &users)
+	return
+}
@@ -0,0 +1,329 @@
+package access
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/AliRostami1/baagh/pkg/controller/general"
+	"github.com/AliRostami1/baagh/pkg/controller/input"
+	"github.com/AliRostami1/baagh/pkg/controller/store"
+	"github.com/AliRostami1/baagh/pkg/logy"
+)
+
+var logger logy.Logger = logy.DummyLogger{}
+
+func SetLogger(l logy.Logger) error {
+	if l == nil {
+		return fmt.Errorf("logger can't be nil")
+	}
+	logger = l
+	return nil
+}
+
+const keyPrefix = "access/user/"
+
+// User is someone who may unlock doors and disarm alarms
+type User struct {
+	Name string `json:"name"`
+	// Credentials are in the form of input.Credential.String()
+	Credentials []string `json:"credentials"`
+	// Access are the tags of the doors and alarms the user may use
+	Access   []string `json:"access"`
+	Disabled bool     `json:"disabled,omitempty"`
+}
+
+func (u User) check() error {
+	if u.Name == "" || strings.Contains(u.Name, "/") {
+		return UserError{Name: u.Name, Reason: "name can't be empty or contain /"}
+	}
+	return nil
+}
+
+func (u User) allowed(tag string) bool {
+	for _, t := range u.Access {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Reader is anything credentials can be presented to
+type Reader interface {
+	Name() string
+	AddEventListener(fns ...input.CredentialHandler)
+}
+
+// Access keeps the users in the store and checks the credentials
+// presented to readers against them
+type Access struct {
+	db store.Store
+	// users mirror the store, credentials index them by every credential
+	// they have so a presented credential is found right away
+	users       map[string]User
+	credentials map[string]string
+	mu          *sync.RWMutex
+}
+
+// New loads the users persisted in db
+func New(db store.Store) (*Access, error) {
+	a := &Access{
+		db:          db,
+		users:       map[string]User{},
+		credentials: map[string]string{},
+		mu:          &sync.RWMutex{},
+	}
+	keys, err := db.Keys(keyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		var u User
+		if err = db.Get(k, &u); err != nil {
+			return nil, err
+		}
+		a.index(u)
+	}
+	return a, nil
+}
+
+// Put adds or replaces a user
+func (a *Access) Put(u User) error {
+	if err := u.check(); err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, c := range u.Credentials {
+		if other, ok := a.credentials[c]; ok && other != u.Name {
+			return UserError{Name: u.Name, Reason: fmt.Sprintf("credential %s already belongs to %s", c, other)}
+		}
+	}
+	if err := a.db.Put(keyPrefix+u.Name, u); err != nil {
+		return err
+	}
+	a.unindex(u.Name)
+	a.index(u)
+	return nil
+}
+
+// SetUsers replaces every user with users, anyone who isn't among them
+// loses access. nothing changes if any of them is invalid
+func (a *Access) SetUsers(users []User) error {
+	names := map[string]bool{}
+	credentials := map[string]string{}
+	for _, u := range users {
+		if err := u.check(); err != nil {
+			return err
+		}
+		if names[u.Name] {
+			return UserError{Name: u.Name, Reason: "there's more than one user with this name"}
+		}
+		names[u.Name] = true
+		for _, c := range u.Credentials {
+			if other, ok := credentials[c]; ok && other != u.Name {
+				return UserError{Name: u.Name, Reason: fmt.Sprintf("credential %s already belongs to %s", c, other)}
+			}
+			credentials[c] = u.Name
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for name := range a.users {
+		if names[name] {
+			continue
+		}
+		if err := a.db.Delete(keyPrefix + name); err != nil {
+			return err
+		}
+		a.unindex(name)
+		logger.Infof("user %s was removed", name)
+	}
+	for _, u := range users {
+		if err := a.db.Put(keyPrefix+u.Name, u); err != nil {
+			return err
+		}
+		a.unindex(u.Name)
+	}
+	for _, u := range users {
+		a.index(u)
+	}
+	return nil
+}
+
+func (a *Access) Delete(name string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.db.Delete(keyPrefix + name); err != nil {
+		return err
+	}
+	a.unindex(name)
+	return nil
+}
+
+func (a *Access) User(name string) (u User, err error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	u, ok := a.users[name]
+	if !ok {
+		return u, UserNotFoundError{Name: name}
+	}
+	return u, nil
+}
+
+// Users returns every user sorted by name
+func (a *Access) Users() (users []User, err error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	for _, u := range a.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Name < users[j].Name
+	})
+	return
+}
+
+// Lookup returns the user a credential belongs to
+func (a *Access) Lookup(credential string) (u User, ok bool, err error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	name, ok := a.credentials[credential]
+	if !ok {
+		return User{}, false, nil
+	}
+	return a.users[name], true, nil
+}
+
+// index adds u to users and credentials, must be called with mu locked
+func (a *Access) index(u User) {
+	a.users[u.Name] = u
+	for _, c := range u.Credentials {
+		a.credentials[c] = u.Name
+	}
+}
+
+// unindex removes the user called name from users and credentials, must
+// be called with mu locked
+func (a *Access) unindex(name string) {
+	u, ok := a.users[name]
+	if !ok {
+		return
+	}
+	delete(a.users, name)
+	for _, c := range u.Credentials {
+		if a.credentials[c] == name {
+			delete(a.credentials, c)
+		}
+	}
+}
+
+const (
+	unlock = "unlock"
+	arm    = "arm"
+	disarm = "disarm"
+	toggle = "toggle"
+)
+
+// Target is what a granted credential does
+type Target struct {
+	tag    string
+	action string
+}
+
+// UnlockDoor unlocks the door general with tag
+func UnlockDoor(tag string) Target {
+	return Target{tag: tag, action: unlock}
+}
+
+// ArmAlarm arms the general with tag
+func ArmAlarm(tag string) Target {
+	return Target{tag: tag, action: arm}
+}
+
+// DisarmAlarm disarms the general with tag
+func DisarmAlarm(tag string) Target {
+	return Target{tag: tag, action: disarm}
+}
+
+// ToggleAlarm disarms the general with tag if it's armed and arms it
+// otherwise, it's what a single pin typed on an alarm panel does
+func ToggleAlarm(tag string) Target {
+	return Target{tag: tag, action: toggle}
+}
+
+// Guard checks every credential presented to reader and acts on each of
+// the targets the user has access to
+func (a *Access) Guard(reader Reader, targets ...Target) {
+	reader.AddEventListener(func(event *input.CredentialEvent) {
+		a.presented(event, targets)
+	})
+}
+
+func (a *Access) presented(event *input.CredentialEvent, targets []Target) {
+	credential := event.Credential.String()
+	u, ok, err := a.Lookup(credential)
+	if err != nil {
+		logger.Errorf("couldn't look up %s presented to %s: %v", event.Credential.Redacted(), event.Reader, err)
+		return
+	}
+	if !ok || u.Disabled {
+		logger.Warnf("access denied to unknown credential %s at %s", event.Credential.Redacted(), event.Reader)
+		return
+	}
+	granted := false
+	for _, t := range targets {
+		if !u.allowed(t.tag) {
+			continue
+		}
+		g, err := general.Get(t.tag)
+		if err != nil {
+			logger.Errorf("reader %s: %v", event.Reader, err)
+			continue
+		}
+		action := t.action
+		if action == toggle {
+			action = arm
+			if g.Armed() {
+				action = disarm
+			}
+		}
+		switch action {
+		case arm:
+			g.Arm()
+			logger.Infof("%s armed %s at %s", u.Name, t.tag, event.Reader)
+		case disarm:
+			g.Disarm()
+			logger.Infof("%s disarmed %s at %s", u.Name, t.tag, event.Reader)
+		default:
+			if err = g.Unlock(u.Name); err != nil {
+				logger.Errorf("couldn't unlock %s for %s: %v", t.tag, u.Name, err)
+				continue
+			}
+		}
+		granted = true
+	}
+	if !granted {
+		logger.Warnf("access denied to %s at %s", u.Name, event.Reader)
+	}
+}
+
+type UserError struct {
+	Name   string
+	Reason string
+}
+
+func (u UserError) Error() string {
+	return fmt.Sprintf("invalid user %s: %s", u.Name, u.Reason)
+}
+
+type UserNotFoundError struct {
+	Name string
+}
+
+func (u UserNotFoundError) Error() string {
+	return fmt.Sprintf("there is no user named %s", u.Name)
+}
@@ -0,0 +1,107 @@
+package access
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/AliRostami1/baagh/pkg/controller/store"
+)
+
+func TestSetUsers(t *testing.T) {
+	db := store.NewMemory()
+	a, err := New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := User{Name: "alice", Credentials: []string{"wiegand26:12:3456", "pin:1234"}, Access: []string{"door"}}
+	bob := User{Name: "bob", Credentials: []string{"wiegand26:12:7890"}, Access: []string{"door"}}
+	if err = a.SetUsers([]User{alice, bob}); err != nil {
+		t.Fatal(err)
+	}
+	if u, ok, err := a.Lookup("pin:1234"); err != nil || !ok || u.Name != "alice" {
+		t.Errorf("pin:1234 belongs to %v, %v, %v, want alice", u.Name, ok, err)
+	}
+
+	// bob left and alice's badge was revoked
+	alice.Credentials = []string{"pin:1234"}
+	if err = a.SetUsers([]User{alice}); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []string{"wiegand26:12:3456", "wiegand26:12:7890"} {
+		if u, ok, _ := a.Lookup(c); ok {
+			t.Errorf("revoked credential %s still belongs to %s", c, u.Name)
+		}
+	}
+	if _, err = a.User("bob"); err == nil {
+		t.Error("bob wasn't removed")
+	}
+
+	// what's persisted is loaded back the same
+	a, err = New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := a.Users()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []User{alice}; !reflect.DeepEqual(users, want) {
+		t.Errorf("users loaded from the store are %v, want %v", users, want)
+	}
+	if _, ok, _ := a.Lookup("wiegand26:12:7890"); ok {
+		t.Error("bob's badge works after loading the store")
+	}
+}
+
+func TestSetUsersInvalid(t *testing.T) {
+	a, err := New(store.NewMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := User{Name: "alice", Credentials: []string{"pin:1234"}}
+	if err = a.SetUsers([]User{alice}); err != nil {
+		t.Fatal(err)
+	}
+	for _, users := range [][]User{
+		{{Name: "a/b"}},
+		{alice, alice},
+		{{Name: "bob", Credentials: []string{"pin:1"}}, {Name: "carol", Credentials: []string{"pin:1"}}},
+	} {
+		if err = a.SetUsers(users); err == nil {
+			t.Errorf("%v were accepted", users)
+		}
+	}
+	// nothing changes when the users are invalid
+	if u, ok, _ := a.Lookup("pin:1234"); !ok || u.Name != "alice" {
+		t.Error("invalid users replaced alice")
+	}
+}
+
+func TestPutConflict(t *testing.T) {
+	a, err := New(store.NewMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = a.Put(User{Name: "alice", Credentials: []string{"pin:1234", "pin:5678"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.Put(User{Name: "bob", Credentials: []string{"pin:1234"}}); err == nil {
+		t.Error("bob took alice's pin")
+	}
+	// replacing a user frees the credentials it doesn't have anymore
+	if err = a.Put(User{Name: "alice", Credentials: []string{"pin:1234"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.Put(User{Name: "bob", Credentials: []string{"pin:5678"}}); err != nil {
+		t.Errorf("bob couldn't take the pin alice dropped: %v", err)
+	}
+	if u, ok, _ := a.Lookup("pin:5678"); !ok || u.Name != "bob" {
+		t.Errorf("pin:5678 belongs to %s, want bob", u.Name)
+	}
+	if err = a.Delete("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := a.Lookup("pin:5678"); ok {
+		t.Error("a deleted user's pin still works")
+	}
+}
@@ -3,18 +3,27 @@ package core
 import (
 	"context"
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/AliRostami1/baagh/pkg/logy"
 
-	"github.com/warthog618/gpiod"
 	"go.uber.org/multierr"
 )
 
 // key is chip name
 var chips chipRegistry = chipRegistry{
-	registry: map[string]*Chip{},
-	RWMutex:  &sync.RWMutex{},
+	registry: map[string]*Chip{
+		VirtualChip: {
+			driver:   virtual,
+			name:     VirtualChip,
+			consumer: "baagh",
+			virtual:  true,
+			items:    &itemRegistry{registry: map[int]*Item{}, RWMutex: &sync.RWMutex{}},
+			mu:       &sync.RWMutex{},
+		},
+	},
+	RWMutex: &sync.RWMutex{},
 }
 
 var events = eventRegistry{
@@ -45,31 +54,54 @@ func GetItem(chipName string, offset int) (i *Item, err error) {
 }
 
 func RegisterChip(ctx context.Context, opts ...ChipOption) (chip *Chip, err error) {
-	options := &ChipOptions{}
+	options := &ChipOptions{consumer: "baagh"}
 	for _, co := range opts {
 		err = co.applyChipOption(options)
 		if err != nil {
 			return
 		}
 	}
-	c, err := gpiod.NewChip(options.name, gpiod.WithConsumer(options.consumer))
-	if err != nil {
+	if err = options.checkName(); err != nil {
 		return
 	}
+	if options.expander != nil && options.expander.kind == "" {
+		return nil, OptionError{Field: "expander", Value: options.expander}
+	}
+	if _, err = chips.Get(options.name); err == nil {
+		return nil, DuplicateChipError{Chip: options.name}
+	}
 	chip = &Chip{
-		chip:  c,
-		items: &itemRegistry{registry: map[int]*Item{}, RWMutex: &sync.RWMutex{}},
-		mu:    &sync.RWMutex{},
+		name:     options.name,
+		consumer: options.consumer,
+		items:    &itemRegistry{registry: map[int]*Item{}, RWMutex: &sync.RWMutex{}},
+		mu:       &sync.RWMutex{},
+	}
+	if options.expander != nil && options.sysfs != "" {
+		return nil, OptionError{Field: "sysfs", Value: options.sysfs}
+	}
+	if options.expander != nil {
+		chip.driver, err = newExpander(ctx, options.name, options.expander)
+		if i := options.expander.interrupt; i != nil {
+			chip.parent = i.Chip
+		}
+	} else if options.sysfs != "" {
+		chip.driver, err = newSysfsChip(options.sysfs, options.name)
+	} else {
+		chip.driver, err = newGpiodChip(options.name, options.consumer)
+	}
+	if err != nil {
+		return nil, err
 	}
 	err = chips.Append(options.name, chip)
 	if err != nil {
+		chip.driver.Close()
 		return nil, err
 	}
 	logger.Infof("chip %s registerd successfully by %s", options.name, options.consumer)
 	return
 }
 
-func RegisterItem(chip string, offset int, opts ...ItemOption) (item *Item, err error) {
+func RegisterItem(chip string, offset int, opts ...ItemOption) (handle *ItemHandle, err error) {
 	// get the chip
 	c, err := chips.Get(chip)
 	if err != nil {
@@ -79,6 +111,15 @@ func RegisterItem(chip string, offset int, opts ...ItemOption) (item *Item, err
 	return c.RegisterItem(offset, opts...)
 }
 
+// Items returns a snapshot of every registered item on every chip,
+// including the owners currently holding a handle to it
+func Items() (infos []ItemInfo) {
+	chips.ForEach(func(chipName string, chip *Chip) {
+		infos = append(infos, chip.Items()...)
+	})
+	return
+}
+
 func Subscribe(fns ...EventHandler) {
 	events.AddEventListener(fns...)
 }
@@ -112,8 +153,27 @@ func AddEventListener(chipName string, offset int, fns ...EventHandler) (err err
 }
 
 func Cleanup() (err error) {
+	// counters save their totals before their lines go away
+	for _, c := range Counters() {
+		err = multierr.Append(err, c.Close())
+	}
+	for _, p := range PWMs() {
+		err = multierr.Append(err, p.Close())
+	}
+	for _, b := range Blinkers() {
+		err = multierr.Append(err, b.Close())
+	}
+	// chips driven by the lines of others go first, while those lines
+	// can still turn their outputs off
 	chips.ForEach(func(chipName string, chip *Chip) {
-		err = multierr.Append(err, chip.Cleanup())
+		if chip.parent != "" {
+			err = multierr.Append(err, chip.Cleanup())
+		}
+	})
+	chips.ForEach(func(chipName string, chip *Chip) {
+		if chip.parent == "" {
+			err = multierr.Append(err, chip.Cleanup())
+		}
 	})
 	if err != nil {
 		logger.Errorf(err.Error())
@@ -124,87 +184,117 @@ func Cleanup() (err error) {
 }
 
 type Chip struct {
-	chip  *gpiod.Chip
-	items *itemRegistry
+	driver   chipDriver
+	name     string
+	consumer string
+	// virtual is only true for the chip of virtual items
+	virtual bool
+	// parent is the chip whose lines drive this one, e.g. for shift
+	// registers, it's empty for chips of their own
+	parent string
+	items  *itemRegistry
 
 	mu *sync.RWMutex
 }
 
-func (c *Chip) RegisterItem(offset int, opts ...ItemOption) (item *Item, err error) {
+// RegisterItem requests the line on offset for the owner set by WithOwner,
+// if the line is already registered by another owner the same line is shared
+// and a new handle is returned, as long as the requested options don't
+// conflict with the ones the line was requested with
+func (c *Chip) RegisterItem(offset int, opts ...ItemOption) (handle *ItemHandle, err error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	// apply options
-	options := &ItemOptions{}
+	options := &ItemOptions{owner: c.consumer}
 	for _, io := range opts {
 		err = io.applyItemOption(options)
 		if err != nil {
 			return nil, err
 		}
 	}
+	if err = options.io.mode.Check(); err != nil {
+		return nil, fmt.Errorf("you have to set the mode")
+	}
+	if c.virtual {
+		// virtual items can be both read and set by anyone
+		options.io.mode = Output
+	}
 
-	item, err = c.items.Get(offset)
-
-	if _, ok := err.(ItemNotFound); !ok {
-		// already exits, check if its of the same line direction
-		info, err := item.line.Info()
-		if err != nil {
+	item, err := c.items.Get(offset)
+	if err == nil {
+		// already exits, check if the requested options match the ones it's registered with
+		if err = item.checkOptions(options); err != nil {
 			return nil, err
 		}
-		if info.Config.Direction != gpiod.LineDirection(options.io.mode) {
-			return nil, fmt.Errorf("this item is already registered as %s", Mode(info.Config.Direction))
+		if owner := item.directOwner(); owner != "" {
+			return nil, DirectError{Chip: c.name, Offset: offset, Reason: fmt.Sprintf("%s switches it directly", owner)}
+		}
+		return item.addOwner(options.owner), nil
+	}
+	if _, ok := err.(ItemNotFound); !ok {
+		return nil, err
+	}
+
+	if options.io.mode == Output && options.state == Active && !c.virtual {
+		if err = interlocks.check(Line{Chip: c.name, Offset: offset}); err != nil {
+			return nil, err
 		}
-		item.incrOwner()
-		return item, nil
 	}
 
 	item = &Item{
-		line:  nil,
-		state: options.state,
+		line:      nil,
+		chip:      c.name,
+		offset:    offset,
+		virtual:   c.virtual,
+		mode:      options.io.mode,
+		pull:      options.io.pull,
+		activeLow: options.activeLow && !c.virtual,
+		initial:   options.state,
+		state:     options.state,
 		events: &eventRegistry{
 			events:  []EventHandler{},
 			RWMutex: &sync.RWMutex{},
 		},
-		ownerCount: 0,
-		mu:         &sync.RWMutex{},
+		owners: map[string]int{},
+		mu:     &sync.RWMutex{},
 	}
-	item.AddEventListener(func(event *ItemEvent) {
-		events.CallAll(event)
-	})
 
-	switch options.io.mode {
-	case Input:
-		handler := func(evt gpiod.LineEvent) {
-			switch evt.Type {
-			case gpiod.LineEventRisingEdge:
-				item.SetState(Active)
-			case gpiod.LineEventFallingEdge:
-				item.SetState(Inactive)
-			}
-		}
-		var l *gpiod.Line
-		l, err = c.chip.RequestLine(offset, gpiod.AsInput, gpiod.WithEventHandler(handler), gpiod.WithBothEdges)
-		if err != nil {
-			return nil, err
-		}
-		item.line = l
-	case Output:
-		var l *gpiod.Line
-		l, err = c.chip.RequestLine(offset, gpiod.AsOutput(int(options.state)))
+	config := lineConfig{
+		mode:    options.io.mode,
+		pull:    options.io.pull,
+		state:   options.state,
+		handler: item.onEdge,
+	}
+	if item.activeLow {
+		config.state = config.state.invert()
+		config.handler = invertEdges(item.onEdge)
+	}
+	item.line, err = c.driver.RequestLine(offset, config)
+	if err != nil {
+		return nil, err
+	}
+	if item.activeLow {
+		item.line = activeLowLine{item.line}
+	}
+	if options.io.mode == Input {
+		// inputs start with whatever the line is reading right now
+		var value int
+		value, err = item.line.Value()
 		if err != nil {
+			item.line.Close()
 			return nil, err
 		}
-		item.line = l
-	default:
-		return nil, fmt.Errorf("you have to set the mode")
+		item.state = State(value)
 	}
 
 	err = c.items.Add(offset, item)
 	if err != nil {
+		item.line.Close()
 		return nil, err
 	}
-	logger.Infof("item registerd on line %o as %s", offset, options.io.mode)
-	return
+	logger.Infof("item registerd on line %d of %s as %s", offset, c.name, options.io.mode)
+	return item.addOwner(options.owner), nil
 }
 
 func (c *Chip) GetItem(offset int) (i *Item, err error) {
@@ -213,58 +303,224 @@ func (c *Chip) GetItem(offset int) (i *Item, err error) {
 	return c.items.Get(offset)
 }
 
+// Items returns a snapshot of the items registered on this chip
+func (c *Chip) Items() (infos []ItemInfo) {
+	c.items.ForEach(func(offset int, item *Item) {
+		infos = append(infos, item.Info())
+	})
+	sort.Slice(infos, func(a, b int) bool {
+		return infos[a].Offset < infos[b].Offset
+	})
+	return
+}
+
+// Cleanup closes every line of the chip regardless of how many owners
+// are still holding them, and then the chip itself
 func (c *Chip) Cleanup() (err error) {
 	c.mu.Lock()
-	ir := c.items
-	multierr.Append(err, c.chip.Close())
-	chipName := c.chip.Name
-	c.mu.Unlock()
-	if err != nil {
-		logger.Errorf(err.Error())
-	}
-	ir.ForEach(func(offset int, item *Item) {
-		err = multierr.Append(err, item.Cleanup())
+	defer c.mu.Unlock()
+	c.items.ForEach(func(offset int, item *Item) {
+		c.items.Delete(offset)
+		err = multierr.Append(err, item.close())
 	})
+	err = multierr.Append(err, c.driver.Close())
 	if err != nil {
 		logger.Errorf(err.Error())
 	} else {
-		logger.Infof("%s is successfuly cleaned up", chipName)
+		logger.Infof("%s is successfuly cleaned up", c.name)
 	}
 	return
 }
 
 type Item struct {
-	line       *gpiod.Line
-	state      State
-	events     *eventRegistry
-	ownerCount int
+	line    lineDriver
+	chip    string
+	offset  int
+	virtual bool
+	mode    Mode
+	pull    Pull
+	// activeLow items are active while their line is low
+	activeLow bool
+	initial   State
+	state     State
+	events    *eventRegistry
+	// edges are called for every edge of an input before its state changes,
+	// edgeIDs are the ids of the handles that added them like in eventRegistry
+	edges   []EdgeHandler
+	edgeIDs []int
+	// owners maps each owner to the number of handles it holds
+	owners map[string]int
+	// direct is the owner that switches the line directly, if any
+	direct string
+	// handles counts the handles given out, they're identified by it
+	handles int
 
 	mu *sync.RWMutex
 }
 
-func (i *Item) Unregister() {
-	i.decrOwner()
+// ItemInfo is a snapshot of an item, used for introspection
+type ItemInfo struct {
+	Chip   string
+	Offset int
+	// Name is only set for virtual items
+	Name      string
+	Mode      Mode
+	Pull      Pull
+	ActiveLow bool
+	State     State
+	Owners    []string
+}
+
+func (i *Item) checkOptions(options *ItemOptions) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.virtual {
+		return nil
+	}
+	if options.io.mode != i.mode {
+		return ConflictError{Chip: i.chip, Offset: i.offset, Field: "mode", Current: i.mode, Requested: options.io.mode}
+	}
+	if options.io.pull != PullUnknown && options.io.pull != i.pull {
+		return ConflictError{Chip: i.chip, Offset: i.offset, Field: "pull", Current: i.pull, Requested: options.io.pull}
+	}
+	if options.activeLow != i.activeLow {
+		return ConflictError{Chip: i.chip, Offset: i.offset, Field: "active low", Current: i.activeLow, Requested: options.activeLow}
+	}
+	// the state of an input is dictated by the line, so only outputs can conflict
+	if i.mode == Output && options.stateSet && options.state != i.initial {
+		return ConflictError{Chip: i.chip, Offset: i.offset, Field: "state", Current: i.initial, Requested: options.state}
+	}
+	return nil
+}
+
+func (i *Item) addOwner(owner string) *ItemHandle {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.owners[owner] += 1
+	i.handles++
+	return &ItemHandle{
+		Item:  i,
+		id:    i.handles,
+		owner: owner,
+		mu:    &sync.RWMutex{},
+	}
+}
+
+// directOwner returns the owner that switches the line directly, it's
+// empty if nobody does
+func (i *Item) directOwner() string {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.direct
 }
 
-func (i *Item) incrOwner() {
+// release drops one handle of owner, the line is closed once the last
+// handle of the last owner is released
+func (i *Item) release(owner string) (err error) {
+	c, err := GetChip(i.chip)
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	i.mu.Lock()
+	if _, ok := i.owners[owner]; !ok {
+		i.mu.Unlock()
+		return NotOwnerError{Chip: i.chip, Offset: i.offset, Owner: owner}
+	}
+	i.owners[owner] -= 1
+	if i.owners[owner] == 0 {
+		delete(i.owners, owner)
+	}
+	last := len(i.owners) == 0
+	i.mu.Unlock()
+
+	if !last {
+		return
+	}
+	c.items.Delete(i.offset)
+	return i.close()
+}
+
+// Owners returns the sorted list of owners currently holding the item
+func (i *Item) Owners() []string {
 	i.mu.Lock()
 	defer i.mu.Unlock()
-	i.ownerCount += 1
-	if i.ownerCount == 0 {
-		i.Cleanup()
+	owners := make([]string, 0, len(i.owners))
+	for owner := range i.owners {
+		owners = append(owners, owner)
 	}
+	sort.Strings(owners)
+	return owners
 }
 
-func (i *Item) decrOwner() {
+func (i *Item) Info() ItemInfo {
+	owners := i.Owners()
 	i.mu.Lock()
 	defer i.mu.Unlock()
-	i.ownerCount -= 1
-	if i.ownerCount == 0 {
-		i.Cleanup()
+	var name string
+	if i.virtual {
+		name, _ = virtual.nameOf(i.offset)
+	}
+	return ItemInfo{
+		Chip:      i.chip,
+		Offset:    i.offset,
+		Name:      name,
+		Mode:      i.mode,
+		Pull:      i.pull,
+		ActiveLow: i.activeLow,
+		State:     i.state,
+		Owners:    owners,
+	}
+}
+
+func (i *Item) Chip() string {
+	return i.chip
+}
+
+func (i *Item) Offset() int {
+	return i.offset
+}
+
+func (i *Item) Mode() Mode {
+	return i.mode
+}
+
+func (i *Item) onEdge(edge Edge) {
+	i.mu.Lock()
+	edges := i.edges
+	i.mu.Unlock()
+	for _, fn := range edges {
+		fn(edge)
+	}
+	switch edge.Type {
+	case RisingEdge:
+		i.SetState(Active)
+	case FallingEdge:
+		i.SetState(Inactive)
 	}
 }
 
+// SetState changes the state of the item, outputs that are part of an
+// interlock are only changed if the interlock allows it
 func (i *Item) SetState(state State) (err error) {
+	return i.setState(state, "")
+}
+
+// setState is SetState on behalf of owner, which is passed on to the events
+func (i *Item) setState(state State, owner string) (err error) {
+	if direct := i.directOwner(); direct != "" {
+		return DirectError{Chip: i.chip, Offset: i.offset, Reason: fmt.Sprintf("%s switches it directly", direct)}
+	}
+	if i.mode == Output {
+		return interlocks.guard(i, state, owner)
+	}
+	return i.apply(state, owner)
+}
+
+// apply changes the state without going through the interlocks
+func (i *Item) apply(state State, owner string) (err error) {
 	i.mu.Lock()
 	iState := i.state
 	line := i.line
@@ -272,11 +528,7 @@ func (i *Item) SetState(state State) (err error) {
 	if iState == state {
 		return
 	}
-	info, err := line.Info()
-	if err != nil {
-		return
-	}
-	if info.Config.Direction == gpiod.LineDirectionOutput {
+	if i.mode == Output {
 		err = line.SetValue(int(state))
 		if err != nil {
 			return
@@ -288,12 +540,16 @@ func (i *Item) SetState(state State) (err error) {
 	i.mu.Unlock()
 
 	events.CallAll(&ItemEvent{
-		Item: i,
+		Item:  i,
+		State: state,
+		Owner: owner,
 	})
 	itemEvents.CallAll(&ItemEvent{
-		Item: i,
+		Item:  i,
+		State: state,
+		Owner: owner,
 	})
-	logger.Debugf("state changed to %s on line %o of chip %s", state, i.line.Offset(), i.line.Chip())
+	logger.Debugf("state changed to %s on line %d of chip %s", state, i.offset, i.chip)
 	return
 }
 
@@ -303,6 +559,22 @@ func (i *Item) State() State {
 	return i.state
 }
 
+// Read reads an input line right away instead of returning the state of
+// its last edge, for outputs it's the same as State
+func (i *Item) Read() (State, error) {
+	if i.mode != Input {
+		return i.State(), nil
+	}
+	i.mu.Lock()
+	line := i.line
+	i.mu.Unlock()
+	value, err := line.Value()
+	if err != nil {
+		return Inactive, err
+	}
+	return State(value), nil
+}
+
 func (i *Item) AddEventListener(fns ...EventHandler) (err error) {
 	i.mu.Lock()
 	defer i.mu.Unlock()
@@ -310,18 +582,52 @@ func (i *Item) AddEventListener(fns ...EventHandler) (err error) {
 	return
 }
 
-func (i *Item) Cleanup() (err error) {
+// AddEdgeListener adds listeners for the raw edges of an input, unlike
+// events they carry the kernel timestamp of the edge and are never dropped
+// or reordered, which is what decoders of timing based protocols need
+func (i *Item) AddEdgeListener(fns ...EdgeHandler) error {
+	return i.addEdgeListener(0, fns...)
+}
+
+func (i *Item) addEdgeListener(id int, fns ...EdgeHandler) error {
+	if i.mode != Input {
+		return EdgeError{Chip: i.chip, Offset: i.offset}
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.edges = append(append([]EdgeHandler{}, i.edges...), fns...)
+	for range fns {
+		i.edgeIDs = append(i.edgeIDs, id)
+	}
+	return nil
+}
+
+// removeListeners removes the event and edge listeners added by the handle
+// with id
+func (i *Item) removeListeners(id int) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.events.remove(id)
+	edges := make([]EdgeHandler, 0, len(i.edges))
+	edgeIDs := make([]int, 0, len(i.edgeIDs))
+	for index, fn := range i.edges {
+		if i.edgeIDs[index] != id {
+			edges = append(edges, fn)
+			edgeIDs = append(edgeIDs, i.edgeIDs[index])
+		}
+	}
+	i.edges, i.edgeIDs = edges, edgeIDs
+}
+
+func (i *Item) close() (err error) {
 	i.mu.Lock()
 	line := i.line
 	i.mu.Unlock()
-	c, err := GetChip(line.Chip())
-	if err != nil {
-		return
+	// virtual items keep their state so it can be restored on the next start
+	if i.mode == Output && !i.virtual {
+		err = multierr.Append(err, line.SetValue(int(Inactive)))
 	}
-	c.items.Delete(line.Offset())
-	line.SetValue(int(Inactive))
-	line.Close()
-	i = nil
-	logger.Infof("cleaned up item %o of %s", line.Offset(), line.Chip())
+	err = multierr.Append(err, line.Close())
+	logger.Infof("cleaned up item %d of %s", i.offset, i.chip)
 	return
 }
@@ -0,0 +1,315 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/controller/store"
+	"go.uber.org/multierr"
+)
+
+// saveInterval is how often the total of a counter that's counting is
+// persisted, at most this many seconds of pulses are lost on a crash
+const saveInterval = 10 * time.Second
+
+// rateTimeout is how long after the last pulse the rate of a counter drops
+// to 0 unless it's set with WithTiming
+const rateTimeout = 5 * time.Minute
+
+var counters = counterRegistry{registry: map[string]*Counter{}, RWMutex: &sync.RWMutex{}}
+
+// Counter counts the rising edges of an input, like the pulses of an S0
+// energy meter, a water meter or an anemometer. its total survives restarts
+type Counter struct {
+	name  string
+	input *ItemHandle
+	key   string
+
+	pulses uint64
+	// interval is the time between the last two pulses and last is the
+	// timestamp of the last one, both on the clock of the chip
+	interval time.Duration
+	last     time.Duration
+	// lastSeen is when the last pulse was counted, to let the rate decay
+	lastSeen time.Time
+	saving   *time.Timer
+	options  *CounterOptions
+
+	mu *sync.Mutex
+}
+
+type counterState struct {
+	Pulses uint64 `json:"pulses"`
+}
+
+// RegisterCounter starts counting the pulses on line offset of chip, the
+// count is restored from the store
+func RegisterCounter(name string, chip string, offset int, opts ...CounterOption) (c *Counter, err error) {
+	if name == "" {
+		return nil, OptionError{Field: "name", Value: name}
+	}
+	options := &CounterOptions{pull: PullDown, perUnit: 1, timeout: rateTimeout}
+	for _, opt := range opts {
+		err = opt.applyCounterOption(options)
+		if err != nil {
+			return
+		}
+	}
+	c = &Counter{
+		name:    name,
+		key:     counterKey(name),
+		options: options,
+		mu:      &sync.Mutex{},
+	}
+	var state counterState
+	err = db.Get(c.key, &state)
+	if _, ok := err.(store.NotFoundError); err != nil && !ok {
+		return nil, err
+	}
+	c.pulses = state.Pulses
+
+	c.input, err = RegisterItem(chip, offset, AsInput(options.pull), WithOwner("counter/"+name))
+	if err != nil {
+		return nil, err
+	}
+	if err = c.input.AddEdgeListener(c.edge); err != nil {
+		c.input.Release()
+		return nil, err
+	}
+	if err = counters.Add(c); err != nil {
+		c.input.Release()
+		return nil, err
+	}
+	logger.Infof("counter %s registered on line %d of %s, starting at %d pulses", name, offset, chip, c.pulses)
+	return c, nil
+}
+
+func (c *Counter) Name() string {
+	return c.name
+}
+
+// Unit is what the total of the counter is measured in, e.g. kWh
+func (c *Counter) Unit() string {
+	return c.options.unit
+}
+
+// Pulses returns how many pulses were counted so far
+func (c *Counter) Pulses() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pulses
+}
+
+// Total returns the count in units, e.g. kWh for an energy meter that
+// sends 1000 pulses per kWh
+func (c *Counter) Total() float64 {
+	return float64(c.Pulses()) / c.options.perUnit
+}
+
+// Rate returns the units per second based on the time between the last two
+// pulses, e.g. kWh/s for an energy meter. once the next pulse is overdue the
+// rate decays as if it came right now, and it drops to 0 after the timeout
+func (c *Counter) Rate() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.interval <= 0 {
+		return 0
+	}
+	since := time.Since(c.lastSeen)
+	if since > c.options.timeout {
+		return 0
+	}
+	interval := c.interval
+	if since > interval {
+		interval = since
+	}
+	return 1 / interval.Seconds() / c.options.perUnit
+}
+
+// Reset sets the count back to 0, e.g. after a meter was replaced
+func (c *Counter) Reset() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pulses = 0
+	c.interval = 0
+	return c.save()
+}
+
+// GetCounter returns the registered counter called name
+func GetCounter(name string) (*Counter, error) {
+	counters.RLock()
+	defer counters.RUnlock()
+	c, ok := counters.registry[name]
+	if !ok {
+		return nil, CounterNotFoundError{Name: name}
+	}
+	return c, nil
+}
+
+// Counters returns every registered counter sorted by name
+func Counters() (list []*Counter) {
+	counters.RLock()
+	defer counters.RUnlock()
+	for _, c := range counters.registry {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(a, b int) bool { return list[a].name < list[b].name })
+	return
+}
+
+// Close saves the count and stops counting
+func (c *Counter) Close() (err error) {
+	counters.Remove(c)
+	c.mu.Lock()
+	if c.saving != nil {
+		c.saving.Stop()
+		c.saving = nil
+	}
+	err = c.save()
+	c.mu.Unlock()
+	if !c.input.Released() {
+		err = multierr.Append(err, c.input.Release())
+	}
+	return
+}
+
+func (c *Counter) edge(edge Edge) {
+	if edge.Type != RisingEdge {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	since := edge.Timestamp - c.last
+	// a count restored from the store has no previous pulse to bounce off
+	if !c.lastSeen.IsZero() && since < c.options.debounce {
+		return
+	}
+	if !c.lastSeen.IsZero() {
+		c.interval = since
+	}
+	c.last = edge.Timestamp
+	c.lastSeen = time.Now()
+	c.pulses++
+	if c.saving == nil {
+		c.saving = time.AfterFunc(saveInterval, func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.saving = nil
+			if err := c.save(); err != nil {
+				logger.Errorf("couldn't save counter %s: %v", c.name, err)
+			}
+		})
+	}
+}
+
+// save must be called with mu locked
+func (c *Counter) save() error {
+	return db.Put(c.key, counterState{Pulses: c.pulses})
+}
+
+type counterRegistry struct {
+	registry map[string]*Counter
+	*sync.RWMutex
+}
+
+func (r *counterRegistry) Add(c *Counter) error {
+	r.Lock()
+	defer r.Unlock()
+	if _, ok := r.registry[c.name]; ok {
+		return DuplicateCounterError{Name: c.name}
+	}
+	r.registry[c.name] = c
+	return nil
+}
+
+func (r *counterRegistry) Remove(c *Counter) {
+	r.Lock()
+	defer r.Unlock()
+	if r.registry[c.name] == c {
+		delete(r.registry, c.name)
+	}
+}
+
+func counterKey(name string) string {
+	return "counter/" + name
+}
+
+type CounterOption interface {
+	applyCounterOption(*CounterOptions) error
+}
+
+type CounterOptions struct {
+	pull     Pull
+	perUnit  float64
+	unit     string
+	debounce time.Duration
+	timeout  time.Duration
+}
+
+func (i InputOption) applyCounterOption(o *CounterOptions) error {
+	o.pull = i.pull
+	return nil
+}
+
+type ScaleOption struct {
+	perUnit float64
+	unit    string
+}
+
+func (s ScaleOption) applyCounterOption(o *CounterOptions) error {
+	if s.perUnit <= 0 {
+		return OptionError{Field: "pulses per unit", Value: s.perUnit}
+	}
+	o.perUnit = s.perUnit
+	o.unit = s.unit
+	return nil
+}
+
+// WithScale sets how many pulses make a unit, e.g. 1000 pulses per kWh
+func WithScale(pulsesPerUnit float64, unit string) ScaleOption {
+	return ScaleOption{perUnit: pulsesPerUnit, unit: unit}
+}
+
+type TimingOption struct {
+	debounce time.Duration
+	timeout  time.Duration
+}
+
+func (t TimingOption) applyCounterOption(o *CounterOptions) error {
+	if t.debounce < 0 {
+		return OptionError{Field: "debounce", Value: t.debounce}
+	}
+	if t.timeout < 0 {
+		return OptionError{Field: "timeout", Value: t.timeout}
+	}
+	o.debounce = t.debounce
+	if t.timeout > 0 {
+		o.timeout = t.timeout
+	}
+	return nil
+}
+
+// WithTiming ignores pulses less than debounce after the previous one, like
+// the bounces of a reed switch, and reports a rate of 0 once there was no
+// pulse for timeout, which is 5 minutes if it's 0
+func WithTiming(debounce time.Duration, timeout time.Duration) TimingOption {
+	return TimingOption{debounce: debounce, timeout: timeout}
+}
+
+type DuplicateCounterError struct {
+	Name string
+}
+
+func (d DuplicateCounterError) Error() string {
+	return fmt.Sprintf("counter %s is already registered", d.Name)
+}
+
+type CounterNotFoundError struct {
+	Name string
+}
+
+func (c CounterNotFoundError) Error() string {
+	return fmt.Sprintf("there is no counter named %s", c.Name)
+}
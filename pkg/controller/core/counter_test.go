@@ -0,0 +1,154 @@
+package core
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/controller/internal/testutil"
+)
+
+// count feeds edges to c without waiting for it to save
+func count(c *Counter, edges ...Edge) {
+	for _, edge := range edges {
+		c.edge(edge)
+	}
+	if c.saving != nil {
+		c.saving.Stop()
+	}
+}
+
+func TestCounterDebounce(t *testing.T) {
+	const ms = time.Millisecond
+	for _, tt := range []struct {
+		name     string
+		restored uint64
+		edges    []Edge
+		pulses   uint64
+		interval time.Duration
+	}{
+		{
+			name:     "clean",
+			edges:    pulses(5*ms, 0, 100*ms, 200*ms),
+			pulses:   3,
+			interval: 100 * ms,
+		},
+		{
+			name:     "bouncing",
+			edges:    pulses(ms, 0, 2*ms, 4*ms, 100*ms, 103*ms, 200*ms),
+			pulses:   3,
+			interval: 100 * ms,
+		},
+		{
+			// the bounces don't push the next pulse away
+			name:     "bounces right before the debounce",
+			edges:    pulses(ms, 0, 9*ms, 10*ms),
+			pulses:   2,
+			interval: 10 * ms,
+		},
+		{
+			// the first pulse after a restart is counted however soon it comes
+			name:     "restored",
+			restored: 41,
+			edges:    pulses(ms, 3*ms, 5*ms),
+			pulses:   42,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Counter{pulses: tt.restored, options: &CounterOptions{perUnit: 1, debounce: 10 * ms, timeout: rateTimeout}, mu: &sync.Mutex{}}
+			count(c, tt.edges...)
+			if c.pulses != tt.pulses || c.interval != tt.interval {
+				t.Errorf("counted %d pulses %v apart, want %d %v apart", c.pulses, c.interval, tt.pulses, tt.interval)
+			}
+		})
+	}
+}
+
+func TestCounterRate(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		since time.Duration
+		want  float64
+	}{
+		{"right after a pulse", 0, 1.0 / 1000},
+		{"before the next pulse is due", 500 * time.Millisecond, 1.0 / 1000},
+		{"next pulse overdue", 4 * time.Second, 1.0 / 4 / 1000},
+		{"timed out", rateTimeout + time.Second, 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			// an energy meter with 1000 pulses per kWh sending a pulse a second
+			c := &Counter{options: &CounterOptions{perUnit: 1000, timeout: rateTimeout}, mu: &sync.Mutex{}}
+			count(c, Edge{Type: RisingEdge, Timestamp: 0}, Edge{Type: RisingEdge, Timestamp: time.Second})
+			c.lastSeen = time.Now().Add(-tt.since)
+			if got := c.Rate(); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("rate is %v kWh/s, want %v", got, tt.want)
+			}
+		})
+	}
+
+	c := &Counter{options: &CounterOptions{perUnit: 1, timeout: rateTimeout}, mu: &sync.Mutex{}}
+	count(c, Edge{Type: RisingEdge, Timestamp: 0})
+	if got := c.Rate(); got != 0 {
+		t.Errorf("rate is %v after a single pulse, want 0", got)
+	}
+}
+
+func TestCounterRestore(t *testing.T) {
+	device := testutil.NewPCF8574()
+	chip := fakeChip(t, device, AsPCF8574(1, 0x20))
+	name := chip + "-counter"
+	pulse := func(c *Counter, want uint64) {
+		t.Helper()
+		device.Pull(0, false)
+		testutil.Eventually(t, "the pulse being counted", func() bool { return c.Pulses() == want })
+		device.Pull(0, true)
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	c, err := RegisterCounter(name, chip, 0, WithScale(2, "l"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := uint64(1); n <= 3; n++ {
+		pulse(c, n)
+	}
+	if got := c.Total(); got != 1.5 {
+		t.Errorf("total is %v l, want 1.5", got)
+	}
+	if _, err = RegisterCounter(name, chip, 1); err == nil {
+		t.Error("a counter was registered twice")
+	}
+	if err = c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = GetCounter(name); err == nil {
+		t.Error("a closed counter is still registered")
+	}
+
+	// the count carries on where it was once the counter is registered again
+	c, err = RegisterCounter(name, chip, 0, WithScale(2, "l"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Pulses(); got != 3 {
+		t.Fatalf("counter restarted at %d pulses, want 3", got)
+	}
+	pulse(c, 4)
+	if err = c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	c, err = RegisterCounter(name, chip, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Pulses(); got != 0 {
+		t.Errorf("counter restarted at %d pulses after it was reset, want 0", got)
+	}
+	if err = c.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
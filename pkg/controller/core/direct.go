@@ -0,0 +1,65 @@
+package core
+
+import "fmt"
+
+// DirectLine switches an output without going through its item, so the
+// changes don't show up as events and don't flood the history. it's for
+// lines that change far too often to be events, like the rows of a
+// scanned keypad. the line belongs to a single owner while it's switched
+// directly, nobody else can register it and it can't be interlocked
+type DirectLine struct {
+	handle *ItemHandle
+	line   lineDriver
+}
+
+// Direct claims the output of h for switching it directly, h has to be the
+// only handle on the line and the line can't be part of an interlock
+func (h *ItemHandle) Direct() (*DirectLine, error) {
+	if h.Released() {
+		return nil, ReleasedError{Chip: h.chip, Offset: h.offset, Owner: h.owner}
+	}
+	if h.mode != Output || h.virtual {
+		return nil, DirectError{Chip: h.chip, Offset: h.offset, Reason: "only outputs of real chips can be switched directly"}
+	}
+	interlocks.RLock()
+	groups := interlocks.byLine[Line{Chip: h.chip, Offset: h.offset}]
+	interlocks.RUnlock()
+	if len(groups) > 0 {
+		return nil, DirectError{Chip: h.chip, Offset: h.offset, Reason: fmt.Sprintf("it's part of interlock %s", groups[0].name)}
+	}
+
+	h.Item.mu.Lock()
+	defer h.Item.mu.Unlock()
+	if len(h.owners) != 1 || h.owners[h.owner] != 1 {
+		return nil, DirectError{Chip: h.chip, Offset: h.offset, Reason: "it's shared with other handles"}
+	}
+	h.direct = h.owner
+	return &DirectLine{handle: h, line: h.line}, nil
+}
+
+// Set switches the line
+func (d *DirectLine) Set(state State) error {
+	if d.handle.Released() {
+		return ReleasedError{Chip: d.handle.chip, Offset: d.handle.offset, Owner: d.handle.owner}
+	}
+	return d.line.SetValue(int(state))
+}
+
+func (d *DirectLine) Released() bool {
+	return d.handle.Released()
+}
+
+// Release turns the line off and releases it
+func (d *DirectLine) Release() error {
+	return d.handle.Release()
+}
+
+type DirectError struct {
+	Chip   string
+	Offset int
+	Reason string
+}
+
+func (d DirectError) Error() string {
+	return fmt.Sprintf("line %d of %s can't be switched directly: %s", d.Offset, d.Chip, d.Reason)
+}
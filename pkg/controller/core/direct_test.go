@@ -0,0 +1,105 @@
+package core
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/controller/internal/testutil"
+)
+
+// fakeChip registers a chip that drives device, it does what coretest.Chip
+// does for the tests of core, which can't import it
+func fakeChip(t *testing.T, device I2CDevice, opts ...ChipOption) string {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	name := testutil.ChipName(t)
+	opts = append([]ChipOption{WithName(name), WithI2CDevice(device), WithPolling(time.Millisecond)}, opts...)
+	if _, err := RegisterChip(ctx, opts...); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestDirect(t *testing.T) {
+	device := testutil.NewPCF8574()
+	chip := fakeChip(t, device, AsPCF8574(1, 0x20))
+	h, err := RegisterItem(chip, 1, AsOutput(), WithState(Inactive), WithOwner("direct"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := 0
+	mu := &sync.Mutex{}
+	h.AddEventListener(func(*ItemEvent) {
+		mu.Lock()
+		events++
+		mu.Unlock()
+	})
+	d, err := h.Direct()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, state := range []State{Active, Inactive, Active} {
+		if err = d.Set(state); err != nil {
+			t.Fatal(err)
+		}
+		if device.High(1) != (state == Active) {
+			t.Errorf("line is high %v after switching it %s", device.High(1), state)
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	mu.Lock()
+	if events != 0 {
+		t.Errorf("switching the line directly made %d events", events)
+	}
+	mu.Unlock()
+
+	// nobody else gets to switch the line
+	if _, err = RegisterItem(chip, 1, AsOutput(), WithState(Inactive), WithOwner("other")); err == nil {
+		t.Error("a line that's switched directly was shared")
+	}
+	if err = h.SetState(Inactive); err == nil {
+		t.Error("a line that's switched directly was set through its item")
+	}
+	if _, err = RegisterInterlock(chip+"-interlock", WithLines(chip, 1, 2)); err == nil {
+		t.Error("a line that's switched directly was interlocked")
+	}
+
+	if err = d.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Set(Active); err == nil {
+		t.Error("a released line was switched")
+	}
+}
+
+func TestDirectRefused(t *testing.T) {
+	chip := fakeChip(t, testutil.NewPCF8574(), AsPCF8574(1, 0x20))
+	if _, err := RegisterInterlock(chip+"-interlock", WithLines(chip, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	interlocked, err := RegisterItem(chip, 0, AsOutput(), WithState(Inactive), WithOwner("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared, err := RegisterItem(chip, 2, AsOutput(), WithState(Inactive), WithOwner("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = RegisterItem(chip, 2, AsOutput(), WithState(Inactive), WithOwner("b")); err != nil {
+		t.Fatal(err)
+	}
+	input, err := RegisterItem(chip, 3, AsInput(PullUp), WithOwner("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for what, h := range map[string]*ItemHandle{"interlocked": interlocked, "shared": shared, "input": input} {
+		if _, err = h.Direct(); err == nil {
+			t.Errorf("the %s line is switched directly", what)
+		} else if _, ok := err.(DirectError); !ok {
+			t.Errorf("the %s line failed with %v, want a DirectError", what, err)
+		}
+	}
+}
@@ -0,0 +1,400 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/multierr"
+)
+
+const (
+	// triggerPulse is how long the trigger is held, the HC-SR04 needs at least 10µs
+	triggerPulse = 10 * time.Microsecond
+	// echoTimeout is the longest echo the HC-SR04 sends, about 38ms when
+	// there's nothing in range
+	echoTimeout = 40 * time.Millisecond
+)
+
+var distanceSensors = distanceRegistry{registry: map[string]*DistanceSensor{}, RWMutex: &sync.RWMutex{}}
+
+// DistanceSensor measures distances with an HC-SR04 style ultrasonic
+// sensor, it pulses the trigger output and times how long the echo input
+// stays active. the distance is the median of the last few measurements
+type DistanceSensor struct {
+	name    string
+	trigger *ItemHandle
+	echo    *ItemHandle
+	options *DistanceOptions
+	// temperature is in °C, the speed of sound depends on it
+	temperature float64
+
+	// waiting is set between the trigger pulse and the end of its echo
+	waiting bool
+	rise    time.Duration
+	echoes  chan time.Duration
+	// samples are the last measured distances in meters, oldest first
+	samples []float64
+	// threshold is the virtual item that's active while the distance is
+	// outside the thresholds
+	threshold *ItemHandle
+	// done stops measuring once the sensor is closed
+	done    chan struct{}
+	closing *sync.Once
+
+	mu *sync.Mutex
+}
+
+// RegisterDistanceSensor starts measuring with the sensor wired to the
+// trigger and echo lines of chip until it's closed or ctx is done
+func RegisterDistanceSensor(ctx context.Context, name string, chip string, trigger int, echo int, opts ...DistanceOption) (d *DistanceSensor, err error) {
+	if name == "" {
+		return nil, OptionError{Field: "name", Value: name}
+	}
+	options := &DistanceOptions{pull: PullDown, interval: 100 * time.Millisecond, samples: 5, temperature: 20, max: 4}
+	for _, opt := range opts {
+		err = opt.applyDistanceOption(options)
+		if err != nil {
+			return
+		}
+	}
+	d = &DistanceSensor{
+		name:        name,
+		options:     options,
+		temperature: options.temperature,
+		echoes:      make(chan time.Duration, 1),
+		done:        make(chan struct{}),
+		closing:     &sync.Once{},
+		mu:          &sync.Mutex{},
+	}
+	owner := "distance/" + name
+	d.trigger, err = RegisterItem(chip, trigger, AsOutput(), WithState(Inactive), WithOwner(owner))
+	if err != nil {
+		return nil, err
+	}
+	d.echo, err = RegisterItem(chip, echo, AsInput(options.pull), WithOwner(owner))
+	if err == nil && options.threshold != nil {
+		d.threshold, err = RegisterVirtualItem(options.threshold.name, WithOwner(owner), WithState(Inactive))
+	}
+	if err == nil {
+		err = d.echo.AddEdgeListener(d.edge)
+	}
+	if err == nil {
+		err = distanceSensors.Add(d)
+	}
+	if err != nil {
+		d.Close()
+		return nil, err
+	}
+
+	go func() {
+		ticker := time.NewTicker(options.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				d.measure()
+			case <-ctx.Done():
+				if err := d.Close(); err != nil {
+					logger.Errorf("couldn't close distance sensor %s: %v", name, err)
+				}
+				return
+			case <-d.done:
+				return
+			}
+		}
+	}()
+	logger.Infof("distance sensor %s registered on lines %d and %d of %s", name, trigger, echo, chip)
+	return d, nil
+}
+
+// GetDistanceSensor returns the registered distance sensor called name
+func GetDistanceSensor(name string) (*DistanceSensor, error) {
+	distanceSensors.RLock()
+	defer distanceSensors.RUnlock()
+	d, ok := distanceSensors.registry[name]
+	if !ok {
+		return nil, DistanceSensorNotFoundError{Name: name}
+	}
+	return d, nil
+}
+
+// DistanceSensors returns every registered distance sensor sorted by name
+func DistanceSensors() (list []*DistanceSensor) {
+	distanceSensors.RLock()
+	defer distanceSensors.RUnlock()
+	for _, d := range distanceSensors.registry {
+		list = append(list, d)
+	}
+	sort.Slice(list, func(a, b int) bool { return list[a].name < list[b].name })
+	return
+}
+
+func (d *DistanceSensor) Name() string {
+	return d.name
+}
+
+// Distance returns the median of the last measurements in meters, ok is
+// false while there's nothing in range or nothing was measured yet
+func (d *DistanceSensor) Distance() (distance float64, ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.samples) == 0 {
+		return 0, false
+	}
+	sorted := append([]float64{}, d.samples...)
+	sort.Float64s(sorted)
+	middle := len(sorted) / 2
+	distance = sorted[middle]
+	if len(sorted)%2 == 0 {
+		distance = (sorted[middle-1] + sorted[middle]) / 2
+	}
+	if math.IsInf(distance, 1) {
+		return 0, false
+	}
+	return distance, true
+}
+
+// SetTemperature updates the air temperature in °C the distances are
+// compensated for, e.g. from a thermometer next to the sensor
+func (d *DistanceSensor) SetTemperature(celsius float64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.temperature = celsius
+}
+
+// Close stops measuring and releases the trigger, the echo and the
+// threshold item
+func (d *DistanceSensor) Close() (err error) {
+	d.closing.Do(func() { close(d.done) })
+	distanceSensors.Remove(d)
+	for _, h := range []*ItemHandle{d.trigger, d.echo, d.threshold} {
+		if h != nil && !h.Released() {
+			err = multierr.Append(err, h.Release())
+		}
+	}
+	return
+}
+
+func (d *DistanceSensor) edge(edge Edge) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.waiting {
+		return
+	}
+	if edge.Type == RisingEdge {
+		d.rise = edge.Timestamp
+		return
+	}
+	if d.rise == 0 {
+		return
+	}
+	d.waiting = false
+	select {
+	case d.echoes <- edge.Timestamp - d.rise:
+	default:
+	}
+}
+
+// measure pulses the trigger and waits for the echo
+func (d *DistanceSensor) measure() {
+	d.mu.Lock()
+	d.waiting = true
+	d.rise = 0
+	d.mu.Unlock()
+
+	err := d.trigger.SetState(Active)
+	if err == nil {
+		time.Sleep(triggerPulse)
+		err = d.trigger.SetState(Inactive)
+	}
+	if err != nil {
+		logger.Errorf("distance sensor %s couldn't trigger: %v", d.name, err)
+		return
+	}
+
+	var distance float64
+	select {
+	case width := <-d.echoes:
+		d.mu.Lock()
+		distance = echoDistance(width, d.temperature)
+		d.mu.Unlock()
+	case <-time.After(echoTimeout):
+		d.mu.Lock()
+		d.waiting = false
+		d.mu.Unlock()
+		// nothing in range, the sensor never ended its echo
+		distance = math.Inf(1)
+	}
+
+	d.mu.Lock()
+	if distance > d.options.max {
+		// a missed echo is just another sample, the median drops it
+		distance = math.Inf(1)
+	}
+	d.samples = append(d.samples, distance)
+	if len(d.samples) > d.options.samples {
+		d.samples = d.samples[1:]
+	}
+	d.mu.Unlock()
+	d.check()
+}
+
+// check updates the threshold item, nothing in range counts as far away
+func (d *DistanceSensor) check() {
+	if d.threshold == nil {
+		return
+	}
+	t := d.options.threshold
+	distance, ok := d.Distance()
+	if !ok {
+		distance = math.Inf(1)
+	}
+	state := Inactive
+	if (t.below > 0 && distance < t.below) || (t.above > 0 && distance > t.above) {
+		state = Active
+	}
+	if d.threshold.State() == state {
+		return
+	}
+	logger.Infof("distance sensor %s measured %.3fm, %s is now %s", d.name, distance, t.name, state)
+	if err := d.threshold.SetState(state); err != nil {
+		logger.Errorf("distance sensor %s couldn't set %s: %v", d.name, t.name, err)
+	}
+}
+
+// echoDistance is how far in meters the sound of an echo that took width
+// at celsius traveled, it went there and back
+func echoDistance(width time.Duration, celsius float64) float64 {
+	return width.Seconds() * speedOfSound(celsius) / 2
+}
+
+// speedOfSound in dry air in m/s at celsius
+func speedOfSound(celsius float64) float64 {
+	return 331.3 + 0.606*celsius
+}
+
+type distanceRegistry struct {
+	registry map[string]*DistanceSensor
+	*sync.RWMutex
+}
+
+func (r *distanceRegistry) Add(d *DistanceSensor) error {
+	r.Lock()
+	defer r.Unlock()
+	if _, ok := r.registry[d.name]; ok {
+		return DuplicateDistanceSensorError{Name: d.name}
+	}
+	r.registry[d.name] = d
+	return nil
+}
+
+func (r *distanceRegistry) Remove(d *DistanceSensor) {
+	r.Lock()
+	defer r.Unlock()
+	if r.registry[d.name] == d {
+		delete(r.registry, d.name)
+	}
+}
+
+type DistanceOption interface {
+	applyDistanceOption(*DistanceOptions) error
+}
+
+type DistanceOptions struct {
+	pull        Pull
+	interval    time.Duration
+	samples     int
+	temperature float64
+	// max is the farthest distance in meters that's still trusted
+	max       float64
+	threshold *thresholdOptions
+}
+
+func (i InputOption) applyDistanceOption(o *DistanceOptions) error {
+	o.pull = i.pull
+	return nil
+}
+
+type SamplingOption struct {
+	interval time.Duration
+	samples  int
+}
+
+func (s SamplingOption) applyDistanceOption(o *DistanceOptions) error {
+	// the echoes of the previous measurement have to die out first
+	if s.interval < 60*time.Millisecond {
+		return OptionError{Field: "interval", Value: s.interval}
+	}
+	if s.samples < 1 {
+		return OptionError{Field: "samples", Value: s.samples}
+	}
+	o.interval = s.interval
+	o.samples = s.samples
+	return nil
+}
+
+// WithSampling measures every interval, which has to be at least 60ms, and
+// reports the median of the last samples measurements. it's every 100ms
+// and 5 samples by default
+func WithSampling(interval time.Duration, samples int) SamplingOption {
+	return SamplingOption{interval: interval, samples: samples}
+}
+
+type TemperatureOption float64
+
+func (t TemperatureOption) applyDistanceOption(o *DistanceOptions) error {
+	if t < -50 || t > 80 {
+		return OptionError{Field: "temperature", Value: float64(t)}
+	}
+	o.temperature = float64(t)
+	return nil
+}
+
+// WithTemperature sets the air temperature in °C distances are compensated
+// for until SetTemperature is called, it's 20°C by default
+func WithTemperature(celsius float64) TemperatureOption {
+	return TemperatureOption(celsius)
+}
+
+type RangeOption float64
+
+func (r RangeOption) applyDistanceOption(o *DistanceOptions) error {
+	if r <= 0 {
+		return OptionError{Field: "range", Value: float64(r)}
+	}
+	o.max = float64(r)
+	return nil
+}
+
+// WithRange drops measurements farther than max meters, the HC-SR04 is
+// specified up to 4m and that's the default
+func WithRange(max float64) RangeOption {
+	return RangeOption(max)
+}
+
+// the thresholds of distance sensors are in meters, e.g. to turn a pump on
+// when the water in a tank is farther than some distance from the sensor
+func (t ThresholdOption) applyDistanceOption(o *DistanceOptions) (err error) {
+	o.threshold, err = t.check()
+	return
+}
+
+type DuplicateDistanceSensorError struct {
+	Name string
+}
+
+func (d DuplicateDistanceSensorError) Error() string {
+	return fmt.Sprintf("distance sensor %s is already registered", d.Name)
+}
+
+type DistanceSensorNotFoundError struct {
+	Name string
+}
+
+func (d DistanceSensorNotFoundError) Error() string {
+	return fmt.Sprintf("there is no distance sensor named %s", d.Name)
+}
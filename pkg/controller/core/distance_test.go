@@ -0,0 +1,91 @@
+package core
+
+import (
+	"context"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/controller/internal/testutil"
+)
+
+func TestDistanceMedian(t *testing.T) {
+	inf := math.Inf(1)
+	for _, tt := range []struct {
+		name    string
+		samples []float64
+		want    float64
+		ok      bool
+	}{
+		{"nothing measured", nil, 0, false},
+		{"odd", []float64{1, 3, 2}, 2, true},
+		{"even", []float64{1, 10, 2, 3}, 2.5, true},
+		{"outlier", []float64{1.2, 1.1, 4, 1.3, 1.2}, 1.2, true},
+		// missed echoes are just samples far away, the median drops a few
+		{"missed echo", []float64{1, inf, 2}, 2, true},
+		{"out of range", []float64{inf, 1, inf}, 0, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &DistanceSensor{samples: tt.samples, mu: &sync.Mutex{}}
+			got, ok := d.Distance()
+			if ok != tt.ok || math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("distance is %v, %v, want %v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestEchoDistance(t *testing.T) {
+	for _, tt := range []struct {
+		width   time.Duration
+		celsius float64
+		want    float64
+	}{
+		{10 * time.Millisecond, 20, 1.7171},
+		{10 * time.Millisecond, 0, 1.6565},
+		{10 * time.Millisecond, -20, 1.59591},
+		{10 * time.Millisecond, 40, 1.7777},
+		{5823800 * time.Nanosecond, 20, 1},
+	} {
+		if got := echoDistance(tt.width, tt.celsius); math.Abs(got-tt.want) > 1e-4 {
+			t.Errorf("an echo of %v at %v°C is %.5fm away, want %.5fm", tt.width, tt.celsius, got, tt.want)
+		}
+	}
+}
+
+func TestDistanceSensorClose(t *testing.T) {
+	chip := fakeChip(t, testutil.NewPCF8574(), AsPCF8574(1, 0x20))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	near := chip + "-near"
+	d, err := RegisterDistanceSensor(ctx, chip+"-distance", chip, 1, 0, WithThreshold(near, 0.5, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = GetDistanceSensor(d.Name()); err == nil {
+		t.Error("a closed distance sensor is still registered")
+	}
+	for _, offset := range []int{0, 1} {
+		if _, err = GetItem(chip, offset); err == nil {
+			t.Errorf("line %d of a closed distance sensor is still registered", offset)
+		}
+	}
+	if _, err = GetVirtualItem(near); err == nil {
+		t.Error("the threshold of a closed distance sensor is still registered")
+	}
+
+	// the sensor is closed once ctx is done too
+	if _, err = RegisterDistanceSensor(ctx, chip+"-distance", chip, 3, 2); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	testutil.Eventually(t, "the distance sensor closing with its context", func() bool {
+		_, err2 := GetItem(chip, 2)
+		_, err3 := GetItem(chip, 3)
+		return err2 != nil && err3 != nil
+	})
+}
@@ -0,0 +1,88 @@
+package core
+
+import "time"
+
+// chipDriver is what a Chip talks to, every backend that can provide
+// lines implements it
+type chipDriver interface {
+	RequestLine(offset int, config lineConfig) (lineDriver, error)
+	Close() error
+}
+
+type lineDriver interface {
+	Value() (int, error)
+	SetValue(value int) error
+	Close() error
+}
+
+// activeLowLine inverts the value of a line, it's active while it's low
+type activeLowLine struct {
+	lineDriver
+}
+
+func (l activeLowLine) Value() (int, error) {
+	value, err := l.lineDriver.Value()
+	if err != nil {
+		return 0, err
+	}
+	return 1 - value, nil
+}
+
+func (l activeLowLine) SetValue(value int) error {
+	if value == 0 {
+		return l.lineDriver.SetValue(1)
+	}
+	return l.lineDriver.SetValue(0)
+}
+
+// invertEdges turns the edges of an active low line into the edges of
+// its state
+func invertEdges(handler func(Edge)) func(Edge) {
+	return func(edge Edge) {
+		switch edge.Type {
+		case RisingEdge:
+			edge.Type = FallingEdge
+		case FallingEdge:
+			edge.Type = RisingEdge
+		}
+		handler(edge)
+	}
+}
+
+type lineConfig struct {
+	mode  Mode
+	pull  Pull
+	state State
+	// handler is called on every edge of an input line
+	handler func(Edge)
+}
+
+type EdgeType int
+
+const (
+	_ EdgeType = iota
+	RisingEdge
+	FallingEdge
+)
+
+func (e EdgeType) String() string {
+	switch e {
+	case RisingEdge:
+		return "rising"
+	case FallingEdge:
+		return "falling"
+	default:
+		return "unknown"
+	}
+}
+
+// Edge is a change on an input line, Timestamp is only meant to
+// measure the time between edges of the same chip
+type Edge struct {
+	Type      EdgeType
+	Timestamp time.Duration
+}
+
+// EdgeHandler is called synchronously and in order for every edge of an
+// input, so it has to return quickly
+type EdgeHandler func(edge Edge)
@@ -0,0 +1,267 @@
+package core
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/multierr"
+)
+
+// transitions maps the previous and current levels of A and B, packed as
+// A<<1|B, to a quarter step. invalid transitions where both lines changed
+// at once are 0 since there's no telling which way the knob was turned
+var transitions = [4][4]int{
+	{0, -1, 1, 0},
+	{1, 0, 0, -1},
+	{-1, 0, 0, 1},
+	{0, 1, -1, 0},
+}
+
+// EncoderEvent is sent for every detent the knob is turned, Steps is
+// negative when it's turned counter clockwise and larger than one when
+// it's turned quickly with acceleration enabled
+type EncoderEvent struct {
+	Encoder *Encoder
+	Steps   int
+	// Count is the sum of every step so far
+	Count int64
+	Time  time.Time
+}
+
+type EncoderHandler func(event *EncoderEvent)
+
+// encoderBacklog is how many detents can wait for the handlers before
+// decoding waits for them
+const encoderBacklog = 64
+
+// Encoder decodes a quadrature rotary encoder wired to two inputs, a knob
+// with detents usually goes through a full cycle of 4 transitions per detent
+type Encoder struct {
+	name string
+	a, b *ItemHandle
+	// levels are the current levels of A and B packed as A<<1|B
+	levels int
+	// quarters are the transitions since the last detent
+	quarters int
+	count    int64
+	// last is the timestamp of the last detent and direction the way it
+	// was turned, for acceleration
+	last      time.Duration
+	direction int
+	options   *EncoderOptions
+	handlers  []EncoderHandler
+	// events are handed to a single goroutine, so handlers see the detents
+	// in the order they were turned
+	events  chan *EncoderEvent
+	done    chan struct{}
+	closing sync.Once
+
+	mu *sync.Mutex
+}
+
+// RegisterEncoder starts decoding the encoder called name with its A and B
+// pins wired to lines a and b of chip
+func RegisterEncoder(name string, chip string, a int, b int, opts ...EncoderOption) (e *Encoder, err error) {
+	options := &EncoderOptions{pull: PullUp, stepsPerDetent: 4}
+	for _, opt := range opts {
+		err = opt.applyEncoderOption(options)
+		if err != nil {
+			return
+		}
+	}
+	e = &Encoder{
+		name:    name,
+		options: options,
+		events:  make(chan *EncoderEvent, encoderBacklog),
+		done:    make(chan struct{}),
+		mu:      &sync.Mutex{},
+	}
+	owner := "encoder/" + name
+	e.a, err = RegisterItem(chip, a, AsInput(options.pull), WithOwner(owner))
+	if err != nil {
+		return nil, err
+	}
+	e.b, err = RegisterItem(chip, b, AsInput(options.pull), WithOwner(owner))
+	if err != nil {
+		e.a.Release()
+		return nil, err
+	}
+
+	levelA, err := e.a.Read()
+	if err != nil {
+		e.Close()
+		return nil, err
+	}
+	levelB, err := e.b.Read()
+	if err != nil {
+		e.Close()
+		return nil, err
+	}
+	e.levels = int(levelA)<<1 | int(levelB)
+
+	for bit, h := range map[int]*ItemHandle{1: e.a, 0: e.b} {
+		bit := bit
+		if err = h.AddEdgeListener(func(edge Edge) { e.edge(bit, edge) }); err != nil {
+			e.Close()
+			return nil, err
+		}
+	}
+	go e.dispatch()
+	logger.Infof("encoder %s registered on lines %d and %d of %s", name, a, b, chip)
+	return e, nil
+}
+
+func (e *Encoder) Name() string {
+	return e.name
+}
+
+// Count returns the sum of every step so far
+func (e *Encoder) Count() int64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.count
+}
+
+// Reset sets the count back to 0
+func (e *Encoder) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.count = 0
+}
+
+func (e *Encoder) AddEventListener(fns ...EncoderHandler) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.handlers = append(append([]EncoderHandler{}, e.handlers...), fns...)
+}
+
+// Close stops decoding and releases both lines
+func (e *Encoder) Close() (err error) {
+	e.closing.Do(func() { close(e.done) })
+	for _, h := range []*ItemHandle{e.a, e.b} {
+		if h != nil && !h.Released() {
+			err = multierr.Append(err, h.Release())
+		}
+	}
+	return
+}
+
+// edge is called for every edge of A (bit 1) or B (bit 0)
+func (e *Encoder) edge(bit int, edge Edge) {
+	e.mu.Lock()
+	levels := e.levels &^ (1 << bit)
+	if edge.Type == RisingEdge {
+		levels |= 1 << bit
+	}
+	e.quarters += transitions[e.levels][levels]
+	e.levels = levels
+
+	detents := e.quarters / e.options.stepsPerDetent
+	if detents == 0 {
+		e.mu.Unlock()
+		return
+	}
+	e.quarters -= detents * e.options.stepsPerDetent
+	steps := detents * e.accelerate(edge.Timestamp, detents > 0)
+	e.count += int64(steps)
+	event := &EncoderEvent{Encoder: e, Steps: steps, Count: e.count, Time: time.Now()}
+	e.mu.Unlock()
+
+	select {
+	case e.events <- event:
+	case <-e.done:
+	}
+}
+
+// dispatch calls the handlers for every detent in order until the encoder
+// is closed
+func (e *Encoder) dispatch() {
+	for {
+		select {
+		case event := <-e.events:
+			e.mu.Lock()
+			handlers := e.handlers
+			e.mu.Unlock()
+			for _, fn := range handlers {
+				fn(event)
+			}
+		case <-e.done:
+			return
+		}
+	}
+}
+
+// accelerate returns how many steps a detent at timestamp is worth, the
+// faster the knob is turned the more. turning it back never accelerates,
+// must be called with mu locked
+func (e *Encoder) accelerate(timestamp time.Duration, clockwise bool) int {
+	direction := map[bool]int{true: 1, false: -1}[clockwise]
+	since := timestamp - e.last
+	reversed := direction != e.direction
+	e.last, e.direction = timestamp, direction
+	max, threshold := e.options.maxAcceleration, e.options.threshold
+	if max <= 1 || reversed || since <= 0 || since >= threshold {
+		return 1
+	}
+	multiplier := int(threshold / since)
+	if multiplier > max {
+		return max
+	}
+	return multiplier
+}
+
+type EncoderOption interface {
+	applyEncoderOption(*EncoderOptions) error
+}
+
+type EncoderOptions struct {
+	pull           Pull
+	stepsPerDetent int
+	// detents closer than threshold are multiplied by up to maxAcceleration
+	threshold       time.Duration
+	maxAcceleration int
+}
+
+func (i InputOption) applyEncoderOption(o *EncoderOptions) error {
+	o.pull = i.pull
+	return nil
+}
+
+type DetentOption int
+
+func (d DetentOption) applyEncoderOption(o *EncoderOptions) error {
+	if d != 1 && d != 2 && d != 4 {
+		return OptionError{Field: "steps per detent", Value: int(d)}
+	}
+	o.stepsPerDetent = int(d)
+	return nil
+}
+
+// WithStepsPerDetent sets how many transitions the encoder goes through
+// between two detents, it's 4 by default and either 1, 2 or 4
+func WithStepsPerDetent(steps int) DetentOption {
+	return DetentOption(steps)
+}
+
+type AccelerationOption struct {
+	threshold time.Duration
+	max       int
+}
+
+func (a AccelerationOption) applyEncoderOption(o *EncoderOptions) error {
+	if a.threshold <= 0 {
+		return OptionError{Field: "threshold", Value: a.threshold}
+	}
+	if a.max < 1 {
+		return OptionError{Field: "max acceleration", Value: a.max}
+	}
+	o.threshold = a.threshold
+	o.maxAcceleration = a.max
+	return nil
+}
+
+// WithAcceleration makes detents less than threshold apart count for more
+// than one step, a detent turned in threshold/n counts for n steps up to max
+func WithAcceleration(threshold time.Duration, max int) AccelerationOption {
+	return AccelerationOption{threshold: threshold, max: max}
+}
@@ -0,0 +1,85 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/controller/internal/testutil"
+)
+
+// turn turns the knob wired to lines 0 (A) and 1 (B) of device by detents,
+// clockwise A falls first and counter clockwise B does
+func turn(device *testutil.PCF8574, detents int) {
+	first, second := 0, 1
+	if detents < 0 {
+		first, second, detents = 1, 0, -detents
+	}
+	for i := 0; i < detents; i++ {
+		for _, step := range []struct {
+			line int
+			high bool
+		}{{first, false}, {second, false}, {first, true}, {second, true}} {
+			device.Pull(step.line, step.high)
+			// every level has to be polled on its own
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	device := testutil.NewPCF8574()
+	chip := fakeChip(t, device, AsPCF8574(1, 0x20))
+	e, err := RegisterEncoder("test", chip, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	counts := make(chan int64, 20)
+	e.AddEventListener(func(event *EncoderEvent) {
+		// a slow handler mustn't let later detents overtake earlier ones
+		time.Sleep(2 * time.Millisecond)
+		if event.Steps != 1 && event.Steps != -1 {
+			t.Errorf("a detent was %d steps", event.Steps)
+		}
+		counts <- event.Count
+	})
+
+	turn(device, 5)
+	turn(device, -3)
+	for _, want := range []int64{1, 2, 3, 4, 5, 4, 3, 2} {
+		select {
+		case got := <-counts:
+			if got != want {
+				t.Errorf("count is %d, want %d", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no detent for count %d", want)
+		}
+	}
+	if got := e.Count(); got != 2 {
+		t.Errorf("count is %d after turning 5 forward and 3 back, want 2", got)
+	}
+}
+
+func TestEncoderAcceleration(t *testing.T) {
+	e := &Encoder{options: &EncoderOptions{threshold: 100 * time.Millisecond, maxAcceleration: 4}}
+	tests := []struct {
+		at        time.Duration
+		clockwise bool
+		want      int
+	}{
+		{time.Second, true, 1},
+		// 50ms after the last detent is twice as fast as the threshold
+		{time.Second + 50*time.Millisecond, true, 2},
+		{time.Second + 60*time.Millisecond, true, 4},
+		{time.Second + 200*time.Millisecond, true, 1},
+		// turning it back never accelerates
+		{time.Second + 210*time.Millisecond, false, 1},
+		{time.Second + 240*time.Millisecond, false, 3},
+	}
+	for _, tt := range tests {
+		if got := e.accelerate(tt.at, tt.clockwise); got != tt.want {
+			t.Errorf("detent at %s is %d steps, want %d", tt.at, got, tt.want)
+		}
+	}
+}
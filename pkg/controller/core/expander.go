@@ -0,0 +1,510 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	MCP23017 = "mcp23017"
+	PCF8574  = "pcf8574"
+
+	// defaultPolling is how often expanders without an interrupt line are
+	// read, fast enough for buttons and contacts
+	defaultPolling = 20 * time.Millisecond
+	// defaultFallback is how often expanders with an interrupt line are
+	// read anyway. the interrupt stays low until the inputs are read, so a
+	// missed falling edge would otherwise leave it stuck for good
+	defaultFallback = time.Second
+)
+
+// expanderModel is what differs between expanders, masks have a bit for
+// every line with line 0 as the least significant one
+type expanderModel interface {
+	lines() int
+	// supports reports whether inputs can be pulled with p
+	supports(p Pull) bool
+	// setup prepares the expander once before any line is requested
+	setup(dev I2CDevice) error
+	// configure sets the direction and pull ups of every line, which of the
+	// requested inputs interrupt on a change and the values of the outputs
+	configure(dev I2CDevice, l expanderLines) error
+	// write only sets the values of the outputs
+	write(dev I2CDevice, l expanderLines) error
+	read(dev I2CDevice) (uint16, error)
+}
+
+// expander drives an I2C gpio expander as a chip. its inputs are read when
+// it pulls its interrupt line low and polled, only slowly if there's an
+// interrupt line
+type expander struct {
+	name   string
+	device I2CDevice
+	model  expanderModel
+	// interrupt is the input of another chip the expander's interrupt
+	// output is wired to, it's nil if the expander is polled
+	interrupt *ItemHandle
+	lines     expanderLines
+	// handlers are called for the edges of the requested inputs
+	handlers map[int]func(Edge)
+	// last is what the inputs read the last time
+	last uint16
+	// start is what the timestamps of edges are relative to
+	start   time.Time
+	done    chan struct{}
+	closing sync.Once
+
+	mu *sync.Mutex
+}
+
+func newExpander(ctx context.Context, name string, options *expanderOptions) (e *expander, err error) {
+	e = &expander{
+		name:     name,
+		device:   options.device,
+		handlers: map[int]func(Edge){},
+		start:    time.Now(),
+		done:     make(chan struct{}),
+		mu:       &sync.Mutex{},
+	}
+	switch options.kind {
+	case MCP23017:
+		e.model = mcp23017{}
+	case PCF8574:
+		e.model = pcf8574{}
+	default:
+		return nil, OptionError{Field: "expander", Value: options.kind}
+	}
+	if e.device == nil {
+		e.device, err = openI2C(options.bus, options.address)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err = e.model.setup(e.device); err == nil {
+		err = e.model.configure(e.device, expanderLines{})
+	}
+	if err == nil {
+		e.last, err = e.model.read(e.device)
+	}
+	if err != nil {
+		e.device.Close()
+		return nil, err
+	}
+
+	if options.interrupt != nil {
+		e.interrupt, err = RegisterItem(options.interrupt.Chip, options.interrupt.Offset, AsInput(PullUp), WithOwner("expander/"+name))
+		if err == nil {
+			err = e.interrupt.AddEdgeListener(func(edge Edge) {
+				// the interrupt output is active low
+				if edge.Type == FallingEdge {
+					e.poll()
+				}
+			})
+		}
+		if err != nil {
+			e.Close()
+			return nil, err
+		}
+		// the interrupt may have gone low before there was anyone to
+		// notice, reading clears it
+		e.poll()
+	}
+	polling := options.polling
+	if polling == 0 {
+		polling = defaultPolling
+		if e.interrupt != nil {
+			polling = defaultFallback
+		}
+	}
+	go func() {
+		ticker := time.NewTicker(polling)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				e.mu.Lock()
+				idle := len(e.handlers) == 0
+				e.mu.Unlock()
+				if !idle {
+					e.poll()
+				}
+			case <-e.done:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return e, nil
+}
+
+func (e *expander) RequestLine(offset int, config lineConfig) (lineDriver, error) {
+	if offset < 0 || offset >= e.model.lines() {
+		return nil, OffsetError{Chip: e.name, Offset: offset, Lines: e.model.lines()}
+	}
+	bit := uint16(1) << offset
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	// expanders can only pull their inputs up. an input that's meant to be
+	// pulled down, with its switch to the supply, is pulled up instead with
+	// its switch to ground and read inverted, so it's still active while
+	// the switch is closed
+	pull, handler := config.pull, config.handler
+	inverted := config.mode == Input && pull == PullDown && !e.model.supports(PullDown)
+	if inverted {
+		pull, handler = PullUp, invertEdges(config.handler)
+	}
+	l := e.lines
+	if config.mode == Output {
+		l.outputs |= bit
+		l.values = setBit(l.values, bit, config.state == Active)
+	} else {
+		if pull != PullUnknown && !e.model.supports(pull) {
+			return nil, PullError{Chip: e.name, Offset: offset, Pull: pull}
+		}
+		l.inputs |= bit
+		l.pullups = setBit(l.pullups, bit, pull == PullUp)
+	}
+	if err := e.model.configure(e.device, l); err != nil {
+		return nil, err
+	}
+	e.lines = l
+	if config.mode == Input {
+		value, err := e.model.read(e.device)
+		if err != nil {
+			return nil, err
+		}
+		// only this line starts over, the others may have pending edges
+		e.last = e.last&^bit | value&bit
+		e.handlers[offset] = handler
+	}
+	line := &expanderLine{expander: e, offset: offset, bit: bit}
+	if inverted {
+		return activeLowLine{line}, nil
+	}
+	return line, nil
+}
+
+// poll reads the inputs and calls the handlers of the ones that changed
+func (e *expander) poll() {
+	e.mu.Lock()
+	value, err := e.model.read(e.device)
+	if err != nil {
+		e.mu.Unlock()
+		logger.Errorf("expander %s couldn't read its inputs: %v", e.name, err)
+		return
+	}
+	changed := (value ^ e.last) & e.lines.inputs
+	e.last = value
+	timestamp := time.Since(e.start)
+	type call struct {
+		handler func(Edge)
+		edge    Edge
+	}
+	var calls []call
+	for offset := 0; offset < e.model.lines(); offset++ {
+		bit := uint16(1) << offset
+		handler, ok := e.handlers[offset]
+		if changed&bit == 0 || !ok {
+			continue
+		}
+		edge := Edge{Type: FallingEdge, Timestamp: timestamp}
+		if value&bit != 0 {
+			edge.Type = RisingEdge
+		}
+		calls = append(calls, call{handler: handler, edge: edge})
+	}
+	e.mu.Unlock()
+	for _, c := range calls {
+		c.handler(c.edge)
+	}
+}
+
+// Close stops reading the inputs and releases the interrupt line
+func (e *expander) Close() (err error) {
+	e.closing.Do(func() { close(e.done) })
+	if e.interrupt != nil && !e.interrupt.Released() {
+		err = e.interrupt.Release()
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if cerr := e.device.Close(); err == nil {
+		err = cerr
+	}
+	return
+}
+
+// expanderLine is a single line of an expander
+type expanderLine struct {
+	expander *expander
+	offset   int
+	bit      uint16
+}
+
+func (l *expanderLine) Value() (int, error) {
+	e := l.expander
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.lines.outputs&l.bit != 0 {
+		return bitValue(e.lines.values, l.bit), nil
+	}
+	value, err := e.model.read(e.device)
+	if err != nil {
+		return 0, err
+	}
+	return bitValue(value, l.bit), nil
+}
+
+func (l *expanderLine) SetValue(value int) error {
+	e := l.expander
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	lines := e.lines
+	lines.values = setBit(lines.values, l.bit, value != 0)
+	if err := e.model.write(e.device, lines); err != nil {
+		return err
+	}
+	e.lines = lines
+	return nil
+}
+
+// Close turns the line back into an input without a pull up, which is how
+// expanders start
+func (l *expanderLine) Close() error {
+	e := l.expander
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.handlers, l.offset)
+	e.lines.inputs &^= l.bit
+	e.lines.outputs &^= l.bit
+	e.lines.values &^= l.bit
+	e.lines.pullups &^= l.bit
+	return e.model.configure(e.device, e.lines)
+}
+
+// expanderLines are masks with a bit for every line of an expander
+type expanderLines struct {
+	// inputs and outputs are the requested lines, the rest are inputs
+	// that nobody reads
+	inputs  uint16
+	outputs uint16
+	// values are the values of the outputs
+	values uint16
+	// pullups are the inputs that are pulled up
+	pullups uint16
+}
+
+func setBit(mask uint16, bit uint16, on bool) uint16 {
+	if on {
+		return mask | bit
+	}
+	return mask &^ bit
+}
+
+func bitValue(mask uint16, bit uint16) int {
+	if mask&bit != 0 {
+		return 1
+	}
+	return 0
+}
+
+// registers of the MCP23017 in its default bank 0 layout, the ones of port
+// B follow the ones of port A so both are written at once
+const (
+	mcpIODIR   = 0x00
+	mcpGPINTEN = 0x04
+	mcpIOCON   = 0x0a
+	mcpGPPU    = 0x0c
+	mcpGPIO    = 0x12
+	mcpOLAT    = 0x14
+
+	// mcpMirror ties the interrupt outputs of both ports together, so a
+	// single interrupt line is enough
+	mcpMirror = 0x40
+)
+
+// mcp23017 has 16 lines with optional pull ups, 0 to 7 are port A and 8
+// to 15 port B. every input interrupts when it changes
+type mcp23017 struct{}
+
+func (mcp23017) lines() int {
+	return 16
+}
+
+func (mcp23017) supports(p Pull) bool {
+	return p == PullUp || p == PullDisabled
+}
+
+func (mcp23017) setup(dev I2CDevice) error {
+	return dev.Tx([]byte{mcpIOCON, mcpMirror}, nil)
+}
+
+func (m mcp23017) configure(dev I2CDevice, l expanderLines) error {
+	// the outputs get their values before they turn into outputs
+	if err := m.write(dev, l); err != nil {
+		return err
+	}
+	directions := ^l.outputs
+	for _, w := range [][]byte{
+		{mcpGPPU, byte(l.pullups), byte(l.pullups >> 8)},
+		{mcpGPINTEN, byte(l.inputs), byte(l.inputs >> 8)},
+		{mcpIODIR, byte(directions), byte(directions >> 8)},
+	} {
+		if err := dev.Tx(w, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (mcp23017) write(dev I2CDevice, l expanderLines) error {
+	return dev.Tx([]byte{mcpOLAT, byte(l.values), byte(l.values >> 8)}, nil)
+}
+
+// read also clears the interrupt
+func (mcp23017) read(dev I2CDevice) (uint16, error) {
+	r := make([]byte, 2)
+	if err := dev.Tx([]byte{mcpGPIO}, r); err != nil {
+		return 0, err
+	}
+	return uint16(r[0]) | uint16(r[1])<<8, nil
+}
+
+// pcf8574 has 8 quasi bidirectional lines, an input is a line that's
+// written high so its weak pull up can be pulled low from outside. it
+// interrupts on any change of its lines
+type pcf8574 struct{}
+
+func (pcf8574) lines() int {
+	return 8
+}
+
+func (pcf8574) supports(p Pull) bool {
+	return p == PullUp
+}
+
+func (pcf8574) setup(dev I2CDevice) error {
+	return nil
+}
+
+func (p pcf8574) configure(dev I2CDevice, l expanderLines) error {
+	return p.write(dev, l)
+}
+
+func (pcf8574) write(dev I2CDevice, l expanderLines) error {
+	return dev.Tx([]byte{byte(l.values | ^l.outputs)}, nil)
+}
+
+func (pcf8574) read(dev I2CDevice) (uint16, error) {
+	r := make([]byte, 1)
+	if err := dev.Tx(nil, r); err != nil {
+		return 0, err
+	}
+	return uint16(r[0]), nil
+}
+
+type expanderOptions struct {
+	kind    string
+	bus     int
+	address uint16
+	// device is used instead of opening the bus if it's set
+	device    I2CDevice
+	interrupt *Line
+	// polling is 0 for the default of polling or falling back
+	polling time.Duration
+}
+
+func (o *ChipOptions) expanderOptions() *expanderOptions {
+	if o.expander == nil {
+		o.expander = &expanderOptions{}
+	}
+	return o.expander
+}
+
+type ExpanderOption struct {
+	kind    string
+	bus     int
+	address uint16
+}
+
+func (e ExpanderOption) applyChipOption(o *ChipOptions) error {
+	if e.bus < 0 || e.address < 0x03 || e.address > 0x77 {
+		return OptionError{Field: "expander", Value: e}
+	}
+	options := o.expanderOptions()
+	options.kind, options.bus, options.address = e.kind, e.bus, e.address
+	return nil
+}
+
+// AsMCP23017 makes the chip the MCP23017 at address on /dev/i2c-bus
+func AsMCP23017(bus int, address uint16) ExpanderOption {
+	return ExpanderOption{kind: MCP23017, bus: bus, address: address}
+}
+
+// AsPCF8574 makes the chip the PCF8574 at address on /dev/i2c-bus
+func AsPCF8574(bus int, address uint16) ExpanderOption {
+	return ExpanderOption{kind: PCF8574, bus: bus, address: address}
+}
+
+type I2CDeviceOption struct {
+	device I2CDevice
+}
+
+func (d I2CDeviceOption) applyChipOption(o *ChipOptions) error {
+	if d.device == nil {
+		return OptionError{Field: "device", Value: d.device}
+	}
+	o.expanderOptions().device = d.device
+	return nil
+}
+
+// WithI2CDevice makes an expander talk to device instead of opening its
+// bus, e.g. to drive a simulated expander
+func WithI2CDevice(device I2CDevice) I2CDeviceOption {
+	return I2CDeviceOption{device: device}
+}
+
+type InterruptOption Line
+
+func (i InterruptOption) applyChipOption(o *ChipOptions) error {
+	if i.Chip == "" || i.Offset < 0 {
+		return OptionError{Field: "interrupt", Value: Line(i)}
+	}
+	o.expanderOptions().interrupt = &Line{Chip: i.Chip, Offset: i.Offset}
+	return nil
+}
+
+// WithInterrupt reads the inputs of an expander whenever its interrupt
+// output, wired to line offset of chip, goes low. they're still polled
+// once a second in case an interrupt is missed
+func WithInterrupt(chip string, offset int) InterruptOption {
+	return InterruptOption{Chip: chip, Offset: offset}
+}
+
+type PollingOption time.Duration
+
+func (p PollingOption) applyChipOption(o *ChipOptions) error {
+	if p <= 0 {
+		return OptionError{Field: "polling", Value: time.Duration(p)}
+	}
+	o.expanderOptions().polling = time.Duration(p)
+	return nil
+}
+
+// WithPolling sets how often the inputs of an expander are read, it's
+// every 20ms by default and every second with an interrupt line
+func WithPolling(interval time.Duration) PollingOption {
+	return PollingOption(interval)
+}
+
+type PullError struct {
+	Chip   string
+	Offset int
+	Pull   Pull
+}
+
+func (p PullError) Error() string {
+	return fmt.Sprintf("line %d of %s can't be pulled %s", p.Offset, p.Chip, p.Pull)
+}
@@ -0,0 +1,288 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/controller/internal/testutil"
+)
+
+// fakeMCP23017 simulates the registers of an MCP23017 in bank 0, the
+// lines are either driven from outside, pulled up or floating, which
+// reads low. INT goes low when an input that interrupts changes and back
+// high once the inputs are read
+type fakeMCP23017 struct {
+	regs [0x16]byte
+	// driven are the inputs driven from outside and levels what to
+	driven uint16
+	levels uint16
+	// interrupt is true while INT is low
+	interrupt bool
+	// onInterrupt is called whenever INT changes, with true when it goes low
+	onInterrupt func(low bool)
+	// miss keeps the next interrupt from reaching onInterrupt
+	miss bool
+	mu   *sync.Mutex
+}
+
+func newFakeMCP23017() *fakeMCP23017 {
+	m := &fakeMCP23017{mu: &sync.Mutex{}}
+	// every line starts as an input
+	m.regs[mcpIODIR], m.regs[mcpIODIR+1] = 0xff, 0xff
+	return m
+}
+
+// word must be called with mu locked
+func (m *fakeMCP23017) word(reg byte) uint16 {
+	return uint16(m.regs[reg]) | uint16(m.regs[reg+1])<<8
+}
+
+// pins must be called with mu locked
+func (m *fakeMCP23017) pins() (value uint16) {
+	inputs, latch, pullups := m.word(mcpIODIR), m.word(mcpOLAT), m.word(mcpGPPU)
+	for line := 0; line < 16; line++ {
+		bit := uint16(1) << line
+		switch {
+		case inputs&bit == 0:
+			value |= latch & bit
+		case m.driven&bit != 0:
+			value |= m.levels & bit
+		default:
+			value |= pullups & bit
+		}
+	}
+	return
+}
+
+func (m *fakeMCP23017) Tx(w []byte, r []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(w) == 0 || int(w[0])+len(w)-1+len(r) > len(m.regs) {
+		return fmt.Errorf("invalid transfer of %v", w)
+	}
+	reg := w[0]
+	for i, b := range w[1:] {
+		m.regs[int(reg)+i] = b
+	}
+	pins := m.pins()
+	for i := range r {
+		switch int(reg) + i {
+		case mcpGPIO:
+			r[i] = byte(pins)
+			m.clear()
+		case mcpGPIO + 1:
+			r[i] = byte(pins >> 8)
+			m.clear()
+		default:
+			r[i] = m.regs[int(reg)+i]
+		}
+	}
+	return nil
+}
+
+func (m *fakeMCP23017) Close() error {
+	return nil
+}
+
+// clear lets INT go back high, must be called with mu locked
+func (m *fakeMCP23017) clear() {
+	if !m.interrupt {
+		return
+	}
+	m.interrupt = false
+	if m.onInterrupt != nil {
+		m.onInterrupt(false)
+	}
+}
+
+// drive drives line high or low from outside
+func (m *fakeMCP23017) drive(line int, high bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	before := m.pins()
+	bit := uint16(1) << line
+	m.driven |= bit
+	m.levels = setBit(m.levels, bit, high)
+	if (before^m.pins())&m.word(mcpGPINTEN) == 0 || m.interrupt {
+		return
+	}
+	m.interrupt = true
+	if m.miss {
+		m.miss = false
+		return
+	}
+	if m.onInterrupt != nil {
+		m.onInterrupt(true)
+	}
+}
+
+func (m *fakeMCP23017) missNext() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.miss = true
+}
+
+func (m *fakeMCP23017) interrupting() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.interrupt
+}
+
+func (m *fakeMCP23017) register(reg byte) uint16 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.word(reg)
+}
+
+// wired wires the INT output of device to line 0 of a simulated PCF8574
+// and returns the chip of the PCF8574
+func wired(t *testing.T, device *fakeMCP23017) string {
+	t.Helper()
+	host := testutil.NewPCF8574()
+	device.onInterrupt = func(low bool) { host.Pull(0, !low) }
+	return fakeChip(t, host, AsPCF8574(1, 0x21))
+}
+
+func TestMCP23017(t *testing.T) {
+	device := newFakeMCP23017()
+	chip := fakeChip(t, device, AsMCP23017(1, 0x20))
+	if device.register(mcpIOCON)&mcpMirror == 0 {
+		t.Error("the interrupt outputs aren't mirrored")
+	}
+	output, err := RegisterItem(chip, 9, AsOutput(), WithState(Active), WithOwner("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lowOutput, err := RegisterItem(chip, 10, AsOutput(), WithState(Active), AsActiveLow(), WithOwner("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pulledUp, err := RegisterItem(chip, 2, AsInput(PullUp), WithOwner("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	floating, err := RegisterItem(chip, 3, AsInput(PullDisabled), WithOwner("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pulledDown, err := RegisterItem(chip, 4, AsInput(PullDown), WithOwner("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := device.register(mcpIODIR), ^uint16(1<<9|1<<10); got != want {
+		t.Errorf("directions are %016b, want %016b", got, want)
+	}
+	if got, want := device.register(mcpGPPU), uint16(1<<2|1<<4); got != want {
+		t.Errorf("pull ups are %016b, want %016b", got, want)
+	}
+	if got, want := device.register(mcpGPINTEN), uint16(1<<2|1<<3|1<<4); got != want {
+		t.Errorf("interrupts are enabled for %016b, want %016b", got, want)
+	}
+	if got, want := device.register(mcpOLAT)&(1<<9|1<<10), uint16(1<<9); got != want {
+		t.Errorf("outputs are %016b, want %016b", got, want)
+	}
+	if err = output.SetState(Inactive); err != nil {
+		t.Fatal(err)
+	}
+	if err = lowOutput.SetState(Inactive); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := device.register(mcpOLAT)&(1<<9|1<<10), uint16(1<<10); got != want {
+		t.Errorf("outputs are %016b after turning them off, want %016b", got, want)
+	}
+
+	// a line that's meant to be pulled down is pulled up and read
+	// inverted, it's active while its switch pulls it to ground
+	for _, tt := range []struct {
+		h    *ItemHandle
+		want State
+	}{{pulledUp, Active}, {floating, Inactive}, {pulledDown, Inactive}} {
+		if state := tt.h.State(); state != tt.want {
+			t.Errorf("line %d starts %s, want %s", tt.h.Offset(), state, tt.want)
+		}
+	}
+	device.drive(2, false)
+	device.drive(3, true)
+	device.drive(4, false)
+	for _, tt := range []struct {
+		h    *ItemHandle
+		want State
+	}{{pulledUp, Inactive}, {floating, Active}, {pulledDown, Active}} {
+		testutil.Eventually(t, fmt.Sprintf("line %d turning %s", tt.h.Offset(), tt.want), func() bool { return tt.h.State() == tt.want })
+	}
+}
+
+func TestExpanderPulls(t *testing.T) {
+	device := testutil.NewPCF8574()
+	chip := fakeChip(t, device, AsPCF8574(1, 0x20))
+	if _, err := RegisterItem(chip, 0, AsInput(PullDisabled), WithOwner("test")); err == nil {
+		t.Error("a PCF8574 input was registered without its pull up")
+	} else if _, ok := err.(PullError); !ok {
+		t.Errorf("registering an input without a pull up failed with %v, want a PullError", err)
+	}
+	pulledDown, err := RegisterItem(chip, 1, AsInput(PullDown), WithOwner("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	activeLow, err := RegisterItem(chip, 2, AsInput(PullUp), AsActiveLow(), WithOwner("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = RegisterItem(chip, 2, AsInput(PullUp), WithOwner("other")); err == nil {
+		t.Error("an active low line was shared as an active high one")
+	}
+	for _, h := range []*ItemHandle{pulledDown, activeLow} {
+		if h.State() != Inactive {
+			t.Errorf("line %d is active while it's pulled up", h.Offset())
+		}
+		device.Pull(h.Offset(), false)
+		testutil.Eventually(t, fmt.Sprintf("line %d turning active", h.Offset()), func() bool { return h.State() == Active })
+	}
+	if info := activeLow.Info(); !info.ActiveLow {
+		t.Error("the active low line isn't shown as one")
+	}
+}
+
+func TestExpanderInterrupt(t *testing.T) {
+	device := newFakeMCP23017()
+	host := wired(t, device)
+	// nothing is polled, only the interrupt reads the inputs
+	chip := fakeChip(t, device, AsMCP23017(1, 0x20), WithInterrupt(host, 0), WithPolling(time.Hour))
+	h, err := RegisterItem(chip, 5, AsInput(PullUp), WithOwner("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	interrupt, err := GetItem(host, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, high := range []bool{false, true, false} {
+		device.drive(5, high)
+		want := map[bool]State{true: Active, false: Inactive}[high]
+		testutil.Eventually(t, fmt.Sprintf("the input turning %s", want), func() bool { return h.State() == want })
+		// the host is polled too, it has to see the interrupt go back high
+		// before the next falling edge
+		testutil.Eventually(t, "the interrupt clearing", func() bool { return !device.interrupting() && interrupt.State() == Active })
+	}
+}
+
+func TestExpanderMissedInterrupt(t *testing.T) {
+	device := newFakeMCP23017()
+	host := wired(t, device)
+	chip := fakeChip(t, device, AsMCP23017(1, 0x20), WithInterrupt(host, 0), WithPolling(20*time.Millisecond))
+	h, err := RegisterItem(chip, 5, AsInput(PullUp), WithOwner("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the falling edge of the interrupt is missed, it'd stay low forever
+	// without the fallback poll and no change would interrupt again
+	device.missNext()
+	device.drive(5, false)
+	testutil.Eventually(t, "the fallback poll reading the input", func() bool { return h.State() == Inactive })
+	testutil.Eventually(t, "the interrupt clearing", func() bool { return !device.interrupting() })
+	device.drive(5, true)
+	testutil.Eventually(t, "the next interrupt", func() bool { return h.State() == Active })
+}
@@ -0,0 +1,47 @@
+package core
+
+import (
+	"github.com/warthog618/gpiod"
+)
+
+// gpiodChip drives a chip through the gpio character device
+type gpiodChip struct {
+	chip *gpiod.Chip
+}
+
+func newGpiodChip(name string, consumer string) (*gpiodChip, error) {
+	c, err := gpiod.NewChip(name, gpiod.WithConsumer(consumer))
+	if err != nil {
+		return nil, err
+	}
+	return &gpiodChip{chip: c}, nil
+}
+
+func (g *gpiodChip) RequestLine(offset int, config lineConfig) (lineDriver, error) {
+	var l *gpiod.Line
+	var err error
+	switch config.mode {
+	case Input:
+		handler := func(evt gpiod.LineEvent) {
+			edge := Edge{Timestamp: evt.Timestamp}
+			switch evt.Type {
+			case gpiod.LineEventRisingEdge:
+				edge.Type = RisingEdge
+			case gpiod.LineEventFallingEdge:
+				edge.Type = FallingEdge
+			}
+			config.handler(edge)
+		}
+		l, err = g.chip.RequestLine(offset, gpiod.AsInput, config.pull.bias(), gpiod.WithEventHandler(handler), gpiod.WithBothEdges)
+	default:
+		l, err = g.chip.RequestLine(offset, gpiod.AsOutput(int(config.state)))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (g *gpiodChip) Close() error {
+	return g.chip.Close()
+}
@@ -0,0 +1,83 @@
+package core
+
+import "sync"
+
+// ItemHandle is what an owner gets back from RegisterItem, the underlying
+// line stays requested until every handle on it is released
+type ItemHandle struct {
+	*Item
+	// id identifies the listeners added through the handle on the item
+	id       int
+	owner    string
+	released bool
+
+	mu *sync.RWMutex
+}
+
+func (h *ItemHandle) Owner() string {
+	return h.owner
+}
+
+func (h *ItemHandle) Released() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.released
+}
+
+// Release gives up this handle, the line is closed if it was the last one
+func (h *ItemHandle) Release() error {
+	h.mu.Lock()
+	if h.released {
+		h.mu.Unlock()
+		return ReleasedError{Chip: h.chip, Offset: h.offset, Owner: h.owner}
+	}
+	h.released = true
+	h.mu.Unlock()
+	h.Item.removeListeners(h.id)
+	return h.Item.release(h.owner)
+}
+
+func (h *ItemHandle) SetState(state State) error {
+	if h.Released() {
+		return ReleasedError{Chip: h.chip, Offset: h.offset, Owner: h.owner}
+	}
+	return h.Item.setState(state, h.owner)
+}
+
+// AddEventListener adds listeners that are removed once the handle is released
+func (h *ItemHandle) AddEventListener(fns ...EventHandler) error {
+	if h.Released() {
+		return ReleasedError{Chip: h.chip, Offset: h.offset, Owner: h.owner}
+	}
+	wrapped := make([]EventHandler, 0, len(fns))
+	for _, fn := range fns {
+		fn := fn
+		wrapped = append(wrapped, func(event *ItemEvent) {
+			if h.Released() {
+				return
+			}
+			fn(event)
+		})
+	}
+	h.Item.mu.Lock()
+	defer h.Item.mu.Unlock()
+	return h.Item.events.add(h.id, wrapped...)
+}
+
+// AddEdgeListener adds edge listeners that are removed once the handle is released
+func (h *ItemHandle) AddEdgeListener(fns ...EdgeHandler) error {
+	if h.Released() {
+		return ReleasedError{Chip: h.chip, Offset: h.offset, Owner: h.owner}
+	}
+	wrapped := make([]EdgeHandler, 0, len(fns))
+	for _, fn := range fns {
+		fn := fn
+		wrapped = append(wrapped, func(edge Edge) {
+			if h.Released() {
+				return
+			}
+			fn(edge)
+		})
+	}
+	return h.Item.addEdgeListener(h.id, wrapped...)
+}
@@ -0,0 +1,59 @@
+package core
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/AliRostami1/baagh/pkg/controller/internal/testutil"
+)
+
+func TestHandleReleaseRemovesListeners(t *testing.T) {
+	device := testutil.NewPCF8574()
+	chip := fakeChip(t, device, AsPCF8574(1, 0x20))
+	a, err := RegisterItem(chip, 0, AsInput(PullUp), WithOwner("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := RegisterItem(chip, 0, AsInput(PullUp), WithOwner("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu := &sync.Mutex{}
+	calls := map[string]int{}
+	count := func(owner string) {
+		mu.Lock()
+		calls[owner]++
+		mu.Unlock()
+	}
+	for _, h := range []*ItemHandle{a, b} {
+		owner := h.Owner()
+		if err = h.AddEventListener(func(*ItemEvent) { count(owner + " event") }); err != nil {
+			t.Fatal(err)
+		}
+		if err = h.AddEdgeListener(func(Edge) { count(owner + " edge") }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = a.Release(); err != nil {
+		t.Fatal(err)
+	}
+	item := b.Item
+	item.mu.Lock()
+	events, edges := len(item.events.events), len(item.edges)
+	item.mu.Unlock()
+	if events != 1 || edges != 1 {
+		t.Errorf("the item has %d event and %d edge listeners after a handle was released, want 1 of each", events, edges)
+	}
+
+	device.Pull(0, false)
+	testutil.Eventually(t, "the listeners of b being called", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return calls["b event"] == 1 && calls["b edge"] == 1
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	if calls["a event"] != 0 || calls["a edge"] != 0 {
+		t.Errorf("the listeners of a released handle were called: %v", calls)
+	}
+}
@@ -0,0 +1,57 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+)
+
+// i2cSlave is the ioctl that sets the address of the device a file of
+// /dev/i2c-* talks to
+const i2cSlave = 0x0703
+
+// I2CDevice is a single device on an I2C bus, expanders only talk to it
+// through this so they can be driven by a simulated device
+type I2CDevice interface {
+	// Tx writes w to the device and then reads len(r) bytes into r,
+	// either of them can be empty
+	Tx(w []byte, r []byte) error
+	Close() error
+}
+
+// i2cDevice talks to a device through the i2c-dev driver of the kernel
+type i2cDevice struct {
+	file *os.File
+}
+
+// openI2C opens the device at address on /dev/i2c-bus
+func openI2C(bus int, address uint16) (*i2cDevice, error) {
+	f, err := os.OpenFile(fmt.Sprintf("/dev/i2c-%d", bus), os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), i2cSlave, uintptr(address)); errno != 0 {
+		f.Close()
+		return nil, fmt.Errorf("couldn't select device 0x%02x on i2c bus %d: %w", address, bus, errno)
+	}
+	return &i2cDevice{file: f}, nil
+}
+
+func (d *i2cDevice) Tx(w []byte, r []byte) error {
+	if len(w) > 0 {
+		if _, err := d.file.Write(w); err != nil {
+			return err
+		}
+	}
+	if len(r) > 0 {
+		if _, err := io.ReadFull(d.file, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *i2cDevice) Close() error {
+	return d.file.Close()
+}
@@ -0,0 +1,306 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Refuse fails activating an output while another output of its interlock is active
+	Refuse = "refuse"
+	// Sequence turns the other outputs off first and waits for the dead time
+	Sequence = "sequence"
+)
+
+var interlocks = interlockRegistry{
+	registry: map[string]*Interlock{},
+	byLine:   map[Line][]*Interlock{},
+	RWMutex:  &sync.RWMutex{},
+}
+
+// Line identifies a line of a chip
+type Line struct {
+	Chip   string
+	Offset int
+}
+
+func (l Line) String() string {
+	return fmt.Sprintf("line %d of %s", l.Offset, l.Chip)
+}
+
+// Interlock is a group of mutually exclusive outputs, at most one of them
+// is active at any time no matter who asks for it
+type Interlock struct {
+	name     string
+	policy   string
+	deadTime time.Duration
+	lines    []Line
+	// lastOff is when each line was last deactivated, for the dead time
+	lastOff map[Line]time.Time
+
+	mu *sync.Mutex
+}
+
+// RegisterInterlock declares a group of mutually exclusive outputs, the
+// outputs don't have to be registered yet
+func RegisterInterlock(name string, opts ...InterlockOption) (il *Interlock, err error) {
+	options := &InterlockOptions{policy: Refuse}
+	for _, opt := range opts {
+		err = opt.applyInterlockOption(options)
+		if err != nil {
+			return
+		}
+	}
+	if len(options.lines) < 2 {
+		return nil, OptionError{Field: "lines", Value: options.lines}
+	}
+	il = &Interlock{
+		name:     name,
+		policy:   options.policy,
+		deadTime: options.deadTime,
+		lines:    options.lines,
+		lastOff:  map[Line]time.Time{},
+		mu:       &sync.Mutex{},
+	}
+	if active := il.active(Line{}); len(active) > 1 {
+		return nil, InterlockError{Interlock: name, Line: active[0], Active: active[1:]}
+	}
+	for _, l := range il.lines {
+		// lines that are switched directly would bypass the interlock
+		if i, err := GetItem(l.Chip, l.Offset); err == nil {
+			if owner := i.directOwner(); owner != "" {
+				return nil, DirectError{Chip: l.Chip, Offset: l.Offset, Reason: fmt.Sprintf("%s switches it directly, it can't be interlocked", owner)}
+			}
+		}
+	}
+	err = interlocks.Add(il)
+	if err != nil {
+		return nil, err
+	}
+	logger.Infof("interlock %s registered with %d lines", name, len(il.lines))
+	return
+}
+
+func (il *Interlock) Name() string {
+	return il.name
+}
+
+// active returns the active lines of the interlock except "except"
+func (il *Interlock) active(except Line) (lines []Line) {
+	for _, l := range il.lines {
+		if l == except {
+			continue
+		}
+		// the chip's lock isn't taken, this is also called while registering items
+		c, err := chips.Get(l.Chip)
+		if err != nil {
+			continue
+		}
+		i, err := c.items.Get(l.Offset)
+		if err != nil {
+			continue
+		}
+		if i.State() == Active {
+			lines = append(lines, l)
+		}
+	}
+	return
+}
+
+type interlockRegistry struct {
+	registry map[string]*Interlock
+	byLine   map[Line][]*Interlock
+	*sync.RWMutex
+}
+
+func (r *interlockRegistry) Add(il *Interlock) error {
+	r.Lock()
+	defer r.Unlock()
+	if _, ok := r.registry[il.name]; ok {
+		return DuplicateInterlockError{Name: il.name}
+	}
+	r.registry[il.name] = il
+	for _, l := range il.lines {
+		r.byLine[l] = append(r.byLine[l], il)
+		sort.Slice(r.byLine[l], func(a, b int) bool {
+			return r.byLine[l][a].name < r.byLine[l][b].name
+		})
+	}
+	return nil
+}
+
+// check fails if l can't start out active because of one of its interlocks
+func (r *interlockRegistry) check(l Line) error {
+	r.Lock()
+	groups := append([]*Interlock{}, r.byLine[l]...)
+	r.Unlock()
+	for _, il := range groups {
+		il.mu.Lock()
+		active := il.active(l)
+		il.mu.Unlock()
+		if len(active) > 0 {
+			return InterlockError{Interlock: il.name, Line: l, Active: active}
+		}
+	}
+	return nil
+}
+
+// guard applies state to an output on behalf of owner while holding every
+// interlock it's a member of, interlocks are always locked in name order so
+// they can't deadlock. other outputs it turns off are changed by owner too
+func (r *interlockRegistry) guard(i *Item, state State, owner string) error {
+	l := Line{Chip: i.chip, Offset: i.offset}
+	r.Lock()
+	groups := append([]*Interlock{}, r.byLine[l]...)
+	r.Unlock()
+	if len(groups) == 0 {
+		return i.apply(state, owner)
+	}
+	for _, il := range groups {
+		il.mu.Lock()
+		defer il.mu.Unlock()
+	}
+
+	if state == Active && i.State() != Active {
+		// every refusing interlock is checked before anything is turned off,
+		// otherwise a sequencing one could turn the other lines off only for
+		// the activation to be refused after all
+		for _, il := range groups {
+			if il.policy != Refuse {
+				continue
+			}
+			if active := il.active(l); len(active) > 0 {
+				err := InterlockError{Interlock: il.name, Line: l, Active: active}
+				logger.Warnf("refused: %v", err)
+				return err
+			}
+		}
+		var wait time.Duration
+		for _, il := range groups {
+			for _, other := range il.active(l) {
+				logger.Warnf("interlock %s: turning off %s before activating %s", il.name, other, l)
+				o, err := GetItem(other.Chip, other.Offset)
+				if err != nil {
+					return err
+				}
+				if err = o.apply(Inactive, owner); err != nil {
+					return err
+				}
+				il.off(other)
+			}
+			for _, other := range il.lines {
+				if other == l {
+					continue
+				}
+				if left := il.lastOff[other].Add(il.deadTime).Sub(time.Now()); left > wait {
+					wait = left
+				}
+			}
+		}
+		time.Sleep(wait)
+	}
+
+	wasActive := i.State() == Active
+	if err := i.apply(state, owner); err != nil {
+		return err
+	}
+	if wasActive && state == Inactive {
+		for _, il := range groups {
+			il.off(l)
+		}
+	}
+	return nil
+}
+
+// off must be called with il.mu held
+func (il *Interlock) off(l Line) {
+	il.lastOff[l] = time.Now()
+}
+
+type InterlockOption interface {
+	applyInterlockOption(*InterlockOptions) error
+}
+
+type InterlockOptions struct {
+	policy   string
+	deadTime time.Duration
+	lines    []Line
+}
+
+type LinesOption []Line
+
+func (l LinesOption) applyInterlockOption(o *InterlockOptions) error {
+	for _, line := range l {
+		if line.Chip == "" {
+			return OptionError{Field: "chip", Value: line.Chip}
+		}
+		for _, existing := range o.lines {
+			if existing == line {
+				return OptionError{Field: "lines", Value: line}
+			}
+		}
+		o.lines = append(o.lines, line)
+	}
+	return nil
+}
+
+func WithLines(chip string, offsets ...int) LinesOption {
+	lines := make(LinesOption, 0, len(offsets))
+	for _, offset := range offsets {
+		lines = append(lines, Line{Chip: chip, Offset: offset})
+	}
+	return lines
+}
+
+type PolicyOption struct {
+	policy   string
+	deadTime time.Duration
+}
+
+func (p PolicyOption) applyInterlockOption(o *InterlockOptions) error {
+	if p.policy != Refuse && p.policy != Sequence {
+		return OptionError{Field: "policy", Value: p.policy}
+	}
+	if p.deadTime < 0 {
+		return OptionError{Field: "dead time", Value: p.deadTime}
+	}
+	o.policy = p.policy
+	o.deadTime = p.deadTime
+	return nil
+}
+
+// AsRefusing makes activations fail while another line of the interlock is
+// active, if one was deactivated less than deadTime ago it waits for the rest of it
+func AsRefusing(deadTime time.Duration) PolicyOption {
+	return PolicyOption{policy: Refuse, deadTime: deadTime}
+}
+
+// AsSequencing turns the other lines off first and waits deadTime before activating
+func AsSequencing(deadTime time.Duration) PolicyOption {
+	return PolicyOption{policy: Sequence, deadTime: deadTime}
+}
+
+type InterlockError struct {
+	Interlock string
+	Line      Line
+	Active    []Line
+}
+
+func (e InterlockError) Error() string {
+	active := make([]string, 0, len(e.Active))
+	for _, l := range e.Active {
+		active = append(active, l.String())
+	}
+	return fmt.Sprintf("interlock %s doesn't allow activating %s while %s is active", e.Interlock, e.Line, strings.Join(active, ", "))
+}
+
+type DuplicateInterlockError struct {
+	Name string
+}
+
+func (d DuplicateInterlockError) Error() string {
+	return fmt.Sprintf("interlock %s is already registered", d.Name)
+}
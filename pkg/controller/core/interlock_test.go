@@ -0,0 +1,132 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/controller/internal/testutil"
+)
+
+func TestInterlock(t *testing.T) {
+	type group struct {
+		policy PolicyOption
+		lines  []int
+	}
+	for _, tt := range []struct {
+		name string
+		// groups are registered in order, their names sort the same way
+		groups []group
+		// on are turned on in order and off are turned off after them
+		on       []int
+		off      []int
+		activate int
+		refused  bool
+		// active are the lines that are active afterwards
+		active []int
+		// wait is the least activating takes
+		wait time.Duration
+	}{
+		{
+			name:     "refuse",
+			groups:   []group{{AsRefusing(0), []int{0, 1}}},
+			on:       []int{0},
+			activate: 1,
+			refused:  true,
+			active:   []int{0},
+		},
+		{
+			name:     "refuse inactive",
+			groups:   []group{{AsRefusing(0), []int{0, 1}}},
+			on:       []int{2},
+			activate: 1,
+			active:   []int{1, 2},
+		},
+		{
+			name:     "refuse dead time",
+			groups:   []group{{AsRefusing(30 * time.Millisecond), []int{0, 1}}},
+			on:       []int{0},
+			off:      []int{0},
+			activate: 1,
+			active:   []int{1},
+			wait:     20 * time.Millisecond,
+		},
+		{
+			name:     "sequence",
+			groups:   []group{{AsSequencing(0), []int{0, 1, 2}}},
+			on:       []int{0},
+			activate: 1,
+			active:   []int{1},
+		},
+		{
+			name:     "sequence dead time",
+			groups:   []group{{AsSequencing(30 * time.Millisecond), []int{0, 1}}},
+			on:       []int{0},
+			activate: 1,
+			active:   []int{1},
+			wait:     20 * time.Millisecond,
+		},
+		{
+			// the sequencing group comes first but mustn't turn 1 off when
+			// the refusing one doesn't let 0 turn on anyway
+			name:     "sequence then refuse",
+			groups:   []group{{AsSequencing(0), []int{0, 1}}, {AsRefusing(0), []int{0, 2}}},
+			on:       []int{1, 2},
+			activate: 0,
+			refused:  true,
+			active:   []int{1, 2},
+		},
+		{
+			name:     "sequence and refuse",
+			groups:   []group{{AsSequencing(0), []int{0, 1}}, {AsRefusing(0), []int{0, 2}}},
+			on:       []int{1},
+			activate: 0,
+			active:   []int{0},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			chip := fakeChip(t, testutil.NewPCF8574(), AsPCF8574(1, 0x20))
+			for n, g := range tt.groups {
+				if _, err := RegisterInterlock(fmt.Sprintf("%s-%d", chip, n), g.policy, WithLines(chip, g.lines...)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			items := make([]*ItemHandle, 4)
+			for offset := range items {
+				h, err := RegisterItem(chip, offset, AsOutput(), WithState(Inactive), WithOwner("test"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				items[offset] = h
+			}
+			for _, offset := range tt.on {
+				if err := items[offset].SetState(Active); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, offset := range tt.off {
+				if err := items[offset].SetState(Inactive); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			start := time.Now()
+			err := items[tt.activate].SetState(Active)
+			if took := time.Since(start); took < tt.wait {
+				t.Errorf("activating took %v, want at least %v", took, tt.wait)
+			}
+			if _, ok := err.(InterlockError); tt.refused != ok {
+				t.Errorf("activating failed with %v, refused %v", err, tt.refused)
+			}
+			want := map[int]bool{}
+			for _, offset := range tt.active {
+				want[offset] = true
+			}
+			for offset, h := range items {
+				if active := h.State() == Active; active != want[offset] {
+					t.Errorf("line %d is active %v, want %v", offset, active, want[offset])
+				}
+			}
+		})
+	}
+}
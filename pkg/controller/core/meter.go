@@ -0,0 +1,336 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/multierr"
+)
+
+var meters = meterRegistry{registry: map[string]*Meter{}, RWMutex: &sync.RWMutex{}}
+
+// Measurement is what a meter measured over its window, frequencies are in
+// Hz. without at least two rising edges only DutyCycle is set, to the
+// current state of the input
+type Measurement struct {
+	Frequency    float64
+	MinFrequency float64
+	MaxFrequency float64
+	Period       time.Duration
+	// PulseWidth is the average time the input was active per period
+	PulseWidth time.Duration
+	// DutyCycle is the fraction of the time the input was active, between 0 and 1
+	DutyCycle float64
+	// Periods is how many full periods the measurement is based on
+	Periods int
+}
+
+// Meter measures the frequency and pulse width of an input over a sliding
+// window using the timestamps of its edges, like the tachometer of a fan
+// or a flow sensor
+type Meter struct {
+	name    string
+	input   *ItemHandle
+	options *MeterOptions
+	// edges are the edges inside the window, oldest first
+	edges []Edge
+	// lastSeen is when the last edge came, to relate the clock of the
+	// chip to the wall clock when there are no more edges
+	lastSeen time.Time
+	// threshold is the virtual item that's active while the frequency is
+	// outside the thresholds
+	threshold *ItemHandle
+	// done stops checking the threshold once the meter is closed
+	done    chan struct{}
+	closing *sync.Once
+
+	mu *sync.Mutex
+}
+
+// RegisterMeter starts measuring line offset of chip until it's closed or
+// ctx is done
+func RegisterMeter(ctx context.Context, name string, chip string, offset int, opts ...MeterOption) (m *Meter, err error) {
+	if name == "" {
+		return nil, OptionError{Field: "name", Value: name}
+	}
+	options := &MeterOptions{pull: PullDown, window: time.Second}
+	for _, opt := range opts {
+		err = opt.applyMeterOption(options)
+		if err != nil {
+			return
+		}
+	}
+	m = &Meter{
+		name:    name,
+		options: options,
+		done:    make(chan struct{}),
+		closing: &sync.Once{},
+		mu:      &sync.Mutex{},
+	}
+	owner := "meter/" + name
+	m.input, err = RegisterItem(chip, offset, AsInput(options.pull), WithOwner(owner))
+	if err != nil {
+		return nil, err
+	}
+	if options.threshold != nil {
+		m.threshold, err = RegisterVirtualItem(options.threshold.name, WithOwner(owner), WithState(Inactive))
+		if err != nil {
+			m.input.Release()
+			return nil, err
+		}
+	}
+	if err = m.input.AddEdgeListener(m.edge); err == nil {
+		err = meters.Add(m)
+	}
+	if err != nil {
+		m.Close()
+		return nil, err
+	}
+
+	go func() {
+		ticker := time.NewTicker(options.window / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.check()
+			case <-ctx.Done():
+				if err := m.Close(); err != nil {
+					logger.Errorf("couldn't close meter %s: %v", name, err)
+				}
+				return
+			case <-m.done:
+				return
+			}
+		}
+	}()
+	logger.Infof("meter %s registered on line %d of %s", name, offset, chip)
+	return m, nil
+}
+
+// GetMeter returns the registered meter called name
+func GetMeter(name string) (*Meter, error) {
+	meters.RLock()
+	defer meters.RUnlock()
+	m, ok := meters.registry[name]
+	if !ok {
+		return nil, MeterNotFoundError{Name: name}
+	}
+	return m, nil
+}
+
+// Meters returns every registered meter sorted by name
+func Meters() (list []*Meter) {
+	meters.RLock()
+	defer meters.RUnlock()
+	for _, m := range meters.registry {
+		list = append(list, m)
+	}
+	sort.Slice(list, func(a, b int) bool { return list[a].name < list[b].name })
+	return
+}
+
+func (m *Meter) Name() string {
+	return m.name
+}
+
+// Close stops measuring and releases the input and the threshold item
+func (m *Meter) Close() (err error) {
+	m.closing.Do(func() { close(m.done) })
+	meters.Remove(m)
+	for _, h := range []*ItemHandle{m.input, m.threshold} {
+		if h != nil && !h.Released() {
+			err = multierr.Append(err, h.Release())
+		}
+	}
+	return
+}
+
+func (m *Meter) edge(edge Edge) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.edges = append(m.edges, edge)
+	m.lastSeen = time.Now()
+	m.prune(edge.Timestamp)
+}
+
+// prune drops the edges that left the window, now is on the clock of the
+// chip. must be called with mu locked
+func (m *Meter) prune(now time.Duration) {
+	i := 0
+	for i < len(m.edges) && m.edges[i].Timestamp < now-m.options.window {
+		i++
+	}
+	m.edges = append(m.edges[:0], m.edges[i:]...)
+}
+
+// Measure returns what was measured over the window that ended right now
+func (m *Meter) Measure() (ms Measurement) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.edges) > 0 {
+		m.prune(m.edges[len(m.edges)-1].Timestamp + time.Since(m.lastSeen))
+	}
+
+	var rising []time.Duration
+	for _, e := range m.edges {
+		if e.Type == RisingEdge {
+			rising = append(rising, e.Timestamp)
+		}
+	}
+	if len(rising) < 2 {
+		if m.input.State() == Active {
+			ms.DutyCycle = 1
+		}
+		return
+	}
+	ms.Periods = len(rising) - 1
+	var high time.Duration
+	for i, e := range m.edges {
+		// only pulses of full periods count towards the duty cycle
+		if e.Type == RisingEdge && e.Timestamp < rising[len(rising)-1] &&
+			i+1 < len(m.edges) && m.edges[i+1].Type == FallingEdge {
+			high += m.edges[i+1].Timestamp - e.Timestamp
+		}
+	}
+	span := rising[len(rising)-1] - rising[0]
+	ms.Period = span / time.Duration(ms.Periods)
+	ms.Frequency = 1 / ms.Period.Seconds()
+	ms.PulseWidth = high / time.Duration(ms.Periods)
+	ms.DutyCycle = high.Seconds() / span.Seconds()
+	for i := 1; i < len(rising); i++ {
+		f := 1 / (rising[i] - rising[i-1]).Seconds()
+		if ms.MinFrequency == 0 || f < ms.MinFrequency {
+			ms.MinFrequency = f
+		}
+		if f > ms.MaxFrequency {
+			ms.MaxFrequency = f
+		}
+	}
+	return
+}
+
+// check updates the threshold item, it's also what lets it notice that
+// the edges stopped coming
+func (m *Meter) check() {
+	if m.threshold == nil {
+		return
+	}
+	t := m.options.threshold
+	f := m.Measure().Frequency
+	state := Inactive
+	if (t.below > 0 && f < t.below) || (t.above > 0 && f > t.above) {
+		state = Active
+	}
+	if m.threshold.State() == state {
+		return
+	}
+	logger.Infof("meter %s measured %.2fHz, %s is now %s", m.name, f, t.name, state)
+	if err := m.threshold.SetState(state); err != nil {
+		logger.Errorf("meter %s couldn't set %s: %v", m.name, t.name, err)
+	}
+}
+
+type meterRegistry struct {
+	registry map[string]*Meter
+	*sync.RWMutex
+}
+
+func (r *meterRegistry) Add(m *Meter) error {
+	r.Lock()
+	defer r.Unlock()
+	if _, ok := r.registry[m.name]; ok {
+		return DuplicateMeterError{Name: m.name}
+	}
+	r.registry[m.name] = m
+	return nil
+}
+
+func (r *meterRegistry) Remove(m *Meter) {
+	r.Lock()
+	defer r.Unlock()
+	if r.registry[m.name] == m {
+		delete(r.registry, m.name)
+	}
+}
+
+type MeterOption interface {
+	applyMeterOption(*MeterOptions) error
+}
+
+type MeterOptions struct {
+	pull      Pull
+	window    time.Duration
+	threshold *thresholdOptions
+}
+
+type thresholdOptions struct {
+	name         string
+	below, above float64
+}
+
+func (i InputOption) applyMeterOption(o *MeterOptions) error {
+	o.pull = i.pull
+	return nil
+}
+
+type WindowOption time.Duration
+
+func (w WindowOption) applyMeterOption(o *MeterOptions) error {
+	if w <= 0 {
+		return OptionError{Field: "window", Value: time.Duration(w)}
+	}
+	o.window = time.Duration(w)
+	return nil
+}
+
+// WithWindow sets how far back a meter looks, it's a second by default.
+// slow signals need a window of at least a few of their periods
+func WithWindow(window time.Duration) WindowOption {
+	return WindowOption(window)
+}
+
+type ThresholdOption thresholdOptions
+
+func (t ThresholdOption) check() (*thresholdOptions, error) {
+	if t.name == "" {
+		return nil, OptionError{Field: "threshold", Value: t.name}
+	}
+	if t.below < 0 || t.above < 0 || (t.above > 0 && t.above <= t.below) {
+		return nil, OptionError{Field: "threshold", Value: t}
+	}
+	return &thresholdOptions{name: t.name, below: t.below, above: t.above}, nil
+}
+
+func (t ThresholdOption) applyMeterOption(o *MeterOptions) (err error) {
+	o.threshold, err = t.check()
+	return
+}
+
+// WithThreshold registers a virtual item called name that is active while
+// the measured value is below below or above above, either of them can be
+// 0 to disable it. it's in Hz for meters and in meters for distance
+// sensors. generals can use it as a sensor, e.g. to raise an alarm when a
+// fan stalls
+func WithThreshold(name string, below float64, above float64) ThresholdOption {
+	return ThresholdOption{name: name, below: below, above: above}
+}
+
+type DuplicateMeterError struct {
+	Name string
+}
+
+func (d DuplicateMeterError) Error() string {
+	return fmt.Sprintf("meter %s is already registered", d.Name)
+}
+
+type MeterNotFoundError struct {
+	Name string
+}
+
+func (m MeterNotFoundError) Error() string {
+	return fmt.Sprintf("there is no meter named %s", m.Name)
+}
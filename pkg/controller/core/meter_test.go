@@ -0,0 +1,121 @@
+package core
+
+import (
+	"context"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/controller/internal/testutil"
+)
+
+// pulses returns a rising edge at every start and a falling one width later
+func pulses(width time.Duration, starts ...time.Duration) (edges []Edge) {
+	for _, start := range starts {
+		edges = append(edges, Edge{Type: RisingEdge, Timestamp: start}, Edge{Type: FallingEdge, Timestamp: start + width})
+	}
+	return
+}
+
+func TestMeasure(t *testing.T) {
+	const ms = time.Millisecond
+	for _, tt := range []struct {
+		name  string
+		edges []Edge
+		want  Measurement
+	}{
+		{
+			name:  "steady",
+			edges: pulses(25*ms, 0, 100*ms, 200*ms, 300*ms),
+			want:  Measurement{Frequency: 10, MinFrequency: 10, MaxFrequency: 10, Period: 100 * ms, PulseWidth: 25 * ms, DutyCycle: 0.25, Periods: 3},
+		},
+		{
+			name:  "varying",
+			edges: append(pulses(50*ms, 0), pulses(25*ms, 100*ms, 150*ms)...),
+			want:  Measurement{Frequency: 1 / 0.075, MinFrequency: 10, MaxFrequency: 20, Period: 75 * ms, PulseWidth: 37500 * time.Microsecond, DutyCycle: 0.5, Periods: 2},
+		},
+		{
+			// the window is a second, the first two pulses are older than that
+			name:  "window",
+			edges: pulses(10*ms, 0, 100*ms, 1500*ms, 1600*ms),
+			want:  Measurement{Frequency: 10, MinFrequency: 10, MaxFrequency: 10, Period: 100 * ms, PulseWidth: 10 * ms, DutyCycle: 0.1, Periods: 1},
+		},
+		{
+			// the last pulse hasn't ended, only full periods count
+			name:  "open pulse",
+			edges: append(pulses(20*ms, 0, 50*ms), Edge{Type: RisingEdge, Timestamp: 100 * ms}),
+			want:  Measurement{Frequency: 20, MinFrequency: 20, MaxFrequency: 20, Period: 50 * ms, PulseWidth: 20 * ms, DutyCycle: 0.4, Periods: 2},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Meter{options: &MeterOptions{window: time.Second}, mu: &sync.Mutex{}}
+			for _, edge := range tt.edges {
+				m.edge(edge)
+			}
+			got := m.Measure()
+			floats := []struct {
+				what      string
+				got, want float64
+			}{
+				{"frequency", got.Frequency, tt.want.Frequency},
+				{"min frequency", got.MinFrequency, tt.want.MinFrequency},
+				{"max frequency", got.MaxFrequency, tt.want.MaxFrequency},
+				{"duty cycle", got.DutyCycle, tt.want.DutyCycle},
+			}
+			for _, f := range floats {
+				if math.Abs(f.got-f.want) > 1e-9 {
+					t.Errorf("%s is %v, want %v", f.what, f.got, f.want)
+				}
+			}
+			if got.Period != tt.want.Period || got.PulseWidth != tt.want.PulseWidth || got.Periods != tt.want.Periods {
+				t.Errorf("measured %d periods of %v with pulses of %v, want %d of %v with %v", got.Periods, got.Period, got.PulseWidth, tt.want.Periods, tt.want.Period, tt.want.PulseWidth)
+			}
+		})
+	}
+}
+
+func TestMeterClose(t *testing.T) {
+	device := testutil.NewPCF8574()
+	chip := fakeChip(t, device, AsPCF8574(1, 0x20))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stalled := chip + "-stalled"
+	m, err := RegisterMeter(ctx, chip+"-meter", chip, 0, WithWindow(40*time.Millisecond), WithThreshold(stalled, 5, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// without a full period the duty cycle is the state of the input
+	if got := m.Measure(); got.DutyCycle != 0 || got.Periods != 0 {
+		t.Errorf("measured %+v without any edges", got)
+	}
+	device.Pull(0, false)
+	testutil.Eventually(t, "the input turning active", func() bool { return m.Measure().DutyCycle == 1 })
+	testutil.Eventually(t, "the meter noticing the stall", func() bool {
+		i, err := GetVirtualItem(stalled)
+		return err == nil && i.State() == Active
+	})
+
+	if err = m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = GetMeter(m.Name()); err == nil {
+		t.Error("a closed meter is still registered")
+	}
+	if _, err = GetItem(chip, 0); err == nil {
+		t.Error("the input of a closed meter is still registered")
+	}
+	if _, err = GetVirtualItem(stalled); err == nil {
+		t.Error("the threshold of a closed meter is still registered")
+	}
+
+	// the meter is closed once ctx is done too
+	if _, err = RegisterMeter(ctx, chip+"-meter", chip, 1); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	testutil.Eventually(t, "the meter closing with its context", func() bool {
+		_, err := GetItem(chip, 1)
+		return err != nil
+	})
+}
@@ -18,28 +18,63 @@ type ChipOption interface {
 type ChipOptions struct {
 	name     string
 	consumer string
+	// expander is only set for I2C gpio expanders
+	expander *expanderOptions
+	// sysfs is the root of the legacy sysfs interface the chip is driven
+	// through, it's empty for the gpio character device
+	sysfs string
 }
 
 type NameOption string
 
 func (n NameOption) applyChipOption(c *ChipOptions) error {
-	var chipExistsOnDevice bool
-	for _, deviceChipName := range gpiod.Chips() {
-		if string(n) == deviceChipName {
-			chipExistsOnDevice = true
-		}
+	if n == "" {
+		return OptionError{Field: "name", Value: n}
 	}
-	if chipExistsOnDevice {
-		c.name = string(n)
+	c.name = string(n)
+	return nil
+}
+
+// checkName makes sure a gpiod chip exists on the device
+func (c *ChipOptions) checkName() error {
+	if c.expander != nil || c.sysfs != "" {
 		return nil
 	}
-	return OptionError{Field: "name", Value: n}
+	for _, deviceChipName := range gpiod.Chips() {
+		if c.name == deviceChipName {
+			return nil
+		}
+	}
+	return OptionError{Field: "name", Value: c.name}
 }
 
 func WithName(name string) NameOption {
 	return NameOption(name)
 }
 
+type SysfsOption string
+
+func (s SysfsOption) applyChipOption(c *ChipOptions) error {
+	if s == "" {
+		return OptionError{Field: "sysfs", Value: string(s)}
+	}
+	c.sysfs = string(s)
+	return nil
+}
+
+// AsSysfs drives the chip through /sys/class/gpio instead of the gpio
+// character device, for older kernels. the chip is the directory of the
+// same name there, e.g. "gpiochip0"
+func AsSysfs() SysfsOption {
+	return SysfsOption(defaultGPIOSysfs)
+}
+
+// WithSysfsRoot drives the chip through the sysfs interface at root
+// instead of /sys/class/gpio, e.g. a tree that simulates it
+func WithSysfsRoot(root string) SysfsOption {
+	return SysfsOption(root)
+}
+
 type ConsumerOption string
 
 func (n ConsumerOption) applyChipOption(c *ChipOptions) error {
@@ -64,7 +99,12 @@ type ItemOptions struct {
 		mode Mode
 		pull Pull
 	}
-	state State
+	state    State
+	stateSet bool
+	// activeLow inverts the line, it's active while it's low
+	activeLow bool
+	// owner is who is holding the returned handle, defaults to the chip's consumer
+	owner string
 }
 
 func (o OptionError) Error() string {
@@ -116,9 +156,38 @@ func (s StateOption) applyItemOption(item *ItemOptions) (err error) {
 		return OptionError{Field: "state", Value: s}
 	}
 	item.state = State(s)
+	item.stateSet = true
 	return
 }
 
 func WithState(state State) StateOption {
 	return StateOption(state)
 }
+
+type ActiveLowOption bool
+
+func (a ActiveLowOption) applyItemOption(item *ItemOptions) (err error) {
+	item.activeLow = bool(a)
+	return
+}
+
+// AsActiveLow inverts the line, an input is active while it reads low and
+// an output is driven low while it's active. it's for inputs with a pull
+// up and a switch to ground, or relay boards that switch on a low level
+func AsActiveLow() ActiveLowOption {
+	return ActiveLowOption(true)
+}
+
+type OwnerOption string
+
+func (o OwnerOption) applyItemOption(item *ItemOptions) (err error) {
+	if o == "" {
+		return OptionError{Field: "owner", Value: o}
+	}
+	item.owner = string(o)
+	return
+}
+
+func WithOwner(owner string) OwnerOption {
+	return OwnerOption(owner)
+}
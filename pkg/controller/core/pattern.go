@@ -0,0 +1,330 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	patterns = patternRegistry{registry: map[string]Pattern{
+		"blink":      Blink(1),
+		"fast-blink": Blink(4),
+		"heartbeat":  Custom(0, 100*time.Millisecond, 100*time.Millisecond, 100*time.Millisecond, 700*time.Millisecond),
+		"strobe":     Custom(0, 50*time.Millisecond, 450*time.Millisecond),
+		"sos":        Morse("sos", 150*time.Millisecond),
+	}, RWMutex: &sync.RWMutex{}}
+	blinkers = blinkerRegistry{registry: map[string]*Blinker{}, RWMutex: &sync.RWMutex{}}
+)
+
+// Pattern is a sequence of durations the output is on and off for,
+// starting with on. it's played Repeat times, or forever if Repeat is 0
+type Pattern struct {
+	Steps  []time.Duration
+	Repeat int
+}
+
+// Blink turns on and off hz times a second forever
+func Blink(hz float64) Pattern {
+	half := time.Duration(float64(time.Second) / hz / 2)
+	return Custom(0, half, half)
+}
+
+// Custom plays steps, alternating between on and off, repeat times
+func Custom(repeat int, steps ...time.Duration) Pattern {
+	return Pattern{Steps: steps, Repeat: repeat}
+}
+
+var morse = map[rune]string{
+	'a': ".-", 'b': "-...", 'c': "-.-.", 'd': "-..", 'e': ".", 'f': "..-.",
+	'g': "--.", 'h': "....", 'i': "..", 'j': ".---", 'k': "-.-", 'l': ".-..",
+	'm': "--", 'n': "-.", 'o': "---", 'p': ".--.", 'q': "--.-", 'r': ".-.",
+	's': "...", 't': "-", 'u': "..-", 'v': "...-", 'w': ".--", 'x': "-..-",
+	'y': "-.--", 'z': "--..", '0': "-----", '1': ".----", '2': "..---",
+	'3': "...--", '4': "....-", '5': ".....", '6': "-....", '7': "--...",
+	'8': "---..", '9': "----.",
+}
+
+// Morse spells text forever, a dot is unit long and a dash three units.
+// letters are three units apart, words seven, and characters that have
+// no morse code are skipped
+func Morse(text string, unit time.Duration) Pattern {
+	var steps []time.Duration
+	// gap extends the off step that ends the last symbol
+	gap := func(units time.Duration) {
+		if len(steps) > 0 {
+			steps[len(steps)-1] = units * unit
+		}
+	}
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		for _, r := range word {
+			code, ok := morse[r]
+			if !ok {
+				continue
+			}
+			for _, symbol := range code {
+				on := unit
+				if symbol == '-' {
+					on = 3 * unit
+				}
+				steps = append(steps, on, unit)
+			}
+			gap(3)
+		}
+		gap(7)
+	}
+	return Custom(0, steps...)
+}
+
+func (p Pattern) check() error {
+	if len(p.Steps) == 0 || len(p.Steps)%2 != 0 || p.Repeat < 0 {
+		return PatternError{Pattern: p}
+	}
+	for _, step := range p.Steps {
+		if step <= 0 {
+			return PatternError{Pattern: p}
+		}
+	}
+	return nil
+}
+
+// RegisterPattern makes p playable by name, replacing a pattern that had
+// the same name. blink, fast-blink, heartbeat, strobe and sos are built in
+func RegisterPattern(name string, p Pattern) error {
+	if name == "" {
+		return OptionError{Field: "name", Value: name}
+	}
+	if err := p.check(); err != nil {
+		return err
+	}
+	patterns.Lock()
+	defer patterns.Unlock()
+	patterns.registry[name] = p
+	return nil
+}
+
+// GetPattern returns the pattern called name
+func GetPattern(name string) (Pattern, error) {
+	patterns.RLock()
+	defer patterns.RUnlock()
+	p, ok := patterns.registry[name]
+	if !ok {
+		return Pattern{}, PatternNotFoundError{Name: name}
+	}
+	return p, nil
+}
+
+// Blinker is an output that plays patterns, like a status LED or the
+// beacon of an alarm. it switches its line directly, so the steps of a
+// pattern don't show up as events of the item and don't flood the history.
+// that's why its line can't be shared or interlocked
+type Blinker struct {
+	name string
+	line *DirectLine
+	// playing is the name of the pattern that's playing, empty if none is
+	playing string
+	// stop ends the pattern that's playing
+	stop chan struct{}
+	// stopped is closed once the pattern that's playing left the line off
+	stopped chan struct{}
+
+	mu *sync.Mutex
+}
+
+// RegisterBlinker registers line offset of chip as the blinker called name
+func RegisterBlinker(name string, chip string, offset int) (b *Blinker, err error) {
+	if name == "" {
+		return nil, OptionError{Field: "name", Value: name}
+	}
+	b = &Blinker{name: name, mu: &sync.Mutex{}}
+	h, err := RegisterItem(chip, offset, AsOutput(), WithState(Inactive), WithOwner("blinker/"+name))
+	if err != nil {
+		return nil, err
+	}
+	if b.line, err = h.Direct(); err != nil {
+		h.Release()
+		return nil, err
+	}
+	if err = blinkers.Add(b); err != nil {
+		b.line.Release()
+		return nil, err
+	}
+	return b, nil
+}
+
+// GetBlinker returns the registered blinker called name
+func GetBlinker(name string) (*Blinker, error) {
+	blinkers.RLock()
+	defer blinkers.RUnlock()
+	b, ok := blinkers.registry[name]
+	if !ok {
+		return nil, BlinkerNotFoundError{Name: name}
+	}
+	return b, nil
+}
+
+// Blinkers returns every registered blinker sorted by name
+func Blinkers() (list []*Blinker) {
+	blinkers.RLock()
+	defer blinkers.RUnlock()
+	for _, b := range blinkers.registry {
+		list = append(list, b)
+	}
+	sort.Slice(list, func(a, c int) bool { return list[a].name < list[c].name })
+	return
+}
+
+func (b *Blinker) Name() string {
+	return b.name
+}
+
+// Playing returns the name of the pattern that's playing, it's empty if
+// none is or the last one ended
+func (b *Blinker) Playing() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	select {
+	case <-b.stopped:
+		return ""
+	default:
+		return b.playing
+	}
+}
+
+// Play stops whatever is playing and plays the pattern called name, an
+// empty name just stops
+func (b *Blinker) Play(name string) error {
+	if name == "" {
+		b.Stop()
+		return nil
+	}
+	p, err := GetPattern(name)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.halt()
+	b.playing = name
+	b.stop = make(chan struct{})
+	b.stopped = make(chan struct{})
+	go b.play(p, b.stop, b.stopped)
+	return nil
+}
+
+// Stop stops the pattern that's playing and turns the output off
+func (b *Blinker) Stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.halt()
+}
+
+// halt stops the pattern that's playing and waits until the output is
+// off, must be called with mu locked
+func (b *Blinker) halt() {
+	if b.stop == nil {
+		return
+	}
+	close(b.stop)
+	<-b.stopped
+	b.stop = nil
+	b.playing = ""
+}
+
+func (b *Blinker) play(p Pattern, stop chan struct{}, stopped chan struct{}) {
+	defer close(stopped)
+	defer b.set(Inactive)
+	timer := time.NewTimer(0)
+	<-timer.C
+	for round := 0; p.Repeat == 0 || round < p.Repeat; round++ {
+		for i, step := range p.Steps {
+			state := Inactive
+			if i%2 == 0 {
+				state = Active
+			}
+			b.set(state)
+			timer.Reset(step)
+			select {
+			case <-timer.C:
+			case <-stop:
+				timer.Stop()
+				return
+			}
+		}
+	}
+}
+
+func (b *Blinker) set(state State) {
+	if err := b.line.Set(state); err != nil {
+		logger.Errorf("blinker %s: %v", b.name, err)
+	}
+}
+
+// Close stops playing and releases the output
+func (b *Blinker) Close() error {
+	blinkers.Remove(b)
+	b.Stop()
+	return b.line.Release()
+}
+
+type patternRegistry struct {
+	registry map[string]Pattern
+	*sync.RWMutex
+}
+
+type blinkerRegistry struct {
+	registry map[string]*Blinker
+	*sync.RWMutex
+}
+
+func (r *blinkerRegistry) Add(b *Blinker) error {
+	r.Lock()
+	defer r.Unlock()
+	if _, ok := r.registry[b.name]; ok {
+		return DuplicateBlinkerError{Name: b.name}
+	}
+	r.registry[b.name] = b
+	return nil
+}
+
+func (r *blinkerRegistry) Remove(b *Blinker) {
+	r.Lock()
+	defer r.Unlock()
+	if r.registry[b.name] == b {
+		delete(r.registry, b.name)
+	}
+}
+
+type PatternError struct {
+	Pattern Pattern
+}
+
+func (p PatternError) Error() string {
+	return fmt.Sprintf("invalid pattern %v: it needs pairs of positive on and off durations", p.Pattern)
+}
+
+type PatternNotFoundError struct {
+	Name string
+}
+
+func (p PatternNotFoundError) Error() string {
+	return fmt.Sprintf("there is no pattern named %s", p.Name)
+}
+
+type DuplicateBlinkerError struct {
+	Name string
+}
+
+func (d DuplicateBlinkerError) Error() string {
+	return fmt.Sprintf("blinker %s is already registered", d.Name)
+}
+
+type BlinkerNotFoundError struct {
+	Name string
+}
+
+func (b BlinkerNotFoundError) Error() string {
+	return fmt.Sprintf("there is no blinker named %s", b.Name)
+}
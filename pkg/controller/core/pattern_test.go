@@ -0,0 +1,69 @@
+package core
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/controller/internal/testutil"
+)
+
+func TestBlinker(t *testing.T) {
+	device := testutil.NewPCF8574()
+	chip := fakeChip(t, device, AsPCF8574(1, 0x20))
+	if err := RegisterPattern(chip+"-blink", Custom(0, 5*time.Millisecond, 5*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	events := 0
+	mu := &sync.Mutex{}
+	Subscribe(func(event *ItemEvent) {
+		if event.Item.chip == chip {
+			mu.Lock()
+			events++
+			mu.Unlock()
+		}
+	})
+	b, err := RegisterBlinker(chip+"-blinker", chip, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if err = b.Play(chip + "-blink"); err != nil {
+		t.Fatal(err)
+	}
+	testutil.Eventually(t, "the blinker turning on", func() bool { return device.High(0) })
+	testutil.Eventually(t, "the blinker turning off", func() bool { return !device.High(0) })
+	testutil.Eventually(t, "the blinker turning on again", func() bool { return device.High(0) })
+	b.Stop()
+	if device.High(0) {
+		t.Error("the line was left on after stopping")
+	}
+	if playing := b.Playing(); playing != "" {
+		t.Errorf("%s is playing after stopping", playing)
+	}
+	time.Sleep(10 * time.Millisecond)
+	mu.Lock()
+	if events != 0 {
+		t.Errorf("blinking made %d events", events)
+	}
+	mu.Unlock()
+}
+
+func TestBlinkerRefused(t *testing.T) {
+	chip := fakeChip(t, testutil.NewPCF8574(), AsPCF8574(1, 0x20))
+	if _, err := RegisterInterlock(chip+"-interlock", WithLines(chip, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RegisterBlinker(chip+"-interlocked", chip, 0); err == nil {
+		t.Error("an interlocked line became a blinker")
+	}
+	if _, err := RegisterItem(chip, 2, AsOutput(), WithState(Inactive), WithOwner("other")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RegisterBlinker(chip+"-shared", chip, 2); err == nil {
+		t.Error("a line that's already registered became a blinker")
+	}
+	if _, err := GetBlinker(chip + "-shared"); err == nil {
+		t.Error("a refused blinker was registered")
+	}
+}
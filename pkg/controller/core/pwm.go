@@ -0,0 +1,484 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// fadeStep is how often the duty cycle is updated while fading
+	fadeStep = 20 * time.Millisecond
+	// defaultFrequency is fast enough for LEDs not to flicker and slow
+	// enough for a software timed line
+	defaultFrequency = 200
+)
+
+// pwmSysfs is where the kernel exposes its pwm chips
+var pwmSysfs = "/sys/class/pwm"
+
+var pwms = pwmRegistry{registry: map[string]*PWM{}, RWMutex: &sync.RWMutex{}}
+
+// pwmDriver is what a PWM drives, either a line that's switched in
+// software or a channel of a pwm chip of the kernel
+type pwmDriver interface {
+	// Set sets the period and the duty cycle, which is between 0 and 1
+	Set(period time.Duration, duty float64) error
+	Close() error
+}
+
+// PWM is an output whose duty cycle can be set between 0 and 100%, like a
+// dimmable LED strip or the speed of a fan
+type PWM struct {
+	name   string
+	driver pwmDriver
+	// handle is only set for lines switched in software
+	handle *ItemHandle
+	period time.Duration
+	// duty is in percent
+	duty float64
+	// fading invalidates fades that were replaced
+	fading int
+
+	mu *sync.Mutex
+}
+
+// RegisterPWM registers the pwm output called name. chips named like
+// "pwmchip0" are pwm chips of the kernel and offset is the channel,
+// anything else is a gpio chip whose line offset is switched in software
+func RegisterPWM(name string, chip string, offset int, opts ...PWMOption) (p *PWM, err error) {
+	if name == "" {
+		return nil, OptionError{Field: "name", Value: name}
+	}
+	options := &PWMOptions{frequency: defaultFrequency}
+	for _, opt := range opts {
+		err = opt.applyPWMOption(options)
+		if err != nil {
+			return
+		}
+	}
+	p = &PWM{
+		name:   name,
+		period: time.Duration(float64(time.Second) / options.frequency),
+		duty:   options.duty,
+		mu:     &sync.Mutex{},
+	}
+	if strings.HasPrefix(chip, "pwmchip") {
+		p.driver, err = newSysfsPWM(filepath.Join(pwmSysfs, chip), offset)
+	} else {
+		p.handle, err = RegisterItem(chip, offset, AsOutput(), WithState(Inactive), WithOwner("pwm/"+name))
+		if err == nil {
+			// the line is switched far too often to go through its item,
+			// so it can't be shared or interlocked
+			var line *DirectLine
+			if line, err = p.handle.Direct(); err == nil {
+				p.driver = newSoftPWM(name, line)
+			} else {
+				p.handle.Release()
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = p.driver.Set(p.period, p.duty/100); err == nil {
+		err = pwms.Add(p)
+	}
+	if err != nil {
+		p.release()
+		return nil, err
+	}
+	logger.Infof("pwm %s registered on %d of %s at %vHz", name, offset, chip, options.frequency)
+	return p, nil
+}
+
+// GetPWM returns the registered pwm output called name
+func GetPWM(name string) (*PWM, error) {
+	pwms.RLock()
+	defer pwms.RUnlock()
+	p, ok := pwms.registry[name]
+	if !ok {
+		return nil, PWMNotFoundError{Name: name}
+	}
+	return p, nil
+}
+
+// PWMs returns every registered pwm output sorted by name
+func PWMs() (list []*PWM) {
+	pwms.RLock()
+	defer pwms.RUnlock()
+	for _, p := range pwms.registry {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(a, b int) bool { return list[a].name < list[b].name })
+	return
+}
+
+func (p *PWM) Name() string {
+	return p.name
+}
+
+// Duty returns the duty cycle in percent
+func (p *PWM) Duty() float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.duty
+}
+
+// SetDuty sets the duty cycle in percent right away, stopping any fade
+func (p *PWM) SetDuty(duty float64) error {
+	if duty < 0 || duty > 100 {
+		return DutyError{Name: p.name, Duty: duty}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fading++
+	return p.set(duty)
+}
+
+// set must be called with mu locked
+func (p *PWM) set(duty float64) error {
+	if err := p.driver.Set(p.period, duty/100); err != nil {
+		return err
+	}
+	p.duty = duty
+	return nil
+}
+
+// Frequency returns the frequency in Hz
+func (p *PWM) Frequency() float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return float64(time.Second) / float64(p.period)
+}
+
+// SetFrequency changes the frequency in Hz keeping the duty cycle
+func (p *PWM) SetFrequency(hz float64) error {
+	if hz <= 0 {
+		return OptionError{Field: "frequency", Value: hz}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	period := time.Duration(float64(time.Second) / hz)
+	if err := p.driver.Set(period, p.duty/100); err != nil {
+		return err
+	}
+	p.period = period
+	return nil
+}
+
+// Fade changes the duty cycle to duty percent linearly over d, it returns
+// right away and the fade is stopped by the next SetDuty or Fade
+func (p *PWM) Fade(duty float64, d time.Duration) error {
+	if duty < 0 || duty > 100 {
+		return DutyError{Name: p.name, Duty: duty}
+	}
+	if d < fadeStep {
+		return p.SetDuty(duty)
+	}
+	p.mu.Lock()
+	p.fading++
+	fading, from := p.fading, p.duty
+	p.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(fadeStep)
+		defer ticker.Stop()
+		start := time.Now()
+		for range ticker.C {
+			progress := float64(time.Since(start)) / float64(d)
+			if progress > 1 {
+				progress = 1
+			}
+			p.mu.Lock()
+			if fading != p.fading {
+				p.mu.Unlock()
+				return
+			}
+			err := p.set(from + (duty-from)*progress)
+			p.mu.Unlock()
+			if err != nil {
+				logger.Errorf("pwm %s stopped fading: %v", p.name, err)
+				return
+			}
+			if progress == 1 {
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Close turns the output off and releases it
+func (p *PWM) Close() error {
+	pwms.Remove(p)
+	p.mu.Lock()
+	p.fading++
+	p.mu.Unlock()
+	return p.release()
+}
+
+func (p *PWM) release() (err error) {
+	if p.driver != nil {
+		err = p.driver.Close()
+	}
+	if p.handle != nil && !p.handle.Released() {
+		if e := p.handle.Release(); err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+type pwmSetting struct {
+	period time.Duration
+	duty   float64
+}
+
+// softPWM switches a line in its own goroutine, the timing is only as
+// precise as the scheduler so it's meant for LEDs and fans, not servos
+type softPWM struct {
+	name     string
+	line     *DirectLine
+	settings chan pwmSetting
+	done     chan struct{}
+	// stopped is closed once the line was turned off for good
+	stopped chan struct{}
+	closing sync.Once
+	// err is why the line stopped being switched
+	err error
+
+	mu *sync.Mutex
+}
+
+func newSoftPWM(name string, line *DirectLine) *softPWM {
+	s := &softPWM{
+		name:     name,
+		line:     line,
+		settings: make(chan pwmSetting, 1),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+		mu:       &sync.Mutex{},
+	}
+	go s.run()
+	return s
+}
+
+func (s *softPWM) Set(period time.Duration, duty float64) error {
+	s.mu.Lock()
+	err := s.err
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	// only the latest setting matters
+	select {
+	case <-s.settings:
+	default:
+	}
+	s.settings <- pwmSetting{period: period, duty: duty}
+	return nil
+}
+
+// Close returns once the line is off, so it can be released right after
+func (s *softPWM) Close() error {
+	s.closing.Do(func() { close(s.done) })
+	<-s.stopped
+	return nil
+}
+
+// run switches the line until the pwm is closed or switching it fails
+func (s *softPWM) run() {
+	defer close(s.stopped)
+	var current pwmSetting
+	for {
+		// hold the line while it's fully on or off until something changes
+		if current.duty <= 0 || current.duty >= 1 {
+			state := Inactive
+			if current.duty >= 1 {
+				state = Active
+			}
+			if !s.set(state) {
+				return
+			}
+			select {
+			case current = <-s.settings:
+				continue
+			case <-s.done:
+				s.set(Inactive)
+				return
+			}
+		}
+		on := time.Duration(float64(current.period) * current.duty)
+		if !s.set(Active) {
+			return
+		}
+		time.Sleep(on)
+		if !s.set(Inactive) {
+			return
+		}
+		time.Sleep(current.period - on)
+		select {
+		case current = <-s.settings:
+		case <-s.done:
+			s.set(Inactive)
+			return
+		default:
+		}
+	}
+}
+
+// set switches the line, if that fails the error is kept for the next
+// Set and false is returned
+func (s *softPWM) set(state State) bool {
+	err := s.line.Set(state)
+	if err == nil {
+		return true
+	}
+	logger.Errorf("pwm %s stopped switching its line: %v", s.name, err)
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+	return false
+}
+
+// sysfsPWM drives a channel of a pwm chip through sysfs, the hardware
+// keeps the timing so it's precise at any frequency
+type sysfsPWM struct {
+	chip    string
+	channel string
+	period  time.Duration
+}
+
+func newSysfsPWM(chip string, channel int) (*sysfsPWM, error) {
+	s := &sysfsPWM{chip: chip, channel: filepath.Join(chip, fmt.Sprintf("pwm%d", channel))}
+	if _, err := os.Stat(s.channel); os.IsNotExist(err) {
+		if err = s.write(filepath.Join(chip, "export"), strconv.Itoa(channel)); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *sysfsPWM) write(path string, value string) error {
+	return ioutil.WriteFile(path, []byte(value), 0644)
+}
+
+func (s *sysfsPWM) Set(period time.Duration, duty float64) error {
+	// the duty cycle can never be longer than the period, so it's lowered
+	// before the period is shortened
+	if period != s.period {
+		if err := s.write(filepath.Join(s.channel, "duty_cycle"), "0"); err != nil {
+			return err
+		}
+		if err := s.write(filepath.Join(s.channel, "period"), strconv.FormatInt(period.Nanoseconds(), 10)); err != nil {
+			return err
+		}
+		s.period = period
+	}
+	dutyCycle := int64(float64(period.Nanoseconds()) * duty)
+	if err := s.write(filepath.Join(s.channel, "duty_cycle"), strconv.FormatInt(dutyCycle, 10)); err != nil {
+		return err
+	}
+	return s.write(filepath.Join(s.channel, "enable"), "1")
+}
+
+func (s *sysfsPWM) Close() error {
+	if err := s.write(filepath.Join(s.channel, "enable"), "0"); err != nil {
+		return err
+	}
+	return s.write(filepath.Join(s.chip, "unexport"), strings.TrimPrefix(filepath.Base(s.channel), "pwm"))
+}
+
+type pwmRegistry struct {
+	registry map[string]*PWM
+	*sync.RWMutex
+}
+
+func (r *pwmRegistry) Add(p *PWM) error {
+	r.Lock()
+	defer r.Unlock()
+	if _, ok := r.registry[p.name]; ok {
+		return DuplicatePWMError{Name: p.name}
+	}
+	r.registry[p.name] = p
+	return nil
+}
+
+func (r *pwmRegistry) Remove(p *PWM) {
+	r.Lock()
+	defer r.Unlock()
+	if r.registry[p.name] == p {
+		delete(r.registry, p.name)
+	}
+}
+
+type PWMOption interface {
+	applyPWMOption(*PWMOptions) error
+}
+
+type PWMOptions struct {
+	frequency float64
+	duty      float64
+}
+
+type FrequencyOption float64
+
+func (f FrequencyOption) applyPWMOption(o *PWMOptions) error {
+	if f <= 0 {
+		return OptionError{Field: "frequency", Value: float64(f)}
+	}
+	o.frequency = float64(f)
+	return nil
+}
+
+// WithFrequency sets the frequency in Hz, it's 200Hz by default
+func WithFrequency(hz float64) FrequencyOption {
+	return FrequencyOption(hz)
+}
+
+type DutyOption float64
+
+func (d DutyOption) applyPWMOption(o *PWMOptions) error {
+	if d < 0 || d > 100 {
+		return OptionError{Field: "duty", Value: float64(d)}
+	}
+	o.duty = float64(d)
+	return nil
+}
+
+// WithDuty sets the duty cycle in percent the output starts with, it's 0 by default
+func WithDuty(duty float64) DutyOption {
+	return DutyOption(duty)
+}
+
+type DutyError struct {
+	Name string
+	Duty float64
+}
+
+func (d DutyError) Error() string {
+	return fmt.Sprintf("pwm %s can't be set to %v%%, the duty cycle is between 0 and 100", d.Name, d.Duty)
+}
+
+type DuplicatePWMError struct {
+	Name string
+}
+
+func (d DuplicatePWMError) Error() string {
+	return fmt.Sprintf("pwm %s is already registered", d.Name)
+}
+
+type PWMNotFoundError struct {
+	Name string
+}
+
+func (p PWMNotFoundError) Error() string {
+	return fmt.Sprintf("there is no pwm named %s", p.Name)
+}
@@ -0,0 +1,70 @@
+package core
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/AliRostami1/baagh/pkg/controller/internal/testutil"
+)
+
+func TestSoftPWM(t *testing.T) {
+	device := testutil.NewPCF8574()
+	chip := fakeChip(t, device, AsPCF8574(1, 0x20))
+	p, err := RegisterPWM(chip+"-pwm", chip, 0, WithDuty(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	testutil.Eventually(t, "turning the line on at 100%", func() bool { return device.High(0) })
+	if err = p.SetDuty(0); err != nil {
+		t.Fatal(err)
+	}
+	testutil.Eventually(t, "turning the line off at 0%", func() bool { return !device.High(0) })
+
+	// nobody else can switch the line while it's a pwm
+	if _, err = RegisterItem(chip, 0, AsOutput(), WithState(Active), WithOwner("other")); err == nil {
+		t.Error("the line of a pwm was shared")
+	}
+}
+
+func TestSoftPWMRefused(t *testing.T) {
+	chip := fakeChip(t, testutil.NewPCF8574(), AsPCF8574(1, 0x20))
+	if _, err := RegisterInterlock(chip+"-interlock", WithLines(chip, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RegisterPWM(chip+"-interlocked", chip, 0); err == nil {
+		t.Error("an interlocked line became a pwm")
+	}
+	h, err := RegisterItem(chip, 2, AsOutput(), WithState(Inactive), WithOwner("other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = RegisterPWM(chip+"-shared", chip, 2); err == nil {
+		t.Error("a line that's already registered became a pwm")
+	}
+	if owners := h.Owners(); len(owners) != 1 || owners[0] != "other" {
+		t.Errorf("the owners of the shared line are %v after the pwm was refused", owners)
+	}
+}
+
+func TestSoftPWMFailure(t *testing.T) {
+	device := testutil.NewPCF8574()
+	chip := fakeChip(t, device, AsPCF8574(1, 0x20))
+	p, err := RegisterPWM(chip+"-pwm", chip, 0, WithFrequency(1000), WithDuty(50))
+	if err != nil {
+		t.Fatal(err)
+	}
+	broken := errors.New("bus is gone")
+	device.Break(broken)
+	testutil.Eventually(t, "the pwm noticing", func() bool { return errors.Is(p.SetDuty(50), broken) })
+	if err = p.SetDuty(20); !errors.Is(err, broken) {
+		t.Errorf("setting the duty cycle of a broken pwm returned %v", err)
+	}
+	if duty := p.Duty(); duty != 50 {
+		t.Errorf("duty is %v%% after setting it failed, want 50%%", duty)
+	}
+	device.Break(nil)
+	if err = p.Close(); err != nil {
+		t.Error(err)
+	}
+}
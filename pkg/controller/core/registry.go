@@ -119,25 +119,98 @@ func (n ItemNotFound) Error() string {
 	return fmt.Sprintf("there is no item registered on offset: %o", n.offset)
 }
 
+type ConflictError struct {
+	Chip      string
+	Offset    int
+	Field     string
+	Current   interface{}
+	Requested interface{}
+}
+
+func (c ConflictError) Error() string {
+	return fmt.Sprintf("line %d of %s is already registered with %s %v, can't register it with %v", c.Offset, c.Chip, c.Field, c.Current, c.Requested)
+}
+
+type NotOwnerError struct {
+	Chip   string
+	Offset int
+	Owner  string
+}
+
+func (n NotOwnerError) Error() string {
+	return fmt.Sprintf("%s doesn't own line %d of %s", n.Owner, n.Offset, n.Chip)
+}
+
+type ReleasedError struct {
+	Chip   string
+	Offset int
+	Owner  string
+}
+
+func (r ReleasedError) Error() string {
+	return fmt.Sprintf("handle of %s on line %d of %s is already released", r.Owner, r.Offset, r.Chip)
+}
+
+type EdgeError struct {
+	Chip   string
+	Offset int
+}
+
+func (e EdgeError) Error() string {
+	return fmt.Sprintf("line %d of %s is not an input, it has no edges", e.Offset, e.Chip)
+}
+
 type ItemEvent struct {
 	Item *Item
+	// State is what the item changed to, handlers run asynchronously so
+	// the item itself might have changed again by the time they're called
+	State State
+	// Owner is the owner of the handle the change was made through, it's
+	// empty for edges and changes made without a handle
+	Owner string
 }
 
 type EventHandler func(event *ItemEvent)
 
 type eventRegistry struct {
 	events []EventHandler
+	// ids are the ids of the handles that added events, 0 for the ones
+	// that are never removed
+	ids []int
 	*sync.RWMutex
 }
 
 func (e *eventRegistry) AddEventListener(fn ...EventHandler) error {
+	return e.add(0, fn...)
+}
+
+func (e *eventRegistry) add(id int, fn ...EventHandler) error {
 	e.Lock()
 	defer e.Unlock()
 
 	e.events = append(e.events, fn...)
+	for range fn {
+		e.ids = append(e.ids, id)
+	}
 	return nil
 }
 
+// remove removes the handlers added by the handle with id, they're copied
+// so the ones that are being called aren't touched
+func (e *eventRegistry) remove(id int) {
+	e.Lock()
+	defer e.Unlock()
+	events := make([]EventHandler, 0, len(e.events))
+	ids := make([]int, 0, len(e.ids))
+	for index, eh := range e.events {
+		if e.ids[index] != id {
+			events = append(events, eh)
+			ids = append(ids, e.ids[index])
+		}
+	}
+	e.events, e.ids = events, ids
+}
+
 func (e *eventRegistry) ForEach(cb func(index int, handler EventHandler)) {
 	e.Lock()
 	ev := e.events
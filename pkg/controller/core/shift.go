@@ -0,0 +1,235 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+
+	"go.uber.org/multierr"
+)
+
+// RegisterShiftRegister registers count chained 74HC595 shift registers,
+// wired to the data, clock and latch lines of chip, as the chip called
+// name. its offsets are the outputs, 0 to 7 are Q0 to Q7 of the register
+// wired to chip, 8 to 15 the ones of the register after it and so on.
+// every output starts off
+func RegisterShiftRegister(name string, chip string, data int, clock int, latch int, count int) (c *Chip, err error) {
+	if name == "" {
+		return nil, OptionError{Field: "name", Value: name}
+	}
+	if count < 1 {
+		return nil, OptionError{Field: "count", Value: count}
+	}
+	parent, err := chips.Get(chip)
+	if err != nil {
+		return nil, err
+	}
+	s := &shiftRegister{
+		name:    name,
+		outputs: make([]int, count*8),
+		mu:      &sync.Mutex{},
+	}
+	owner := "shift/" + name
+	for i, offset := range []int{data, clock, latch} {
+		var h *ItemHandle
+		h, err = parent.RegisterItem(offset, AsOutput(), WithState(Inactive), WithOwner(owner))
+		if err != nil {
+			s.release()
+			return nil, err
+		}
+		s.handles = append(s.handles, h)
+		h.Item.mu.RLock()
+		s.lines[i] = h.Item.line
+		h.Item.mu.RUnlock()
+	}
+	if err = s.shift(); err != nil {
+		s.release()
+		return nil, err
+	}
+	c = &Chip{
+		driver:   s,
+		name:     name,
+		consumer: parent.consumer,
+		parent:   chip,
+		items:    &itemRegistry{registry: map[int]*Item{}, RWMutex: &sync.RWMutex{}},
+		mu:       &sync.RWMutex{},
+	}
+	if err = chips.Append(name, c); err != nil {
+		s.release()
+		return nil, err
+	}
+	logger.Infof("shift register %s registered on lines %d, %d and %d of %s with %d outputs", name, data, clock, latch, chip, count*8)
+	return c, nil
+}
+
+// Batch calls fn and changes every output it set on the chip called
+// chipName at once when it returns, see Chip.Batch
+func Batch(chipName string, fn func() error) error {
+	c, err := chips.Get(chipName)
+	if err != nil {
+		return err
+	}
+	return c.Batch(fn)
+}
+
+// batcher is implemented by chip drivers that can hold back changes and
+// apply them together
+type batcher interface {
+	hold()
+	flush() error
+}
+
+// Batch calls fn and, on chips that support it like shift registers,
+// holds back the outputs it sets until it returns so they all change at
+// the same moment. on other chips the outputs change as they're set
+func (c *Chip) Batch(fn func() error) error {
+	b, ok := c.driver.(batcher)
+	if !ok {
+		return fn()
+	}
+	b.hold()
+	err := fn()
+	return multierr.Append(err, b.flush())
+}
+
+// shiftRegister shifts the state of every output out on each change and
+// latches them together, so the outputs never show the bits passing by
+type shiftRegister struct {
+	name string
+	// handles hold the data, clock and latch lines and lines drive them
+	// directly, so the bits passing by aren't events of those items
+	handles []*ItemHandle
+	lines   [3]lineDriver
+	// outputs are the values of every output, indexed by offset
+	outputs []int
+	// holding counts the batches in progress, nothing is shifted out
+	// until the last one ends
+	holding int
+
+	mu *sync.Mutex
+}
+
+func (s *shiftRegister) RequestLine(offset int, config lineConfig) (lineDriver, error) {
+	if offset < 0 || offset >= len(s.outputs) {
+		return nil, OffsetError{Chip: s.name, Offset: offset, Lines: len(s.outputs)}
+	}
+	if config.mode != Output {
+		return nil, OutputOnlyError{Chip: s.name, Offset: offset}
+	}
+	l := &shiftLine{register: s, offset: offset}
+	if err := l.SetValue(int(config.state)); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (s *shiftRegister) hold() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.holding++
+}
+
+func (s *shiftRegister) flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.holding--
+	if s.holding > 0 {
+		return nil
+	}
+	return s.shift()
+}
+
+func (s *shiftRegister) set(offset int, value int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.outputs[offset] = value
+	if s.holding > 0 {
+		return nil
+	}
+	return s.shift()
+}
+
+// shift clocks every output out, the last one first so it ends up in the
+// last register of the chain, and then latches them. must be called with
+// mu locked
+func (s *shiftRegister) shift() error {
+	data, clock, latch := s.lines[0], s.lines[1], s.lines[2]
+	for i := len(s.outputs) - 1; i >= 0; i-- {
+		if err := data.SetValue(s.outputs[i]); err != nil {
+			return err
+		}
+		if err := pulseLine(clock); err != nil {
+			return err
+		}
+	}
+	return pulseLine(latch)
+}
+
+// pulseLine sets l active and back, the 74HC595 only needs a few
+// nanoseconds so there's no need to wait in between
+func pulseLine(l lineDriver) error {
+	if err := l.SetValue(1); err != nil {
+		return err
+	}
+	return l.SetValue(0)
+}
+
+// Close turns every output off and releases the lines driving the chain
+func (s *shiftRegister) Close() (err error) {
+	s.mu.Lock()
+	for i := range s.outputs {
+		s.outputs[i] = 0
+	}
+	s.holding = 0
+	err = s.shift()
+	s.mu.Unlock()
+	return multierr.Append(err, s.release())
+}
+
+func (s *shiftRegister) release() (err error) {
+	for _, h := range s.handles {
+		if !h.Released() {
+			err = multierr.Append(err, h.Release())
+		}
+	}
+	return
+}
+
+// shiftLine is a single output of a shift register
+type shiftLine struct {
+	register *shiftRegister
+	offset   int
+}
+
+func (l *shiftLine) Value() (int, error) {
+	l.register.mu.Lock()
+	defer l.register.mu.Unlock()
+	return l.register.outputs[l.offset], nil
+}
+
+func (l *shiftLine) SetValue(value int) error {
+	return l.register.set(l.offset, value)
+}
+
+// Close turns the output off, the register itself stays in use
+func (l *shiftLine) Close() error {
+	return l.register.set(l.offset, 0)
+}
+
+type OffsetError struct {
+	Chip   string
+	Offset int
+	Lines  int
+}
+
+func (o OffsetError) Error() string {
+	return fmt.Sprintf("%s has no line %d, it only has %d lines", o.Chip, o.Offset, o.Lines)
+}
+
+type OutputOnlyError struct {
+	Chip   string
+	Offset int
+}
+
+func (o OutputOnlyError) Error() string {
+	return fmt.Sprintf("line %d of %s can't be an input, %s only has outputs", o.Offset, o.Chip, o.Chip)
+}
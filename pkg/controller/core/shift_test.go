@@ -0,0 +1,173 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/AliRostami1/baagh/pkg/controller/internal/testutil"
+)
+
+// the data, clock and latch lines of the shift registers are on lines 0 to
+// 2 of a PCF8574
+const (
+	shiftData  = 0
+	shiftClock = 1
+	shiftLatch = 2
+)
+
+// hc595 plays chained 74HC595s back from what was written to the lines
+// driving them
+type hc595 struct {
+	last     byte
+	shifted  []bool
+	latched  []bool
+	latches  []string
+	clocking int
+}
+
+func newHC595(count int) *hc595 {
+	// every line of a PCF8574 is high after power up
+	return &hc595{last: 0xff, shifted: make([]bool, count*8), latched: make([]bool, count*8)}
+}
+
+func (h *hc595) play(writes []byte) {
+	high := func(b byte, line int) bool { return b&(1<<line) != 0 }
+	for _, b := range writes {
+		if !high(h.last, shiftClock) && high(b, shiftClock) {
+			// Q7 of every register is shifted into Q0 of the next one
+			copy(h.shifted[1:], h.shifted)
+			h.shifted[0] = high(b, shiftData)
+			h.clocking++
+		}
+		if !high(h.last, shiftLatch) && high(b, shiftLatch) {
+			copy(h.latched, h.shifted)
+			h.latches = append(h.latches, h.outputs())
+		}
+		h.last = b
+	}
+}
+
+// outputs returns the latched outputs, Q0 of the first register first
+func (h *hc595) outputs() (s string) {
+	for n, q := range h.latched {
+		if n > 0 && n%8 == 0 {
+			s += " "
+		}
+		s += map[bool]string{true: "1", false: "0"}[q]
+	}
+	return
+}
+
+// shiftChip registers two chained shift registers and returns their name
+func shiftChip(t *testing.T, device *testutil.PCF8574) string {
+	t.Helper()
+	chip := fakeChip(t, device, AsPCF8574(1, 0x20))
+	name := chip + "-shift"
+	s, err := RegisterShiftRegister(name, chip, shiftData, shiftClock, shiftLatch, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Cleanup() })
+	return name
+}
+
+func TestShiftRegisterOrder(t *testing.T) {
+	device := testutil.NewPCF8574()
+	h := newHC595(2)
+	name := shiftChip(t, device)
+	h.play(device.Writes())
+	if got := h.outputs(); got != "00000000 00000000" || len(h.latches) != 1 {
+		t.Fatalf("the registers latched %v, want every output off once", h.latches)
+	}
+
+	set := func(offset int, state State) {
+		t.Helper()
+		i, err := RegisterItem(name, offset, AsOutput())
+		if err != nil {
+			t.Fatal(err)
+		}
+		// registering the output shifts everything out too
+		h.play(device.Writes())
+		h.latches, h.clocking = nil, 0
+		if err = i.SetState(state); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tt := range []struct {
+		offset int
+		state  State
+		want   string
+	}{
+		{0, Active, "10000000 00000000"},
+		{7, Active, "10000001 00000000"},
+		{8, Active, "10000001 10000000"},
+		{14, Active, "10000001 10000010"},
+		{0, Inactive, "00000001 10000010"},
+	} {
+		t.Run(fmt.Sprintf("%d %s", tt.offset, tt.state), func(t *testing.T) {
+			set(tt.offset, tt.state)
+			h.play(device.Writes())
+			if len(h.latches) != 1 || h.latches[0] != tt.want {
+				t.Errorf("the registers latched %v, want %s once", h.latches, tt.want)
+			}
+			if h.clocking != 16 {
+				t.Errorf("%d bits were clocked in, want 16", h.clocking)
+			}
+		})
+	}
+}
+
+func TestShiftRegisterBatch(t *testing.T) {
+	device := testutil.NewPCF8574()
+	h := newHC595(2)
+	name := shiftChip(t, device)
+	var items []*ItemHandle
+	for offset := 0; offset < 16; offset++ {
+		i, err := RegisterItem(name, offset, AsOutput())
+		if err != nil {
+			t.Fatal(err)
+		}
+		items = append(items, i)
+	}
+	h.play(device.Writes())
+	h.latches = nil
+
+	err := Batch(name, func() error {
+		for _, offset := range []int{1, 3, 9} {
+			if err := items[offset].SetState(Active); err != nil {
+				return err
+			}
+		}
+		// a batch inside a batch doesn't latch when it ends
+		err := Batch(name, func() error {
+			return items[15].SetState(Active)
+		})
+		h.play(device.Writes())
+		if len(h.latches) != 0 {
+			t.Errorf("the registers latched %v before the outer batch ended", h.latches)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.play(device.Writes())
+	if want := "01010000 01000001"; len(h.latches) != 1 || h.latches[0] != want {
+		t.Errorf("the registers latched %v, want %s once", h.latches, want)
+	}
+
+	// a failing batch still latches what was set before it failed
+	h.latches = nil
+	failed := fmt.Errorf("failed")
+	err = Batch(name, func() error {
+		items[1].SetState(Inactive)
+		return failed
+	})
+	if err != failed {
+		t.Errorf("the batch failed with %v, want %v", err, failed)
+	}
+	h.play(device.Writes())
+	if want := "00010000 01000001"; len(h.latches) != 1 || h.latches[0] != want {
+		t.Errorf("the registers latched %v, want %s once", h.latches, want)
+	}
+}
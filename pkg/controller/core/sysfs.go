@@ -0,0 +1,295 @@
+package core
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// exportTimeout is how long an exported line may take to show up, udev
+	// may still be fixing its permissions
+	exportTimeout = time.Second
+	// sysfsPolling is how often the value of an input is read when its
+	// file can't be waited on, like in a fake sysfs tree
+	sysfsPolling = 10 * time.Millisecond
+	// epollTimeout is how long a wait for an edge lasts before checking
+	// whether the line was closed, in milliseconds
+	epollTimeout = 100
+
+	// defaultGPIOSysfs is where the kernel exposes its legacy gpio interface
+	defaultGPIOSysfs = "/sys/class/gpio"
+)
+
+// sysfsChip drives a chip through the legacy sysfs interface, for kernels
+// without the gpio character device. its lines are numbered from the
+// base of the chip
+type sysfsChip struct {
+	// root is where the interface is, usually defaultGPIOSysfs
+	root  string
+	name  string
+	base  int
+	ngpio int
+	// start is what the timestamps of edges are relative to
+	start time.Time
+}
+
+func newSysfsChip(root string, name string) (*sysfsChip, error) {
+	c := &sysfsChip{root: root, name: name, start: time.Now()}
+	var err error
+	if c.base, err = readInt(filepath.Join(root, name, "base")); err != nil {
+		return nil, err
+	}
+	if c.ngpio, err = readInt(filepath.Join(root, name, "ngpio")); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *sysfsChip) RequestLine(offset int, config lineConfig) (lineDriver, error) {
+	if offset < 0 || offset >= c.ngpio {
+		return nil, OffsetError{Chip: c.name, Offset: offset, Lines: c.ngpio}
+	}
+	number := strconv.Itoa(c.base + offset)
+	l := &sysfsLine{
+		chip:    c,
+		offset:  offset,
+		number:  number,
+		dir:     filepath.Join(c.root, "gpio"+number),
+		handler: config.handler,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if err := l.export(); err != nil {
+		l.unexport()
+		return nil, err
+	}
+	err := l.configure(config)
+	if err == nil && config.mode == Input {
+		err = l.watch()
+	} else {
+		close(l.stopped)
+	}
+	if err != nil {
+		l.unexport()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (c *sysfsChip) Close() error {
+	return nil
+}
+
+// sysfsLine is a single exported line, edges of inputs are waited for by
+// polling its value file
+type sysfsLine struct {
+	chip    *sysfsChip
+	offset  int
+	number  string
+	dir     string
+	handler func(Edge)
+	// exported is true if the line was exported by us and not by someone
+	// before, only then is it unexported when it's closed
+	exported bool
+	done     chan struct{}
+	// stopped is closed once an input stopped being watched, it's closed
+	// right away for outputs
+	stopped chan struct{}
+	closing sync.Once
+}
+
+func (l *sysfsLine) export() error {
+	if _, err := os.Stat(l.dir); err == nil {
+		return nil
+	}
+	if err := writeFile(filepath.Join(l.chip.root, "export"), l.number); err != nil {
+		return err
+	}
+	l.exported = true
+	deadline := time.Now().Add(exportTimeout)
+	for {
+		// the line is ready once its direction can be written
+		f, err := os.OpenFile(filepath.Join(l.dir, "direction"), os.O_WRONLY, 0)
+		if err == nil {
+			return f.Close()
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (l *sysfsLine) unexport() error {
+	if !l.exported {
+		return nil
+	}
+	return writeFile(filepath.Join(l.chip.root, "unexport"), l.number)
+}
+
+// configure sets the direction and the edges the line reports. sysfs
+// can't set a bias, so the pull is left as the board configured it
+func (l *sysfsLine) configure(config lineConfig) error {
+	if config.mode == Output {
+		// setting the direction to high or low also sets the value, so
+		// the output never glitches
+		direction := "low"
+		if config.state == Active {
+			direction = "high"
+		}
+		if err := writeFile(filepath.Join(l.dir, "direction"), direction); err != nil {
+			return err
+		}
+		return l.setEdge("none")
+	}
+	if config.pull != PullUnknown && config.pull != PullDisabled {
+		logger.Warnf("line %d of %s can't be pulled %s through sysfs, it's left as is", l.offset, l.chip.name, config.pull)
+	}
+	if err := writeFile(filepath.Join(l.dir, "direction"), "in"); err != nil {
+		return err
+	}
+	return l.setEdge("both")
+}
+
+// setEdge is ignored on lines that can't interrupt, the edge file is
+// missing there
+func (l *sysfsLine) setEdge(edge string) error {
+	err := writeFile(filepath.Join(l.dir, "edge"), edge)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// watch calls the handler for every edge until the line is closed. the
+// kernel wakes up pollers of the value file on an edge, files that can't
+// be waited on are read periodically instead
+func (l *sysfsLine) watch() error {
+	f, err := os.Open(filepath.Join(l.dir, "value"))
+	if err != nil {
+		return err
+	}
+	last, err := readValue(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	event := syscall.EpollEvent{Events: syscall.EPOLLPRI | syscall.EPOLLERR, Fd: int32(f.Fd())}
+	pollable := syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, int(f.Fd()), &event) == nil
+
+	go func() {
+		defer close(l.stopped)
+		defer f.Close()
+		defer syscall.Close(epfd)
+		ticker := time.NewTicker(sysfsPolling)
+		defer ticker.Stop()
+		events := make([]syscall.EpollEvent, 1)
+		for {
+			if pollable {
+				n, err := syscall.EpollWait(epfd, events, epollTimeout)
+				if err != nil && !errors.Is(err, syscall.EINTR) {
+					logger.Errorf("stopped watching line %d of %s: %v", l.offset, l.chip.name, err)
+					return
+				}
+				select {
+				case <-l.done:
+					return
+				default:
+				}
+				if n == 0 {
+					continue
+				}
+			} else {
+				select {
+				case <-ticker.C:
+				case <-l.done:
+					return
+				}
+			}
+			value, err := readValue(f)
+			if err != nil {
+				logger.Errorf("couldn't read line %d of %s: %v", l.offset, l.chip.name, err)
+				continue
+			}
+			if value == last {
+				continue
+			}
+			last = value
+			edge := Edge{Type: FallingEdge, Timestamp: time.Since(l.chip.start)}
+			if value == 1 {
+				edge.Type = RisingEdge
+			}
+			l.handler(edge)
+		}
+	}()
+	return nil
+}
+
+func (l *sysfsLine) Value() (int, error) {
+	f, err := os.Open(filepath.Join(l.dir, "value"))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return readValue(f)
+}
+
+func (l *sysfsLine) SetValue(value int) error {
+	return writeFile(filepath.Join(l.dir, "value"), strconv.Itoa(value))
+}
+
+// Close stops watching the line and unexports it if it was exported by us
+func (l *sysfsLine) Close() error {
+	l.closing.Do(func() { close(l.done) })
+	<-l.stopped
+	return l.unexport()
+}
+
+// readValue reads a value file from its start, reading it is also what
+// lets the kernel report the next edge
+func readValue(f *os.File) (int, error) {
+	if _, err := f.Seek(0, 0); err != nil {
+		return 0, err
+	}
+	b := make([]byte, 2)
+	n, err := f.Read(b)
+	if err != nil {
+		return 0, err
+	}
+	if n > 0 && b[0] == '1' {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func readInt(path string) (int, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(b)))
+}
+
+func writeFile(path string, value string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	if _, err = f.WriteString(value); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
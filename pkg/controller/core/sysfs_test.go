@@ -0,0 +1,178 @@
+package core
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/controller/internal/testutil"
+)
+
+// fakeSysfs simulates the legacy gpio interface in a temporary directory,
+// it exports and unexports the lines written to its export and unexport
+// files like the kernel does
+type fakeSysfs struct {
+	root string
+	// exported and unexported are the numbers written to the files
+	exported   []string
+	unexported []string
+	mu         *sync.Mutex
+}
+
+func newFakeSysfs(t *testing.T, chip string, base int, ngpio int) *fakeSysfs {
+	t.Helper()
+	f := &fakeSysfs{root: t.TempDir(), mu: &sync.Mutex{}}
+	for path, content := range map[string]string{
+		"export":                     "",
+		"unexport":                   "",
+		filepath.Join(chip, "base"):  strconv.Itoa(base) + "\n",
+		filepath.Join(chip, "ngpio"): strconv.Itoa(ngpio) + "\n",
+	} {
+		f.write(t, path, content)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go func() {
+		for ctx.Err() == nil {
+			f.serve(t, "export")
+			f.serve(t, "unexport")
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	return f
+}
+
+func (f *fakeSysfs) write(t *testing.T, path string, content string) {
+	path = filepath.Join(f.root, path)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Error(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Error(err)
+	}
+}
+
+func (f *fakeSysfs) read(t *testing.T, path string) string {
+	t.Helper()
+	b, err := ioutil.ReadFile(filepath.Join(f.root, path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// serve handles what was written to the export or unexport file
+func (f *fakeSysfs) serve(t *testing.T, file string) {
+	b, err := ioutil.ReadFile(filepath.Join(f.root, file))
+	if err != nil || len(b) == 0 {
+		return
+	}
+	number := strings.TrimSpace(string(b))
+	f.write(t, file, "")
+	dir := "gpio" + number
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if file == "export" {
+		f.exported = append(f.exported, number)
+		f.write(t, filepath.Join(dir, "direction"), "in\n")
+		f.write(t, filepath.Join(dir, "edge"), "none\n")
+		f.write(t, filepath.Join(dir, "value"), "0\n")
+		return
+	}
+	f.unexported = append(f.unexported, number)
+	os.RemoveAll(filepath.Join(f.root, dir))
+}
+
+func (f *fakeSysfs) log() (exported []string, unexported []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.exported...), append([]string{}, f.unexported...)
+}
+
+func TestSysfs(t *testing.T) {
+	// sysfs chips are named like their directory, which has to be unique
+	// since chips can't be unregistered
+	chip := testutil.ChipName(t)
+	fake := newFakeSysfs(t, chip, 32, 8)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := RegisterChip(ctx, WithName(chip+"-missing"), WithSysfsRoot(fake.root)); err == nil {
+		t.Error("a chip that isn't in the tree was registered")
+	}
+	if _, err := RegisterChip(ctx, WithName(chip), WithSysfsRoot(fake.root)); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := RegisterItem(chip, 3, AsOutput(), WithState(Active), WithOwner("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if direction := fake.read(t, "gpio35/direction"); direction != "high" {
+		t.Errorf("the output's direction is %s, want high so it starts active", direction)
+	}
+	if edge := fake.read(t, "gpio35/edge"); edge != "none" {
+		t.Errorf("the output reports %s edges", edge)
+	}
+	if err = output.SetState(Inactive); err != nil {
+		t.Fatal(err)
+	}
+	if value := fake.read(t, "gpio35/value"); value != "0" {
+		t.Errorf("the output's value is %s after turning it off", value)
+	}
+
+	input, err := RegisterItem(chip, 5, AsInput(PullDisabled), WithOwner("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if direction, edge := fake.read(t, "gpio37/direction"), fake.read(t, "gpio37/edge"); direction != "in" || edge != "both" {
+		t.Errorf("the input's direction is %s and it reports %s edges, want in and both", direction, edge)
+	}
+	edges := make(chan Edge, 10)
+	if err = input.AddEdgeListener(func(edge Edge) { edges <- edge }); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		value string
+		edge  EdgeType
+		state State
+	}{{"1", RisingEdge, Active}, {"0", FallingEdge, Inactive}} {
+		fake.write(t, "gpio37/value", tt.value+"\n")
+		select {
+		case edge := <-edges:
+			if edge.Type != tt.edge {
+				t.Errorf("writing %s made a %s edge, want %s", tt.value, edge.Type, tt.edge)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("writing %s made no edge", tt.value)
+		}
+		testutil.Eventually(t, "the input turning "+tt.state.String(), func() bool { return input.State() == tt.state })
+	}
+
+	// a line someone else exported is left exported
+	fake.write(t, "gpio38/direction", "in\n")
+	fake.write(t, "gpio38/value", "0\n")
+	other, err := RegisterItem(chip, 6, AsOutput(), WithState(Inactive), WithOwner("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range []*ItemHandle{output, input, other} {
+		if err = h.Release(); err != nil {
+			t.Error(err)
+		}
+		// the kernel has to handle an unexport before the next one
+		time.Sleep(10 * time.Millisecond)
+	}
+	exported, unexported := fake.log()
+	if strings.Join(exported, ",") != "35,37" || strings.Join(unexported, ",") != "35,37" {
+		t.Errorf("exported %v and unexported %v, want 35 and 37", exported, unexported)
+	}
+	if _, err = RegisterItem(chip, 8, AsInput(PullDisabled), WithOwner("test")); err == nil {
+		t.Error("a line past the end of the chip was registered")
+	}
+}
@@ -2,6 +2,8 @@ package core
 
 import (
 	"fmt"
+
+	"github.com/warthog618/gpiod"
 )
 
 type State int
@@ -22,6 +24,14 @@ func (s State) String() string {
 	}
 }
 
+// invert returns the other state
+func (s State) invert() State {
+	if s == Active {
+		return Inactive
+	}
+	return Active
+}
+
 func (s State) Check() error {
 	if s == Active || s == Inactive {
 		return nil
@@ -98,6 +108,19 @@ func (p Pull) Check() error {
 	return InvalidPullError{}
 }
 
+func (p Pull) bias() gpiod.BiasOption {
+	switch p {
+	case PullDisabled:
+		return gpiod.WithBiasDisabled
+	case PullDown:
+		return gpiod.WithPullDown
+	case PullUp:
+		return gpiod.WithPullUp
+	default:
+		return gpiod.WithBiasAsIs
+	}
+}
+
 type InvalidPullError struct{}
 
 func (i InvalidPullError) Error() string {
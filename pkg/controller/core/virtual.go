@@ -0,0 +1,155 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/AliRostami1/baagh/pkg/controller/store"
+)
+
+// VirtualChip is the name of the chip every virtual item lives on, virtual
+// items are software only flags that aren't backed by any line
+const VirtualChip = "virtual"
+
+var virtual = &virtualChip{
+	names:   map[string]int{},
+	offsets: map[int]string{},
+	RWMutex: &sync.RWMutex{},
+}
+
+var db store.Store = store.NewMemory()
+
+// SetStore sets where the state of virtual items is persisted
+func SetStore(s store.Store) error {
+	if s == nil {
+		return fmt.Errorf("store can't be nil")
+	}
+	db = s
+	return nil
+}
+
+// RegisterVirtualItem registers a virtual item, its last known state is
+// restored from the store and every change to it is persisted
+func RegisterVirtualItem(name string, opts ...ItemOption) (handle *ItemHandle, err error) {
+	if name == "" {
+		return nil, OptionError{Field: "name", Value: name}
+	}
+	c, err := chips.Get(VirtualChip)
+	if err != nil {
+		return nil, err
+	}
+	opts = append([]ItemOption{AsOutput()}, opts...)
+	var state State
+	err = db.Get(virtualKey(name), &state)
+	if err == nil && state.Check() == nil {
+		opts = append(opts, WithState(state))
+	} else if _, ok := err.(store.NotFoundError); err != nil && !ok {
+		logger.Warnf("couldn't restore the state of virtual item %s: %v", name, err)
+	}
+	return c.RegisterItem(virtual.offsetOf(name), opts...)
+}
+
+func GetVirtualItem(name string) (*Item, error) {
+	offset, ok := virtual.lookup(name)
+	if !ok {
+		return nil, VirtualItemNotFoundError{Name: name}
+	}
+	return GetItem(VirtualChip, offset)
+}
+
+func SetVirtualState(name string, state State) error {
+	i, err := GetVirtualItem(name)
+	if err != nil {
+		return err
+	}
+	return i.SetState(state)
+}
+
+func virtualKey(name string) string {
+	return "virtual/" + name
+}
+
+// virtualChip hands out an offset for every name, so virtual items can be
+// addressed just like the lines of any other chip
+type virtualChip struct {
+	names   map[string]int
+	offsets map[int]string
+	*sync.RWMutex
+}
+
+func (v *virtualChip) offsetOf(name string) int {
+	v.Lock()
+	defer v.Unlock()
+	if offset, ok := v.names[name]; ok {
+		return offset
+	}
+	offset := len(v.names)
+	v.names[name] = offset
+	v.offsets[offset] = name
+	return offset
+}
+
+func (v *virtualChip) lookup(name string) (int, bool) {
+	v.Lock()
+	defer v.Unlock()
+	offset, ok := v.names[name]
+	return offset, ok
+}
+
+func (v *virtualChip) nameOf(offset int) (string, bool) {
+	v.Lock()
+	defer v.Unlock()
+	name, ok := v.offsets[offset]
+	return name, ok
+}
+
+func (v *virtualChip) RequestLine(offset int, config lineConfig) (lineDriver, error) {
+	name, ok := v.nameOf(offset)
+	if !ok {
+		return nil, ItemNotFound{offset: offset}
+	}
+	return &virtualLine{
+		key:   virtualKey(name),
+		value: int(config.state),
+		mu:    &sync.RWMutex{},
+	}, nil
+}
+
+func (v *virtualChip) Close() error {
+	return nil
+}
+
+type virtualLine struct {
+	key   string
+	value int
+
+	mu *sync.RWMutex
+}
+
+func (v *virtualLine) Value() (int, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.value, nil
+}
+
+func (v *virtualLine) SetValue(value int) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if err := db.Put(v.key, State(value)); err != nil {
+		return err
+	}
+	v.value = value
+	return nil
+}
+
+func (v *virtualLine) Close() error {
+	return nil
+}
+
+type VirtualItemNotFoundError struct {
+	Name string
+}
+
+func (v VirtualItemNotFoundError) Error() string {
+	return fmt.Sprintf("there is no virtual item named %s", v.Name)
+}
@@ -0,0 +1,50 @@
+// Package daytime handles times of day like "22:00" that aren't tied to a date
+package daytime
+
+import (
+	"fmt"
+	"time"
+)
+
+// Parse parses "15:04" into the time since midnight
+func Parse(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %s, it should look like 15:04", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Window is the part of every day from From to To, both since midnight.
+// it spans midnight when To isn't after From
+type Window struct {
+	From, To time.Duration
+}
+
+// ParseWindow parses a window between two times of day like "22:00" and "06:00"
+func ParseWindow(from string, to string) (w Window, err error) {
+	if w.From, err = Parse(from); err != nil {
+		return
+	}
+	w.To, err = Parse(to)
+	return
+}
+
+// Next returns the occurrence of the window t is in, or the next one if
+// it's outside of it
+func (w Window) Next(t time.Time) (start, end time.Time) {
+	to := w.To
+	if to <= w.From {
+		to += 24 * time.Hour
+	}
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	// a window spanning midnight might have started yesterday
+	for day := -1; day <= 1; day++ {
+		start = midnight.AddDate(0, 0, day).Add(w.From)
+		end = midnight.AddDate(0, 0, day).Add(to)
+		if t.Before(end) {
+			return
+		}
+	}
+	return
+}
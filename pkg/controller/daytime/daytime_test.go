@@ -0,0 +1,61 @@
+package daytime
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		s    string
+		want time.Duration
+	}{
+		{"00:00", 0},
+		{"06:30", 6*time.Hour + 30*time.Minute},
+		{"23:59", 23*time.Hour + 59*time.Minute},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.s)
+		if err != nil || got != tt.want {
+			t.Errorf("Parse(%q) = %s, %v, want %s", tt.s, got, err, tt.want)
+		}
+	}
+	for _, s := range []string{"", "24:00", "12:60", "6pm", "12:00:00"} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("Parse(%q) succeeded", s)
+		}
+	}
+}
+
+func TestWindowNext(t *testing.T) {
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2021, time.June, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		from, to   string
+		t          time.Time
+		start, end time.Time
+	}{
+		// inside a window within the day
+		{"08:00", "17:00", at(4, 12, 0), at(4, 8, 0), at(4, 17, 0)},
+		{"08:00", "17:00", at(4, 6, 0), at(4, 8, 0), at(4, 17, 0)},
+		// the end belongs to the next window
+		{"08:00", "17:00", at(4, 17, 0), at(5, 8, 0), at(5, 17, 0)},
+		// spanning midnight, in the part before and after it
+		{"22:00", "06:00", at(4, 23, 0), at(4, 22, 0), at(5, 6, 0)},
+		{"22:00", "06:00", at(4, 3, 0), at(3, 22, 0), at(4, 6, 0)},
+		{"22:00", "06:00", at(4, 12, 0), at(4, 22, 0), at(5, 6, 0)},
+		// the same times make a whole day
+		{"07:00", "07:00", at(4, 12, 0), at(4, 7, 0), at(5, 7, 0)},
+	}
+	for _, tt := range tests {
+		w, err := ParseWindow(tt.from, tt.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		start, end := w.Next(tt.t)
+		if !start.Equal(tt.start) || !end.Equal(tt.end) {
+			t.Errorf("%s-%s at %s = %s - %s, want %s - %s", tt.from, tt.to, tt.t, start, end, tt.start, tt.end)
+		}
+	}
+}
@@ -0,0 +1,398 @@
+package general
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/controller/core"
+	"github.com/AliRostami1/baagh/pkg/controller/store"
+	"go.uber.org/multierr"
+)
+
+const (
+	// Closed and Open are the positions at the two ends of travel of a cover
+	Closed float64 = 0
+	Open   float64 = 100
+
+	coverPrefix = "cover/"
+)
+
+var db store.Store = store.NewMemory()
+
+// SetStore sets where the position of covers is persisted
+func SetStore(s store.Store) error {
+	if s == nil {
+		return fmt.Errorf("store can't be nil")
+	}
+	db = s
+	return nil
+}
+
+// cover drives the up and down relays of a roller shutter or a blind, there
+// is no feedback from the motor so the position is estimated from how long
+// it has been moving. runs to either end go on for a bit longer than needed
+// so the cover ends up against its end stop, which calibrates the estimate
+type cover struct {
+	general  *General
+	up, down *core.ItemHandle
+	// stops are the optional end stop switches and the position they mark
+	stops   map[*core.ItemHandle]float64
+	travel  time.Duration
+	reverse time.Duration
+	overrun time.Duration
+
+	position   float64
+	calibrated bool
+	// direction is 1 while going up, -1 while going down and 0 when stopped
+	direction int
+	// from is the position the cover was at since
+	from  float64
+	since time.Time
+	timer *time.Timer
+	// generation invalidates timers of movements that were superseded
+	generation int
+
+	mu *sync.Mutex
+}
+
+// coverState is what is persisted about a cover
+type coverState struct {
+	Position   float64 `json:"position"`
+	Calibrated bool    `json:"calibrated"`
+	// Moving is set while the motor runs, if baagh stops in the meantime
+	// the persisted position can't be trusted anymore
+	Moving bool `json:"moving"`
+}
+
+func newCover(g *General, options *coverOptions) (c *cover, err error) {
+	c = &cover{
+		general: g,
+		stops:   map[*core.ItemHandle]float64{},
+		travel:  options.travel,
+		reverse: options.reverse,
+		overrun: options.travel / 10,
+		mu:      &sync.Mutex{},
+	}
+	c.up, err = core.RegisterItem(options.chip, options.up, core.AsOutput(), core.WithState(core.Inactive), core.WithOwner(g.tag))
+	if err != nil {
+		return nil, err
+	}
+	c.down, err = core.RegisterItem(options.chip, options.down, core.AsOutput(), core.WithState(core.Inactive), core.WithOwner(g.tag))
+	if err != nil {
+		c.release()
+		return nil, err
+	}
+	for _, s := range options.stops {
+		var h *core.ItemHandle
+		h, err = core.RegisterItem(s.chip, s.offset, g.asInput(g.tag)...)
+		if err != nil {
+			c.release()
+			return nil, err
+		}
+		c.stops[h] = s.position
+		position := s.position
+		h.AddEventListener(func(event *core.ItemEvent) {
+			if event.State == core.Active {
+				c.reached(position)
+			}
+		})
+	}
+
+	var state coverState
+	err = db.Get(coverPrefix+g.tag, &state)
+	if _, ok := err.(store.NotFoundError); err != nil && !ok {
+		c.release()
+		return nil, err
+	}
+	c.position = state.Position
+	c.calibrated = state.Calibrated && !state.Moving
+	if !c.calibrated {
+		logger.Warnf("position of cover %s is unknown, it will be calibrated on its next run to either end", g.tag)
+	}
+	for h, position := range c.stops {
+		if h.State() == core.Active {
+			c.position = position
+			c.calibrated = true
+		}
+	}
+	return c, nil
+}
+
+func (c *cover) turnOn() {
+	c.moveTo(Open)
+}
+
+func (c *cover) turnOff() {
+	c.moveTo(Closed)
+}
+
+func (c *cover) update() {
+	c.mu.Lock()
+	position := c.estimate()
+	c.mu.Unlock()
+	c.moved(position)
+}
+
+func (c *cover) close() error {
+	return multierr.Append(c.stop(), c.release())
+}
+
+func (c *cover) release() (err error) {
+	for _, h := range []*core.ItemHandle{c.up, c.down} {
+		if h != nil {
+			err = multierr.Append(err, h.Release())
+		}
+	}
+	for h := range c.stops {
+		err = multierr.Append(err, h.Release())
+	}
+	return
+}
+
+// estimate returns where the cover is right now, must be called with mu locked
+func (c *cover) estimate() float64 {
+	if c.direction == 0 {
+		return c.position
+	}
+	moved := float64(time.Since(c.since)) / float64(c.travel) * 100
+	return math.Max(Closed, math.Min(Open, c.from+float64(c.direction)*moved))
+}
+
+// moveTo starts moving the cover towards target
+func (c *cover) moveTo(target float64) error {
+	if target < Closed || target > Open || math.IsNaN(target) {
+		return PositionError{Tag: c.general.tag, Position: target}
+	}
+	c.mu.Lock()
+	c.position = c.estimate()
+	distance := target - c.position
+	end := target == Closed || target == Open
+	if end && !c.calibrated {
+		// the estimate can't be trusted, go all the way
+		distance = (target - Open/2) * 2
+	}
+	direction := 0
+	if distance > 0 {
+		direction = 1
+	} else if distance < 0 {
+		direction = -1
+	}
+	if direction == 0 {
+		c.mu.Unlock()
+		return c.stop()
+	}
+	run := time.Duration(math.Abs(distance) / 100 * float64(c.travel))
+	if end {
+		run += c.overrun
+	}
+
+	c.generation++
+	generation := c.generation
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	switch c.direction {
+	case direction:
+		// already going the right way, only when to stop changes
+		c.timer = time.AfterFunc(run, func() { c.arrive(generation, target) })
+	case 0:
+		c.start(generation, direction, run, target)
+	default:
+		// reversing right away is hard on the motor
+		c.halt()
+		c.timer = time.AfterFunc(c.reverse, func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if generation == c.generation {
+				c.start(generation, direction, run, target)
+			}
+		})
+	}
+	c.mu.Unlock()
+	return nil
+}
+
+// start turns on the relay of direction, must be called with mu locked
+func (c *cover) start(generation int, direction int, run time.Duration, target float64) {
+	c.direction = direction
+	c.from = c.position
+	c.since = time.Now()
+	c.relays(direction)
+	c.persist(true)
+	c.timer = time.AfterFunc(run, func() { c.arrive(generation, target) })
+	logger.Debugf("cover %s moving from %.0f%% to %.0f%%", c.general.tag, c.position, target)
+}
+
+// halt turns off both relays and settles the estimate, must be called with mu locked
+func (c *cover) halt() {
+	c.position = c.estimate()
+	c.direction = 0
+	c.relays(0)
+}
+
+func (c *cover) arrive(generation int, target float64) {
+	c.mu.Lock()
+	if generation != c.generation {
+		c.mu.Unlock()
+		return
+	}
+	c.halt()
+	c.position = target
+	if target == Closed || target == Open {
+		c.calibrated = true
+	}
+	c.persist(false)
+	position := c.position
+	c.mu.Unlock()
+	c.moved(position)
+}
+
+// reached is called when an end stop switch is hit
+func (c *cover) reached(position float64) {
+	c.mu.Lock()
+	c.position = c.estimate()
+	heading := (position == Open && c.direction == 1) || (position == Closed && c.direction == -1)
+	if heading {
+		c.generation++
+		if c.timer != nil {
+			c.timer.Stop()
+		}
+		c.halt()
+	}
+	c.position = position
+	c.from, c.since = position, time.Now()
+	c.calibrated = true
+	c.persist(c.direction != 0)
+	c.mu.Unlock()
+	c.moved(position)
+}
+
+func (c *cover) stop() error {
+	c.mu.Lock()
+	c.generation++
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	c.halt()
+	c.persist(false)
+	position := c.position
+	c.mu.Unlock()
+	c.moved(position)
+	return nil
+}
+
+// relays drives the relays for direction, the one that should be off is
+// always turned off first so both are never on at the same time
+func (c *cover) relays(direction int) {
+	off, on := c.up, c.down
+	if direction > 0 {
+		off, on = c.down, c.up
+	}
+	if err := off.SetState(core.Inactive); err != nil {
+		logger.Errorf("cover %s couldn't turn off line %d of %s: %v", c.general.tag, off.Offset(), off.Chip(), err)
+	}
+	if direction == 0 {
+		on.SetState(core.Inactive)
+		return
+	}
+	if err := on.SetState(core.Active); err != nil {
+		logger.Errorf("cover %s couldn't turn on line %d of %s: %v", c.general.tag, on.Offset(), on.Chip(), err)
+	}
+}
+
+// persist saves the position, must be called with mu locked
+func (c *cover) persist(moving bool) {
+	err := db.Put(coverPrefix+c.general.tag, coverState{
+		Position:   c.position,
+		Calibrated: c.calibrated,
+		Moving:     moving,
+	})
+	if err != nil {
+		logger.Errorf("couldn't persist the position of cover %s: %v", c.general.tag, err)
+	}
+}
+
+// moved updates the state of the general, it's active whenever the cover isn't closed
+func (c *cover) moved(position float64) {
+	c.general.setState(stateOf(position > Closed))
+}
+
+func (g *General) coverOf() (*cover, error) {
+	c, ok := g.device.(*cover)
+	if !ok {
+		return nil, NotCoverError{Tag: g.tag}
+	}
+	return c, nil
+}
+
+// MoveTo moves a cover to position, 0 is closed and 100 fully open
+func (g *General) MoveTo(position float64) error {
+	c, err := g.coverOf()
+	if err != nil {
+		return err
+	}
+	return c.moveTo(position)
+}
+
+// Stop stops a cover wherever it is
+func (g *General) Stop() error {
+	c, err := g.coverOf()
+	if err != nil {
+		return err
+	}
+	return c.stop()
+}
+
+// Calibrate closes a cover all the way regardless of its estimated position
+func (g *General) Calibrate() error {
+	c, err := g.coverOf()
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.calibrated = false
+	c.mu.Unlock()
+	return c.moveTo(Closed)
+}
+
+// Position returns the estimated position of a cover and whether the
+// estimate is calibrated
+func (g *General) Position() (position float64, calibrated bool, err error) {
+	c, err := g.coverOf()
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.estimate(), c.calibrated, nil
+}
+
+// Moving returns 1 while a cover is opening, -1 while it's closing and 0 otherwise
+func (g *General) Moving() (direction int, err error) {
+	c, err := g.coverOf()
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.direction, nil
+}
+
+type NotCoverError struct {
+	Tag string
+}
+
+func (n NotCoverError) Error() string {
+	return fmt.Sprintf("general \"%s\" is not a cover", n.Tag)
+}
+
+type PositionError struct {
+	Tag      string
+	Position float64
+}
+
+func (p PositionError) Error() string {
+	return fmt.Sprintf("cover \"%s\" can't move to %v%%, it has to be between 0 and 100", p.Tag, p.Position)
+}
@@ -0,0 +1,188 @@
+package general
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/controller/core"
+	"github.com/AliRostami1/baagh/pkg/controller/internal/coretest"
+	"github.com/AliRostami1/baagh/pkg/controller/internal/testutil"
+)
+
+// the relays of the covers are on lines 4 and 5 and their end stops on 0
+// and 1, which are active while they're pulled to ground
+const (
+	coverUp     = 4
+	coverDown   = 5
+	coverClosed = 0
+	coverOpen   = 1
+)
+
+// position returns the estimated position of a cover
+func position(t *testing.T, g *General) (float64, bool) {
+	t.Helper()
+	position, calibrated, err := g.Position()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return position, calibrated
+}
+
+// stopped waits for a cover to stop and returns how long it took
+func stopped(t *testing.T, g *General) time.Duration {
+	t.Helper()
+	start := time.Now()
+	testutil.Eventually(t, "the cover stopping", func() bool {
+		direction, err := g.Moving()
+		return err == nil && direction == 0
+	})
+	return time.Since(start)
+}
+
+func TestCoverTravel(t *testing.T) {
+	chip, device := coretest.PCF8574(t)
+	const travel = 100 * time.Millisecond
+	g := register(t, chip+"-cover", AsCover(chip, coverUp, coverDown, travel))
+	if _, calibrated := position(t, g); calibrated {
+		t.Fatal("a cover that was never moved is calibrated")
+	}
+
+	if err := g.MoveTo(50); err != nil {
+		t.Fatal(err)
+	}
+	if !device.High(coverUp) || device.High(coverDown) {
+		t.Fatal("the cover isn't going up")
+	}
+	if took := stopped(t, g); took < travel/2-5*time.Millisecond {
+		t.Errorf("the cover got halfway in %v, want %v", took, travel/2)
+	}
+	if device.High(coverUp) {
+		t.Error("the up relay is still on after the cover stopped")
+	}
+	if got, _ := position(t, g); got != 50 {
+		t.Errorf("the cover is at %v%%, want 50%%", got)
+	}
+
+	// an uncalibrated cover runs a full travel to either end and then some,
+	// so it ends up against the end stop
+	if err := g.MoveTo(Open); err != nil {
+		t.Fatal(err)
+	}
+	if took := stopped(t, g); took < travel+travel/10-5*time.Millisecond {
+		t.Errorf("the uncalibrated cover opened in %v, want a full travel of %v and the overrun", took, travel)
+	}
+	if got, calibrated := position(t, g); got != Open || !calibrated {
+		t.Errorf("the cover is at %v%%, calibrated %v, want it open and calibrated", got, calibrated)
+	}
+	if g.State() != core.Active {
+		t.Error("an open cover is inactive")
+	}
+
+	// once it's calibrated it only runs as far as it needs to
+	if err := g.MoveTo(75); err != nil {
+		t.Fatal(err)
+	}
+	if !device.High(coverDown) {
+		t.Fatal("the cover isn't going down")
+	}
+	if took := stopped(t, g); took > travel/2 {
+		t.Errorf("the cover took %v to close a quarter, want about %v", took, travel/4)
+	}
+	if err := g.MoveTo(Closed); err != nil {
+		t.Fatal(err)
+	}
+	stopped(t, g)
+	if g.State() != core.Inactive {
+		t.Error("a closed cover is active")
+	}
+	if err := g.MoveTo(101); err == nil {
+		t.Error("the cover moved past its end")
+	}
+}
+
+func TestCoverReverse(t *testing.T) {
+	chip, device := coretest.PCF8574(t)
+	const travel, reverse = 200 * time.Millisecond, 40 * time.Millisecond
+	g := register(t, chip+"-cover", AsCover(chip, coverUp, coverDown, travel), WithReverseDelay(reverse))
+
+	if err := g.MoveTo(Open); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(travel / 4)
+	if err := g.MoveTo(Closed); err != nil {
+		t.Fatal(err)
+	}
+	reversed := time.Now()
+	if device.High(coverUp) || device.High(coverDown) {
+		t.Fatal("the cover reversed without stopping first")
+	}
+	testutil.Eventually(t, "the cover going down", func() bool {
+		if device.High(coverUp) {
+			t.Fatal("the cover went up again")
+		}
+		return device.High(coverDown)
+	})
+	if waited := time.Since(reversed); waited < reverse {
+		t.Errorf("the cover reversed after %v, want it to wait for %v", waited, reverse)
+	}
+	stopped(t, g)
+	if got, _ := position(t, g); got != Closed {
+		t.Errorf("the cover is at %v%%, want it closed", got)
+	}
+
+	// moving on in the same direction only changes where it stops
+	if err := g.MoveTo(Open); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(travel / 4)
+	if err := g.MoveTo(50); err != nil {
+		t.Fatal(err)
+	}
+	if !device.High(coverUp) {
+		t.Fatal("the cover stopped on its way up")
+	}
+	stopped(t, g)
+	if got, _ := position(t, g); got != 50 {
+		t.Errorf("the cover is at %v%%, want 50%%", got)
+	}
+}
+
+func TestCoverEndStop(t *testing.T) {
+	chip, device := coretest.PCF8574(t)
+	const travel = time.Second
+	g := register(t, chip+"-cover", AsCover(chip, coverUp, coverDown, travel), WithEndStop(chip, coverClosed, Closed), WithEndStop(chip, coverOpen, Open))
+
+	// the cover hits its end stop long before the estimate says it should
+	if err := g.MoveTo(Open); err != nil {
+		t.Fatal(err)
+	}
+	device.Pull(coverOpen, false)
+	if took := stopped(t, g); took > travel/2 {
+		t.Errorf("the cover stopped %v after it hit its end stop", took)
+	}
+	if device.High(coverUp) {
+		t.Error("the up relay is still on against the end stop")
+	}
+	if got, calibrated := position(t, g); got != Open || !calibrated {
+		t.Errorf("the cover is at %v%%, calibrated %v, want it open and calibrated", got, calibrated)
+	}
+
+	// leaving an end stop doesn't stop the cover
+	if err := g.MoveTo(Closed); err != nil {
+		t.Fatal(err)
+	}
+	device.Pull(coverOpen, true)
+	time.Sleep(20 * time.Millisecond)
+	if !device.High(coverDown) {
+		t.Fatal("the cover stopped when it left the open end stop")
+	}
+	if err := g.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if device.High(coverDown) {
+		t.Error("the down relay is still on after the cover was stopped")
+	}
+	if got, _ := position(t, g); got <= Closed || got >= Open {
+		t.Errorf("the cover stopped at %v%%, want it between the ends", got)
+	}
+}
@@ -0,0 +1,55 @@
+package general
+
+// device is implemented by the kinds of generals that drive their own
+// lines instead of following their sensors, like covers and pumps
+type device interface {
+	// turnOn and turnOff are what turning the general on and off does
+	turnOn()
+	turnOff()
+	// update brings the state of the general in line with the device once
+	// the general is registered
+	update()
+	// close stops the device and releases its lines
+	close() error
+}
+
+// newDevice creates the device of kinds that have one, it returns nil for
+// kinds that follow their sensors
+func newDevice(g *General, options *Options) (device, error) {
+	var (
+		d   device
+		err error
+	)
+	switch options.kind {
+	case Cover:
+		if options.cover == nil || options.cover.chip == "" {
+			return nil, OptionError{Field: "Cover", Value: options.cover}
+		}
+		d, err = newCover(g, options.cover)
+	case Garage:
+		if options.garage == nil || options.garage.chip == "" {
+			return nil, OptionError{Field: "Garage", Value: options.garage}
+		}
+		d, err = newGarage(g, options.garage)
+	case Irrigation:
+		if options.irrigation == nil || len(options.irrigation.zones) == 0 {
+			return nil, OptionError{Field: "Zones", Value: options.irrigation}
+		}
+		d, err = newIrrigation(g, options.irrigation)
+	case Pump:
+		if options.pump == nil || options.pump.chip == "" {
+			return nil, OptionError{Field: "Pump", Value: options.pump}
+		}
+		d, err = newPump(g, options.pump)
+	case Door:
+		if options.door == nil || options.door.chip == "" {
+			return nil, OptionError{Field: "Door", Value: options.door}
+		}
+		d, err = newDoor(g, options.door)
+	}
+	if err != nil {
+		// d holds a nil pointer of the kind here, which isn't a nil device
+		return nil, err
+	}
+	return d, nil
+}
@@ -0,0 +1,29 @@
+package general
+
+import (
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/controller/core"
+)
+
+// dimmer is a pwm output that fades to level while its general is active
+type dimmer struct {
+	pwm   string
+	level float64
+	fade  time.Duration
+}
+
+func (d dimmer) follow(tag string, state core.State) {
+	p, err := core.GetPWM(d.pwm)
+	if err != nil {
+		logger.Errorf("general %s couldn't dim %s: %v", tag, d.pwm, err)
+		return
+	}
+	level := 0.0
+	if state == core.Active {
+		level = d.level
+	}
+	if err = p.Fade(level, d.fade); err != nil {
+		logger.Errorf("general %s couldn't dim %s: %v", tag, d.pwm, err)
+	}
+}
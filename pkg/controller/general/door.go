@@ -0,0 +1,256 @@
+package general
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/controller/core"
+	"go.uber.org/multierr"
+)
+
+const (
+	// Door generals control an access door with an electric strike
+	Door = "door"
+
+	// DoorForced and DoorHeldOpen are the faults a door raises
+	DoorForced   = "forced open"
+	DoorHeldOpen = "held open too long"
+)
+
+// door unlocks its strike for a while on authorized requests and watches
+// the door contact. the general is active while the door is forced or held
+// open, so it can be used as a sensor of an alarm general
+type door struct {
+	general    *General
+	strike     *core.ItemHandle
+	contact    *core.ItemHandle
+	exit       *core.ItemHandle
+	unlockTime time.Duration
+	heldOpen   time.Duration
+
+	unlocked bool
+	// opened is set once the door is opened while unlocked
+	opened bool
+	// fault is either empty, DoorForced or DoorHeldOpen
+	fault      string
+	lockTimer  *time.Timer
+	heldTimer  *time.Timer
+	generation int
+	// openings invalidates held open timers of earlier openings
+	openings int
+
+	mu *sync.Mutex
+}
+
+func newDoor(g *General, options *doorOptions) (d *door, err error) {
+	d = &door{
+		general:    g,
+		unlockTime: options.unlockTime,
+		heldOpen:   options.heldOpen,
+		mu:         &sync.Mutex{},
+	}
+	d.strike, err = core.RegisterItem(options.chip, options.strike, core.AsOutput(), core.WithState(core.Inactive), core.WithOwner(g.tag))
+	if err != nil {
+		return nil, err
+	}
+	d.contact, err = core.RegisterItem(options.chip, options.contact, g.asInput(g.tag)...)
+	if err != nil {
+		d.release()
+		return nil, err
+	}
+	d.contact.AddEventListener(func(event *core.ItemEvent) {
+		if event.State == core.Active {
+			d.doorOpened()
+		} else {
+			d.doorClosed()
+		}
+	})
+	if options.exit != nil {
+		d.exit, err = core.RegisterItem(options.chip, *options.exit, g.asInput(g.tag)...)
+		if err != nil {
+			d.release()
+			return nil, err
+		}
+		d.exit.AddEventListener(func(event *core.ItemEvent) {
+			if event.State == core.Active {
+				d.unlock("exit button")
+			}
+		})
+	}
+	return d, nil
+}
+
+func (d *door) turnOn() {
+	if err := d.unlock("turn on"); err != nil {
+		logger.Errorf("door %s couldn't unlock: %v", d.general.tag, err)
+	}
+}
+
+// turnOff locks the door right away
+func (d *door) turnOff() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.generation++
+	d.lock()
+}
+
+// update has nothing to do, the general stays inactive until the door
+// contact reports a fault
+func (d *door) update() {}
+
+func (d *door) close() error {
+	return d.release()
+}
+
+func (d *door) release() (err error) {
+	d.mu.Lock()
+	d.generation++
+	for _, t := range []*time.Timer{d.lockTimer, d.heldTimer} {
+		if t != nil {
+			t.Stop()
+		}
+	}
+	d.mu.Unlock()
+	for _, h := range []*core.ItemHandle{d.strike, d.contact, d.exit} {
+		if h != nil {
+			err = multierr.Append(err, h.Release())
+		}
+	}
+	return
+}
+
+// unlock releases the strike for unlockTime, by is who asked for it
+func (d *door) unlock(by string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.strike.SetState(core.Active); err != nil {
+		return err
+	}
+	d.unlocked = true
+	d.generation++
+	generation := d.generation
+	if d.lockTimer != nil {
+		d.lockTimer.Stop()
+	}
+	d.lockTimer = time.AfterFunc(d.unlockTime, func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if generation == d.generation {
+			d.lock()
+		}
+	})
+	logger.Infof("door %s unlocked by %s", d.general.tag, by)
+	return nil
+}
+
+// lock engages the strike, must be called with mu locked
+func (d *door) lock() {
+	if d.lockTimer != nil {
+		d.lockTimer.Stop()
+	}
+	d.unlocked = false
+	d.opened = false
+	if err := d.strike.SetState(core.Inactive); err != nil {
+		logger.Errorf("door %s couldn't lock: %v", d.general.tag, err)
+	}
+}
+
+func (d *door) doorOpened() {
+	d.mu.Lock()
+	if d.unlocked {
+		d.opened = true
+	} else {
+		d.raise(DoorForced)
+	}
+	if d.heldTimer != nil {
+		d.heldTimer.Stop()
+	}
+	d.openings++
+	opening := d.openings
+	d.heldTimer = time.AfterFunc(d.heldOpen, func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if opening == d.openings && d.fault == "" {
+			d.raise(DoorHeldOpen)
+		}
+	})
+	d.mu.Unlock()
+}
+
+func (d *door) doorClosed() {
+	d.mu.Lock()
+	if d.heldTimer != nil {
+		d.heldTimer.Stop()
+	}
+	d.openings++
+	// relock right away so nobody else can follow
+	if d.opened {
+		d.generation++
+		d.lock()
+	}
+	cleared := d.fault != ""
+	d.fault = ""
+	d.mu.Unlock()
+	if cleared {
+		d.general.setState(core.Inactive)
+	}
+}
+
+// raise reports a fault unless the general is disarmed, must be called with mu locked
+func (d *door) raise(fault string) {
+	if !d.general.Armed() {
+		return
+	}
+	d.fault = fault
+	logger.Warnf("door %s %s", d.general.tag, fault)
+	d.general.events.CallAll(&Event{General: d.general, Fault: fault})
+	d.general.setState(core.Active)
+}
+
+func (g *General) doorOf() (*door, error) {
+	d, ok := g.device.(*door)
+	if !ok {
+		return nil, NotDoorError{Tag: g.tag}
+	}
+	return d, nil
+}
+
+// Unlock releases the strike of a door for its unlock time, by is who
+// asked for it and is only used for logging
+func (g *General) Unlock(by string) error {
+	d, err := g.doorOf()
+	if err != nil {
+		return err
+	}
+	return d.unlock(by)
+}
+
+// Lock engages the strike of a door right away
+func (g *General) Lock() error {
+	d, err := g.doorOf()
+	if err != nil {
+		return err
+	}
+	d.turnOff()
+	return nil
+}
+
+// Locked reports whether the strike of a door is engaged
+func (g *General) Locked() (locked bool, err error) {
+	d, err := g.doorOf()
+	if err != nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return !d.unlocked, nil
+}
+
+type NotDoorError struct {
+	Tag string
+}
+
+func (n NotDoorError) Error() string {
+	return fmt.Sprintf("general \"%s\" is not a door", n.Tag)
+}
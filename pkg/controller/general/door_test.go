@@ -0,0 +1,147 @@
+package general
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/controller/core"
+	"github.com/AliRostami1/baagh/pkg/controller/internal/coretest"
+	"github.com/AliRostami1/baagh/pkg/controller/internal/testutil"
+)
+
+// the strikes of the doors are on line 4, their contacts on 0 and their
+// exit buttons on 1, which are active while they're pulled to ground
+const (
+	doorStrike  = 4
+	doorContact = 0
+	doorExit    = 1
+)
+
+// locked reports whether the strike of a door is engaged
+func locked(t *testing.T, g *General) bool {
+	t.Helper()
+	locked, err := g.Locked()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return locked
+}
+
+func TestDoorLock(t *testing.T) {
+	chip, device := coretest.PCF8574(t)
+	const unlockTime = 100 * time.Millisecond
+	g := register(t, chip+"-door", AsDoor(chip, doorStrike, doorContact, unlockTime, time.Second), WithExitButton(doorExit))
+	raised := faults(g)
+	if !locked(t, g) || device.High(doorStrike) {
+		t.Fatal("the door is unlocked before anyone asked")
+	}
+
+	start := time.Now()
+	if err := g.Unlock("test"); err != nil {
+		t.Fatal(err)
+	}
+	if locked(t, g) || !device.High(doorStrike) {
+		t.Fatal("the strike wasn't released")
+	}
+	testutil.Eventually(t, "the door locking again", func() bool { return locked(t, g) && !device.High(doorStrike) })
+	if took := time.Since(start); took < unlockTime {
+		t.Errorf("the door locked again after %v, want it unlocked for %v", took, unlockTime)
+	}
+
+	// the door locks as soon as it closes behind whoever went through
+	if err := g.Unlock("test"); err != nil {
+		t.Fatal(err)
+	}
+	start = time.Now()
+	device.Pull(doorContact, false)
+	time.Sleep(10 * time.Millisecond)
+	device.Pull(doorContact, true)
+	testutil.Eventually(t, "the door locking once it closed", func() bool { return locked(t, g) })
+	if took := time.Since(start); took >= unlockTime {
+		t.Errorf("the door locked %v after it was opened, want it locked once it closed", took)
+	}
+
+	device.Pull(doorExit, false)
+	testutil.Eventually(t, "the exit button unlocking the door", func() bool { return !locked(t, g) })
+	device.Pull(doorExit, true)
+	if err := g.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if !locked(t, g) || device.High(doorStrike) {
+		t.Error("the door is still unlocked after it was locked")
+	}
+	if got := raised(); len(got) != 0 {
+		t.Errorf("faults %v were raised, want none", got)
+	}
+	if g.State() != core.Inactive {
+		t.Error("the door is active without a fault")
+	}
+}
+
+func TestDoorForced(t *testing.T) {
+	chip, device := coretest.PCF8574(t)
+	const heldOpen = 30 * time.Millisecond
+	g := register(t, chip+"-door", AsDoor(chip, doorStrike, doorContact, time.Second, heldOpen))
+	raised := faults(g)
+
+	device.Pull(doorContact, false)
+	testutil.Eventually(t, "the door being forced", func() bool { return g.State() == core.Active })
+	// a forced door that stays open is still just forced
+	time.Sleep(2 * heldOpen)
+	if got := raised(); len(got) != 1 || got[0] != DoorForced {
+		t.Errorf("faults %v were raised, want %q", got, DoorForced)
+	}
+	device.Pull(doorContact, true)
+	testutil.Eventually(t, "the fault clearing once the door closed", func() bool { return g.State() == core.Inactive })
+
+	// a disarmed door doesn't raise anything
+	g.Disarm()
+	device.Pull(doorContact, false)
+	time.Sleep(2 * heldOpen)
+	device.Pull(doorContact, true)
+	time.Sleep(10 * time.Millisecond)
+	if got := raised(); len(got) != 1 {
+		t.Errorf("faults %v were raised, want only the one before the door was disarmed", got)
+	}
+	if g.State() != core.Inactive {
+		t.Error("a disarmed door is active")
+	}
+}
+
+func TestDoorHeldOpen(t *testing.T) {
+	chip, device := coretest.PCF8574(t)
+	const heldOpen = 30 * time.Millisecond
+	g := register(t, chip+"-door", AsDoor(chip, doorStrike, doorContact, time.Second, heldOpen))
+	raised := faults(g)
+
+	if err := g.Unlock("test"); err != nil {
+		t.Fatal(err)
+	}
+	opened := time.Now()
+	device.Pull(doorContact, false)
+	testutil.Eventually(t, "the door being held open", func() bool { return g.State() == core.Active })
+	if took := time.Since(opened); took < heldOpen {
+		t.Errorf("the door was held open after %v, want %v", took, heldOpen)
+	}
+	testutil.Eventually(t, "the fault being raised", func() bool { return len(raised()) == 1 })
+	if got := raised(); got[0] != DoorHeldOpen {
+		t.Errorf("%q was raised, want %q", got[0], DoorHeldOpen)
+	}
+	device.Pull(doorContact, true)
+	testutil.Eventually(t, "the fault clearing once the door closed", func() bool { return g.State() == core.Inactive })
+	if !locked(t, g) {
+		t.Error("the door is unlocked after it closed")
+	}
+
+	// closing in time doesn't raise anything
+	if err := g.Unlock("test"); err != nil {
+		t.Fatal(err)
+	}
+	device.Pull(doorContact, false)
+	time.Sleep(heldOpen / 3)
+	device.Pull(doorContact, true)
+	time.Sleep(2 * heldOpen)
+	if got := raised(); len(got) != 1 {
+		t.Errorf("faults %v were raised, want only the first one", got)
+	}
+}
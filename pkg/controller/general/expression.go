@@ -0,0 +1,283 @@
+package general
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// expression is the parsed form of an activation condition like
+// "(door1 | door2) & !maintenance & 2of(pir1,pir2,pir3)"
+//
+//	expr    = and { "|" and }
+//	and     = unary { "&" unary }
+//	unary   = "!" unary | primary
+//	primary = "(" expr ")" | name | number "of" "(" expr { "," expr } ")"
+//
+// names are either named sensors of the general or tags of other generals,
+// they can't start with a digit so they're never mistaken for a count
+type expression interface {
+	eval(lookup func(name string) bool) bool
+	names() []string
+}
+
+type nameExpr string
+
+func (n nameExpr) eval(lookup func(name string) bool) bool {
+	return lookup(string(n))
+}
+
+func (n nameExpr) names() []string {
+	return []string{string(n)}
+}
+
+type notExpr struct {
+	x expression
+}
+
+func (n notExpr) eval(lookup func(name string) bool) bool {
+	return !n.x.eval(lookup)
+}
+
+func (n notExpr) names() []string {
+	return n.x.names()
+}
+
+type andExpr []expression
+
+func (a andExpr) eval(lookup func(name string) bool) bool {
+	for _, x := range a {
+		if !x.eval(lookup) {
+			return false
+		}
+	}
+	return true
+}
+
+func (a andExpr) names() (names []string) {
+	for _, x := range a {
+		names = append(names, x.names()...)
+	}
+	return
+}
+
+type orExpr []expression
+
+func (o orExpr) eval(lookup func(name string) bool) bool {
+	for _, x := range o {
+		if x.eval(lookup) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o orExpr) names() (names []string) {
+	for _, x := range o {
+		names = append(names, x.names()...)
+	}
+	return
+}
+
+// kOfExpr is true when at least k of its operands are true
+type kOfExpr struct {
+	k  int
+	xs []expression
+}
+
+func (k kOfExpr) eval(lookup func(name string) bool) bool {
+	count := 0
+	for _, x := range k.xs {
+		if x.eval(lookup) {
+			count++
+		}
+	}
+	return count >= k.k
+}
+
+func (k kOfExpr) names() (names []string) {
+	for _, x := range k.xs {
+		names = append(names, x.names()...)
+	}
+	return
+}
+
+type ExpressionError struct {
+	Expression string
+	Pos        int
+	Reason     string
+}
+
+func (e ExpressionError) Error() string {
+	return fmt.Sprintf("invalid expression \"%s\" at %d: %s", e.Expression, e.Pos, e.Reason)
+}
+
+type UnknownReferenceError struct {
+	Name string
+}
+
+func (u UnknownReferenceError) Error() string {
+	return fmt.Sprintf("\"%s\" is neither a named sensor nor a registered general", u.Name)
+}
+
+type parser struct {
+	src string
+	pos int
+}
+
+func parseExpression(src string) (x expression, err error) {
+	p := &parser{src: src}
+	x, err = p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	if p.pos != len(p.src) {
+		return nil, p.errorf("unexpected %q", p.src[p.pos])
+	}
+	return x, nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return ExpressionError{Expression: p.src, Pos: p.pos, Reason: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) skipSpaces() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+}
+
+// accept consumes c if it's the next non-space character
+func (p *parser) accept(c byte) bool {
+	p.skipSpaces()
+	if p.pos < len(p.src) && p.src[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) parseOr() (expression, error) {
+	x, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	xs := orExpr{x}
+	for p.accept('|') {
+		x, err = p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		xs = append(xs, x)
+	}
+	if len(xs) == 1 {
+		return xs[0], nil
+	}
+	return xs, nil
+}
+
+func (p *parser) parseAnd() (expression, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	xs := andExpr{x}
+	for p.accept('&') {
+		x, err = p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		xs = append(xs, x)
+	}
+	if len(xs) == 1 {
+		return xs[0], nil
+	}
+	return xs, nil
+}
+
+func (p *parser) parseUnary() (expression, error) {
+	if p.accept('!') {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (expression, error) {
+	if p.accept('(') {
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(')') {
+			return nil, p.errorf("expected )")
+		}
+		return x, nil
+	}
+	p.skipSpaces()
+	if p.pos == len(p.src) {
+		return nil, p.errorf("unexpected end of expression")
+	}
+	start := p.pos
+	for p.pos < len(p.src) && isNameChar(p.src[p.pos]) {
+		p.pos++
+	}
+	if start == p.pos {
+		return nil, p.errorf("unexpected %q", p.src[p.pos])
+	}
+	name := p.src[start:p.pos]
+	if isDigit(name[0]) {
+		if k := strings.TrimSuffix(name, "of"); k != name && strings.IndexFunc(k, isNotDigit) < 0 {
+			return p.parseKOf(k)
+		}
+		p.pos = start
+		return nil, p.errorf("name \"%s\" can't start with a digit, only counts like 2of(...) can", name)
+	}
+	return nameExpr(name), nil
+}
+
+// parseKOf parses the operands of k-of-n, the "kof" itself is already consumed
+func (p *parser) parseKOf(count string) (expression, error) {
+	k, err := strconv.Atoi(count)
+	if err != nil {
+		return nil, p.errorf("invalid number %s", count)
+	}
+	if !p.accept('(') {
+		return nil, p.errorf("expected ( after %dof", k)
+	}
+	x := kOfExpr{k: k}
+	for {
+		operand, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		x.xs = append(x.xs, operand)
+		if p.accept(')') {
+			break
+		}
+		if !p.accept(',') {
+			return nil, p.errorf("expected , or )")
+		}
+	}
+	if k < 1 || k > len(x.xs) {
+		return nil, p.errorf("%dof needs between 1 and %d", k, len(x.xs))
+	}
+	return x, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isNotDigit(r rune) bool {
+	return r < '0' || r > '9'
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || c == '-' || c == '.' || isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
@@ -0,0 +1,99 @@
+package general
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestParseExpression(t *testing.T) {
+	tests := []struct {
+		src    string
+		active []string
+		want   bool
+	}{
+		{"a", []string{"a"}, true},
+		{"a", nil, false},
+		// & binds tighter than |
+		{"a | b & c", []string{"a"}, true},
+		{"a | b & c", []string{"b"}, false},
+		{"a | b & c", []string{"b", "c"}, true},
+		{"(a | b) & c", []string{"a"}, false},
+		{"(a | b) & c", []string{"a", "c"}, true},
+		// ! binds tighter than &
+		{"!a & b", []string{"b"}, true},
+		{"!a & b", []string{"a", "b"}, false},
+		{"!(a & b)", []string{"a"}, true},
+		{"!(a & b)", []string{"a", "b"}, false},
+		{"!!a", []string{"a"}, true},
+		{"2of(a, b, c)", []string{"a"}, false},
+		{"2of(a, b, c)", []string{"a", "c"}, true},
+		{"3of(a,b,c)", []string{"a", "b", "c"}, true},
+		{"1of(a & b, c)", []string{"a"}, false},
+		{"1of(a & b, c)", []string{"a", "b"}, true},
+		{"(door1 | door2) & !maintenance & 2of(pir1,pir2,pir3)", []string{"door2", "pir1", "pir3"}, true},
+		{"(door1 | door2) & !maintenance & 2of(pir1,pir2,pir3)", []string{"door2", "pir1", "pir3", "maintenance"}, false},
+		{"floor1.hall-pir_2", []string{"floor1.hall-pir_2"}, true},
+	}
+	for _, tt := range tests {
+		x, err := parseExpression(tt.src)
+		if err != nil {
+			t.Errorf("parseExpression(%q) failed: %v", tt.src, err)
+			continue
+		}
+		active := map[string]bool{}
+		for _, name := range tt.active {
+			active[name] = true
+		}
+		got := x.eval(func(name string) bool { return active[name] })
+		if got != tt.want {
+			t.Errorf("%q with %v active = %v, want %v", tt.src, tt.active, got, tt.want)
+		}
+	}
+}
+
+func TestExpressionNames(t *testing.T) {
+	x, err := parseExpression("!a | 2of(b, c & d)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := x.names()
+	sort.Strings(names)
+	if want := []string{"a", "b", "c", "d"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("names = %v, want %v", names, want)
+	}
+}
+
+func TestParseExpressionErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		pos int
+	}{
+		{"", 0},
+		{"a &", 3},
+		{"a | | b", 4},
+		{"(a | b", 6},
+		{"a b", 2},
+		{"a)", 1},
+		{"!", 1},
+		{"a & #", 4},
+		{"1st_floor", 0},
+		{"a | 2office", 4},
+		{"2of a, b", 4},
+		{"2of(a, b", 8},
+		{"0of(a)", 6},
+		{"3of(a, b)", 9},
+		{"99999999999999999999of(a)", 22},
+	}
+	for _, tt := range tests {
+		_, err := parseExpression(tt.src)
+		e, ok := err.(ExpressionError)
+		if !ok {
+			t.Errorf("parseExpression(%q) = %v, want an ExpressionError", tt.src, err)
+			continue
+		}
+		if e.Pos != tt.pos {
+			t.Errorf("parseExpression(%q) failed at %d, want %d: %v", tt.src, e.Pos, tt.pos, e)
+		}
+	}
+}
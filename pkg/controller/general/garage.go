@@ -0,0 +1,351 @@
+package general
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/controller/core"
+	"github.com/AliRostami1/baagh/pkg/controller/daytime"
+	"go.uber.org/multierr"
+)
+
+const (
+	// Garage generals drive a single button garage door opener
+	Garage = "garage"
+
+	DoorUnknown = "unknown"
+	DoorClosed  = "closed"
+	DoorOpening = "opening"
+	DoorOpen    = "open"
+	DoorClosing = "closing"
+	DoorStopped = "stopped"
+)
+
+// garage tracks a door driven by an opener that only has a single button,
+// every pulse of the relay moves it one step through open, stop, close, stop.
+// the limit switches are the only feedback, so the state between them is
+// inferred from the pulses and from which switch was released last
+type garage struct {
+	general        *General
+	relay          *core.ItemHandle
+	opened, closed *core.ItemHandle
+	pulse          time.Duration
+	timeout        time.Duration
+	autoClose      *autoClose
+
+	state string
+	// direction the door moved last, 1 is up and -1 is down
+	direction int
+	// fault describes why the door didn't end up where it should have
+	fault string
+	timer *time.Timer
+	// generation invalidates timers of states that were left
+	generation int
+
+	mu *sync.Mutex
+	// pulsing serializes pulses so they aren't merged into one by the opener
+	pulsing *sync.Mutex
+}
+
+func newGarage(g *General, options *garageOptions) (d *garage, err error) {
+	d = &garage{
+		general:   g,
+		pulse:     options.pulse,
+		timeout:   options.timeout,
+		autoClose: options.autoClose,
+		state:     DoorUnknown,
+		mu:        &sync.Mutex{},
+		pulsing:   &sync.Mutex{},
+	}
+	d.relay, err = core.RegisterItem(options.chip, options.relay, core.AsOutput(), core.WithState(core.Inactive), core.WithOwner(g.tag))
+	if err != nil {
+		return nil, err
+	}
+	d.opened, err = core.RegisterItem(options.chip, options.opened, g.asInput(g.tag)...)
+	if err != nil {
+		d.release()
+		return nil, err
+	}
+	d.closed, err = core.RegisterItem(options.chip, options.closed, g.asInput(g.tag)...)
+	if err != nil {
+		d.release()
+		return nil, err
+	}
+	d.opened.AddEventListener(func(event *core.ItemEvent) {
+		d.limit(Open, event.State)
+	})
+	d.closed.AddEventListener(func(event *core.ItemEvent) {
+		d.limit(Closed, event.State)
+	})
+
+	switch {
+	case d.closed.State() == core.Active:
+		d.state, d.direction = DoorClosed, -1
+	case d.opened.State() == core.Active:
+		d.state, d.direction = DoorOpen, 1
+		d.scheduleAutoClose()
+	default:
+		logger.Warnf("garage door %s is neither open nor closed", g.tag)
+	}
+	return d, nil
+}
+
+func (d *garage) turnOn() {
+	go d.moveTo(DoorOpening)
+}
+
+func (d *garage) turnOff() {
+	go d.moveTo(DoorClosing)
+}
+
+func (d *garage) update() {
+	d.changed()
+}
+
+func (d *garage) close() error {
+	return d.release()
+}
+
+func (d *garage) release() (err error) {
+	for _, h := range []*core.ItemHandle{d.relay, d.opened, d.closed} {
+		if h != nil {
+			err = multierr.Append(err, h.Release())
+		}
+	}
+	d.mu.Lock()
+	d.generation++
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	d.mu.Unlock()
+	return
+}
+
+// limit is called whenever one of the limit switches changes
+func (d *garage) limit(position float64, state core.State) {
+	d.mu.Lock()
+	switch {
+	case position == Open && state == core.Active:
+		if d.state == DoorClosing {
+			// the opener reverses when its own safety sensor sees something
+			d.fault = "obstructed while closing"
+			logger.Warnf("garage door %s was obstructed while closing", d.general.tag)
+		} else {
+			d.fault = ""
+		}
+		d.enter(DoorOpen, 1)
+		d.scheduleAutoClose()
+	case position == Closed && state == core.Active:
+		d.fault = ""
+		d.enter(DoorClosed, -1)
+	case position == Open && d.state == DoorOpen:
+		// the door left the open position without us pulsing the relay,
+		// while it's moving anyway the release is old news
+		d.enter(DoorClosing, -1)
+	case position == Closed && d.state == DoorClosed:
+		d.enter(DoorOpening, 1)
+	}
+	d.mu.Unlock()
+	d.changed()
+}
+
+// enter moves to state and watches for the door getting stuck on the way,
+// must be called with mu locked
+func (d *garage) enter(state string, direction int) {
+	d.generation++
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	d.state = state
+	d.direction = direction
+	if state != DoorOpening && state != DoorClosing {
+		return
+	}
+	generation := d.generation
+	d.timer = time.AfterFunc(d.timeout, func() {
+		d.mu.Lock()
+		if generation != d.generation {
+			d.mu.Unlock()
+			return
+		}
+		d.fault = fmt.Sprintf("didn't finish %s within %s", d.state, d.timeout)
+		logger.Warnf("garage door %s %s", d.general.tag, d.fault)
+		d.enter(DoorStopped, d.direction)
+		d.mu.Unlock()
+		d.changed()
+	})
+}
+
+// scheduleAutoClose closes the door once it has been open for long enough
+// inside the auto close window, must be called with mu locked
+func (d *garage) scheduleAutoClose() {
+	if d.autoClose == nil {
+		return
+	}
+	generation := d.generation
+	now := time.Now()
+	due := now.Add(d.autoClose.after)
+	if start, _ := d.autoClose.window.Next(due); due.Before(start) {
+		// by the time the window starts it has been open for long enough
+		due = start
+	}
+	d.timer = time.AfterFunc(due.Sub(now), func() {
+		d.mu.Lock()
+		if generation != d.generation || d.state != DoorOpen {
+			d.mu.Unlock()
+			return
+		}
+		if start, _ := d.autoClose.window.Next(time.Now()); time.Now().Before(start) {
+			// the window ended while waiting, try again in the next one
+			d.scheduleAutoClose()
+			d.mu.Unlock()
+			return
+		}
+		d.mu.Unlock()
+		logger.Infof("garage door %s has been open for %s, closing it", d.general.tag, d.autoClose.after)
+		d.moveTo(DoorClosing)
+	})
+}
+
+// changed updates the state of the general, it's active unless the door is
+// closed and a door is only considered closed when the closed limit switch
+// says so
+func (d *garage) changed() {
+	d.mu.Lock()
+	closed := d.state == DoorClosed
+	d.mu.Unlock()
+	d.general.setState(stateOf(!closed))
+}
+
+// moveTo pulses the relay as many times as it takes to make the door
+// move in the direction of target, which is either DoorOpening or DoorClosing
+func (d *garage) moveTo(target string) error {
+	d.mu.Lock()
+	if (target == DoorOpening && (d.state == DoorOpen || d.state == DoorOpening)) ||
+		(target == DoorClosing && (d.state == DoorClosed || d.state == DoorClosing)) {
+		d.mu.Unlock()
+		return nil
+	}
+	if d.state == DoorUnknown {
+		d.mu.Unlock()
+		return DoorStateError{Tag: d.general.tag, State: DoorUnknown}
+	}
+	// steps of the opener's cycle: opening, stopped going up, closing, stopped going down
+	current := map[bool]int{true: 1, false: 3}[d.direction > 0]
+	if d.state == DoorOpening || d.state == DoorClosing {
+		current--
+	}
+	want := map[string]int{DoorOpening: 0, DoorClosing: 2}[target]
+	pulses := (want - current + 4) % 4
+	direction := map[string]int{DoorOpening: 1, DoorClosing: -1}[target]
+	d.fault = ""
+	d.enter(target, direction)
+	d.mu.Unlock()
+
+	d.changed()
+	return d.press(pulses)
+}
+
+// stop pulses the relay once if the door is moving
+func (d *garage) stop() error {
+	d.mu.Lock()
+	if d.state != DoorOpening && d.state != DoorClosing {
+		d.mu.Unlock()
+		return nil
+	}
+	d.enter(DoorStopped, d.direction)
+	d.mu.Unlock()
+	d.changed()
+	return d.press(1)
+}
+
+// press pulses the relay n times
+func (d *garage) press(n int) (err error) {
+	d.pulsing.Lock()
+	defer d.pulsing.Unlock()
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			time.Sleep(d.pulse)
+		}
+		if err = d.relay.SetState(core.Active); err != nil {
+			return
+		}
+		time.Sleep(d.pulse)
+		if err = d.relay.SetState(core.Inactive); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (g *General) garageOf() (*garage, error) {
+	d, ok := g.device.(*garage)
+	if !ok {
+		return nil, NotGarageError{Tag: g.tag}
+	}
+	return d, nil
+}
+
+// DoorState returns the state of a garage door and, if it's stuck or was
+// obstructed, what went wrong
+func (g *General) DoorState() (state string, fault string, err error) {
+	d, err := g.garageOf()
+	if err != nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.state, d.fault, nil
+}
+
+// OpenDoor opens a garage door
+func (g *General) OpenDoor() error {
+	d, err := g.garageOf()
+	if err != nil {
+		return err
+	}
+	return d.moveTo(DoorOpening)
+}
+
+// CloseDoor closes a garage door
+func (g *General) CloseDoor() error {
+	d, err := g.garageOf()
+	if err != nil {
+		return err
+	}
+	return d.moveTo(DoorClosing)
+}
+
+// StopDoor stops a garage door that is moving
+func (g *General) StopDoor() error {
+	d, err := g.garageOf()
+	if err != nil {
+		return err
+	}
+	return d.stop()
+}
+
+type NotGarageError struct {
+	Tag string
+}
+
+func (n NotGarageError) Error() string {
+	return fmt.Sprintf("general \"%s\" is not a garage door", n.Tag)
+}
+
+type DoorStateError struct {
+	Tag   string
+	State string
+}
+
+func (d DoorStateError) Error() string {
+	return fmt.Sprintf("garage door \"%s\" can't be moved while it's %s", d.Tag, d.State)
+}
+
+// autoClose closes a door left open for longer than after, but only inside
+// window, which can span midnight
+type autoClose struct {
+	after  time.Duration
+	window daytime.Window
+}
@@ -0,0 +1,209 @@
+package general
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/controller/core"
+	"github.com/AliRostami1/baagh/pkg/controller/internal/coretest"
+	"github.com/AliRostami1/baagh/pkg/controller/internal/testutil"
+)
+
+// the opener relay of the garage doors is on line 4 and their limit
+// switches on 0 and 1, which are active while they're pulled to ground
+const (
+	garageRelay  = 4
+	garageOpened = 0
+	garageClosed = 1
+)
+
+// presses counts how often the opener relay on line relay of chip is pressed
+func presses(t *testing.T, chip string, relay int) func() int {
+	t.Helper()
+	h, err := core.RegisterItem(chip, relay, core.AsOutput(), core.WithOwner("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.Release() })
+	mu := &sync.Mutex{}
+	n := 0
+	h.AddEventListener(func(event *core.ItemEvent) {
+		if event.State == core.Active {
+			mu.Lock()
+			n++
+			mu.Unlock()
+		}
+	})
+	return func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return n
+	}
+}
+
+// leave releases the limit switch on line from and waits for it to be
+// noticed, a door can't get to the other end in the same instant
+func leave(t *testing.T, chip string, device *testutil.PCF8574, from int) {
+	t.Helper()
+	device.Pull(from, true)
+	limit, err := core.GetItem(chip, from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.Eventually(t, "the door leaving its limit switch", func() bool { return limit.State() == core.Inactive })
+}
+
+// reaches waits for a garage door to get to state
+func reaches(t *testing.T, g *General, state string) {
+	t.Helper()
+	testutil.Eventually(t, "the door "+state, func() bool {
+		got, _, err := g.DoorState()
+		return err == nil && got == state
+	})
+}
+
+func TestGarageDoor(t *testing.T) {
+	chip, device := coretest.PCF8574(t)
+	device.Pull(garageClosed, false)
+	g := register(t, chip+"-garage", AsGarageDoor(chip, garageRelay, garageOpened, garageClosed, time.Second), WithPulse(2*time.Millisecond))
+	pressed := presses(t, chip, garageRelay)
+	reaches(t, g, DoorClosed)
+	if g.State() != core.Inactive {
+		t.Error("a closed garage door is active")
+	}
+
+	if err := g.OpenDoor(); err != nil {
+		t.Fatal(err)
+	}
+	reaches(t, g, DoorOpening)
+	testutil.Eventually(t, "the opener being pressed once", func() bool { return pressed() == 1 })
+	leave(t, chip, device, garageClosed)
+	device.Pull(garageOpened, false)
+	reaches(t, g, DoorOpen)
+	if g.State() != core.Active {
+		t.Error("an open garage door is inactive")
+	}
+	// opening an open door does nothing
+	if err := g.OpenDoor(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if n := pressed(); n != 1 {
+		t.Errorf("the opener was pressed %d times, want once", n)
+	}
+
+	if err := g.CloseDoor(); err != nil {
+		t.Fatal(err)
+	}
+	reaches(t, g, DoorClosing)
+	leave(t, chip, device, garageOpened)
+	device.Pull(garageClosed, false)
+	reaches(t, g, DoorClosed)
+	if n := pressed(); n != 2 {
+		t.Errorf("the opener was pressed %d times, want twice", n)
+	}
+	if _, fault, _ := g.DoorState(); fault != "" {
+		t.Errorf("the door is closed with the fault %q", fault)
+	}
+
+	// someone opened the door with the button on the wall
+	leave(t, chip, device, garageClosed)
+	reaches(t, g, DoorOpening)
+	if n := pressed(); n != 2 {
+		t.Errorf("the opener was pressed %d times, want twice", n)
+	}
+}
+
+func TestGarageDoorObstruction(t *testing.T) {
+	chip, device := coretest.PCF8574(t)
+	device.Pull(garageOpened, false)
+	g := register(t, chip+"-garage", AsGarageDoor(chip, garageRelay, garageOpened, garageClosed, time.Second), WithPulse(2*time.Millisecond))
+	reaches(t, g, DoorOpen)
+
+	if err := g.CloseDoor(); err != nil {
+		t.Fatal(err)
+	}
+	leave(t, chip, device, garageOpened)
+	time.Sleep(10 * time.Millisecond)
+	// the opener sees something in the way and opens the door again
+	device.Pull(garageOpened, false)
+	reaches(t, g, DoorOpen)
+	if _, fault, _ := g.DoorState(); !strings.Contains(fault, "obstructed") {
+		t.Errorf("the door reopened while closing with the fault %q, want it obstructed", fault)
+	}
+
+	// the fault is cleared by the next run that gets where it should
+	if err := g.CloseDoor(); err != nil {
+		t.Fatal(err)
+	}
+	leave(t, chip, device, garageOpened)
+	device.Pull(garageClosed, false)
+	reaches(t, g, DoorClosed)
+	if _, fault, _ := g.DoorState(); fault != "" {
+		t.Errorf("the door closed with the fault %q", fault)
+	}
+}
+
+func TestGarageDoorTimeout(t *testing.T) {
+	chip, device := coretest.PCF8574(t)
+	const timeout = 30 * time.Millisecond
+	device.Pull(garageClosed, false)
+	g := register(t, chip+"-garage", AsGarageDoor(chip, garageRelay, garageOpened, garageClosed, timeout), WithPulse(2*time.Millisecond))
+	reaches(t, g, DoorClosed)
+
+	started := time.Now()
+	if err := g.OpenDoor(); err != nil {
+		t.Fatal(err)
+	}
+	leave(t, chip, device, garageClosed)
+	// the door never gets to the open limit switch
+	reaches(t, g, DoorStopped)
+	if took := time.Since(started); took < timeout {
+		t.Errorf("the door was stopped after %v, want it to get %v to finish", took, timeout)
+	}
+	if _, fault, _ := g.DoorState(); !strings.Contains(fault, "opening") {
+		t.Errorf("the stuck door has the fault %q, want it to say it didn't finish opening", fault)
+	}
+	if g.State() != core.Active {
+		t.Error("a stuck garage door is inactive")
+	}
+}
+
+func TestGarageDoorReverse(t *testing.T) {
+	chip, device := coretest.PCF8574(t)
+	device.Pull(garageOpened, false)
+	g := register(t, chip+"-garage", AsGarageDoor(chip, garageRelay, garageOpened, garageClosed, time.Second), WithPulse(2*time.Millisecond))
+	pressed := presses(t, chip, garageRelay)
+	reaches(t, g, DoorOpen)
+
+	if err := g.CloseDoor(); err != nil {
+		t.Fatal(err)
+	}
+	leave(t, chip, device, garageOpened)
+	reaches(t, g, DoorClosing)
+	// the opener has to stop the door before it goes the other way
+	if err := g.OpenDoor(); err != nil {
+		t.Fatal(err)
+	}
+	reaches(t, g, DoorOpening)
+	testutil.Eventually(t, "the opener being pressed three times", func() bool { return pressed() == 3 })
+
+	// a stopped door goes the other way with a single press
+	if err := g.StopDoor(); err != nil {
+		t.Fatal(err)
+	}
+	reaches(t, g, DoorStopped)
+	if err := g.CloseDoor(); err != nil {
+		t.Fatal(err)
+	}
+	reaches(t, g, DoorClosing)
+	testutil.Eventually(t, "the opener being pressed five times", func() bool { return pressed() == 5 })
+	device.Pull(garageClosed, false)
+	reaches(t, g, DoorClosed)
+	time.Sleep(10 * time.Millisecond)
+	if n := pressed(); n != 5 {
+		t.Errorf("the opener was pressed %d times, want 5", n)
+	}
+}
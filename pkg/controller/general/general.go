@@ -1,10 +1,13 @@
 package general
 
 import (
-	"log"
+	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/AliRostami1/baagh/pkg/controller/core"
+	"github.com/AliRostami1/baagh/pkg/logy"
+	"go.uber.org/multierr"
 )
 
 var general registry = registry{
@@ -12,12 +15,41 @@ var general registry = registry{
 	RWMutex:  &sync.RWMutex{},
 }
 
+var logger logy.Logger = logy.DummyLogger{}
+
+func SetLogger(l logy.Logger) error {
+	if l == nil {
+		return fmt.Errorf("logger can't be nil")
+	}
+	logger = l
+	return nil
+}
+
 type General struct {
+	tag       string
 	state     core.State
 	sensors   *itemRegistry
 	actuators *itemRegistry
 	kind      string
 	strategy  string
+	events    *eventRegistry
+	// expression, named and references are only used by expression generals
+	expression expression
+	named      map[string]*core.ItemHandle
+	// upstream are the generals this one uses as sensors, downstream the ones using this one
+	upstream   map[string]*General
+	downstream map[string]*General
+	// a disarmed general ignores its sensors and stays inactive
+	disarmed bool
+	// device is only set for kinds that drive their own lines
+	device device
+	// dimmers are the pwm outputs faded along with the state
+	dimmers []dimmer
+	// indicators are the blinkers that play a pattern for the state
+	indicators []indicator
+	// pull and activeLow are how every input is registered
+	pull      core.Pull
+	activeLow bool
 
 	mu *sync.RWMutex
 }
@@ -25,6 +57,8 @@ type General struct {
 func Register(tag string, opts ...Option) (g *General, err error) {
 	options := &Options{
 		control: map[string]Control{},
+		named:   map[string]namedSensor{},
+		pull:    core.PullDown,
 	}
 	for _, opt := range opts {
 		err = opt.applyOption(options)
@@ -34,91 +68,414 @@ func Register(tag string, opts ...Option) (g *General, err error) {
 	}
 
 	g = &General{
+		tag:   tag,
 		state: core.Inactive,
 		sensors: &itemRegistry{
-			registry: map[string]map[int]*core.Item{},
+			registry: map[string]map[int]*core.ItemHandle{},
 			RWMutex:  &sync.RWMutex{},
 		},
 		actuators: &itemRegistry{
-			registry: map[string]map[int]*core.Item{},
+			registry: map[string]map[int]*core.ItemHandle{},
 			RWMutex:  &sync.RWMutex{},
 		},
 		kind:     options.kind,
 		strategy: options.strategy,
-		mu:       &sync.RWMutex{},
+		events: &eventRegistry{
+			events:  []EventHandler{},
+			RWMutex: &sync.RWMutex{},
+		},
+		expression: options.expression,
+		named:      map[string]*core.ItemHandle{},
+		upstream:   map[string]*General{},
+		downstream: map[string]*General{},
+		pull:       options.pull,
+		activeLow:  options.activeLow,
+		mu:         &sync.RWMutex{},
 	}
 	for chip, opt := range options.control {
 		err = g.AddSensor(chip, tag, opt.sensors)
 		if err != nil {
-			return
+			g.release()
+			return nil, err
 		}
 		err = g.AddActuator(chip, tag, opt.actuators)
 		if err != nil {
-			return
+			g.release()
+			return nil, err
+		}
+	}
+	for _, name := range options.virtual.sensors {
+		err = g.AddVirtualSensor(name)
+		if err != nil {
+			g.release()
+			return nil, err
+		}
+	}
+	for _, name := range options.virtual.actuators {
+		err = g.AddVirtualActuator(name)
+		if err != nil {
+			g.release()
+			return nil, err
+		}
+	}
+	for _, d := range options.dimmers {
+		if _, err = core.GetPWM(d.pwm); err != nil {
+			g.release()
+			return nil, err
+		}
+		g.dimmers = append(g.dimmers, d)
+	}
+	for _, i := range options.indicators {
+		if _, err = core.GetBlinker(i.blinker); err != nil {
+			g.release()
+			return nil, err
+		}
+		if err = i.check(); err != nil {
+			g.release()
+			return nil, err
+		}
+		g.indicators = append(g.indicators, i)
+	}
+	for _, tag := range options.generals {
+		err = g.AddGeneralSensor(tag)
+		if err != nil {
+			g.release()
+			return nil, err
 		}
 	}
+	if options.kind == Expression {
+		err = g.resolveExpression(options.named)
+		if err != nil {
+			g.release()
+			return nil, err
+		}
+	}
+	g.device, err = newDevice(g, options)
+	if err != nil {
+		g.release()
+		return nil, err
+	}
+	err = general.Append(tag, g)
+	if err != nil {
+		g.release()
+		return nil, err
+	}
+
+	switch {
+	case g.device != nil:
+		g.device.update()
+	case options.kind == RSync:
+		g.setState(core.Active)
+	default:
+		// sensors that are already active count right away
+		g.update()
+	}
+	// a general that starts inactive never changed its state, so the
+	// indicators haven't been told yet
+	for _, i := range g.indicators {
+		i.follow(g.tag, g.State())
+	}
 
-	initalState := core.Inactive
-	if options.kind == RSync {
-		initalState = core.Active
+	return
+}
+
+func Get(tag string) (*General, error) {
+	return general.Get(tag)
+}
+
+// Unregister removes the general and releases its hold on every
+// sensor and actuator, lines shared with other generals stay requested.
+// a general that other generals use as a sensor can't be unregistered
+func (g *General) Unregister() error {
+	graph.Lock()
+	if len(g.downstream) > 0 {
+		dependents := make([]string, 0, len(g.downstream))
+		for tag := range g.downstream {
+			dependents = append(dependents, tag)
+		}
+		graph.Unlock()
+		sort.Strings(dependents)
+		return InUseError{Tag: g.tag, Dependents: dependents}
 	}
-	g.setState(initalState)
+	general.Delete(g.tag)
+	graph.Unlock()
+	return g.release()
+}
 
+func (g *General) release() (err error) {
+	g.detach()
+	g.mu.Lock()
+	sensors := g.sensors
+	actuators := g.actuators
+	d := g.device
+	g.mu.Unlock()
+	if d != nil {
+		err = d.close()
+	}
+	sensors.ForEach(func(i *core.ItemHandle) {
+		err = multierr.Append(err, i.Release())
+	})
+	actuators.ForEach(func(i *core.ItemHandle) {
+		err = multierr.Append(err, i.Release())
+	})
 	return
 }
 
+// resolveExpression binds every name in the expression either to one of the
+// named sensors or to an already registered general
+func (g *General) resolveExpression(named map[string]namedSensor) error {
+	for _, name := range g.expression.names() {
+		g.mu.Lock()
+		_, isNamed := g.named[name]
+		_, isUpstream := g.upstream[name]
+		g.mu.Unlock()
+		if isNamed || isUpstream {
+			continue
+		}
+		if sensor, ok := named[name]; ok {
+			if err := g.AddNamedSensor(name, sensor.chip, sensor.offset); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := core.GetVirtualItem(name); err == nil {
+			if err := g.addVirtualSensor(name, name); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := general.Get(name); err != nil {
+			return UnknownReferenceError{Name: name}
+		}
+		if err := g.AddGeneralSensor(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddVirtualSensor uses an already registered virtual item as a sensor
+func (g *General) AddVirtualSensor(name string) error {
+	return g.addVirtualSensor(name, "")
+}
+
+func (g *General) addVirtualSensor(name string, as string) error {
+	if _, err := core.GetVirtualItem(name); err != nil {
+		return err
+	}
+	i, err := core.RegisterVirtualItem(name, core.WithOwner(g.tag))
+	if err != nil {
+		return err
+	}
+	g.sensors.Add(core.VirtualChip, i.Offset(), i)
+	if as != "" {
+		g.mu.Lock()
+		g.named[as] = i
+		g.mu.Unlock()
+	}
+	return i.AddEventListener(g.SensorHandler)
+}
+
+// AddVirtualActuator uses an already registered virtual item as an actuator
+func (g *General) AddVirtualActuator(name string) error {
+	if _, err := core.GetVirtualItem(name); err != nil {
+		return err
+	}
+	i, err := core.RegisterVirtualItem(name, core.WithOwner(g.tag))
+	if err != nil {
+		return err
+	}
+	g.actuators.Add(core.VirtualChip, i.Offset(), i)
+	return nil
+}
+
+// AddNamedSensor registers an input that expressions refer to by name
+func (g *General) AddNamedSensor(name string, gpioName string, offset int) error {
+	i, err := core.RegisterItem(gpioName, offset, g.asInput(g.tag)...)
+	if err != nil {
+		return err
+	}
+	g.sensors.Add(gpioName, offset, i)
+	g.mu.Lock()
+	g.named[name] = i
+	g.mu.Unlock()
+	return i.AddEventListener(g.SensorHandler)
+}
+
+// AddEventListener registers handlers that are called whenever the state of the general changes
+func (g *General) AddEventListener(fns ...EventHandler) {
+	g.events.AddEventListener(fns...)
+}
+
+func (g *General) Tag() string {
+	return g.tag
+}
+
 func (g *General) State() core.State {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 	return g.state
 }
 
+// setState changes the state of the general and then re-evaluates every
+// general that depends on it
 func (g *General) setState(state core.State) {
+	if g.apply(state) {
+		g.propagate()
+	}
+}
+
+// apply changes the state and drives the actuators without propagating,
+// it reports whether the state actually changed
+func (g *General) apply(state core.State) bool {
 	g.mu.Lock()
 	if state == g.state {
 		g.mu.Unlock()
-		return
+		return false
 	}
 	g.state = state
 	actuators := g.actuators
+	dimmers := g.dimmers
+	indicators := g.indicators
+	g.mu.Unlock()
+
+	// outputs on chips like shift registers all change at once
+	var chips []string
+	seen := map[string]bool{}
+	actuators.ForEach(func(i *core.ItemHandle) {
+		if !seen[i.Chip()] {
+			seen[i.Chip()] = true
+			chips = append(chips, i.Chip())
+		}
+	})
+	err := batch(chips, func() error {
+		actuators.ForEach(func(i *core.ItemHandle) {
+			i.SetState(state)
+		})
+		return nil
+	})
+	if err != nil {
+		logger.Errorf("general %s couldn't latch its actuators: %v", g.tag, err)
+	}
+	for _, d := range dimmers {
+		d.follow(g.tag, state)
+	}
+	for _, i := range indicators {
+		i.follow(g.tag, state)
+	}
+	g.events.CallAll(&Event{General: g})
+	return true
+}
+
+// batch holds back the outputs of every chip in chips until fn returns
+func batch(chips []string, fn func() error) error {
+	if len(chips) == 0 {
+		return fn()
+	}
+	return core.Batch(chips[0], func() error {
+		return batch(chips[1:], fn)
+	})
+}
+
+// next computes the state the general should be in based on its sensors,
+// ok is false when the general should keep its current state
+func (g *General) next() (state core.State, ok bool) {
+	g.mu.Lock()
+	if g.disarmed {
+		g.mu.Unlock()
+		return
+	}
+	kind := g.kind
+	if g.device != nil {
+		// devices only do something when they're told to
+		g.mu.Unlock()
+		return
+	}
+	strategy := g.strategy
+	x := g.expression
+	named := g.named
+	sensors := g.sensors
+	upstream := make(map[string]*General, len(g.upstream))
+	for tag, u := range g.upstream {
+		upstream[tag] = u
+	}
 	g.mu.Unlock()
 
-	actuators.ForEach(func(i *core.Item) {
-		i.SetState(state)
+	if kind == Expression {
+		active := x.eval(func(name string) bool {
+			if i, ok := named[name]; ok {
+				return i.State() == core.Active
+			}
+			return upstream[name].State() == core.Active
+		})
+		return stateOf(active), true
+	}
+
+	total, active := 0, 0
+	count := func(s core.State) {
+		total++
+		if s == core.Active {
+			active++
+		}
+	}
+	sensors.ForEach(func(i *core.ItemHandle) {
+		count(i.State())
 	})
+	for _, u := range upstream {
+		count(u.State())
+	}
+
+	switch kind {
+	case Alarm:
+		// an alarm only goes off by itself, turning it back off is up to the user
+		return core.Active, active > 0
+	case Sync:
+		if strategy == AllIn {
+			return stateOf(active == total), true
+		}
+		return stateOf(active > 0), true
+	case RSync:
+		if strategy == AllIn {
+			return stateOf(active == 0), true
+		}
+		return stateOf(active < total), true
+	}
+	return
+}
+
+// update moves the general to the state its sensors dictate
+func (g *General) update() {
+	if state, ok := g.next(); ok {
+		g.setState(state)
+	}
+}
+
+// asInput are the options the inputs of the general are registered with
+func (g *General) asInput(owner string) []core.ItemOption {
+	opts := []core.ItemOption{core.AsInput(g.pull), core.WithOwner(owner)}
+	if g.activeLow {
+		opts = append(opts, core.AsActiveLow())
+	}
+	return opts
+}
+
+func stateOf(active bool) core.State {
+	if active {
+		return core.Active
+	}
+	return core.Inactive
 }
 
 func (g *General) AddSensor(gpioName string, tag string, offsets []int) (err error) {
-	log.Printf("*g = %#v", *g)
+	if g.kind == "" {
+		return OptionError{Field: "Kind", Value: g.kind}
+	}
 	for _, offset := range offsets {
-		i, err := core.RegisterItem(gpioName, offset, core.AsInput(core.PullDown), core.WithState(g.state))
+		i, err := core.RegisterItem(gpioName, offset, g.asInput(tag)...)
 		if err != nil {
 			return err
 		}
-		var handler core.EventHandler
-		switch g.kind {
-		case Alarm:
-			handler = g.AlarmHandler
-		case Sync:
-			switch g.strategy {
-			case AllIn:
-				handler = g.SyncHandlerAllIn
-			case OneIn:
-				handler = g.SyncHandlerOneIn
-			}
-		case RSync:
-			switch g.strategy {
-			case AllIn:
-				handler = g.RSyncHandlerAllIn
-			case OneIn:
-				handler = g.RSyncHandlerOneIn
-			}
-		}
-		if handler == nil {
-			panic("mode should be set")
-		}
-
-		i.AddEventListener(handler)
+		i.AddEventListener(g.SensorHandler)
 		g.sensors.Add(gpioName, offset, i)
 	}
 	return
@@ -126,7 +483,7 @@ func (g *General) AddSensor(gpioName string, tag string, offsets []int) (err err
 
 func (g *General) AddActuator(gpioName string, tag string, offsets []int) (err error) {
 	for _, offset := range offsets {
-		i, err := core.RegisterItem(gpioName, offset, core.AsOutput(), core.WithState(core.Inactive))
+		i, err := core.RegisterItem(gpioName, offset, core.AsOutput(), core.WithState(core.Inactive), core.WithOwner(tag))
 		if err != nil {
 			return err
 		}
@@ -135,84 +492,62 @@ func (g *General) AddActuator(gpioName string, tag string, offsets []int) (err e
 	return
 }
 
+// TurnOff turns the general off, covers and garage doors are closed,
+// irrigation is stopped, pumps are stopped until the level drops again and
+// doors are locked
 func (g *General) TurnOff() {
+	if g.device != nil {
+		g.device.turnOff()
+		return
+	}
 	g.setState(core.Inactive)
 }
 
+// TurnOn turns the general on, covers and garage doors are opened,
+// irrigation runs its program, pumps start unless they're locked out and
+// doors are unlocked
 func (g *General) TurnOn() {
-	g.setState(core.Active)
-}
-
-func (g *General) AlarmHandler(event *core.ItemEvent) {
-	if event.Item.State() == core.Active {
-		g.setState(core.Active)
+	if g.device != nil {
+		g.device.turnOn()
+		return
 	}
+	g.setState(core.Active)
 }
 
-func (g *General) SyncHandlerAllIn(event *core.ItemEvent) {
+// Actuators returns a snapshot of the actuators of the general
+func (g *General) Actuators() (infos []core.ItemInfo) {
 	g.mu.Lock()
-	sensors := g.sensors
+	actuators := g.actuators
 	g.mu.Unlock()
-	allIn := true
-	sensors.ForEach(func(i *core.Item) {
-		if i.State() == core.Inactive {
-			allIn = false
-		}
+	actuators.ForEach(func(i *core.ItemHandle) {
+		infos = append(infos, i.Info())
 	})
-	if allIn {
-		g.TurnOn()
-	} else {
-		g.TurnOff()
-	}
+	return
 }
 
-func (g *General) SyncHandlerOneIn(event *core.ItemEvent) {
+// Arm makes the general follow its sensors again
+func (g *General) Arm() {
 	g.mu.Lock()
-	sensors := g.sensors
+	g.disarmed = false
 	g.mu.Unlock()
-	oneIn := false
-	sensors.ForEach(func(i *core.Item) {
-		if i.State() == core.Active {
-			oneIn = true
-		}
-	})
-	if oneIn {
-		g.TurnOn()
-	} else {
-		g.TurnOff()
-	}
+	g.update()
 }
 
-func (g *General) RSyncHandlerAllIn(event *core.ItemEvent) {
+// Disarm turns the general off and makes it ignore its sensors until it's armed again
+func (g *General) Disarm() {
 	g.mu.Lock()
-	sensors := g.sensors
+	g.disarmed = true
 	g.mu.Unlock()
-	allIn := true
-	sensors.ForEach(func(i *core.Item) {
-		if i.State() == core.Active {
-			allIn = false
-		}
-	})
-	if allIn {
-		g.TurnOn()
-	} else {
-		g.TurnOff()
-	}
+	g.TurnOff()
 }
 
-func (g *General) RSyncHandlerOneIn(event *core.ItemEvent) {
+func (g *General) Armed() bool {
 	g.mu.Lock()
-	sensors := g.sensors
-	g.mu.Unlock()
-	oneIn := false
-	sensors.ForEach(func(i *core.Item) {
-		if i.State() == core.Inactive {
-			oneIn = true
-		}
-	})
-	if oneIn {
-		g.TurnOn()
-	} else {
-		g.TurnOff()
-	}
+	defer g.mu.Unlock()
+	return !g.disarmed
+}
+
+// SensorHandler is attached to every sensor of the general
+func (g *General) SensorHandler(event *core.ItemEvent) {
+	g.update()
 }
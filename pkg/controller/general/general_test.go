@@ -0,0 +1,40 @@
+package general
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/AliRostami1/baagh/pkg/controller/core"
+	"github.com/AliRostami1/baagh/pkg/controller/internal/coretest"
+	"github.com/AliRostami1/baagh/pkg/controller/internal/testutil"
+)
+
+func TestGeneralExpanderInputs(t *testing.T) {
+	chip, device := coretest.PCF8574(t)
+	// sensors are pulled down by default, which the expander can't do, so
+	// it pulls them up and they're active while they're pulled to ground
+	pulledDown := register(t, chip+"-down", AsSync(OneIn), WithConfig(chip, []int{0}, []int{4}))
+	activeLow := register(t, chip+"-low", AsSync(OneIn), WithInputs(core.PullUp, true), WithConfig(chip, []int{1}, []int{5}))
+	activeHigh := register(t, chip+"-high", AsSync(OneIn), WithInputs(core.PullUp, false), WithConfig(chip, []int{2}, []int{6}))
+
+	for _, g := range []*General{pulledDown, activeLow} {
+		if g.State() != core.Inactive {
+			t.Errorf("%s is active while its switch is open", g.tag)
+		}
+	}
+	if activeHigh.State() != core.Active {
+		t.Errorf("%s is inactive while its input is pulled up", activeHigh.tag)
+	}
+	for line, g := range []*General{pulledDown, activeLow, activeHigh} {
+		device.Pull(line, false)
+		want := core.Active
+		if g == activeHigh {
+			want = core.Inactive
+		}
+		testutil.Eventually(t, fmt.Sprintf("%s turning %s", g.tag, want), func() bool { return g.State() == want })
+	}
+
+	if _, err := Register(chip+"-floating", AsSync(OneIn), WithInputs(core.PullDisabled, false), WithConfig(chip, []int{3}, []int{7})); err == nil {
+		t.Error("a general's input on the expander was registered without a pull up")
+	}
+}
@@ -0,0 +1,113 @@
+package general
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// graph guards the upstream/downstream edges between generals and
+// serializes propagation, so dependents always see a consistent state
+var graph = &sync.Mutex{}
+
+// AddGeneralSensor makes the general with the given tag a sensor of g,
+// it fails if that would make a general depend on itself
+func (g *General) AddGeneralSensor(tag string) error {
+	u, err := general.Get(tag)
+	if err != nil {
+		return err
+	}
+	graph.Lock()
+	defer graph.Unlock()
+	if path := u.pathFrom(g); path != nil {
+		return CycleError{Path: append(path, g.tag)}
+	}
+	g.mu.Lock()
+	g.upstream[tag] = u
+	g.mu.Unlock()
+	u.mu.Lock()
+	u.downstream[g.tag] = g
+	u.mu.Unlock()
+	return nil
+}
+
+// detach removes every edge between g and the generals it uses as sensors
+func (g *General) detach() {
+	graph.Lock()
+	defer graph.Unlock()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for tag, u := range g.upstream {
+		u.mu.Lock()
+		delete(u.downstream, g.tag)
+		u.mu.Unlock()
+		delete(g.upstream, tag)
+	}
+}
+
+// pathFrom returns the tags on a path going downstream from "from" to g,
+// or nil if g isn't reachable from it. must be called with graph locked
+func (g *General) pathFrom(from *General) []string {
+	if from == g {
+		return []string{g.tag}
+	}
+	for _, d := range from.downstream {
+		if path := g.pathFrom(d); path != nil {
+			return append([]string{from.tag}, path...)
+		}
+	}
+	return nil
+}
+
+// order returns every general downstream of g so that each general comes
+// after all the generals it depends on. must be called with graph locked
+func (g *General) order() []*General {
+	visited := map[*General]bool{}
+	var postorder []*General
+	var visit func(n *General)
+	visit = func(n *General) {
+		visited[n] = true
+		for _, d := range n.downstream {
+			if !visited[d] {
+				visit(d)
+			}
+		}
+		postorder = append(postorder, n)
+	}
+	visit(g)
+
+	ordered := make([]*General, 0, len(postorder)-1)
+	for i := len(postorder) - 2; i >= 0; i-- {
+		ordered = append(ordered, postorder[i])
+	}
+	return ordered
+}
+
+// propagate re-evaluates every general downstream of g in topological order,
+// each of them is evaluated once after all of its sensors are up to date
+func (g *General) propagate() {
+	graph.Lock()
+	defer graph.Unlock()
+	for _, d := range g.order() {
+		if state, ok := d.next(); ok {
+			d.apply(state)
+		}
+	}
+}
+
+type CycleError struct {
+	Path []string
+}
+
+func (c CycleError) Error() string {
+	return fmt.Sprintf("generals can't depend on themselves: %s", strings.Join(c.Path, " -> "))
+}
+
+type InUseError struct {
+	Tag        string
+	Dependents []string
+}
+
+func (i InUseError) Error() string {
+	return fmt.Sprintf("general \"%s\" is used as a sensor by %s", i.Tag, strings.Join(i.Dependents, ", "))
+}
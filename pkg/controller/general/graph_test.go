@@ -0,0 +1,145 @@
+package general
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/controller/core"
+	"github.com/AliRostami1/baagh/pkg/controller/internal/testutil"
+)
+
+// virtualItem registers a virtual item for the duration of the test
+func virtualItem(t *testing.T, name string) {
+	t.Helper()
+	h, err := core.RegisterVirtualItem(name, core.WithOwner("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		core.SetVirtualState(name, core.Inactive)
+		h.Release()
+	})
+}
+
+// register registers a general and unregisters it when the test ends,
+// generals have to be registered before the ones depending on them
+func register(t *testing.T, tag string, opts ...Option) *General {
+	t.Helper()
+	g, err := Register(tag, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := g.Unregister(); err != nil {
+			t.Error(err)
+		}
+	})
+	return g
+}
+
+func TestGeneralSensorCycle(t *testing.T) {
+	virtualItem(t, "cycle-x")
+	a := register(t, "cycle-a", AsSync(OneIn), WithVirtual([]string{"cycle-x"}, nil))
+	register(t, "cycle-b", AsSync(OneIn), WithGeneralSensors("cycle-a"))
+	register(t, "cycle-c", AsSync(OneIn), WithGeneralSensors("cycle-b"))
+
+	err := a.AddGeneralSensor("cycle-c")
+	want := CycleError{Path: []string{"cycle-a", "cycle-b", "cycle-c", "cycle-a"}}
+	if !reflect.DeepEqual(err, want) {
+		t.Errorf("closing a cycle = %v, want %v", err, want)
+	}
+	err = a.AddGeneralSensor("cycle-a")
+	want = CycleError{Path: []string{"cycle-a", "cycle-a"}}
+	if !reflect.DeepEqual(err, want) {
+		t.Errorf("depending on itself = %v, want %v", err, want)
+	}
+	if _, err = Register("cycle-d", WithExpression("cycle-c & cycle-d")); err == nil {
+		t.Error("an expression referring to its own general was registered")
+	}
+	// a rejected edge leaves the graph as it was
+	if len(a.upstream) != 0 {
+		t.Errorf("cycle-a has upstream generals %v after the cycles were rejected", a.upstream)
+	}
+}
+
+func TestGeneralInUse(t *testing.T) {
+	virtualItem(t, "use-x")
+	a := register(t, "use-a", AsSync(OneIn), WithVirtual([]string{"use-x"}, nil))
+	b, err := Register("use-b", AsSync(OneIn), WithGeneralSensors("use-a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := InUseError{Tag: "use-a", Dependents: []string{"use-b"}}
+	if err = a.Unregister(); !reflect.DeepEqual(err, want) {
+		t.Errorf("unregistering a general in use = %v, want %v", err, want)
+	}
+	if err = b.Unregister(); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.downstream) != 0 {
+		t.Errorf("use-a still has downstream generals %v", a.downstream)
+	}
+}
+
+func TestGeneralPropagation(t *testing.T) {
+	virtualItem(t, "prop-x")
+	// b follows a and c is its inverse, so d is always active once every
+	// general is up to date. it would only turn off for a moment if it
+	// were evaluated in between b and c
+	a := register(t, "prop-a", AsSync(OneIn), WithVirtual([]string{"prop-x"}, nil))
+	b := register(t, "prop-b", AsSync(OneIn), WithGeneralSensors("prop-a"))
+	c := register(t, "prop-c", AsRSync(OneIn), WithGeneralSensors("prop-a"))
+	d := register(t, "prop-d", WithExpression("prop-b | prop-c"))
+	e := register(t, "prop-e", WithExpression("prop-d & prop-a"))
+
+	if d.State() != core.Active {
+		t.Fatalf("prop-d started %s", d.State())
+	}
+	// events of the registration itself are delivered asynchronously
+	time.Sleep(10 * time.Millisecond)
+	mu := &sync.Mutex{}
+	var changes []core.State
+	d.AddEventListener(func(event *Event) {
+		mu.Lock()
+		changes = append(changes, event.General.State())
+		mu.Unlock()
+	})
+
+	for _, state := range []core.State{core.Active, core.Inactive, core.Active} {
+		if err := core.SetVirtualState("prop-x", state); err != nil {
+			t.Fatal(err)
+		}
+		testutil.Eventually(t, "prop-e to follow prop-x", func() bool {
+			return a.State() == state && b.State() == state && c.State() != state && e.State() == state
+		})
+	}
+	time.Sleep(10 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(changes) != 0 {
+		t.Errorf("prop-d changed %v while its sensors were propagated", changes)
+	}
+}
+
+func TestGeneralInitialState(t *testing.T) {
+	virtualItem(t, "init-x")
+	virtualItem(t, "init-y")
+	if err := core.SetVirtualState("init-x", core.Active); err != nil {
+		t.Fatal(err)
+	}
+	// sensors that are active before the general is registered count
+	one := register(t, "init-one", AsSync(OneIn), WithVirtual([]string{"init-x", "init-y"}, nil))
+	all := register(t, "init-all", AsSync(AllIn), WithVirtual([]string{"init-x", "init-y"}, nil))
+	alarm := register(t, "init-alarm", AsAlarm(), WithVirtual([]string{"init-x"}, nil))
+	if one.State() != core.Active {
+		t.Errorf("one-in general with an active sensor started %s", one.State())
+	}
+	if all.State() != core.Inactive {
+		t.Errorf("all-in general with an inactive sensor started %s", all.State())
+	}
+	if alarm.State() != core.Active {
+		t.Errorf("alarm with an active sensor started %s", alarm.State())
+	}
+}
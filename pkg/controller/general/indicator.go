@@ -0,0 +1,41 @@
+package general
+
+import (
+	"github.com/AliRostami1/baagh/pkg/controller/core"
+)
+
+// indicator is a blinker that plays one pattern while its general is
+// active and another while it's inactive, an empty pattern leaves it off
+type indicator struct {
+	blinker  string
+	active   string
+	inactive string
+}
+
+func (i indicator) follow(tag string, state core.State) {
+	b, err := core.GetBlinker(i.blinker)
+	if err != nil {
+		logger.Errorf("general %s couldn't play on %s: %v", tag, i.blinker, err)
+		return
+	}
+	pattern := i.inactive
+	if state == core.Active {
+		pattern = i.active
+	}
+	if err = b.Play(pattern); err != nil {
+		logger.Errorf("general %s couldn't play on %s: %v", tag, i.blinker, err)
+	}
+}
+
+// check makes sure both patterns exist, so a typo shows up at startup
+func (i indicator) check() error {
+	for _, name := range []string{i.active, i.inactive} {
+		if name == "" {
+			continue
+		}
+		if _, err := core.GetPattern(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,433 @@
+package general
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/controller/core"
+	"github.com/AliRostami1/baagh/pkg/controller/store"
+	"go.uber.org/multierr"
+)
+
+const (
+	// Irrigation generals run garden valves one zone after the other
+	Irrigation = "irrigation"
+
+	// RainSkip drops every pending and running zone when it starts raining
+	RainSkip = "skip"
+	// RainPause closes the valves while it rains and carries on afterwards
+	RainPause = "pause"
+
+	RunCompleted = "completed"
+	RunStopped   = "stopped"
+	RunSkipped   = "skipped"
+
+	irrigationPrefix = "irrigation/"
+	// defaultRunRetention is how long runs are kept if WithRunRetention isn't used
+	defaultRunRetention = 90 * 24 * time.Hour
+)
+
+// Run is a single run of a zone as it's recorded in the store
+type Run struct {
+	Zone int `json:"zone"`
+	// Manual is false for runs that were part of the program
+	Manual   bool          `json:"manual"`
+	Started  time.Time     `json:"started,omitempty"`
+	Duration time.Duration `json:"duration"`
+	// Ran is how long the valve was actually open, pauses excluded
+	Ran     time.Duration `json:"ran"`
+	Outcome string        `json:"outcome"`
+	Ended   time.Time     `json:"ended"`
+}
+
+type zone struct {
+	valve    *core.ItemHandle
+	duration time.Duration
+}
+
+// irrigation opens at most max valves at a time, the zones of the program
+// run one after the other while manual runs only wait for a free slot
+type irrigation struct {
+	general    *General
+	zones      []zone
+	max        int
+	rain       *core.ItemHandle
+	rainPolicy string
+	// retention is how long recorded runs are kept
+	retention time.Duration
+
+	// queue are the runs waiting for a slot, paused runs go back to the front
+	queue  []*zoneRun
+	active map[*zoneRun]bool
+
+	mu *sync.Mutex
+}
+
+type zoneRun struct {
+	Run
+	remaining time.Duration
+	// opened is when the valve was last opened
+	opened time.Time
+	timer  *time.Timer
+	// generation invalidates the timers of earlier openings, a timer that
+	// already fired can still be waiting for mu while the run is paused
+	// and opened again
+	generation int
+}
+
+func newIrrigation(g *General, options *irrigationOptions) (r *irrigation, err error) {
+	r = &irrigation{
+		general:    g,
+		max:        options.max,
+		rainPolicy: options.rainPolicy,
+		retention:  options.retention,
+		active:     map[*zoneRun]bool{},
+		mu:         &sync.Mutex{},
+	}
+	if r.retention == 0 {
+		r.retention = defaultRunRetention
+	}
+	for _, z := range options.zones {
+		var h *core.ItemHandle
+		h, err = core.RegisterItem(z.chip, z.offset, core.AsOutput(), core.WithState(core.Inactive), core.WithOwner(g.tag))
+		if err != nil {
+			r.release()
+			return nil, err
+		}
+		r.zones = append(r.zones, zone{valve: h, duration: z.duration})
+	}
+	if options.rain != nil {
+		r.rain, err = core.RegisterItem(options.rain.chip, options.rain.offset, g.asInput(g.tag)...)
+		if err != nil {
+			r.release()
+			return nil, err
+		}
+		r.rain.AddEventListener(func(event *core.ItemEvent) {
+			r.rained(event.State == core.Active)
+		})
+	}
+	return r, nil
+}
+
+func (r *irrigation) turnOn() {
+	r.program()
+}
+
+func (r *irrigation) turnOff() {
+	r.stop()
+}
+
+func (r *irrigation) update() {
+	r.changed()
+}
+
+func (r *irrigation) close() error {
+	r.stop()
+	return r.release()
+}
+
+func (r *irrigation) release() (err error) {
+	for _, z := range r.zones {
+		err = multierr.Append(err, z.valve.Release())
+	}
+	if r.rain != nil {
+		err = multierr.Append(err, r.rain.Release())
+	}
+	return
+}
+
+func (r *irrigation) raining() bool {
+	return r.rain != nil && r.rain.State() == core.Active
+}
+
+// program queues every zone for its configured duration, unless the
+// program is already queued or running. while it rains the program is
+// skipped or, with RainPause, waits for the rain to stop
+func (r *irrigation) program() {
+	r.mu.Lock()
+	if r.programmed() {
+		r.mu.Unlock()
+		logger.Infof("irrigation %s is already running its program", r.general.tag)
+		return
+	}
+	if r.raining() && r.rainPolicy == RainSkip {
+		for i, z := range r.zones {
+			r.record(Run{Zone: i + 1, Duration: z.duration, Outcome: RunSkipped, Ended: time.Now()})
+		}
+		r.mu.Unlock()
+		logger.Infof("irrigation %s skipped its program because it's raining", r.general.tag)
+		return
+	}
+	for i, z := range r.zones {
+		r.queue = append(r.queue, &zoneRun{
+			Run:       Run{Zone: i + 1, Duration: z.duration},
+			remaining: z.duration,
+		})
+	}
+	r.dispatch()
+	r.mu.Unlock()
+	r.changed()
+}
+
+// programmed reports whether a zone of the program is queued or running,
+// must be called with mu locked
+func (r *irrigation) programmed() bool {
+	for run := range r.active {
+		if !run.Manual {
+			return true
+		}
+	}
+	for _, run := range r.queue {
+		if !run.Manual {
+			return true
+		}
+	}
+	return false
+}
+
+// runZone queues a manual run of zone, which counts from 1
+func (r *irrigation) runZone(zone int, d time.Duration) error {
+	if zone < 1 || zone > len(r.zones) {
+		return ZoneError{Tag: r.general.tag, Zone: zone}
+	}
+	if d <= 0 {
+		d = r.zones[zone-1].duration
+	}
+	r.mu.Lock()
+	if r.raining() && r.rainPolicy == RainSkip {
+		r.record(Run{Zone: zone, Manual: true, Duration: d, Outcome: RunSkipped, Ended: time.Now()})
+		r.mu.Unlock()
+		return RainError{Tag: r.general.tag}
+	}
+	r.queue = append(r.queue, &zoneRun{
+		Run:       Run{Zone: zone, Manual: true, Duration: d},
+		remaining: d,
+	})
+	r.dispatch()
+	r.mu.Unlock()
+	r.changed()
+	return nil
+}
+
+// dispatch opens the valves of queued runs while there are free slots,
+// must be called with mu locked
+func (r *irrigation) dispatch() {
+	if r.raining() && r.rainPolicy == RainPause {
+		return
+	}
+	programRunning := false
+	busy := map[int]bool{}
+	for run := range r.active {
+		busy[run.Zone] = true
+		programRunning = programRunning || !run.Manual
+	}
+	for i := 0; i < len(r.queue) && len(r.active) < r.max; {
+		run := r.queue[i]
+		if busy[run.Zone] || (!run.Manual && programRunning) {
+			i++
+			// the program is sequential, nothing after its next zone can jump ahead of it
+			if !run.Manual {
+				programRunning = true
+			}
+			continue
+		}
+		r.queue = append(r.queue[:i], r.queue[i+1:]...)
+		r.openValve(run)
+		busy[run.Zone] = true
+		programRunning = programRunning || !run.Manual
+	}
+}
+
+// openValve opens the valve of run, must be called with mu locked
+func (r *irrigation) openValve(run *zoneRun) {
+	valve := r.zones[run.Zone-1].valve
+	if err := valve.SetState(core.Active); err != nil {
+		logger.Errorf("irrigation %s couldn't open zone %d: %v", r.general.tag, run.Zone, err)
+		r.finish(run, RunStopped)
+		return
+	}
+	if run.Started.IsZero() {
+		run.Started = time.Now()
+	}
+	run.opened = time.Now()
+	r.active[run] = true
+	run.generation++
+	generation := run.generation
+	run.timer = time.AfterFunc(run.remaining, func() {
+		r.mu.Lock()
+		if !r.active[run] || generation != run.generation {
+			r.mu.Unlock()
+			return
+		}
+		r.closeValve(run)
+		r.finish(run, RunCompleted)
+		r.dispatch()
+		r.mu.Unlock()
+		r.changed()
+	})
+	logger.Infof("irrigation %s opened zone %d for %s", r.general.tag, run.Zone, run.remaining)
+}
+
+// closeValve closes the valve of an active run and keeps track of how long
+// it was open, must be called with mu locked
+func (r *irrigation) closeValve(run *zoneRun) {
+	run.timer.Stop()
+	delete(r.active, run)
+	ran := time.Since(run.opened)
+	run.Ran += ran
+	run.remaining -= ran
+	if err := r.zones[run.Zone-1].valve.SetState(core.Inactive); err != nil {
+		logger.Errorf("irrigation %s couldn't close zone %d: %v", r.general.tag, run.Zone, err)
+	}
+}
+
+// finish records run, must be called with mu locked
+func (r *irrigation) finish(run *zoneRun, outcome string) {
+	run.Outcome = outcome
+	run.Ended = time.Now()
+	r.record(run.Run)
+	logger.Infof("irrigation %s zone %d %s after %s", r.general.tag, run.Zone, outcome, run.Ran.Round(time.Second))
+}
+
+// record persists run and forgets the runs that ended before the retention
+func (r *irrigation) record(run Run) {
+	prefix := irrigationPrefix + r.general.tag + "/"
+	key := fmt.Sprintf("%s%s-%d", prefix, store.Stamp(run.Ended), run.Zone)
+	if err := db.Put(key, run); err != nil {
+		logger.Errorf("couldn't record a run of irrigation %s: %v", r.general.tag, err)
+	}
+	keys, err := db.Keys(prefix)
+	if err != nil {
+		logger.Errorf("couldn't prune the runs of irrigation %s: %v", r.general.tag, err)
+		return
+	}
+	before := prefix + store.Stamp(time.Now().Add(-r.retention))
+	for _, k := range keys {
+		if k >= before {
+			// keys are sorted, the rest are newer
+			break
+		}
+		if err = db.Delete(k); err != nil {
+			logger.Errorf("couldn't prune the runs of irrigation %s: %v", r.general.tag, err)
+			return
+		}
+	}
+}
+
+// rained is called whenever the rain sensor changes
+func (r *irrigation) rained(raining bool) {
+	r.mu.Lock()
+	switch {
+	case !raining:
+		r.dispatch()
+	case r.rainPolicy == RainPause:
+		paused := []*zoneRun{}
+		for run := range r.active {
+			r.closeValve(run)
+			paused = append(paused, run)
+		}
+		r.queue = append(paused, r.queue...)
+		if len(paused) > 0 {
+			logger.Infof("irrigation %s paused because it's raining", r.general.tag)
+		}
+	default:
+		r.cancel(RunSkipped)
+	}
+	r.mu.Unlock()
+	r.changed()
+}
+
+// cancel closes every valve and drops the queue, must be called with mu locked
+func (r *irrigation) cancel(outcome string) {
+	for run := range r.active {
+		r.closeValve(run)
+		r.finish(run, outcome)
+	}
+	for _, run := range r.queue {
+		r.finish(run, outcome)
+	}
+	r.queue = nil
+}
+
+func (r *irrigation) stop() {
+	r.mu.Lock()
+	r.cancel(RunStopped)
+	r.mu.Unlock()
+	r.changed()
+}
+
+// changed updates the state of the general, it's active while anything is
+// running or waiting to run
+func (r *irrigation) changed() {
+	r.mu.Lock()
+	busy := len(r.active) > 0 || len(r.queue) > 0
+	r.mu.Unlock()
+	r.general.setState(stateOf(busy))
+}
+
+func (g *General) irrigationOf() (*irrigation, error) {
+	r, ok := g.device.(*irrigation)
+	if !ok {
+		return nil, NotIrrigationError{Tag: g.tag}
+	}
+	return r, nil
+}
+
+// RunZone waters zone, counting from 1, for d or for its configured
+// duration if d is 0. it starts as soon as a valve slot is free
+func (g *General) RunZone(zone int, d time.Duration) error {
+	r, err := g.irrigationOf()
+	if err != nil {
+		return err
+	}
+	return r.runZone(zone, d)
+}
+
+// Runs returns the recorded runs of an irrigation general that ended in [from, to)
+func (g *General) Runs(from, to time.Time) (runs []Run, err error) {
+	if _, err = g.irrigationOf(); err != nil {
+		return
+	}
+	prefix := irrigationPrefix + g.tag + "/"
+	keys, err := db.Keys(prefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		if k < prefix+store.Stamp(from) || k >= prefix+store.Stamp(to) {
+			continue
+		}
+		var run Run
+		if err = db.Get(k, &run); err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return
+}
+
+type NotIrrigationError struct {
+	Tag string
+}
+
+func (n NotIrrigationError) Error() string {
+	return fmt.Sprintf("general \"%s\" is not an irrigation general", n.Tag)
+}
+
+type ZoneError struct {
+	Tag  string
+	Zone int
+}
+
+func (z ZoneError) Error() string {
+	return fmt.Sprintf("irrigation \"%s\" has no zone %d", z.Tag, z.Zone)
+}
+
+type RainError struct {
+	Tag string
+}
+
+func (r RainError) Error() string {
+	return fmt.Sprintf("irrigation \"%s\" doesn't run while it's raining", r.Tag)
+}
@@ -0,0 +1,193 @@
+package general
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/controller/core"
+	"github.com/AliRostami1/baagh/pkg/controller/internal/coretest"
+	"github.com/AliRostami1/baagh/pkg/controller/internal/testutil"
+)
+
+// the valves of the zones are on lines 4 to 6 and the rain sensor on 0,
+// which is active while it's pulled to ground
+const rainSensor = 0
+
+func valves(chip string, d time.Duration) []Option {
+	return []Option{WithZone(chip, 4, d), WithZone(chip, 5, d), WithZone(chip, 6, d)}
+}
+
+// runs returns the runs of g that ended since from
+func runs(t *testing.T, g *General, from time.Time) []Run {
+	t.Helper()
+	runs, err := g.Runs(from, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return runs
+}
+
+// rain pulls the rain sensor and waits for it to follow
+func rain(t *testing.T, chip string, device *testutil.PCF8574, raining bool) {
+	t.Helper()
+	device.Pull(rainSensor, !raining)
+	sensor, err := core.GetItem(chip, rainSensor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.Eventually(t, "the rain sensor changing", func() bool { return (sensor.State() == core.Active) == raining })
+}
+
+func TestIrrigationSlots(t *testing.T) {
+	chip, device := coretest.PCF8574(t)
+	g := register(t, chip+"-irrigation", append([]Option{AsIrrigation(2)}, valves(chip, 30*time.Millisecond)...)...)
+	open := func() (open []int) {
+		for zone, line := range []int{4, 5, 6} {
+			if device.High(line) {
+				open = append(open, zone+1)
+			}
+		}
+		return
+	}
+	start := time.Now()
+
+	g.TurnOn()
+	if got := open(); len(got) != 1 || got[0] != 1 {
+		t.Fatalf("zones %v are open after the program started, want 1", got)
+	}
+	// manual runs take the free slot but don't jump ahead of each other
+	if err := g.RunZone(3, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.RunZone(2, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if got := open(); len(got) != 2 || got[1] != 3 {
+		t.Fatalf("zones %v are open after the manual runs were queued, want 1 and 3", got)
+	}
+	deadline := time.Now().Add(time.Second)
+	for g.State() == core.Active {
+		if got := open(); len(got) > 2 {
+			t.Fatalf("zones %v are open at the same time, at most 2 are allowed", got)
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the runs didn't finish")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	var program, manual []int
+	for _, run := range runs(t, g, start) {
+		if run.Outcome != RunCompleted {
+			t.Errorf("zone %d %s, want it completed", run.Zone, run.Outcome)
+		}
+		if run.Ran < run.Duration {
+			t.Errorf("zone %d ran for %v, want %v", run.Zone, run.Ran, run.Duration)
+		}
+		if run.Manual {
+			manual = append(manual, run.Zone)
+		} else {
+			program = append(program, run.Zone)
+		}
+	}
+	if len(program) != 3 || program[0] != 1 || program[1] != 2 || program[2] != 3 {
+		t.Errorf("the program ran zones %v, want 1, 2 and 3 in order", program)
+	}
+	if len(manual) != 2 {
+		t.Errorf("the manual runs ran zones %v, want 3 and 2", manual)
+	}
+}
+
+func TestIrrigationProgramOnce(t *testing.T) {
+	chip, _ := coretest.PCF8574(t)
+	g := register(t, chip+"-irrigation", append([]Option{AsIrrigation(1)}, valves(chip, 10*time.Millisecond)...)...)
+	start := time.Now()
+	g.TurnOn()
+	g.TurnOn()
+	testutil.Eventually(t, "the program finishing", func() bool { return g.State() == core.Inactive })
+	if got := runs(t, g, start); len(got) != 3 {
+		t.Errorf("the program ran %d zones after it was started twice, want 3", len(got))
+	}
+}
+
+func TestIrrigationRainPause(t *testing.T) {
+	chip, device := coretest.PCF8574(t)
+	const d, pause = 30 * time.Millisecond, 30 * time.Millisecond
+	g := register(t, chip+"-irrigation", AsIrrigation(1), WithZone(chip, 4, d), WithZone(chip, 5, d), WithRainSensor(chip, rainSensor, RainPause))
+	start := time.Now()
+
+	// a program started in the rain waits for it to stop
+	rain(t, chip, device, true)
+	g.TurnOn()
+	time.Sleep(pause)
+	if device.High(4) || g.State() != core.Active {
+		t.Fatalf("zone 1 is open %v and the program is %s while it rains, want it closed and waiting", device.High(4), g.State())
+	}
+	if got := runs(t, g, start); len(got) != 0 {
+		t.Fatalf("runs %v were recorded, want the program to wait", got)
+	}
+	rain(t, chip, device, false)
+	testutil.Eventually(t, "zone 1 opening after the rain", func() bool { return device.High(4) })
+
+	// rain pauses a zone that's open and it carries on afterwards
+	rain(t, chip, device, true)
+	testutil.Eventually(t, "zone 1 closing in the rain", func() bool { return !device.High(4) })
+	time.Sleep(pause)
+	rain(t, chip, device, false)
+	testutil.Eventually(t, "zone 1 opening again after the rain", func() bool { return device.High(4) })
+	testutil.Eventually(t, "the program finishing", func() bool { return g.State() == core.Inactive })
+
+	got := runs(t, g, start)
+	if len(got) != 2 {
+		t.Fatalf("%d runs were recorded, want 2", len(got))
+	}
+	first := got[0]
+	if first.Zone != 1 || first.Outcome != RunCompleted {
+		t.Errorf("zone %d %s, want zone 1 completed", first.Zone, first.Outcome)
+	}
+	if first.Ran < d {
+		t.Errorf("zone 1 ran for %v, want %v", first.Ran, d)
+	}
+	if paused := first.Ended.Sub(first.Started) - first.Ran; paused < pause {
+		t.Errorf("zone 1 was paused for %v, want at least %v", paused, pause)
+	}
+}
+
+func TestIrrigationRainSkip(t *testing.T) {
+	chip, device := coretest.PCF8574(t)
+	g := register(t, chip+"-irrigation", append([]Option{AsIrrigation(1), WithRainSensor(chip, rainSensor, RainSkip)}, valves(chip, time.Hour)...)...)
+	start := time.Now()
+
+	rain(t, chip, device, true)
+	g.TurnOn()
+	if err := g.RunZone(2, 0); err == nil {
+		t.Error("a zone was run while it rains")
+	} else if _, ok := err.(RainError); !ok {
+		t.Errorf("running a zone while it rains failed with %v, want a RainError", err)
+	}
+	if g.State() != core.Inactive {
+		t.Error("the program is waiting while it rains, it should be skipped")
+	}
+
+	// rain drops what's running and what's waiting
+	rain(t, chip, device, false)
+	g.TurnOn()
+	if !device.High(4) {
+		t.Fatal("zone 1 didn't open once the rain stopped")
+	}
+	rain(t, chip, device, true)
+	testutil.Eventually(t, "the program being dropped", func() bool { return g.State() == core.Inactive })
+	if device.High(4) {
+		t.Error("zone 1 is still open while it rains")
+	}
+
+	got := runs(t, g, start)
+	if len(got) != 7 {
+		t.Fatalf("%d runs were recorded, want 7", len(got))
+	}
+	for _, run := range got {
+		if run.Outcome != RunSkipped {
+			t.Errorf("zone %d %s, want it skipped", run.Zone, run.Outcome)
+		}
+	}
+}
@@ -1,11 +1,21 @@
 package general
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/controller/core"
+	"github.com/AliRostami1/baagh/pkg/controller/daytime"
+)
 
 const (
 	Sync  = "sync"
 	RSync = "rsync"
 	Alarm = "alarm"
+	// Expression generals are active whenever their expression evaluates to true
+	Expression = "expression"
+	// Cover generals drive the up and down relays of a roller shutter or blind
+	Cover = "cover"
 
 	AllIn = "all-in"
 	OneIn = "one-in"
@@ -34,6 +44,41 @@ type Options struct {
 	// only when all inputs are active, and "one-in" which will
 	// turn on when any of the inputs are active
 	strategy string
+	// expression is only relevant if kind is "expression"
+	expression expression
+	// named are the sensors an expression can refer to by name
+	named map[string]namedSensor
+	// generals are the tags of the generals used as sensors
+	generals []string
+	// virtual are the names of the virtual items used as sensors and actuators
+	virtual VirtualControl
+	// cover is only relevant if kind is "cover"
+	cover *coverOptions
+	// garage is only relevant if kind is "garage"
+	garage *garageOptions
+	// irrigation is only relevant if kind is "irrigation"
+	irrigation *irrigationOptions
+	// pump is only relevant if kind is "pump"
+	pump *pumpOptions
+	// door is only relevant if kind is "door"
+	door *doorOptions
+	// dimmers are the pwm outputs that follow the state
+	dimmers []dimmer
+	// indicators are the blinkers that play patterns for the state
+	indicators []indicator
+	// pull and activeLow are how every input of the general is registered
+	pull      core.Pull
+	activeLow bool
+}
+
+type VirtualControl struct {
+	sensors   []string
+	actuators []string
+}
+
+type namedSensor struct {
+	chip   string
+	offset int
 }
 
 type ConfigOption struct {
@@ -157,3 +202,644 @@ func AsOneIn() StrategyOption {
 func (o OptionError) Error() string {
 	return fmt.Sprintf("field %s can not be: %v", o.Field, o.Value)
 }
+
+type ExpressionOption string
+
+func (e ExpressionOption) applyOption(o *Options) error {
+	x, err := parseExpression(string(e))
+	if err != nil {
+		return err
+	}
+	o.kind = Expression
+	o.strategy = ""
+	o.expression = x
+	return nil
+}
+
+// WithExpression makes the general an expression general, the expression
+// can use & | ! parentheses and kof(...), e.g. "(door1 | door2) & !maintenance & 2of(pir1,pir2,pir3)"
+func WithExpression(expr string) ExpressionOption {
+	return ExpressionOption(expr)
+}
+
+type NamedSensorOption struct {
+	name string
+	namedSensor
+}
+
+func (n NamedSensorOption) applyOption(o *Options) error {
+	if n.name == "" {
+		return OptionError{Field: "Name", Value: n.name}
+	}
+	if n.chip == "" {
+		return OptionError{Field: "Chip", Value: n.chip}
+	}
+	if _, ok := o.named[n.name]; ok {
+		return OptionError{Field: "Name", Value: n.name}
+	}
+	o.named[n.name] = n.namedSensor
+	return nil
+}
+
+// WithNamedSensor adds a sensor that expressions can refer to by name
+func WithNamedSensor(name string, chip string, offset int) NamedSensorOption {
+	return NamedSensorOption{
+		name: name,
+		namedSensor: namedSensor{
+			chip:   chip,
+			offset: offset,
+		},
+	}
+}
+
+type GeneralSensorOption []string
+
+func (g GeneralSensorOption) applyOption(o *Options) error {
+	for _, tag := range g {
+		if tag == "" {
+			return OptionError{Field: "Generals", Value: []string(g)}
+		}
+	}
+	o.generals = append(o.generals, g...)
+	return nil
+}
+
+// WithGeneralSensors uses the state of already registered generals as sensors
+func WithGeneralSensors(tags ...string) GeneralSensorOption {
+	return GeneralSensorOption(tags)
+}
+
+type VirtualOption VirtualControl
+
+func (v VirtualOption) applyOption(o *Options) error {
+	for _, name := range append(append([]string{}, v.sensors...), v.actuators...) {
+		if name == "" {
+			return OptionError{Field: "Virtual", Value: v}
+		}
+	}
+	o.virtual.sensors = append(o.virtual.sensors, v.sensors...)
+	o.virtual.actuators = append(o.virtual.actuators, v.actuators...)
+	return nil
+}
+
+// WithVirtual uses already registered virtual items as sensors and actuators
+func WithVirtual(sensors []string, actuators []string) VirtualOption {
+	return VirtualOption{
+		sensors:   sensors,
+		actuators: actuators,
+	}
+}
+
+type InputOption struct {
+	pull      core.Pull
+	activeLow bool
+}
+
+func (i InputOption) applyOption(o *Options) error {
+	if err := i.pull.Check(); err != nil {
+		return OptionError{Field: "Pull", Value: i.pull}
+	}
+	o.pull, o.activeLow = i.pull, i.activeLow
+	return nil
+}
+
+// WithInputs sets how every input of the general, its sensors, end stops,
+// limits and switches, is pulled and whether it's active while it's low.
+// inputs are pulled down and active while they're high by default
+func WithInputs(pull core.Pull, activeLow bool) InputOption {
+	return InputOption{pull: pull, activeLow: activeLow}
+}
+
+type DimmerOption dimmer
+
+func (d DimmerOption) applyOption(o *Options) error {
+	if d.pwm == "" {
+		return OptionError{Field: "Dimmer", Value: d.pwm}
+	}
+	if d.level <= 0 || d.level > 100 || d.fade < 0 {
+		return OptionError{Field: "Dimmer", Value: d}
+	}
+	o.dimmers = append(o.dimmers, dimmer(d))
+	return nil
+}
+
+// WithDimmer fades the already registered pwm output called pwm to level
+// percent when the general turns on and back to 0 when it turns off
+func WithDimmer(pwm string, level float64, fade time.Duration) DimmerOption {
+	return DimmerOption{pwm: pwm, level: level, fade: fade}
+}
+
+type IndicatorOption indicator
+
+func (i IndicatorOption) applyOption(o *Options) error {
+	if i.blinker == "" || (i.active == "" && i.inactive == "") {
+		return OptionError{Field: "Indicator", Value: i}
+	}
+	o.indicators = append(o.indicators, indicator(i))
+	return nil
+}
+
+// WithIndicator plays the pattern called active on the already registered
+// blinker called blinker while the general is active and the one called
+// inactive while it's not, either of them can be empty to leave it off
+func WithIndicator(blinker string, active string, inactive string) IndicatorOption {
+	return IndicatorOption{blinker: blinker, active: active, inactive: inactive}
+}
+
+type coverOptions struct {
+	chip     string
+	up, down int
+	travel   time.Duration
+	reverse  time.Duration
+	stops    []endStop
+}
+
+type endStop struct {
+	chip     string
+	offset   int
+	position float64
+}
+
+type CoverOption struct {
+	chip     string
+	up, down int
+	travel   time.Duration
+}
+
+func (c CoverOption) applyOption(o *Options) error {
+	if c.chip == "" {
+		return OptionError{Field: "Chip", Value: c.chip}
+	}
+	if c.up == c.down {
+		return OptionError{Field: "Down", Value: c.down}
+	}
+	if c.travel <= 0 {
+		return OptionError{Field: "Travel", Value: c.travel}
+	}
+	if o.cover == nil {
+		o.cover = &coverOptions{reverse: 500 * time.Millisecond}
+	}
+	o.kind = Cover
+	o.strategy = ""
+	o.cover.chip = c.chip
+	o.cover.up = c.up
+	o.cover.down = c.down
+	o.cover.travel = c.travel
+	return nil
+}
+
+// AsCover makes the general a cover that opens with the relay on up and
+// closes with the one on down, travel is how long a full run takes
+func AsCover(chip string, up int, down int, travel time.Duration) CoverOption {
+	return CoverOption{
+		chip:   chip,
+		up:     up,
+		down:   down,
+		travel: travel,
+	}
+}
+
+type EndStopOption endStop
+
+func (e EndStopOption) applyOption(o *Options) error {
+	if e.chip == "" {
+		return OptionError{Field: "Chip", Value: e.chip}
+	}
+	if e.position != Closed && e.position != Open {
+		return OptionError{Field: "Position", Value: e.position}
+	}
+	if o.cover == nil {
+		o.cover = &coverOptions{reverse: 500 * time.Millisecond}
+	}
+	o.cover.stops = append(o.cover.stops, endStop(e))
+	return nil
+}
+
+// WithEndStop adds a switch that is active when the cover is at position,
+// which has to be either Closed or Open
+func WithEndStop(chip string, offset int, position float64) EndStopOption {
+	return EndStopOption{
+		chip:     chip,
+		offset:   offset,
+		position: position,
+	}
+}
+
+type ReverseDelayOption time.Duration
+
+func (r ReverseDelayOption) applyOption(o *Options) error {
+	if r < 0 {
+		return OptionError{Field: "Reverse", Value: time.Duration(r)}
+	}
+	if o.cover == nil {
+		o.cover = &coverOptions{}
+	}
+	o.cover.reverse = time.Duration(r)
+	return nil
+}
+
+// WithReverseDelay sets how long a cover waits with both relays off before
+// changing direction, it's half a second by default
+func WithReverseDelay(d time.Duration) ReverseDelayOption {
+	return ReverseDelayOption(d)
+}
+
+type garageOptions struct {
+	chip                  string
+	relay, opened, closed int
+	timeout               time.Duration
+	pulse                 time.Duration
+	autoClose             *autoClose
+}
+
+type GarageOption struct {
+	chip                  string
+	relay, opened, closed int
+	timeout               time.Duration
+}
+
+func (g GarageOption) applyOption(o *Options) error {
+	if g.chip == "" {
+		return OptionError{Field: "Chip", Value: g.chip}
+	}
+	if g.relay == g.opened || g.relay == g.closed || g.opened == g.closed {
+		return OptionError{Field: "Garage", Value: g}
+	}
+	if g.timeout <= 0 {
+		return OptionError{Field: "Timeout", Value: g.timeout}
+	}
+	if o.garage == nil {
+		o.garage = &garageOptions{pulse: 500 * time.Millisecond}
+	}
+	o.kind = Garage
+	o.strategy = ""
+	o.garage.chip = g.chip
+	o.garage.relay = g.relay
+	o.garage.opened = g.opened
+	o.garage.closed = g.closed
+	o.garage.timeout = g.timeout
+	return nil
+}
+
+// AsGarageDoor makes the general a garage door whose opener is pulsed
+// through relay, opened and closed are its limit switches and timeout is
+// how long the door may take to get from one to the other
+func AsGarageDoor(chip string, relay int, opened int, closed int, timeout time.Duration) GarageOption {
+	return GarageOption{
+		chip:    chip,
+		relay:   relay,
+		opened:  opened,
+		closed:  closed,
+		timeout: timeout,
+	}
+}
+
+type PulseOption time.Duration
+
+func (p PulseOption) applyOption(o *Options) error {
+	if p <= 0 {
+		return OptionError{Field: "Pulse", Value: time.Duration(p)}
+	}
+	if o.garage == nil {
+		o.garage = &garageOptions{}
+	}
+	o.garage.pulse = time.Duration(p)
+	return nil
+}
+
+// WithPulse sets how long the relay of a garage door opener is held
+// for a single press, it's half a second by default
+func WithPulse(d time.Duration) PulseOption {
+	return PulseOption(d)
+}
+
+type AutoCloseOption struct {
+	after    time.Duration
+	from, to string
+}
+
+func (a AutoCloseOption) applyOption(o *Options) error {
+	if a.after <= 0 {
+		return OptionError{Field: "After", Value: a.after}
+	}
+	from, err := daytime.Parse(a.from)
+	if err != nil {
+		return OptionError{Field: "From", Value: a.from}
+	}
+	to, err := daytime.Parse(a.to)
+	if err != nil {
+		return OptionError{Field: "To", Value: a.to}
+	}
+	if o.garage == nil {
+		o.garage = &garageOptions{pulse: 500 * time.Millisecond}
+	}
+	o.garage.autoClose = &autoClose{after: a.after, window: daytime.Window{From: from, To: to}}
+	return nil
+}
+
+// WithAutoClose closes a garage door that has been open for longer than
+// after, but only between from and to, e.g. "22:00" and "06:00"
+func WithAutoClose(after time.Duration, from string, to string) AutoCloseOption {
+	return AutoCloseOption{
+		after: after,
+		from:  from,
+		to:    to,
+	}
+}
+
+type irrigationOptions struct {
+	max        int
+	zones      []zoneOptions
+	rain       *namedSensor
+	rainPolicy string
+	retention  time.Duration
+}
+
+type zoneOptions struct {
+	chip     string
+	offset   int
+	duration time.Duration
+}
+
+type IrrigationOption int
+
+func (i IrrigationOption) applyOption(o *Options) error {
+	if i < 1 {
+		return OptionError{Field: "Max", Value: int(i)}
+	}
+	if o.irrigation == nil {
+		o.irrigation = &irrigationOptions{}
+	}
+	o.kind = Irrigation
+	o.strategy = ""
+	o.irrigation.max = int(i)
+	return nil
+}
+
+// AsIrrigation makes the general an irrigation general that never opens
+// more than max valves at once, its zones are added with WithZone
+func AsIrrigation(max int) IrrigationOption {
+	return IrrigationOption(max)
+}
+
+type ZoneOption zoneOptions
+
+func (z ZoneOption) applyOption(o *Options) error {
+	if z.chip == "" {
+		return OptionError{Field: "Chip", Value: z.chip}
+	}
+	if z.duration <= 0 {
+		return OptionError{Field: "Duration", Value: z.duration}
+	}
+	if o.irrigation == nil {
+		o.irrigation = &irrigationOptions{max: 1}
+	}
+	o.irrigation.zones = append(o.irrigation.zones, zoneOptions(z))
+	return nil
+}
+
+// WithZone adds a zone whose valve is on offset, zones are numbered from 1
+// in the order they're added and the program waters each for duration
+func WithZone(chip string, offset int, duration time.Duration) ZoneOption {
+	return ZoneOption{
+		chip:     chip,
+		offset:   offset,
+		duration: duration,
+	}
+}
+
+type RainSensorOption struct {
+	namedSensor
+	policy string
+}
+
+func (r RainSensorOption) applyOption(o *Options) error {
+	if r.chip == "" {
+		return OptionError{Field: "Chip", Value: r.chip}
+	}
+	if r.policy != RainSkip && r.policy != RainPause {
+		return OptionError{Field: "Policy", Value: r.policy}
+	}
+	if o.irrigation == nil {
+		o.irrigation = &irrigationOptions{max: 1}
+	}
+	sensor := r.namedSensor
+	o.irrigation.rain = &sensor
+	o.irrigation.rainPolicy = r.policy
+	return nil
+}
+
+// WithRainSensor inhibits irrigation while the input on offset is active,
+// policy is either RainSkip or RainPause
+func WithRainSensor(chip string, offset int, policy string) RainSensorOption {
+	return RainSensorOption{
+		namedSensor: namedSensor{
+			chip:   chip,
+			offset: offset,
+		},
+		policy: policy,
+	}
+}
+
+type RunRetentionOption time.Duration
+
+func (r RunRetentionOption) applyOption(o *Options) error {
+	if r <= 0 {
+		return OptionError{Field: "Retention", Value: time.Duration(r)}
+	}
+	if o.irrigation == nil {
+		o.irrigation = &irrigationOptions{max: 1}
+	}
+	o.irrigation.retention = time.Duration(r)
+	return nil
+}
+
+// WithRunRetention sets how long the runs of an irrigation general are
+// kept in the store, it's 90 days by default
+func WithRunRetention(d time.Duration) RunRetentionOption {
+	return RunRetentionOption(d)
+}
+
+type pumpOptions struct {
+	chip       string
+	motor, low int
+	high, flow *int
+	maxRun     time.Duration
+	minRest    time.Duration
+	dryRun     time.Duration
+}
+
+type PumpOption struct {
+	chip       string
+	motor, low int
+}
+
+func (p PumpOption) applyOption(o *Options) error {
+	if p.chip == "" {
+		return OptionError{Field: "Chip", Value: p.chip}
+	}
+	if p.motor == p.low {
+		return OptionError{Field: "Low", Value: p.low}
+	}
+	if o.pump == nil {
+		o.pump = &pumpOptions{}
+	}
+	o.kind = Pump
+	o.strategy = ""
+	o.pump.chip = p.chip
+	o.pump.motor = p.motor
+	o.pump.low = p.low
+	return nil
+}
+
+// AsPump makes the general a pump that runs the motor on offset motor while
+// the level switch on offset low is inactive
+func AsPump(chip string, motor int, low int) PumpOption {
+	return PumpOption{
+		chip:  chip,
+		motor: motor,
+		low:   low,
+	}
+}
+
+type HighLevelOption int
+
+func (h HighLevelOption) applyOption(o *Options) error {
+	if o.pump == nil {
+		o.pump = &pumpOptions{}
+	}
+	high := int(h)
+	o.pump.high = &high
+	return nil
+}
+
+// WithHighLevel adds a second level switch on the chip of the pump, once
+// started the pump keeps running until it's active
+func WithHighLevel(offset int) HighLevelOption {
+	return HighLevelOption(offset)
+}
+
+type RuntimeOption struct {
+	maxRun, minRest time.Duration
+}
+
+func (r RuntimeOption) applyOption(o *Options) error {
+	if r.maxRun < 0 {
+		return OptionError{Field: "MaxRun", Value: r.maxRun}
+	}
+	if r.minRest < 0 {
+		return OptionError{Field: "MinRest", Value: r.minRest}
+	}
+	if o.pump == nil {
+		o.pump = &pumpOptions{}
+	}
+	o.pump.maxRun = r.maxRun
+	o.pump.minRest = r.minRest
+	return nil
+}
+
+// WithRuntime stops a pump that has been running for maxRun and keeps it
+// off for at least minRest after it stops, 0 disables either of them
+func WithRuntime(maxRun time.Duration, minRest time.Duration) RuntimeOption {
+	return RuntimeOption{
+		maxRun:  maxRun,
+		minRest: minRest,
+	}
+}
+
+type DryRunOption struct {
+	offset  int
+	timeout time.Duration
+}
+
+func (d DryRunOption) applyOption(o *Options) error {
+	if d.timeout <= 0 {
+		return OptionError{Field: "Timeout", Value: d.timeout}
+	}
+	if o.pump == nil {
+		o.pump = &pumpOptions{}
+	}
+	flow := d.offset
+	o.pump.flow = &flow
+	o.pump.dryRun = d.timeout
+	return nil
+}
+
+// WithDryRunProtection locks a pump out until it's reset if the flow
+// switch on offset isn't active within timeout while it's running
+func WithDryRunProtection(offset int, timeout time.Duration) DryRunOption {
+	return DryRunOption{
+		offset:  offset,
+		timeout: timeout,
+	}
+}
+
+type doorOptions struct {
+	chip            string
+	strike, contact int
+	exit            *int
+	unlockTime      time.Duration
+	heldOpen        time.Duration
+}
+
+type DoorOption struct {
+	chip            string
+	strike, contact int
+	unlockTime      time.Duration
+	heldOpen        time.Duration
+}
+
+func (d DoorOption) applyOption(o *Options) error {
+	if d.chip == "" {
+		return OptionError{Field: "Chip", Value: d.chip}
+	}
+	if d.strike == d.contact {
+		return OptionError{Field: "Contact", Value: d.contact}
+	}
+	if d.unlockTime <= 0 {
+		return OptionError{Field: "UnlockTime", Value: d.unlockTime}
+	}
+	if d.heldOpen <= 0 {
+		return OptionError{Field: "HeldOpen", Value: d.heldOpen}
+	}
+	if o.door == nil {
+		o.door = &doorOptions{}
+	}
+	o.kind = Door
+	o.strategy = ""
+	o.door.chip = d.chip
+	o.door.strike = d.strike
+	o.door.contact = d.contact
+	o.door.unlockTime = d.unlockTime
+	o.door.heldOpen = d.heldOpen
+	return nil
+}
+
+// AsDoor makes the general an access door whose strike is released for
+// unlockTime on every authorized request, the door contact raises a fault
+// if the door opens while locked or stays open for longer than heldOpen
+func AsDoor(chip string, strike int, contact int, unlockTime time.Duration, heldOpen time.Duration) DoorOption {
+	return DoorOption{
+		chip:       chip,
+		strike:     strike,
+		contact:    contact,
+		unlockTime: unlockTime,
+		heldOpen:   heldOpen,
+	}
+}
+
+type ExitButtonOption int
+
+func (e ExitButtonOption) applyOption(o *Options) error {
+	if o.door == nil {
+		o.door = &doorOptions{}
+	}
+	exit := int(e)
+	o.door.exit = &exit
+	return nil
+}
+
+// WithExitButton unlocks a door whenever the input on offset is active
+func WithExitButton(offset int) ExitButtonOption {
+	return ExitButtonOption(offset)
+}
@@ -0,0 +1,312 @@
+package general
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/controller/core"
+	"go.uber.org/multierr"
+)
+
+// Pump generals keep a tank filled using level switches
+const Pump = "pump"
+
+// pump runs a motor while the level is below the low switch until it
+// reaches the high one, if there's only a low switch the float itself
+// provides the hysteresis. a pump that runs dry is locked out until reset
+type pump struct {
+	general   *General
+	motor     *core.ItemHandle
+	low, high *core.ItemHandle
+	flow      *core.ItemHandle
+	maxRun    time.Duration
+	minRest   time.Duration
+	dryRun    time.Duration
+
+	running bool
+	// held is set when the pump is turned off by hand, it isn't started
+	// again until a level switch changes or it's turned on
+	held bool
+	// restUntil is when the pump may start again after it stopped
+	restUntil time.Time
+	// fault is why the pump is locked out, it's empty when it isn't
+	fault  string
+	timers map[string]*time.Timer
+
+	mu *sync.Mutex
+}
+
+func newPump(g *General, options *pumpOptions) (p *pump, err error) {
+	p = &pump{
+		general: g,
+		maxRun:  options.maxRun,
+		minRest: options.minRest,
+		dryRun:  options.dryRun,
+		timers:  map[string]*time.Timer{},
+		mu:      &sync.Mutex{},
+	}
+	p.motor, err = core.RegisterItem(options.chip, options.motor, core.AsOutput(), core.WithState(core.Inactive), core.WithOwner(g.tag))
+	if err != nil {
+		return nil, err
+	}
+	inputs := []struct {
+		handle **core.ItemHandle
+		offset *int
+	}{{&p.low, &options.low}, {&p.high, options.high}, {&p.flow, options.flow}}
+	for _, in := range inputs {
+		if in.offset == nil {
+			continue
+		}
+		*in.handle, err = core.RegisterItem(options.chip, *in.offset, g.asInput(g.tag)...)
+		if err != nil {
+			p.release()
+			return nil, err
+		}
+	}
+	p.low.AddEventListener(p.levelChanged)
+	if p.high != nil {
+		p.high.AddEventListener(p.levelChanged)
+	}
+	if p.flow != nil {
+		p.flow.AddEventListener(p.flowChanged)
+	}
+	return p, nil
+}
+
+func (p *pump) turnOn() {
+	p.mu.Lock()
+	p.held = false
+	p.mu.Unlock()
+	p.start()
+}
+
+// turnOff stops the pump until the level changes, it doesn't start again
+// once it has rested like it does after stopping by itself
+func (p *pump) turnOff() {
+	p.mu.Lock()
+	p.held = true
+	p.cancel("rest")
+	p.mu.Unlock()
+	p.stop("turned off", false)
+}
+
+func (p *pump) update() {
+	p.evaluate()
+}
+
+func (p *pump) close() error {
+	p.stop("general is unregistered", false)
+	return p.release()
+}
+
+func (p *pump) release() (err error) {
+	p.mu.Lock()
+	for name, t := range p.timers {
+		t.Stop()
+		delete(p.timers, name)
+	}
+	p.mu.Unlock()
+	for _, h := range []*core.ItemHandle{p.motor, p.low, p.high, p.flow} {
+		if h != nil {
+			err = multierr.Append(err, h.Release())
+		}
+	}
+	return
+}
+
+// after replaces the timer called name, fn isn't called if the timer was
+// replaced or canceled in the meantime. must be called with mu locked
+func (p *pump) after(name string, d time.Duration, fn func()) {
+	p.cancel(name)
+	var t *time.Timer
+	t = time.AfterFunc(d, func() {
+		p.mu.Lock()
+		if p.timers[name] != t {
+			p.mu.Unlock()
+			return
+		}
+		delete(p.timers, name)
+		p.mu.Unlock()
+		fn()
+	})
+	p.timers[name] = t
+}
+
+// cancel stops the timer called name, must be called with mu locked
+func (p *pump) cancel(name string) {
+	if t, ok := p.timers[name]; ok {
+		t.Stop()
+		delete(p.timers, name)
+	}
+}
+
+// levelChanged lets a pump that was turned off by hand start again
+func (p *pump) levelChanged(event *core.ItemEvent) {
+	p.mu.Lock()
+	p.held = false
+	p.mu.Unlock()
+	p.evaluate()
+}
+
+// evaluate starts or stops the pump based on the level switches
+func (p *pump) evaluate() {
+	p.mu.Lock()
+	full := p.low.State() == core.Active
+	if p.high != nil {
+		// between the switches the pump keeps doing what it was doing
+		full = p.high.State() == core.Active || (full && !p.running)
+	}
+	running, held := p.running, p.held
+	p.mu.Unlock()
+	switch {
+	case running && full:
+		p.stop("tank is full", true)
+	case !running && !full && !held && p.general.Armed():
+		p.start()
+	}
+}
+
+// start turns the motor on unless it's already running or the pump is
+// locked out or resting
+func (p *pump) start() {
+	p.mu.Lock()
+	if p.running || p.fault != "" {
+		p.mu.Unlock()
+		return
+	}
+	if wait := time.Until(p.restUntil); wait > 0 {
+		p.after("rest", wait, p.evaluate)
+		p.mu.Unlock()
+		return
+	}
+	if err := p.motor.SetState(core.Active); err != nil {
+		p.mu.Unlock()
+		logger.Errorf("pump %s couldn't start: %v", p.general.tag, err)
+		return
+	}
+	p.running = true
+	if p.maxRun > 0 {
+		p.after("run", p.maxRun, func() {
+			p.raise(fmt.Sprintf("ran for longer than %s", p.maxRun), false)
+		})
+	}
+	if p.flow != nil && p.flow.State() != core.Active {
+		p.watchFlow()
+	}
+	p.mu.Unlock()
+	logger.Infof("pump %s started", p.general.tag)
+	p.general.setState(core.Active)
+}
+
+// stop turns the motor off if it's running and makes the pump rest, it's
+// evaluated again after resting if restart is set
+func (p *pump) stop(reason string, restart bool) {
+	p.mu.Lock()
+	if !p.running {
+		p.mu.Unlock()
+		return
+	}
+	if err := p.motor.SetState(core.Inactive); err != nil {
+		logger.Errorf("pump %s couldn't stop: %v", p.general.tag, err)
+	}
+	p.running = false
+	p.cancel("run")
+	p.cancel("dry")
+	p.restUntil = time.Now().Add(p.minRest)
+	if restart && p.minRest > 0 {
+		p.after("rest", p.minRest, p.evaluate)
+	}
+	p.mu.Unlock()
+	logger.Infof("pump %s stopped, %s", p.general.tag, reason)
+	p.general.setState(core.Inactive)
+}
+
+// watchFlow locks the pump out if there's no flow for dryRun while it's
+// running, must be called with mu locked
+func (p *pump) watchFlow() {
+	p.after("dry", p.dryRun, func() {
+		p.raise(fmt.Sprintf("no flow within %s, it's running dry", p.dryRun), true)
+	})
+}
+
+func (p *pump) flowChanged(event *core.ItemEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.running {
+		return
+	}
+	if event.State == core.Active {
+		p.cancel("dry")
+	} else {
+		p.watchFlow()
+	}
+}
+
+// raise stops the pump because of a fault, locking it out if lockout is
+// set, and lets the listeners of the general know
+func (p *pump) raise(fault string, lockout bool) {
+	if lockout {
+		p.mu.Lock()
+		p.fault = fault
+		p.mu.Unlock()
+	}
+	logger.Warnf("pump %s %s", p.general.tag, fault)
+	p.general.events.CallAll(&Event{General: p.general, Fault: fault})
+	p.stop(fault, !lockout)
+}
+
+// reset clears a lockout
+func (p *pump) reset() {
+	p.mu.Lock()
+	fault := p.fault
+	p.fault = ""
+	p.mu.Unlock()
+	if fault != "" {
+		logger.Infof("pump %s was reset after: %s", p.general.tag, fault)
+	}
+	p.evaluate()
+}
+
+func (g *General) pumpOf() (*pump, error) {
+	p, ok := g.device.(*pump)
+	if !ok {
+		return nil, NotPumpError{Tag: g.tag}
+	}
+	return p, nil
+}
+
+// Fault returns why a pump is locked out or whether a door is forced or
+// held open, it's empty if nothing is wrong
+func (g *General) Fault() (fault string, err error) {
+	if d, err := g.doorOf(); err == nil {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return d.fault, nil
+	}
+	p, err := g.pumpOf()
+	if err != nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.fault, nil
+}
+
+// Reset clears the lockout of a pump that ran dry
+func (g *General) Reset() error {
+	p, err := g.pumpOf()
+	if err != nil {
+		return err
+	}
+	p.reset()
+	return nil
+}
+
+type NotPumpError struct {
+	Tag string
+}
+
+func (n NotPumpError) Error() string {
+	return fmt.Sprintf("general \"%s\" is not a pump", n.Tag)
+}
@@ -0,0 +1,166 @@
+package general
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/controller/internal/coretest"
+	"github.com/AliRostami1/baagh/pkg/controller/internal/testutil"
+)
+
+// the switches of the pumps are active while they're pulled to ground
+const (
+	pumpLow   = 0
+	pumpHigh  = 1
+	pumpFlow  = 2
+	pumpMotor = 4
+)
+
+// faults counts the faults raised by g
+func faults(g *General) func() []string {
+	mu := &sync.Mutex{}
+	var raised []string
+	g.AddEventListener(func(event *Event) {
+		if event.Fault == "" {
+			return
+		}
+		mu.Lock()
+		raised = append(raised, event.Fault)
+		mu.Unlock()
+	})
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, raised...)
+	}
+}
+
+func TestPumpLevels(t *testing.T) {
+	chip, device := coretest.PCF8574(t)
+	register(t, chip+"-pump", AsPump(chip, pumpMotor, pumpLow), WithHighLevel(pumpHigh))
+	running := func() bool { return device.High(pumpMotor) }
+
+	testutil.Eventually(t, "the empty tank being filled", running)
+	// between the switches the pump keeps doing what it was doing
+	device.Pull(pumpLow, false)
+	time.Sleep(10 * time.Millisecond)
+	if !running() {
+		t.Fatal("the pump stopped before the tank was full")
+	}
+	device.Pull(pumpHigh, false)
+	testutil.Eventually(t, "the pump stopping once the tank is full", func() bool { return !running() })
+	device.Pull(pumpHigh, true)
+	time.Sleep(10 * time.Millisecond)
+	if running() {
+		t.Fatal("the pump started before the level dropped below the low switch")
+	}
+	device.Pull(pumpLow, true)
+	testutil.Eventually(t, "the pump starting once the level dropped", running)
+}
+
+func TestPumpRest(t *testing.T) {
+	chip, device := coretest.PCF8574(t)
+	const rest = 50 * time.Millisecond
+	register(t, chip+"-pump", AsPump(chip, pumpMotor, pumpLow), WithRuntime(0, rest))
+	running := func() bool { return device.High(pumpMotor) }
+
+	testutil.Eventually(t, "the empty tank being filled", running)
+	// the pump stops after the tank is full, so it can't rest for less
+	// than the time since then
+	full := time.Now()
+	device.Pull(pumpLow, false)
+	testutil.Eventually(t, "the pump stopping once the tank is full", func() bool { return !running() })
+	device.Pull(pumpLow, true)
+	testutil.Eventually(t, "the pump starting after it rested", running)
+	if rested := time.Since(full); rested < rest {
+		t.Errorf("the pump started again after %v, want it to rest for %v", rested, rest)
+	}
+}
+
+func TestPumpTurnOff(t *testing.T) {
+	chip, device := coretest.PCF8574(t)
+	g := register(t, chip+"-pump", AsPump(chip, pumpMotor, pumpLow), WithRuntime(0, 10*time.Millisecond))
+	running := func() bool { return device.High(pumpMotor) }
+
+	testutil.Eventually(t, "the empty tank being filled", running)
+	g.TurnOff()
+	if running() {
+		t.Fatal("the pump is still running after it was turned off")
+	}
+	// a pump turned off by hand doesn't start again once it has rested
+	time.Sleep(50 * time.Millisecond)
+	if running() {
+		t.Fatal("the pump started again by itself after it was turned off")
+	}
+	// until the level changes
+	device.Pull(pumpLow, false)
+	time.Sleep(10 * time.Millisecond)
+	device.Pull(pumpLow, true)
+	testutil.Eventually(t, "the pump starting after the level dropped again", running)
+
+	// turning it on starts it as soon as it has rested
+	g.TurnOff()
+	g.TurnOn()
+	testutil.Eventually(t, "the pump starting after it was turned on", running)
+}
+
+func TestPumpMaxRun(t *testing.T) {
+	chip, device := coretest.PCF8574(t)
+	const maxRun, rest = 30 * time.Millisecond, 30 * time.Millisecond
+	g := register(t, chip+"-pump", AsPump(chip, pumpMotor, pumpLow), WithRuntime(maxRun, rest))
+	raised := faults(g)
+	running := func() bool { return device.High(pumpMotor) }
+
+	testutil.Eventually(t, "the empty tank being filled", running)
+	started := time.Now()
+	testutil.Eventually(t, "the pump stopping after running for too long", func() bool { return !running() })
+	if ran := time.Since(started); ran < maxRun {
+		t.Errorf("the pump stopped after %v, want it to run for %v", ran, maxRun)
+	}
+	testutil.Eventually(t, "the fault being raised", func() bool { return len(raised()) == 1 })
+	// running for too long doesn't lock the pump out, it starts again once
+	// it has rested
+	if fault, err := g.Fault(); err != nil || fault != "" {
+		t.Errorf("the pump is locked out with %q, %v", fault, err)
+	}
+	testutil.Eventually(t, "the pump starting after it rested", running)
+}
+
+func TestPumpDryRun(t *testing.T) {
+	chip, device := coretest.PCF8574(t)
+	const dryRun = 30 * time.Millisecond
+	g := register(t, chip+"-pump", AsPump(chip, pumpMotor, pumpLow), WithDryRunProtection(pumpFlow, dryRun))
+	raised := faults(g)
+	running := func() bool { return device.High(pumpMotor) }
+
+	testutil.Eventually(t, "the empty tank being filled", running)
+	testutil.Eventually(t, "the pump stopping without flow", func() bool { return !running() })
+	testutil.Eventually(t, "the fault being raised", func() bool { return len(raised()) == 1 })
+	if fault, err := g.Fault(); err != nil || fault == "" {
+		t.Fatalf("the pump isn't locked out after running dry: %q, %v", fault, err)
+	}
+	// a locked out pump stays off whatever the level does
+	g.TurnOn()
+	device.Pull(pumpLow, false)
+	time.Sleep(10 * time.Millisecond)
+	device.Pull(pumpLow, true)
+	time.Sleep(2 * dryRun)
+	if running() {
+		t.Fatal("the pump started while it was locked out")
+	}
+
+	// with flow it keeps running once it's reset
+	device.Pull(pumpFlow, false)
+	if err := g.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if fault, _ := g.Fault(); fault != "" {
+		t.Errorf("the pump is still locked out with %q after it was reset", fault)
+	}
+	testutil.Eventually(t, "the pump starting after it was reset", running)
+	time.Sleep(2 * dryRun)
+	if !running() {
+		t.Error("the pump stopped while water was flowing")
+	}
+}
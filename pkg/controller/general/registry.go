@@ -45,6 +45,12 @@ func (r *registry) ForEach(fn func(chipName string, General *General)) {
 	}
 }
 
+func (r *registry) Delete(tag string) {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.registry, tag)
+}
+
 type DuplicateTagError struct {
 	Tag string
 }
@@ -62,22 +68,22 @@ func (t TagNotFoundError) Error() string {
 }
 
 type itemRegistry struct {
-	registry map[string]map[int]*core.Item
+	registry map[string]map[int]*core.ItemHandle
 	*sync.RWMutex
 }
 
-func (i *itemRegistry) Add(chip string, offset int, item *core.Item) error {
+func (i *itemRegistry) Add(chip string, offset int, item *core.ItemHandle) error {
 	i.Lock()
 	reg := i.registry
 	i.Unlock()
 	if _, ok := reg[chip]; !ok {
-		reg[chip] = make(map[int]*core.Item)
+		reg[chip] = make(map[int]*core.ItemHandle)
 	}
 	reg[chip][offset] = item
 	return nil
 }
 
-func (i *itemRegistry) Get(chip string, offset int) (*core.Item, error) {
+func (i *itemRegistry) Get(chip string, offset int) (*core.ItemHandle, error) {
 	i.Lock()
 	reg := i.registry
 	i.Unlock()
@@ -90,7 +96,7 @@ func (i *itemRegistry) Get(chip string, offset int) (*core.Item, error) {
 	return nil, ItemNotFoundError{Chip: chip, Offset: offset}
 }
 
-func (i *itemRegistry) ForEach(fn func(i *core.Item)) {
+func (i *itemRegistry) ForEach(fn func(i *core.ItemHandle)) {
 	i.Lock()
 	reg := i.registry
 	i.Unlock()
@@ -124,3 +130,34 @@ type DuplicateItemError struct {
 func (d DuplicateItemError) Error() string {
 	return fmt.Sprintf("item with %o offset is already registered on chip %s", d.Offset, d.Chip)
 }
+
+type Event struct {
+	General *General
+	// Fault is set when the event is about something going wrong
+	// instead of a change of state
+	Fault string
+}
+
+type EventHandler func(event *Event)
+
+type eventRegistry struct {
+	events []EventHandler
+	*sync.RWMutex
+}
+
+func (e *eventRegistry) AddEventListener(fn ...EventHandler) {
+	e.Lock()
+	defer e.Unlock()
+	e.events = append(e.events, fn...)
+}
+
+func (e *eventRegistry) CallAll(evt *Event) {
+	go func() {
+		e.Lock()
+		events := e.events
+		e.Unlock()
+		for _, eh := range events {
+			eh(evt)
+		}
+	}()
+}
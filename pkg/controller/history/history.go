@@ -0,0 +1,171 @@
+package history
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/controller/core"
+	"github.com/AliRostami1/baagh/pkg/controller/store"
+	"github.com/AliRostami1/baagh/pkg/logy"
+)
+
+var logger logy.Logger = logy.DummyLogger{}
+
+func SetLogger(l logy.Logger) error {
+	if l == nil {
+		return fmt.Errorf("logger can't be nil")
+	}
+	logger = l
+	return nil
+}
+
+// Record is a single state change of an item
+type Record struct {
+	Chip   string     `json:"chip"`
+	Offset int        `json:"offset"`
+	State  core.State `json:"state"`
+	Time   time.Time  `json:"time"`
+}
+
+// History keeps the state changes of every output in the store for a while,
+// so other parts of baagh can look back at what was turned on and off.
+// inputs aren't recorded, readers like wiegand, ir or meters change them
+// far too often for every edge to be written to the store
+type History struct {
+	db        store.Store
+	retention time.Duration
+	now       func() time.Time
+	// ignored are the owners whose changes aren't recorded
+	ignored map[string]bool
+
+	mu *sync.RWMutex
+}
+
+const keyPrefix = "history/"
+
+// New creates a history that forgets records older than retention,
+// records are pruned once an hour until ctx is done
+func New(ctx context.Context, db store.Store, retention time.Duration) *History {
+	h := &History{
+		db:        db,
+		retention: retention,
+		now:       time.Now,
+		ignored:   map[string]bool{},
+		mu:        &sync.RWMutex{},
+	}
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := h.Prune(h.now().Add(-h.retention)); err != nil {
+					logger.Errorf("couldn't prune history: %v", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return h
+}
+
+// Ignore stops recording the changes owner makes through its handles, e.g.
+// the vacation simulator so it never replays its own replay
+func (h *History) Ignore(owner string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ignored[owner] = true
+}
+
+// Record is a core.EventHandler, subscribe it to record every output
+func (h *History) Record(event *core.ItemEvent) {
+	if event.Item.Mode() != core.Output {
+		return
+	}
+	h.mu.RLock()
+	ignored := h.ignored[event.Owner]
+	h.mu.RUnlock()
+	if ignored {
+		return
+	}
+	r := Record{
+		Chip:   event.Item.Chip(),
+		Offset: event.Item.Offset(),
+		State:  event.State,
+		Time:   h.now(),
+	}
+	if err := h.Add(r); err != nil {
+		logger.Errorf("couldn't record the state of line %d of %s: %v", r.Offset, r.Chip, err)
+	}
+}
+
+// Add stores a record as if the change happened at r.Time, e.g. to import
+// the history of an item from somewhere else
+func (h *History) Add(r Record) error {
+	return h.db.Put(key(r.Chip, r.Offset, r.Time), r)
+}
+
+// Between returns the records of an item in [from, to) in chronological order
+func (h *History) Between(chip string, offset int, from, to time.Time) (records []Record, err error) {
+	keys, err := h.db.Keys(itemPrefix(chip, offset))
+	if err != nil {
+		return nil, err
+	}
+	start, end := key(chip, offset, from), key(chip, offset, to)
+	for _, k := range keys {
+		if k < start || k >= end {
+			continue
+		}
+		var r Record
+		if err = h.db.Get(k, &r); err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return
+}
+
+// Last returns the last record of an item before t, ok is false if there is none
+func (h *History) Last(chip string, offset int, t time.Time) (r Record, ok bool, err error) {
+	keys, err := h.db.Keys(itemPrefix(chip, offset))
+	if err != nil {
+		return
+	}
+	end := key(chip, offset, t)
+	for i := len(keys) - 1; i >= 0; i-- {
+		if keys[i] < end {
+			err = h.db.Get(keys[i], &r)
+			return r, err == nil, err
+		}
+	}
+	return
+}
+
+// Prune removes every record older than before
+func (h *History) Prune(before time.Time) error {
+	keys, err := h.db.Keys(keyPrefix)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if k[strings.LastIndex(k, "/")+1:] < store.Stamp(before) {
+			if err = h.db.Delete(k); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func itemPrefix(chip string, offset int) string {
+	return fmt.Sprintf("%s%s/%d/", keyPrefix, chip, offset)
+}
+
+// key is ordered by time for the same item, so sorted keys are chronological
+func key(chip string, offset int, t time.Time) string {
+	return itemPrefix(chip, offset) + store.Stamp(t)
+}
@@ -0,0 +1,154 @@
+package history
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/controller/core"
+	"github.com/AliRostami1/baagh/pkg/controller/internal/coretest"
+	"github.com/AliRostami1/baagh/pkg/controller/internal/testutil"
+	"github.com/AliRostami1/baagh/pkg/controller/store"
+)
+
+func TestRecordOutputsOnly(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	chip, device := coretest.PCF8574(t)
+	input, err := core.RegisterItem(chip, 0, core.AsInput(core.PullUp), core.WithOwner("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := core.RegisterItem(chip, 1, core.AsOutput(), core.WithState(core.Inactive), core.WithOwner("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := store.NewMemory()
+	h := New(ctx, db, time.Hour)
+	core.Subscribe(h.Record)
+	start := time.Now()
+
+	edges := make(chan core.State, 10)
+	input.AddEventListener(func(event *core.ItemEvent) {
+		edges <- event.State
+	})
+	for _, high := range []bool{false, true, false, true} {
+		device.Pull(0, high)
+		select {
+		case <-edges:
+		case <-time.After(time.Second):
+			t.Fatal("the input didn't change")
+		}
+	}
+	// records are written by event handlers, which run asynchronously
+	recorded := func(n int) {
+		deadline := time.Now().Add(time.Second)
+		for {
+			keys, err := db.Keys(keyPrefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(keys) >= n || time.Now().After(deadline) {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	for i, state := range []core.State{core.Active, core.Inactive} {
+		if err = output.SetState(state); err != nil {
+			t.Fatal(err)
+		}
+		recorded(i + 1)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	records, err := h.Between(chip, 0, start, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Errorf("the edges of an input were recorded: %v", records)
+	}
+	records, err = h.Between(chip, 1, start, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].State != core.Active || records[1].State != core.Inactive {
+		t.Errorf("the output was recorded as %v, want it turned on and off", records)
+	}
+	keys, err := db.Keys(keyPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Errorf("%d records were persisted, want 2", len(keys))
+	}
+}
+
+func TestPrune(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db := store.NewMemory()
+	h := New(ctx, db, time.Hour)
+	now := time.Date(2021, time.June, 4, 12, 0, 0, 0, time.UTC)
+	for _, age := range []time.Duration{3 * time.Hour, 2 * time.Hour, time.Hour, 0} {
+		r := Record{Chip: "chip", Offset: 3, State: core.Active, Time: now.Add(-age)}
+		if err := db.Put(key(r.Chip, r.Offset, r.Time), r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := h.Prune(now.Add(-90 * time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	records, err := h.Between("chip", 3, time.Time{}, now.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || !records[0].Time.Equal(now.Add(-time.Hour)) {
+		t.Errorf("records after pruning are %v, want the last two", records)
+	}
+	last, ok, err := h.Last("chip", 3, now)
+	if err != nil || !ok || !last.Time.Equal(now.Add(-time.Hour)) {
+		t.Errorf("last record before now is %v, %v, %v", last, ok, err)
+	}
+}
+
+func TestIgnore(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	chip, _ := coretest.PCF8574(t)
+	handles := map[string]*core.ItemHandle{}
+	for _, owner := range []string{"test", "ignored"} {
+		h, err := core.RegisterItem(chip, 1, core.AsOutput(), core.WithState(core.Inactive), core.WithOwner(owner))
+		if err != nil {
+			t.Fatal(err)
+		}
+		handles[owner] = h
+	}
+	h := New(ctx, store.NewMemory(), time.Hour)
+	h.Ignore("ignored")
+	handles["test"].AddEventListener(h.Record)
+	start := time.Now()
+
+	for _, change := range []struct {
+		owner string
+		state core.State
+	}{{"ignored", core.Active}, {"test", core.Inactive}, {"ignored", core.Active}} {
+		if err := handles[change.owner].SetState(change.state); err != nil {
+			t.Fatal(err)
+		}
+	}
+	records := func() []Record {
+		records, err := h.Between(chip, 1, start, time.Now().Add(time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return records
+	}
+	testutil.Eventually(t, "the change of test being recorded", func() bool { return len(records()) > 0 })
+	time.Sleep(10 * time.Millisecond)
+	if got := records(); len(got) != 1 || got[0].State != core.Inactive {
+		t.Errorf("the changes were recorded as %v, want only test turning the output off", got)
+	}
+}
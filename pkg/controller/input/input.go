@@ -0,0 +1,19 @@
+// Package input decodes protocols and measurements that are built on top
+// of the edges of core input items
+package input
+
+import (
+	"fmt"
+
+	"github.com/AliRostami1/baagh/pkg/logy"
+)
+
+var logger logy.Logger = logy.DummyLogger{}
+
+func SetLogger(l logy.Logger) error {
+	if l == nil {
+		return fmt.Errorf("logger can't be nil")
+	}
+	logger = l
+	return nil
+}
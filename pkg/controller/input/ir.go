@@ -0,0 +1,280 @@
+package input
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/controller/core"
+	"go.uber.org/multierr"
+)
+
+const (
+	NEC = "nec"
+	RC5 = "rc5"
+
+	// irGap ends a frame, the longest pause inside a frame is the 4.5ms
+	// space after the NEC leader
+	irGap = 15 * time.Millisecond
+	// rc5Repeat is how far apart frames of a held RC5 button are at most,
+	// they're sent every 114ms
+	rc5Repeat = 200 * time.Millisecond
+	// necHalf and rc5Half are the units the timings of the protocols are
+	// multiples of
+	necHalf = 562500 * time.Nanosecond
+	rc5Half = 889 * time.Microsecond
+)
+
+// IREvent is sent for every button press decoded from a remote, Repeat is
+// set for the frames a remote keeps sending while a button is held
+type IREvent struct {
+	// Receiver is the name of the receiver that decoded the frame
+	Receiver string
+	Protocol string
+	Address  uint16
+	Command  uint16
+	Repeat   bool
+	Time     time.Time
+}
+
+// Button is how buttons are referred to, e.g. "nec:4:8" for command 8 of
+// the remote with address 4
+func (e *IREvent) Button() string {
+	return fmt.Sprintf("%s:%d:%d", e.Protocol, e.Address, e.Command)
+}
+
+type IRHandler func(event *IREvent)
+
+// pulse is how long the receiver was in one state, mark is true while it
+// received the carrier, which most receivers signal by pulling their output low
+type pulse struct {
+	mark     bool
+	duration time.Duration
+}
+
+// IR decodes NEC and RC5 remotes from the edges of an IR receiver module.
+// a frame is decoded once the receiver has been idle for a while
+type IR struct {
+	name  string
+	input *core.ItemHandle
+
+	pulses []pulse
+	// last is the timestamp of the last edge
+	last  time.Duration
+	timer *time.Timer
+	// previous is the last decoded frame, repeats refer to it
+	previous *IREvent
+	// toggle is the toggle bit of the last RC5 frame
+	toggle   bool
+	handlers []IRHandler
+
+	mu *sync.Mutex
+}
+
+// NewIR starts decoding the receiver called name on line offset of chip
+func NewIR(name string, chip string, offset int) (r *IR, err error) {
+	r = &IR{
+		name: name,
+		mu:   &sync.Mutex{},
+	}
+	r.input, err = core.RegisterItem(chip, offset, core.AsInput(core.PullUp), core.WithOwner("ir/"+name))
+	if err != nil {
+		return nil, err
+	}
+	if err = r.input.AddEdgeListener(r.edge); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *IR) Name() string {
+	return r.name
+}
+
+// AddEventListener registers handlers that are called for every button press
+func (r *IR) AddEventListener(fns ...IRHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers = append(r.handlers, fns...)
+}
+
+// Close stops decoding and releases the line
+func (r *IR) Close() (err error) {
+	r.mu.Lock()
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	r.mu.Unlock()
+	if r.input != nil && !r.input.Released() {
+		err = multierr.Append(err, r.input.Release())
+	}
+	return
+}
+
+func (r *IR) edge(edge core.Edge) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// a falling edge starts a mark, the pulse before it was a space
+	if len(r.pulses) > 0 || edge.Type == core.RisingEdge {
+		r.pulses = append(r.pulses, pulse{mark: edge.Type == core.RisingEdge, duration: edge.Timestamp - r.last})
+	}
+	r.last = edge.Timestamp
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	r.timer = time.AfterFunc(irGap, r.flush)
+}
+
+// flush decodes the frame once the receiver has been idle for long enough
+func (r *IR) flush() {
+	r.mu.Lock()
+	pulses := r.pulses
+	r.pulses = nil
+	event, err := r.decode(pulses)
+	handlers := r.handlers
+	r.mu.Unlock()
+	if err != nil {
+		logger.Debugf("ir receiver %s: %v", r.name, err)
+		return
+	}
+	if !event.Repeat {
+		logger.Infof("ir receiver %s decoded %s", r.name, event.Button())
+	}
+	for _, fn := range handlers {
+		fn(event)
+	}
+}
+
+// decode tries every protocol on a frame, must be called with mu locked
+func (r *IR) decode(pulses []pulse) (event *IREvent, err error) {
+	if len(pulses) == 0 || !pulses[0].mark {
+		return nil, IRFrameError{Pulses: len(pulses), Reason: "frame doesn't start with a mark"}
+	}
+	if near(pulses[0].duration, 16*necHalf) {
+		event, err = r.nec(pulses)
+	} else {
+		event, err = r.rc5(pulses)
+	}
+	if err != nil {
+		return
+	}
+	event.Receiver = r.name
+	event.Time = time.Now()
+	r.previous = event
+	return
+}
+
+// nec decodes a leader of 9ms mark and 4.5ms space followed by 32 bits,
+// least significant first, each a 562.5µs mark and a space of either the
+// same length for a 0 or three times that for a 1. a 2.25ms space after
+// the leader is a repeat of the previous frame
+func (r *IR) nec(pulses []pulse) (*IREvent, error) {
+	if len(pulses) < 3 {
+		return nil, IRFrameError{Protocol: NEC, Pulses: len(pulses), Reason: "too short"}
+	}
+	if near(pulses[1].duration, 4*necHalf) {
+		if r.previous == nil || r.previous.Protocol != NEC {
+			return nil, IRFrameError{Protocol: NEC, Pulses: len(pulses), Reason: "repeat without a frame"}
+		}
+		repeat := *r.previous
+		repeat.Repeat = true
+		return &repeat, nil
+	}
+	if !near(pulses[1].duration, 8*necHalf) || len(pulses) < 67 {
+		return nil, IRFrameError{Protocol: NEC, Pulses: len(pulses), Reason: "invalid leader or length"}
+	}
+	var data uint32
+	for i := 0; i < 32; i++ {
+		mark, space := pulses[2+2*i], pulses[3+2*i]
+		if !near(mark.duration, necHalf) {
+			return nil, IRFrameError{Protocol: NEC, Pulses: len(pulses), Reason: fmt.Sprintf("invalid mark of bit %d", i)}
+		}
+		switch {
+		case near(space.duration, 3*necHalf):
+			data |= 1 << i
+		case !near(space.duration, necHalf):
+			return nil, IRFrameError{Protocol: NEC, Pulses: len(pulses), Reason: fmt.Sprintf("invalid space of bit %d", i)}
+		}
+	}
+	address, command := uint16(data&0xffff), uint16(data>>16&0xff)
+	if uint8(data>>16)^uint8(data>>24) != 0xff {
+		return nil, IRFrameError{Protocol: NEC, Pulses: len(pulses), Reason: "command doesn't match its complement"}
+	}
+	// extended NEC uses both address bytes instead of the complement
+	if uint8(address)^uint8(address>>8) == 0xff {
+		address &= 0xff
+	}
+	return &IREvent{Protocol: NEC, Address: address, Command: command}, nil
+}
+
+// rc5 decodes 14 manchester coded bits of 1.778ms, a 1 is a space then a
+// mark and a 0 is a mark then a space. the two start bits are followed by
+// the toggle bit, 5 address bits and 6 command bits, most significant
+// first. the second start bit is the inverted 7th command bit
+func (r *IR) rc5(pulses []pulse) (*IREvent, error) {
+	// the first half of the first start bit is idle, so it's not a pulse
+	halves := []bool{false}
+	for _, p := range pulses {
+		var n int
+		switch {
+		case near(p.duration, rc5Half):
+			n = 1
+		case near(p.duration, 2*rc5Half):
+			n = 2
+		default:
+			return nil, IRFrameError{Protocol: RC5, Pulses: len(pulses), Reason: "invalid pulse length"}
+		}
+		for i := 0; i < n; i++ {
+			halves = append(halves, p.mark)
+		}
+	}
+	// if the last bit is a 0 its second half is idle too
+	if len(halves)%2 != 0 {
+		halves = append(halves, false)
+	}
+	if len(halves) != 28 {
+		return nil, IRFrameError{Protocol: RC5, Pulses: len(pulses), Reason: "invalid length"}
+	}
+	var bits uint16
+	for i := 0; i < 28; i += 2 {
+		if halves[i] == halves[i+1] {
+			return nil, IRFrameError{Protocol: RC5, Pulses: len(pulses), Reason: fmt.Sprintf("invalid bit %d", i/2)}
+		}
+		bits <<= 1
+		if halves[i+1] {
+			bits |= 1
+		}
+	}
+	toggle := bits>>11&1 == 1
+	event := &IREvent{
+		Protocol: RC5,
+		Address:  bits >> 6 & 0x1f,
+		Command:  bits&0x3f | (^bits>>12&1)<<6,
+	}
+	// a held button keeps its toggle bit, a new press flips it
+	if p := r.previous; p != nil && p.Protocol == RC5 && toggle == r.toggle &&
+		p.Address == event.Address && p.Command == event.Command && time.Since(p.Time) < rc5Repeat {
+		event.Repeat = true
+	}
+	r.toggle = toggle
+	return event, nil
+}
+
+// near reports whether d is within 25% of want
+func near(d time.Duration, want time.Duration) bool {
+	return d > want*3/4 && d < want*5/4
+}
+
+type IRFrameError struct {
+	Protocol string
+	Pulses   int
+	Reason   string
+}
+
+func (i IRFrameError) Error() string {
+	if i.Protocol == "" {
+		return fmt.Sprintf("dropped a frame of %d pulses: %s", i.Pulses, i.Reason)
+	}
+	return fmt.Sprintf("dropped a %d pulse %s frame: %s", i.Pulses, i.Protocol, i.Reason)
+}
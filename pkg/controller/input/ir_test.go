@@ -0,0 +1,134 @@
+package input
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// necFrame is the pulses of an NEC frame, the low address byte comes first
+// and scale stretches every pulse like a remote with a slow clock would
+func necFrame(address uint16, command uint8, scale float64) []pulse {
+	half := time.Duration(float64(necHalf) * scale)
+	data := uint32(address) | uint32(command)<<16 | uint32(^command)<<24
+	pulses := []pulse{{true, 16 * half}, {false, 8 * half}}
+	for i := 0; i < 32; i++ {
+		space := half
+		if data>>i&1 == 1 {
+			space = 3 * half
+		}
+		pulses = append(pulses, pulse{true, half}, pulse{false, space})
+	}
+	return append(pulses, pulse{true, half})
+}
+
+// necAddress is a standard address followed by its complement
+func necAddress(address uint8) uint16 {
+	return uint16(address) | uint16(^address)<<8
+}
+
+func necRepeat() []pulse {
+	return []pulse{{true, 16 * necHalf}, {false, 4 * necHalf}, {true, necHalf}}
+}
+
+// rc5Frame is the pulses of an RC5 frame, commands from 64 on clear the
+// second start bit
+func rc5Frame(toggle bool, address uint16, command uint16) []pulse {
+	bits := uint16(1)<<13 | (^command>>6&1)<<12 | address&0x1f<<6 | command&0x3f
+	if toggle {
+		bits |= 1 << 11
+	}
+	var halves []bool
+	for i := 13; i >= 0; i-- {
+		one := bits>>i&1 == 1
+		halves = append(halves, !one, one)
+	}
+	// the idle halves at the start and the end aren't pulses
+	halves = halves[1:]
+	if !halves[len(halves)-1] {
+		halves = halves[:len(halves)-1]
+	}
+	var pulses []pulse
+	for _, h := range halves {
+		if n := len(pulses); n > 0 && pulses[n-1].mark == h {
+			pulses[n-1].duration += rc5Half
+			continue
+		}
+		pulses = append(pulses, pulse{h, rc5Half})
+	}
+	return pulses
+}
+
+func TestIRDecode(t *testing.T) {
+	type frame struct {
+		pulses []pulse
+		// button is empty for frames that have to be dropped
+		button string
+		repeat bool
+	}
+	tests := []struct {
+		name   string
+		frames []frame
+	}{
+		{"nec", []frame{
+			{necFrame(necAddress(4), 8, 1), "nec:4:8", false},
+			{necRepeat(), "nec:4:8", true},
+			{necRepeat(), "nec:4:8", true},
+		}},
+		{"nec extended address", []frame{{necFrame(0x1234, 0x45, 1), "nec:4660:69", false}}},
+		{"nec within tolerance", []frame{
+			{necFrame(necAddress(0), 255, 1.2), "nec:0:255", false},
+			{necFrame(necAddress(7), 1, 0.8), "nec:7:1", false},
+		}},
+		{"nec outside tolerance", []frame{{necFrame(necAddress(4), 8, 1.3), "", false}}},
+		{"nec repeat without a frame", []frame{{necRepeat(), "", false}}},
+		{"nec truncated", []frame{{necFrame(necAddress(4), 8, 1)[:40], "", false}}},
+		{"rc5", []frame{
+			{rc5Frame(false, 5, 12), "rc5:5:12", false},
+			// a held button keeps its toggle bit
+			{rc5Frame(false, 5, 12), "rc5:5:12", true},
+			// a new press flips it
+			{rc5Frame(true, 5, 12), "rc5:5:12", false},
+		}},
+		{"rc5 edges of the address and command", []frame{
+			{rc5Frame(false, 0, 0), "rc5:0:0", false},
+			{rc5Frame(true, 31, 63), "rc5:31:63", false},
+			{rc5Frame(false, 0, 127), "rc5:0:127", false},
+		}},
+		{"rc5 truncated", []frame{{rc5Frame(false, 5, 12)[:5], "", false}}},
+		{"no mark", []frame{{[]pulse{{false, rc5Half}}, "", false}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &IR{name: "test", mu: &sync.Mutex{}}
+			for i, f := range tt.frames {
+				event, err := r.decode(f.pulses)
+				if f.button == "" {
+					if _, ok := err.(IRFrameError); !ok {
+						t.Errorf("frame %d decoded to %v, %v, want an IRFrameError", i, event, err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Errorf("frame %d: %v", i, err)
+					continue
+				}
+				if event.Button() != f.button || event.Repeat != f.repeat || event.Receiver != "test" {
+					t.Errorf("frame %d decoded to %s repeat %v, want %s repeat %v", i, event.Button(), event.Repeat, f.button, f.repeat)
+				}
+			}
+		})
+	}
+}
+
+func TestIRRC5RepeatExpires(t *testing.T) {
+	r := &IR{name: "test", mu: &sync.Mutex{}}
+	if _, err := r.decode(rc5Frame(false, 5, 12)); err != nil {
+		t.Fatal(err)
+	}
+	r.previous.Time = time.Now().Add(-rc5Repeat)
+	event, err := r.decode(rc5Frame(false, 5, 12))
+	if err != nil || event.Repeat {
+		t.Errorf("a frame long after the last one is %v, %v, want a new press", event, err)
+	}
+}
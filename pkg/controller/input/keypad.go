@@ -0,0 +1,221 @@
+package input
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/controller/core"
+	"go.uber.org/multierr"
+)
+
+const (
+	// scanInterval is how often every row of a keypad is scanned
+	scanInterval = 10 * time.Millisecond
+	// settle is how long a column needs after its row is driven
+	settle = 50 * time.Microsecond
+	// debounce is how many scans in a row a key has to be seen pressed or
+	// released for it to count
+	debounce = 3
+)
+
+// DefaultKeys is the layout of the common 4x4 membrane keypad
+var DefaultKeys = []string{"123A", "456B", "789C", "*0#D"}
+
+type KeyHandler func(key rune)
+
+// Keypad scans a matrix keypad wired straight to the gpio. rows are outputs
+// that idle high and are pulled low one at a time, columns are inputs with
+// pull ups that read low when the key on the driven row is pressed. the
+// rows are switched directly, a hundred scans a second would flood the
+// events and the history
+type Keypad struct {
+	name    string
+	rows    []*core.DirectLine
+	columns []*core.ItemHandle
+	keys    [][]rune
+
+	// seen counts the scans each key was seen in its new state for
+	seen    map[rune]int
+	pressed map[rune]bool
+	pin     *pinEntry
+	keyFns  []KeyHandler
+	events  []CredentialHandler
+
+	mu *sync.Mutex
+}
+
+// NewKeypad starts scanning the keypad called name until ctx is done, keys
+// are the rows of the layout, one character per column, nil is DefaultKeys
+func NewKeypad(ctx context.Context, name string, chip string, rows []int, columns []int, keys []string) (k *Keypad, err error) {
+	if keys == nil {
+		keys = DefaultKeys
+	}
+	if len(keys) != len(rows) {
+		return nil, KeypadError{Name: name, Reason: fmt.Sprintf("%d rows of keys for %d row lines", len(keys), len(rows))}
+	}
+	k = &Keypad{
+		name:    name,
+		seen:    map[rune]int{},
+		pressed: map[rune]bool{},
+		pin:     &pinEntry{},
+		mu:      &sync.Mutex{},
+	}
+	for _, row := range keys {
+		if len([]rune(row)) != len(columns) {
+			return nil, KeypadError{Name: name, Reason: fmt.Sprintf("row %q of keys for %d column lines", row, len(columns))}
+		}
+		k.keys = append(k.keys, []rune(row))
+	}
+
+	owner := "keypad/" + name
+	for _, offset := range rows {
+		h, err := core.RegisterItem(chip, offset, core.AsOutput(), core.WithState(core.Active), core.WithOwner(owner))
+		if err != nil {
+			k.Close()
+			return nil, err
+		}
+		row, err := h.Direct()
+		if err != nil {
+			h.Release()
+			k.Close()
+			return nil, err
+		}
+		k.rows = append(k.rows, row)
+	}
+	for _, offset := range columns {
+		h, err := core.RegisterItem(chip, offset, core.AsInput(core.PullUp), core.WithOwner(owner))
+		if err != nil {
+			k.Close()
+			return nil, err
+		}
+		k.columns = append(k.columns, h)
+	}
+
+	go func() {
+		ticker := time.NewTicker(scanInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := k.scan(); err != nil {
+					logger.Errorf("keypad %s stopped scanning: %v", k.name, err)
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return k, nil
+}
+
+func (k *Keypad) Name() string {
+	return k.name
+}
+
+// AddEventListener registers handlers that are called for every pin
+func (k *Keypad) AddEventListener(fns ...CredentialHandler) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.events = append(k.events, fns...)
+}
+
+// AddKeyListener registers handlers that are called for every key press
+func (k *Keypad) AddKeyListener(fns ...KeyHandler) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keyFns = append(k.keyFns, fns...)
+}
+
+// Close releases the lines, scanning stops with them
+func (k *Keypad) Close() (err error) {
+	for _, row := range k.rows {
+		if !row.Released() {
+			err = multierr.Append(err, row.Release())
+		}
+	}
+	for _, h := range k.columns {
+		if !h.Released() {
+			err = multierr.Append(err, h.Release())
+		}
+	}
+	return
+}
+
+// scan drives each row low in turn and reads every column
+func (k *Keypad) scan() error {
+	down := map[rune]bool{}
+	for r, row := range k.rows {
+		if err := row.Set(core.Inactive); err != nil {
+			return err
+		}
+		time.Sleep(settle)
+		for c, column := range k.columns {
+			state, err := column.Read()
+			if err != nil {
+				row.Set(core.Active)
+				return err
+			}
+			if state == core.Inactive {
+				down[k.keys[r][c]] = true
+			}
+		}
+		if err := row.Set(core.Active); err != nil {
+			return err
+		}
+	}
+	k.debounce(down)
+	return nil
+}
+
+// debounce turns the keys that are down in a scan into key presses and
+// feeds them to the pin
+func (k *Keypad) debounce(down map[rune]bool) {
+	var presses []rune
+	k.mu.Lock()
+	for _, row := range k.keys {
+		for _, key := range row {
+			if down[key] == k.pressed[key] {
+				k.seen[key] = 0
+				continue
+			}
+			k.seen[key]++
+			if k.seen[key] < debounce {
+				continue
+			}
+			k.seen[key] = 0
+			k.pressed[key] = down[key]
+			if down[key] {
+				presses = append(presses, key)
+			}
+		}
+	}
+	keyFns, events := k.keyFns, k.events
+	k.mu.Unlock()
+
+	for _, key := range presses {
+		for _, fn := range keyFns {
+			fn(key)
+		}
+		pin, done := k.pin.press(key)
+		if !done {
+			continue
+		}
+		event := &CredentialEvent{Reader: k.name, Credential: Credential{Format: PIN, PIN: pin}, Time: time.Now()}
+		logger.Infof("keypad %s read a pin", k.name)
+		for _, fn := range events {
+			fn(event)
+		}
+	}
+}
+
+type KeypadError struct {
+	Name   string
+	Reason string
+}
+
+func (k KeypadError) Error() string {
+	return fmt.Sprintf("invalid keypad %s: %s", k.Name, k.Reason)
+}
@@ -0,0 +1,110 @@
+package input
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/controller/core"
+)
+
+// matrix simulates a 4x4 keypad on a PCF8574, rows on lines 0 to 3 and
+// columns on lines 4 to 7. a pressed key pulls its column low while its
+// row is driven low
+type matrix struct {
+	written byte
+	pressed map[[2]int]bool
+	mu      *sync.Mutex
+}
+
+func (m *matrix) Tx(w []byte, r []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(w) > 0 {
+		m.written = w[len(w)-1]
+	}
+	if len(r) > 0 {
+		value := m.written
+		for key := range m.pressed {
+			if m.written&(1<<key[0]) == 0 {
+				value &^= 1 << (4 + key[1])
+			}
+		}
+		r[0] = value
+	}
+	return nil
+}
+
+func (m *matrix) Close() error {
+	return nil
+}
+
+func (m *matrix) press(row, column int, down bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pressed[[2]int{row, column}] = down
+	if !down {
+		delete(m.pressed, [2]int{row, column})
+	}
+}
+
+// chips can't be unregistered, every run of a test needs a chip of its own
+var chips = 0
+
+func TestKeypad(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	chips++
+	chip := fmt.Sprintf("keypad-%d", chips)
+	device := &matrix{written: 0xff, pressed: map[[2]int]bool{}, mu: &sync.Mutex{}}
+	_, err := core.RegisterChip(ctx, core.WithName(chip), core.AsPCF8574(1, 0x20), core.WithI2CDevice(device), core.WithPolling(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rowEvents := make(chan *core.ItemEvent, 100)
+	core.Subscribe(func(event *core.ItemEvent) {
+		if info := event.Item.Info(); info.Chip == chip && info.Offset < 4 {
+			rowEvents <- event
+		}
+	})
+	k, err := NewKeypad(ctx, "test", chip, []int{0, 1, 2, 3}, []int{4, 5, 6, 7}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	keys := make(chan rune, 10)
+	k.AddKeyListener(func(key rune) { keys <- key })
+	pins := make(chan string, 1)
+	k.AddEventListener(func(event *CredentialEvent) { pins <- event.Credential.PIN })
+
+	// 1 is row 0 column 0, 5 is row 1 column 1 and # is row 3 column 2
+	for _, key := range []struct {
+		row, column int
+		want        rune
+	}{{0, 0, '1'}, {1, 1, '5'}, {3, 2, '#'}} {
+		device.press(key.row, key.column, true)
+		select {
+		case got := <-keys:
+			if got != key.want {
+				t.Errorf("pressed %c, got %c", key.want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%c wasn't pressed", key.want)
+		}
+		device.press(key.row, key.column, false)
+		time.Sleep(debounce * 2 * scanInterval)
+	}
+	select {
+	case pin := <-pins:
+		if pin != "15" {
+			t.Errorf("pin is %s, want 15", pin)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no pin was read")
+	}
+	if len(rowEvents) != 0 {
+		t.Errorf("scanning the rows made %d events", len(rowEvents))
+	}
+}
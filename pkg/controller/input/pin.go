@@ -0,0 +1,35 @@
+package input
+
+import (
+	"strings"
+	"time"
+)
+
+// pinTimeout is how long a half typed pin is kept around
+const pinTimeout = 10 * time.Second
+
+// pinEntry collects the digits typed on a keypad, * clears them and #
+// finishes the pin. it isn't safe for concurrent use
+type pinEntry struct {
+	digits strings.Builder
+	last   time.Time
+}
+
+// press feeds a key to the pin, done is true when # finished a pin
+func (p *pinEntry) press(key rune) (pin string, done bool) {
+	if time.Since(p.last) > pinTimeout {
+		p.digits.Reset()
+	}
+	p.last = time.Now()
+	switch {
+	case key >= '0' && key <= '9':
+		p.digits.WriteRune(key)
+	case key == '*':
+		p.digits.Reset()
+	case key == '#':
+		pin = p.digits.String()
+		p.digits.Reset()
+		return pin, pin != ""
+	}
+	return
+}
@@ -0,0 +1,246 @@
+package input
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/controller/core"
+	"go.uber.org/multierr"
+)
+
+const (
+	Wiegand26 = "wiegand26"
+	Wiegand34 = "wiegand34"
+	// PIN is a code typed on the keypad of a reader and ended with #
+	PIN = "pin"
+
+	// frameGap is how long the lines have to be idle for a frame to end,
+	// bits of the same frame are at most a few milliseconds apart
+	frameGap = 25 * time.Millisecond
+	// pulses are usually 50µs long, anything outside this is noise
+	minPulse = 5 * time.Microsecond
+	maxPulse = 2 * time.Millisecond
+)
+
+// Credential is what a card or keypad reader read
+type Credential struct {
+	Format   string
+	Facility uint32
+	Number   uint64
+	// PIN is only set for the PIN format
+	PIN string
+}
+
+// String is how credentials are referred to, e.g. "wiegand26:12:3456" or "pin:1234"
+func (c Credential) String() string {
+	if c.Format == PIN {
+		return fmt.Sprintf("%s:%s", PIN, c.PIN)
+	}
+	return fmt.Sprintf("%s:%d:%d", c.Format, c.Facility, c.Number)
+}
+
+// Redacted is how credentials are logged, pins are replaced with "pin:****"
+// whatever their length so they never end up in the logs
+func (c Credential) Redacted() string {
+	if c.Format == PIN {
+		return PIN + ":****"
+	}
+	return c.String()
+}
+
+type CredentialEvent struct {
+	// Reader is the name of the reader the credential was presented to
+	Reader     string
+	Credential Credential
+	Time       time.Time
+}
+
+type CredentialHandler func(event *CredentialEvent)
+
+// Wiegand decodes what a reader sends on its D0 and D1 lines. both idle
+// high and one of them is pulsed low for every bit, D0 for a 0 and D1
+// for a 1. frames of 26 and 34 bits are cards, frames of 4 and 8 bits are
+// keys pressed on the keypad of the reader
+type Wiegand struct {
+	name   string
+	d0, d1 *core.ItemHandle
+
+	bits []bool
+	// falling is the timestamp of the last falling edge of each line
+	falling [2]time.Duration
+	timer   *time.Timer
+	pin     *pinEntry
+	events  []CredentialHandler
+
+	mu *sync.Mutex
+}
+
+// NewWiegand starts decoding the reader called name on lines d0 and d1 of chip
+func NewWiegand(name string, chip string, d0 int, d1 int) (w *Wiegand, err error) {
+	w = &Wiegand{
+		name: name,
+		pin:  &pinEntry{},
+		mu:   &sync.Mutex{},
+	}
+	owner := "wiegand/" + name
+	w.d0, err = core.RegisterItem(chip, d0, core.AsInput(core.PullUp), core.WithOwner(owner))
+	if err != nil {
+		return nil, err
+	}
+	w.d1, err = core.RegisterItem(chip, d1, core.AsInput(core.PullUp), core.WithOwner(owner))
+	if err != nil {
+		w.d0.Release()
+		return nil, err
+	}
+	for bit, h := range []*core.ItemHandle{w.d0, w.d1} {
+		bit := bit
+		if err = h.AddEdgeListener(func(edge core.Edge) { w.edge(bit, edge) }); err != nil {
+			w.Close()
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+func (w *Wiegand) Name() string {
+	return w.name
+}
+
+// AddEventListener registers handlers that are called for every credential
+func (w *Wiegand) AddEventListener(fns ...CredentialHandler) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.events = append(w.events, fns...)
+}
+
+// Close stops decoding and releases the lines
+func (w *Wiegand) Close() (err error) {
+	w.mu.Lock()
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.mu.Unlock()
+	for _, h := range []*core.ItemHandle{w.d0, w.d1} {
+		if h != nil && !h.Released() {
+			err = multierr.Append(err, h.Release())
+		}
+	}
+	return
+}
+
+func (w *Wiegand) edge(bit int, edge core.Edge) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if edge.Type == core.FallingEdge {
+		w.falling[bit] = edge.Timestamp
+		return
+	}
+	width := edge.Timestamp - w.falling[bit]
+	if w.falling[bit] == 0 || width < minPulse || width > maxPulse {
+		return
+	}
+	w.falling[bit] = 0
+	w.bits = append(w.bits, bit == 1)
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.timer = time.AfterFunc(frameGap, w.flush)
+}
+
+// flush decodes the frame once the lines have been idle for long enough
+func (w *Wiegand) flush() {
+	w.mu.Lock()
+	bits := w.bits
+	w.bits = nil
+	credential, ok, err := w.decode(bits)
+	events := w.events
+	w.mu.Unlock()
+	if err != nil {
+		logger.Warnf("reader %s: %v", w.name, err)
+		return
+	}
+	if !ok {
+		return
+	}
+	event := &CredentialEvent{Reader: w.name, Credential: credential, Time: time.Now()}
+	logger.Infof("reader %s read %s", w.name, credential.Redacted())
+	for _, fn := range events {
+		fn(event)
+	}
+}
+
+// decode turns a frame into a credential, ok is false for key presses that
+// don't finish a pin. must be called with mu locked
+func (w *Wiegand) decode(bits []bool) (credential Credential, ok bool, err error) {
+	switch len(bits) {
+	case 4:
+		return w.key(int(value(bits)))
+	case 8:
+		// the high nibble is the complement of the low one
+		key, check := value(bits[4:]), value(bits[:4])
+		if key^check != 0xf {
+			return credential, false, FrameError{Bits: len(bits), Reason: "key doesn't match its complement"}
+		}
+		return w.key(int(key))
+	case 26:
+		if ones(bits[:13])%2 != 0 || ones(bits[13:])%2 != 1 {
+			return credential, false, FrameError{Bits: len(bits), Reason: "parity check failed"}
+		}
+		return Credential{Format: Wiegand26, Facility: uint32(value(bits[1:9])), Number: value(bits[9:25])}, true, nil
+	case 34:
+		if ones(bits[:17])%2 != 0 || ones(bits[17:])%2 != 1 {
+			return credential, false, FrameError{Bits: len(bits), Reason: "parity check failed"}
+		}
+		return Credential{Format: Wiegand34, Facility: uint32(value(bits[1:17])), Number: value(bits[17:33])}, true, nil
+	default:
+		return credential, false, FrameError{Bits: len(bits), Reason: "unsupported frame length"}
+	}
+}
+
+// key feeds a key press to the pin, keys 10 and 11 are * and #.
+// must be called with mu locked
+func (w *Wiegand) key(key int) (credential Credential, ok bool, err error) {
+	var r rune
+	switch {
+	case key <= 9:
+		r = rune('0' + key)
+	case key == 10:
+		r = '*'
+	case key == 11:
+		r = '#'
+	}
+	if pin, done := w.pin.press(r); done {
+		return Credential{Format: PIN, PIN: pin}, true, nil
+	}
+	return
+}
+
+// value reads bits as a big endian number
+func value(bits []bool) (v uint64) {
+	for _, b := range bits {
+		v <<= 1
+		if b {
+			v |= 1
+		}
+	}
+	return
+}
+
+func ones(bits []bool) (n int) {
+	for _, b := range bits {
+		if b {
+			n++
+		}
+	}
+	return
+}
+
+type FrameError struct {
+	Bits   int
+	Reason string
+}
+
+func (f FrameError) Error() string {
+	return fmt.Sprintf("dropped a %d bit frame: %s", f.Bits, f.Reason)
+}
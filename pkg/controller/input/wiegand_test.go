@@ -0,0 +1,90 @@
+package input
+
+import (
+	"testing"
+)
+
+// frame turns a string of 0s and 1s into bits
+func frame(s string) (bits []bool) {
+	for _, r := range s {
+		bits = append(bits, r == '1')
+	}
+	return
+}
+
+func TestWiegandDecode(t *testing.T) {
+	tests := []struct {
+		frame string
+		want  Credential
+	}{
+		// parity, 8 bits of facility, 16 bits of card number, parity
+		{"00000110000001101100000001", Credential{Format: Wiegand26, Facility: 12, Number: 3456}},
+		{"01111111111111111111111111", Credential{Format: Wiegand26, Facility: 255, Number: 65535}},
+		{"10000000100000000000000010", Credential{Format: Wiegand26, Facility: 1, Number: 1}},
+		// parity, 16 bits of facility, 16 bits of card number, parity
+		{"1000100100011010001010110011110001", Credential{Format: Wiegand34, Facility: 0x1234, Number: 0x5678}},
+		{"0000000000000000000000000000000010", Credential{Format: Wiegand34, Facility: 0, Number: 1}},
+	}
+	for _, tt := range tests {
+		w := &Wiegand{pin: &pinEntry{}}
+		got, ok, err := w.decode(frame(tt.frame))
+		if err != nil || !ok || got != tt.want {
+			t.Errorf("decode(%s) = %v, %v, %v, want %v", tt.frame, got, ok, err, tt.want)
+		}
+	}
+}
+
+func TestWiegandParity(t *testing.T) {
+	for _, s := range []string{
+		// the leading, then the trailing parity bit flipped
+		"10000110000001101100000001",
+		"00000110000001101100000000",
+		// a data bit flipped in each half
+		"00000110100001101100000001",
+		"00000110000001101100100001",
+		"0000100100011010001010110011110001",
+		"1000100100011010001010110011110000",
+		"1000100100011010001010110011110101",
+	} {
+		w := &Wiegand{pin: &pinEntry{}}
+		_, ok, err := w.decode(frame(s))
+		if _, frameErr := err.(FrameError); ok || !frameErr {
+			t.Errorf("decode(%s) = %v, %v, want a FrameError", s, ok, err)
+		}
+	}
+}
+
+func TestWiegandKeys(t *testing.T) {
+	w := &Wiegand{pin: &pinEntry{}}
+	// 4 bit keys 1, 2 and 8 bit keys 3, # with the complement in the high nibble
+	for i, s := range []string{"0001", "0010", "11000011"} {
+		if _, ok, err := w.decode(frame(s)); ok || err != nil {
+			t.Fatalf("key %d finished a pin or failed: %v, %v", i, ok, err)
+		}
+	}
+	got, ok, err := w.decode(frame("01001011"))
+	if want := (Credential{Format: PIN, PIN: "123"}); !ok || err != nil || got != want {
+		t.Errorf("# gave %v, %v, %v, want %v", got, ok, err, want)
+	}
+	if _, _, err = w.decode(frame("00000011")); err == nil {
+		t.Error("a key that doesn't match its complement was accepted")
+	}
+	if _, _, err = w.decode(frame("101")); err == nil {
+		t.Error("a 3 bit frame was accepted")
+	}
+}
+
+func TestCredentialRedacted(t *testing.T) {
+	for _, tt := range []struct {
+		credential Credential
+		want       string
+	}{
+		{Credential{Format: PIN, PIN: "1234"}, "pin:****"},
+		{Credential{Format: PIN, PIN: "123456"}, "pin:****"},
+		{Credential{Format: Wiegand26, Facility: 12, Number: 3456}, "wiegand26:12:3456"},
+	} {
+		if got := tt.credential.Redacted(); got != tt.want {
+			t.Errorf("%v is logged as %q, want %q", tt.credential, got, tt.want)
+		}
+	}
+}
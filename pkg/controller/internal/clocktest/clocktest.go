@@ -0,0 +1,105 @@
+// Package clocktest has a scheduler.Clock that only moves when a test
+// advances it, for the tests of the packages that take a clock
+package clocktest
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/controller/scheduler"
+)
+
+// Clock only moves when it's advanced
+type Clock struct {
+	now    time.Time
+	timers []*timer
+	mu     *sync.Mutex
+}
+
+type timer struct {
+	clock   *Clock
+	at      time.Time
+	c       chan time.Time
+	stopped bool
+}
+
+func New(now time.Time) *Clock {
+	return &Clock{now: now, mu: &sync.Mutex{}}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *Clock) NewTimer(d time.Duration) scheduler.Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &timer{clock: c, at: c.now.Add(d), c: make(chan time.Time, 1)}
+	c.timers = append(c.timers, t)
+	c.fire()
+	return t
+}
+
+// Advance moves the clock forward and fires every timer that is due
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	c.fire()
+}
+
+// Pending returns how many timers are waiting to fire
+func (c *Clock) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pending := 0
+	for _, t := range c.timers {
+		if !t.stopped {
+			pending++
+		}
+	}
+	return pending
+}
+
+// Settle waits until timers are waiting to fire, which is when every loop
+// that waits on the clock is done with what its last timer started
+func (c *Clock) Settle(t *testing.T, timers int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for c.Pending() != timers {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d timers are pending, want %d", c.Pending(), timers)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// fire must be called with mu locked
+func (c *Clock) fire() {
+	waiting := c.timers[:0]
+	for _, t := range c.timers {
+		switch {
+		case t.stopped:
+		case !t.at.After(c.now):
+			t.c <- c.now
+		default:
+			waiting = append(waiting, t)
+		}
+	}
+	c.timers = waiting
+}
+
+func (t *timer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *timer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	stopped := t.stopped
+	t.stopped = true
+	return !stopped
+}
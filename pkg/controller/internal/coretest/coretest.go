@@ -0,0 +1,33 @@
+// Package coretest registers chips on simulated devices for the tests of
+// the packages built on top of core
+package coretest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/controller/core"
+	"github.com/AliRostami1/baagh/pkg/controller/internal/testutil"
+)
+
+// Chip registers a chip that drives device until t ends and returns its
+// name, the device is polled every millisecond unless opts say otherwise
+func Chip(t *testing.T, device core.I2CDevice, opts ...core.ChipOption) string {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	name := testutil.ChipName(t)
+	opts = append([]core.ChipOption{core.WithName(name), core.WithI2CDevice(device), core.WithPolling(time.Millisecond)}, opts...)
+	if _, err := core.RegisterChip(ctx, opts...); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+// PCF8574 registers a chip on a simulated PCF8574
+func PCF8574(t *testing.T) (string, *testutil.PCF8574) {
+	t.Helper()
+	device := testutil.NewPCF8574()
+	return Chip(t, device, core.AsPCF8574(1, 0x20)), device
+}
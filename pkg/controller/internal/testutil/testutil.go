@@ -0,0 +1,109 @@
+// Package testutil has the simulated devices and helpers shared by the
+// tests of the controller packages, it doesn't import core so the tests of
+// core can use it too
+package testutil
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// PCF8574 simulates the quasi bidirectional lines of a PCF8574, a line
+// reads low if it's written low or pulled low from outside. It can only
+// pull its inputs up
+type PCF8574 struct {
+	written byte
+	outside byte
+	// writes are all the bytes written to the device in order
+	writes []byte
+	// fail is returned by every transfer once it's set
+	fail error
+	mu   *sync.Mutex
+}
+
+func NewPCF8574() *PCF8574 {
+	return &PCF8574{written: 0xff, outside: 0xff, mu: &sync.Mutex{}}
+}
+
+func (p *PCF8574) Tx(w []byte, r []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.fail != nil {
+		return p.fail
+	}
+	if len(w) > 0 {
+		p.written = w[len(w)-1]
+		p.writes = append(p.writes, w...)
+	}
+	if len(r) > 0 {
+		r[0] = p.written & p.outside
+	}
+	return nil
+}
+
+func (p *PCF8574) Close() error {
+	return nil
+}
+
+// Pull pulls line high or low from outside
+func (p *PCF8574) Pull(line int, high bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if high {
+		p.outside |= 1 << line
+	} else {
+		p.outside &^= 1 << line
+	}
+}
+
+// Break makes every transfer fail with err, nil repairs the device
+func (p *PCF8574) Break(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fail = err
+}
+
+// High reports whether line is written high
+func (p *PCF8574) High(line int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.written&(1<<line) != 0
+}
+
+// Writes returns the bytes written to the device since the last call
+func (p *PCF8574) Writes() []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	writes := p.writes
+	p.writes = nil
+	return writes
+}
+
+var (
+	chips   = 0
+	chipsMu = &sync.Mutex{}
+)
+
+// ChipName returns a name for a chip of t, chips can't be unregistered so
+// every run of a test needs chips of its own
+func ChipName(t *testing.T) string {
+	chipsMu.Lock()
+	defer chipsMu.Unlock()
+	chips++
+	return fmt.Sprintf("%s-%d", t.Name(), chips)
+}
+
+// Eventually fails t if cond isn't true within a second, sensors and
+// devices are handled asynchronously
+func Eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s didn't happen", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,172 @@
+package scheduler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/controller/core"
+	"github.com/AliRostami1/baagh/pkg/controller/general"
+)
+
+const (
+	// SetState sets the state of an item, either by Chip and Offset or by Virtual name
+	SetState = "set-state"
+	// TurnOn, TurnOff, Toggle, Arm and Disarm act on the general with Tag
+	TurnOn  = "turn-on"
+	TurnOff = "turn-off"
+	Toggle  = "toggle"
+	Arm     = "arm"
+	Disarm  = "disarm"
+	// Position moves the cover with Tag to Position
+	Position = "position"
+	// Dim fades the pwm output PWM to Level percent over Fade
+	Dim = "dim"
+)
+
+// Action is what a schedule does when it fires
+type Action struct {
+	Kind    string `json:"kind"`
+	Tag     string `json:"tag,omitempty"`
+	Chip    string `json:"chip,omitempty"`
+	Offset  int    `json:"offset,omitempty"`
+	Virtual string `json:"virtual,omitempty"`
+	Active  bool   `json:"active,omitempty"`
+	// Position is only used by the Position kind
+	Position float64 `json:"position,omitempty"`
+	// PWM, Level and Fade are only used by the Dim kind
+	PWM   string        `json:"pwm,omitempty"`
+	Level float64       `json:"level,omitempty"`
+	Fade  time.Duration `json:"fade,omitempty"`
+}
+
+func SetItemState(chip string, offset int, state core.State) Action {
+	return Action{Kind: SetState, Chip: chip, Offset: offset, Active: state == core.Active}
+}
+
+func SetVirtualState(name string, state core.State) Action {
+	return Action{Kind: SetState, Virtual: name, Active: state == core.Active}
+}
+
+func TurnOnGeneral(tag string) Action {
+	return Action{Kind: TurnOn, Tag: tag}
+}
+
+func TurnOffGeneral(tag string) Action {
+	return Action{Kind: TurnOff, Tag: tag}
+}
+
+// ToggleGeneral turns the general with tag off if it's active and on otherwise
+func ToggleGeneral(tag string) Action {
+	return Action{Kind: Toggle, Tag: tag}
+}
+
+func ArmGeneral(tag string) Action {
+	return Action{Kind: Arm, Tag: tag}
+}
+
+func DisarmGeneral(tag string) Action {
+	return Action{Kind: Disarm, Tag: tag}
+}
+
+func MoveCover(tag string, position float64) Action {
+	return Action{Kind: Position, Tag: tag, Position: position}
+}
+
+func DimPWM(name string, level float64, fade time.Duration) Action {
+	return Action{Kind: Dim, PWM: name, Level: level, Fade: fade}
+}
+
+func (a Action) Check() error {
+	switch a.Kind {
+	case SetState:
+		if a.Virtual == "" && a.Chip == "" {
+			return ActionError{Action: a, Reason: "either chip or virtual has to be set"}
+		}
+	case TurnOn, TurnOff, Toggle, Arm, Disarm, Position:
+		if a.Tag == "" {
+			return ActionError{Action: a, Reason: "tag has to be set"}
+		}
+	case Dim:
+		if a.PWM == "" {
+			return ActionError{Action: a, Reason: "pwm has to be set"}
+		}
+		if a.Level < 0 || a.Level > 100 {
+			return ActionError{Action: a, Reason: "level has to be between 0 and 100"}
+		}
+	default:
+		return ActionError{Action: a, Reason: "unknown kind"}
+	}
+	return nil
+}
+
+func (a Action) state() core.State {
+	if a.Active {
+		return core.Active
+	}
+	return core.Inactive
+}
+
+func (a Action) Run() error {
+	switch a.Kind {
+	case SetState:
+		if a.Virtual != "" {
+			return core.SetVirtualState(a.Virtual, a.state())
+		}
+		return core.SetState(a.Chip, a.Offset, a.state())
+	case Dim:
+		p, err := core.GetPWM(a.PWM)
+		if err != nil {
+			return err
+		}
+		return p.Fade(a.Level, a.Fade)
+	}
+	g, err := general.Get(a.Tag)
+	if err != nil {
+		return err
+	}
+	switch a.Kind {
+	case TurnOn:
+		g.TurnOn()
+	case TurnOff:
+		g.TurnOff()
+	case Toggle:
+		if g.State() == core.Active {
+			g.TurnOff()
+		} else {
+			g.TurnOn()
+		}
+	case Arm:
+		g.Arm()
+	case Disarm:
+		g.Disarm()
+	case Position:
+		return g.MoveTo(a.Position)
+	default:
+		return ActionError{Action: a, Reason: "unknown kind"}
+	}
+	return nil
+}
+
+func (a Action) String() string {
+	switch {
+	case a.Kind == SetState && a.Virtual != "":
+		return fmt.Sprintf("set %s to %s", a.Virtual, a.state())
+	case a.Kind == SetState:
+		return fmt.Sprintf("set line %d of %s to %s", a.Offset, a.Chip, a.state())
+	case a.Kind == Position:
+		return fmt.Sprintf("move %s to %v%%", a.Tag, a.Position)
+	case a.Kind == Dim:
+		return fmt.Sprintf("dim %s to %v%% over %s", a.PWM, a.Level, a.Fade)
+	default:
+		return fmt.Sprintf("%s %s", a.Kind, a.Tag)
+	}
+}
+
+type ActionError struct {
+	Action Action
+	Reason string
+}
+
+func (a ActionError) Error() string {
+	return fmt.Sprintf("invalid action %+v: %s", a.Action, a.Reason)
+}
@@ -0,0 +1,34 @@
+package scheduler
+
+import "time"
+
+// Clock is where the scheduler gets the time from, tests can replace it
+// with a clock they control
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// SystemClock is the wall clock
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (SystemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+type systemTimer struct {
+	*time.Timer
+}
+
+func (s systemTimer) C() <-chan time.Time {
+	return s.Timer.C
+}
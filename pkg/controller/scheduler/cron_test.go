@@ -0,0 +1,106 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	// 2021-06-04 is a friday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2021, time.June, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		spec  string
+		after time.Time
+		want  time.Time
+	}{
+		{"* * * * *", at(4, 10, 7), at(4, 10, 8)},
+		// a time that matches isn't its own next run
+		{"7 10 * * *", at(4, 10, 7), at(5, 10, 7)},
+		{"7 10 * * *", at(4, 10, 7).Add(-time.Second), at(4, 10, 7)},
+		{"*/15 * * * *", at(4, 10, 7), at(4, 10, 15)},
+		{"*/15 * * * *", at(4, 23, 50), at(5, 0, 0)},
+		{"5/20 * * * *", at(4, 10, 30), at(4, 10, 45)},
+		{"0,30 9-17 * * *", at(4, 17, 30), at(5, 9, 0)},
+		{"0 9 * * mon-fri", at(4, 10, 0), at(7, 9, 0)},
+		{"0 9 * * MON-FRI", at(4, 8, 0), at(4, 9, 0)},
+		{"0 0 * * 0", at(4, 0, 0), at(6, 0, 0)},
+		{"0 0 * * 7", at(4, 0, 0), at(6, 0, 0)},
+		// both day fields restricted, either of them matching is enough
+		{"0 0 13 * 5", at(4, 0, 0), at(11, 0, 0)},
+		{"0 0 13 * 5", at(11, 0, 0), at(13, 0, 0)},
+		{"0 0 1 jan *", at(4, 0, 0), time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 * *", at(4, 0, 0), time.Date(2021, time.July, 31, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", at(4, 0, 0), time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", at(4, 10, 7), at(4, 11, 0)},
+		{"@daily", at(4, 10, 7), at(5, 0, 0)},
+		{"@weekly", at(4, 10, 7), at(6, 0, 0)},
+		{"@monthly", at(4, 10, 7), time.Date(2021, time.July, 1, 0, 0, 0, 0, time.UTC)},
+		{" @yearly ", at(4, 10, 7), time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		c, err := parseCron(tt.spec)
+		if err != nil {
+			t.Errorf("parseCron(%q) failed: %v", tt.spec, err)
+			continue
+		}
+		got, ok := c.next(tt.after)
+		if !ok || !got.Equal(tt.want) {
+			t.Errorf("%q after %s = %s, %v, want %s", tt.spec, tt.after, got, ok, tt.want)
+		}
+	}
+}
+
+func TestCronNever(t *testing.T) {
+	c, err := parseCron("0 0 30 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next, ok := c.next(time.Now()); ok {
+		t.Errorf("february 30th is at %s", next)
+	}
+}
+
+func TestCronZoned(t *testing.T) {
+	tehran, err := time.LoadLocation("Asia/Tehran")
+	if err != nil {
+		t.Skip(err)
+	}
+	c, err := parseCron("0 9 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	z := zoned{trigger: c, location: tehran}
+	got, _ := z.next(time.Date(2021, time.June, 4, 0, 0, 0, 0, time.UTC))
+	want := time.Date(2021, time.June, 4, 9, 0, 0, 0, tehran)
+	if !got.Equal(want) {
+		t.Errorf("9:00 in Tehran = %s, want %s", got, want)
+	}
+}
+
+func TestCronErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"5-1 * * * *",
+		"1-x * * * *",
+		"x * * * *",
+		"* * * foo *",
+		"@reboot",
+	} {
+		if _, err := parseCron(spec); err == nil {
+			t.Errorf("parseCron(%q) succeeded", spec)
+		} else if _, ok := err.(CronError); !ok {
+			t.Errorf("parseCron(%q) = %v, want a CronError", spec, err)
+		}
+	}
+}
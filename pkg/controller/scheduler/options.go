@@ -0,0 +1,102 @@
+package scheduler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/controller/store"
+)
+
+type OptionError struct {
+	Field string
+	Value interface{}
+}
+
+func (o OptionError) Error() string {
+	return fmt.Sprintf("field %s can not be: %v", o.Field, o.Value)
+}
+
+type Option interface {
+	applyOption(*Options) error
+}
+
+type Options struct {
+	clock Clock
+	store store.Store
+	site  Site
+	// schedules are the declared schedules
+	schedules []Schedule
+}
+
+type ClockOption struct {
+	Clock
+}
+
+func (c ClockOption) applyOption(o *Options) error {
+	if c.Clock == nil {
+		return OptionError{Field: "Clock", Value: c.Clock}
+	}
+	o.clock = c.Clock
+	return nil
+}
+
+func WithClock(clock Clock) ClockOption {
+	return ClockOption{clock}
+}
+
+type StoreOption struct {
+	store.Store
+}
+
+func (s StoreOption) applyOption(o *Options) error {
+	if s.Store == nil {
+		return OptionError{Field: "Store", Value: s.Store}
+	}
+	o.store = s.Store
+	return nil
+}
+
+// WithStore sets where the schedules are persisted
+func WithStore(s store.Store) StoreOption {
+	return StoreOption{s}
+}
+
+type SiteOption Site
+
+func (s SiteOption) applyOption(o *Options) error {
+	if s.Latitude < -90 || s.Latitude > 90 {
+		return OptionError{Field: "Latitude", Value: s.Latitude}
+	}
+	if s.Longitude < -180 || s.Longitude > 180 {
+		return OptionError{Field: "Longitude", Value: s.Longitude}
+	}
+	if s.Location == nil {
+		return OptionError{Field: "Location", Value: s.Location}
+	}
+	o.site = Site(s)
+	return nil
+}
+
+// WithSite sets where the scheduler is, solar events are calculated for it
+// and cron expressions are evaluated in its timezone
+func WithSite(latitude float64, longitude float64, location *time.Location) SiteOption {
+	return SiteOption{
+		Latitude:  latitude,
+		Longitude: longitude,
+		Location:  location,
+	}
+}
+
+type SchedulesOption []Schedule
+
+func (s SchedulesOption) applyOption(o *Options) error {
+	o.schedules = append(o.schedules, s...)
+	return nil
+}
+
+// WithSchedules declares schedules, usually the ones in the config. they're
+// started along with the persisted ones and, unlike schedules that are
+// added later, deleted once they aren't declared anymore
+func WithSchedules(schedules ...Schedule) SchedulesOption {
+	return SchedulesOption(schedules)
+}
@@ -0,0 +1,416 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/controller/store"
+	"github.com/AliRostami1/baagh/pkg/logy"
+)
+
+const (
+	// MissedSkip ignores runs that were due while baagh wasn't running
+	MissedSkip = "skip"
+	// MissedRunOnce runs once right away if one or more runs were missed
+	MissedRunOnce = "run-once"
+)
+
+var logger logy.Logger = logy.DummyLogger{}
+
+func SetLogger(l logy.Logger) error {
+	if l == nil {
+		return fmt.Errorf("logger can't be nil")
+	}
+	logger = l
+	return nil
+}
+
+// Schedule fires its Action on a cron expression, on a fixed interval, once
+// at a certain time or relative to a solar event, exactly one of Cron, Every,
+// At and Sun should be set
+type Schedule struct {
+	ID     string        `json:"id"`
+	Cron   string        `json:"cron,omitempty"`
+	Every  time.Duration `json:"every,omitempty"`
+	At     time.Time     `json:"at,omitempty"`
+	Sun    *Sun          `json:"sun,omitempty"`
+	Action Action        `json:"action"`
+	// Missed is what to do about runs that were due while baagh was down
+	Missed  string    `json:"missed"`
+	Created time.Time `json:"created"`
+	LastRun time.Time `json:"last_run,omitempty"`
+	// Declared is set for schedules passed to New, they're deleted once
+	// they aren't passed anymore while the others are kept until removed
+	Declared bool `json:"declared,omitempty"`
+}
+
+func (s Schedule) trigger(site Site) (trigger, error) {
+	set := 0
+	for _, isSet := range []bool{s.Cron != "", s.Every != 0, !s.At.IsZero(), s.Sun != nil} {
+		if isSet {
+			set++
+		}
+	}
+	if set != 1 {
+		return nil, ScheduleError{ID: s.ID, Reason: "exactly one of cron, every, at and sun has to be set"}
+	}
+	switch {
+	case s.Cron != "":
+		c, err := parseCron(s.Cron)
+		if err != nil {
+			return nil, err
+		}
+		if site.Location == nil {
+			return c, nil
+		}
+		return zoned{trigger: c, location: site.Location}, nil
+	case s.Sun != nil:
+		t, err := newSunTrigger(*s.Sun, site)
+		if err != nil {
+			return nil, ScheduleError{ID: s.ID, Reason: err.Error()}
+		}
+		return t, nil
+	case s.Every != 0:
+		if s.Every < time.Second {
+			return nil, ScheduleError{ID: s.ID, Reason: "every can't be less than a second"}
+		}
+		return interval{anchor: s.Created, every: s.Every}, nil
+	default:
+		return once(s.At), nil
+	}
+}
+
+// trigger tells when a schedule should fire next
+type trigger interface {
+	// next returns the first time strictly after "after", ok is false if there is none
+	next(after time.Time) (t time.Time, ok bool)
+}
+
+// zoned evaluates a trigger in the timezone of the site instead of the system's
+type zoned struct {
+	trigger
+	location *time.Location
+}
+
+func (z zoned) next(after time.Time) (time.Time, bool) {
+	return z.trigger.next(after.In(z.location))
+}
+
+type interval struct {
+	anchor time.Time
+	every  time.Duration
+}
+
+func (i interval) next(after time.Time) (time.Time, bool) {
+	if after.Before(i.anchor) {
+		return i.anchor, true
+	}
+	n := after.Sub(i.anchor)/i.every + 1
+	return i.anchor.Add(n * i.every), true
+}
+
+type once time.Time
+
+func (o once) next(after time.Time) (time.Time, bool) {
+	if time.Time(o).After(after) {
+		return time.Time(o), true
+	}
+	return time.Time{}, false
+}
+
+type Scheduler struct {
+	clock   Clock
+	site    Site
+	db      store.Store
+	entries map[string]*entry
+	ctx     context.Context
+
+	mu *sync.RWMutex
+}
+
+type entry struct {
+	Schedule
+	trigger trigger
+	stop    chan struct{}
+}
+
+const keyPrefix = "schedule/"
+
+// New creates a scheduler and starts every schedule persisted in the store
+// along with the declared ones, which replace the persisted schedules with
+// the same id. declared schedules that were persisted before but aren't
+// declared anymore are deleted. runs missed while baagh was down are
+// handled according to their policy
+func New(ctx context.Context, opts ...Option) (s *Scheduler, err error) {
+	options := &Options{
+		clock: SystemClock{},
+		store: store.NewMemory(),
+	}
+	for _, opt := range opts {
+		err = opt.applyOption(options)
+		if err != nil {
+			return
+		}
+	}
+	s = &Scheduler{
+		clock:   options.clock,
+		site:    options.site,
+		db:      options.store,
+		entries: map[string]*entry{},
+		ctx:     ctx,
+		mu:      &sync.RWMutex{},
+	}
+
+	// every declared schedule is persisted before anything starts, so a
+	// schedule never runs both as it was persisted and as it's declared
+	declared := map[string]bool{}
+	for _, schedule := range options.schedules {
+		if declared[schedule.ID] {
+			return nil, ScheduleError{ID: schedule.ID, Reason: "it's declared more than once"}
+		}
+		declared[schedule.ID] = true
+		schedule.Declared = true
+		if schedule, err = s.prepare(schedule, nil); err != nil {
+			return nil, err
+		}
+		if err = s.db.Put(keyPrefix+schedule.ID, schedule); err != nil {
+			return nil, err
+		}
+	}
+
+	keys, err := s.db.Keys(keyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range keys {
+		var schedule Schedule
+		if err = s.db.Get(key, &schedule); err != nil {
+			return nil, err
+		}
+		if schedule.Declared && !declared[schedule.ID] {
+			logger.Infof("deleting schedule %s, it isn't declared anymore", schedule.ID)
+			if err = s.db.Delete(key); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err = s.start(schedule); err != nil {
+			logger.Errorf("dropping schedule %s: %v", schedule.ID, err)
+			s.db.Delete(key)
+		}
+	}
+	return s, nil
+}
+
+// Add adds or replaces the schedule with the same id, when a persisted
+// schedule is replaced its last run is kept so missed runs are still detected.
+// schedules added this way are kept until they're removed
+func (s *Scheduler) Add(schedule Schedule) error {
+	schedule.Declared = false
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var running *Schedule
+	e, ok := s.entries[schedule.ID]
+	if ok {
+		running = &e.Schedule
+	}
+	schedule, err := s.prepare(schedule, running)
+	if err != nil {
+		return err
+	}
+	// the entry is replaced while mu is locked, so its last run is either
+	// carried over or it doesn't run anymore
+	if ok {
+		delete(s.entries, schedule.ID)
+		close(e.stop)
+	}
+	if err = s.db.Put(keyPrefix+schedule.ID, schedule); err != nil {
+		return err
+	}
+	return s.start(schedule)
+}
+
+// prepare checks schedule and carries over when it was created and when it
+// last ran from previous, or from the store if previous is nil, as long as
+// its timing didn't change
+func (s *Scheduler) prepare(schedule Schedule, previous *Schedule) (Schedule, error) {
+	if schedule.ID == "" || strings.Contains(schedule.ID, "/") {
+		return schedule, ScheduleError{ID: schedule.ID, Reason: "id can't be empty or contain /"}
+	}
+	if err := schedule.Action.Check(); err != nil {
+		return schedule, err
+	}
+	if schedule.Missed == "" {
+		schedule.Missed = MissedSkip
+	}
+	if schedule.Missed != MissedSkip && schedule.Missed != MissedRunOnce {
+		return schedule, ScheduleError{ID: schedule.ID, Reason: fmt.Sprintf("unknown missed run policy %s", schedule.Missed)}
+	}
+
+	if previous == nil {
+		var persisted Schedule
+		err := s.db.Get(keyPrefix+schedule.ID, &persisted)
+		if _, ok := err.(store.NotFoundError); err != nil && !ok {
+			return schedule, err
+		} else if err == nil {
+			previous = &persisted
+		}
+	}
+	if previous != nil && previous.sameTiming(schedule) {
+		schedule.Created = previous.Created
+		schedule.LastRun = previous.LastRun
+	}
+	if schedule.Created.IsZero() {
+		schedule.Created = s.clock.Now()
+	}
+	if _, err := schedule.trigger(s.site); err != nil {
+		return schedule, err
+	}
+	return schedule, nil
+}
+
+func (s Schedule) sameTiming(other Schedule) bool {
+	sameSun := s.Sun == other.Sun || (s.Sun != nil && other.Sun != nil && *s.Sun == *other.Sun)
+	return s.Cron == other.Cron && s.Every == other.Every && s.At.Equal(other.At) && sameSun
+}
+
+// Cron fires action on every time matching the cron expression spec
+func (s *Scheduler) Cron(id string, spec string, action Action, missed string) error {
+	return s.Add(Schedule{ID: id, Cron: spec, Action: action, Missed: missed})
+}
+
+// Every fires action every interval, counting from when it was first added
+func (s *Scheduler) Every(id string, interval time.Duration, action Action, missed string) error {
+	return s.Add(Schedule{ID: id, Every: interval, Action: action, Missed: missed})
+}
+
+// AtSun fires action relative to a solar event every day
+func (s *Scheduler) AtSun(id string, sun Sun, action Action, missed string) error {
+	return s.Add(Schedule{ID: id, Sun: &sun, Action: action, Missed: missed})
+}
+
+// After fires action once after d, the timer survives restarts
+func (s *Scheduler) After(id string, d time.Duration, action Action, missed string) error {
+	return s.Add(Schedule{ID: id, At: s.clock.Now().Add(d), Action: action, Missed: missed})
+}
+
+func (s *Scheduler) Remove(id string) error {
+	s.mu.Lock()
+	e, ok := s.entries[id]
+	delete(s.entries, id)
+	s.mu.Unlock()
+	if ok {
+		close(e.stop)
+	}
+	return s.db.Delete(keyPrefix + id)
+}
+
+// Schedules returns every active schedule sorted by id
+func (s *Scheduler) Schedules() []Schedule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	schedules := make([]Schedule, 0, len(s.entries))
+	for _, e := range s.entries {
+		schedules = append(schedules, e.Schedule)
+	}
+	sort.Slice(schedules, func(a, b int) bool {
+		return schedules[a].ID < schedules[b].ID
+	})
+	return schedules
+}
+
+// start starts the loop of schedule, must be called with mu locked
+func (s *Scheduler) start(schedule Schedule) error {
+	t, err := schedule.trigger(s.site)
+	if err != nil {
+		return err
+	}
+	e := &entry{
+		Schedule: schedule,
+		trigger:  t,
+		stop:     make(chan struct{}),
+	}
+	s.entries[schedule.ID] = e
+	go s.loop(e)
+	return nil
+}
+
+func (s *Scheduler) loop(e *entry) {
+	s.mu.Lock()
+	last := e.LastRun
+	if last.IsZero() {
+		last = e.Created
+	}
+	s.mu.Unlock()
+	now := s.clock.Now()
+	next, ok := e.trigger.next(last)
+	if ok && next.Before(now) {
+		if e.Missed == MissedRunOnce {
+			logger.Infof("schedule %s missed a run at %s, running it now", e.ID, next)
+			s.run(e, now)
+		} else {
+			logger.Infof("schedule %s missed a run at %s, skipping it", e.ID, next)
+		}
+		next, ok = e.trigger.next(now)
+	}
+
+	for ok {
+		timer := s.clock.NewTimer(next.Sub(s.clock.Now()))
+		select {
+		case <-timer.C():
+			s.run(e, next)
+			next, ok = e.trigger.next(next)
+		case <-e.stop:
+			timer.Stop()
+			return
+		case <-s.ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+
+	// nothing left to run, one shot timers end up here
+	s.mu.Lock()
+	if s.entries[e.ID] == e {
+		delete(s.entries, e.ID)
+		s.db.Delete(keyPrefix + e.ID)
+	}
+	s.mu.Unlock()
+}
+
+// run fires the action of e unless it was removed or replaced in the
+// meantime. the run is persisted before the action is, so a run is never
+// repeated even if baagh stops while the action is running
+func (s *Scheduler) run(e *entry, at time.Time) {
+	s.mu.Lock()
+	if s.entries[e.ID] != e {
+		s.mu.Unlock()
+		return
+	}
+	e.LastRun = at
+	if err := s.db.Put(keyPrefix+e.ID, e.Schedule); err != nil {
+		logger.Errorf("couldn't persist the last run of schedule %s: %v", e.ID, err)
+	}
+	s.mu.Unlock()
+
+	if err := e.Action.Run(); err != nil {
+		logger.Errorf("schedule %s couldn't %s: %v", e.ID, e.Action, err)
+	} else {
+		logger.Infof("schedule %s: %s", e.ID, e.Action)
+	}
+}
+
+type ScheduleError struct {
+	ID     string
+	Reason string
+}
+
+func (s ScheduleError) Error() string {
+	return fmt.Sprintf("invalid schedule %s: %s", s.ID, s.Reason)
+}
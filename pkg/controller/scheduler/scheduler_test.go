@@ -0,0 +1,308 @@
+package scheduler
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/controller/general"
+	"github.com/AliRostami1/baagh/pkg/controller/store"
+)
+
+// fakeClock only moves when it's advanced, it's clocktest.Clock for the tests
+// of scheduler, which can't import it
+type fakeClock struct {
+	now    time.Time
+	timers []*fakeTimer
+	mu     *sync.Mutex
+}
+
+type fakeTimer struct {
+	clock   *fakeClock
+	at      time.Time
+	c       chan time.Time
+	stopped bool
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now, mu: &sync.Mutex{}}
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) NewTimer(d time.Duration) Timer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t := &fakeTimer{clock: f, at: f.now.Add(d), c: make(chan time.Time, 1)}
+	f.timers = append(f.timers, t)
+	f.fire()
+	return t
+}
+
+// advance moves the clock forward and fires every timer that is due
+func (f *fakeClock) advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+	f.fire()
+}
+
+// pending returns how many timers are waiting to fire
+func (f *fakeClock) pending() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pending := 0
+	for _, t := range f.timers {
+		if !t.stopped {
+			pending++
+		}
+	}
+	return pending
+}
+
+// fire must be called with mu locked
+func (f *fakeClock) fire() {
+	waiting := f.timers[:0]
+	for _, t := range f.timers {
+		switch {
+		case t.stopped:
+		case !t.at.After(f.now):
+			t.c <- f.now
+		default:
+			waiting = append(waiting, t)
+		}
+	}
+	f.timers = waiting
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	stopped := t.stopped
+	t.stopped = true
+	return !stopped
+}
+
+// toggles registers a general that a schedule can toggle and counts how
+// often it's toggled
+func toggles(t *testing.T, tag string) func() int {
+	t.Helper()
+	g, err := general.Register(tag, general.AsSync(general.OneIn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { g.Unregister() })
+	mu := &sync.Mutex{}
+	count := 0
+	g.AddEventListener(func(*general.Event) {
+		mu.Lock()
+		count++
+		mu.Unlock()
+	})
+	return func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return count
+	}
+}
+
+// settle waits for the loops of the scheduler to wait on their timers and
+// for the events of their runs to be delivered
+func settle(t *testing.T, clock *fakeClock, timers int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for clock.pending() != timers {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d timers are pending, want %d", clock.pending(), timers)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+}
+
+func newScheduler(t *testing.T, opts ...Option) *Scheduler {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	s, err := New(ctx, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+var now = time.Date(2021, time.June, 4, 12, 0, 0, 0, time.UTC)
+
+// hourly was created at 9:00 and last ran at 10:00, so the runs at 11:00
+// and 12:00 were missed
+func hourly(id string, tag string, missed string) Schedule {
+	return Schedule{
+		ID:      id,
+		Every:   time.Hour,
+		Action:  ToggleGeneral(tag),
+		Missed:  missed,
+		Created: now.Add(-3 * time.Hour),
+		LastRun: now.Add(-2 * time.Hour),
+	}
+}
+
+func TestMissedRuns(t *testing.T) {
+	tests := []struct {
+		missed  string
+		runs    int
+		lastRun time.Time
+	}{
+		{MissedRunOnce, 1, now},
+		{MissedSkip, 0, now.Add(-2 * time.Hour)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.missed, func(t *testing.T) {
+			runs := toggles(t, "missed-"+tt.missed)
+			db := store.NewMemory()
+			if err := db.Put(keyPrefix+"hourly", hourly("hourly", "missed-"+tt.missed, tt.missed)); err != nil {
+				t.Fatal(err)
+			}
+			clock := newFakeClock(now)
+			s := newScheduler(t, WithClock(clock), WithStore(db))
+			settle(t, clock, 1)
+			if got := runs(); got != tt.runs {
+				t.Errorf("ran %d times for the missed runs, want %d", got, tt.runs)
+			}
+			if got := s.Schedules()[0].LastRun; !got.Equal(tt.lastRun) {
+				t.Errorf("last run is %s, want %s", got, tt.lastRun)
+			}
+
+			// the next run is on time again
+			clock.advance(time.Hour)
+			settle(t, clock, 1)
+			if got := runs(); got != tt.runs+1 {
+				t.Errorf("ran %d times after the next run was due, want %d", got, tt.runs+1)
+			}
+			var persisted Schedule
+			if err := db.Get(keyPrefix+"hourly", &persisted); err != nil {
+				t.Fatal(err)
+			}
+			if !persisted.LastRun.Equal(now.Add(time.Hour)) {
+				t.Errorf("persisted last run is %s, want %s", persisted.LastRun, now.Add(time.Hour))
+			}
+		})
+	}
+}
+
+func TestMissedRunOnceReplaced(t *testing.T) {
+	runs := toggles(t, "replaced")
+	db := store.NewMemory()
+	schedule := hourly("hourly", "replaced", MissedRunOnce)
+	if err := db.Put(keyPrefix+"hourly", schedule); err != nil {
+		t.Fatal(err)
+	}
+	clock := newFakeClock(now)
+	s := newScheduler(t, WithClock(clock), WithStore(db))
+	// replacing the restored schedule right away must not run the missed
+	// run a second time
+	schedule.Created, schedule.LastRun = time.Time{}, time.Time{}
+	if err := s.Add(schedule); err != nil {
+		t.Fatal(err)
+	}
+	settle(t, clock, 1)
+	if got := runs(); got != 1 {
+		t.Errorf("ran %d times for the missed runs, want 1", got)
+	}
+}
+
+func TestDeclaredSchedules(t *testing.T) {
+	db := store.NewMemory()
+	clock := newFakeClock(now)
+	a := Schedule{ID: "a", Cron: "@daily", Action: TurnOnGeneral("x")}
+	b := Schedule{ID: "b", Cron: "@daily", Action: TurnOffGeneral("x")}
+	c := Schedule{ID: "c", Cron: "@daily", Action: ToggleGeneral("x")}
+	ids := func(s *Scheduler) (ids []string) {
+		for _, schedule := range s.Schedules() {
+			ids = append(ids, schedule.ID)
+		}
+		return
+	}
+
+	s := newScheduler(t, WithClock(clock), WithStore(db), WithSchedules(a, b))
+	if err := s.Add(c); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ids(s), []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("schedules are %v, want %v", got, want)
+	}
+
+	// b isn't declared anymore, c was added at runtime and is kept
+	s = newScheduler(t, WithClock(clock), WithStore(db), WithSchedules(a))
+	if got, want := ids(s), []string{"a", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("schedules after b was removed are %v, want %v", got, want)
+	}
+	keys, err := db.Keys(keyPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{keyPrefix + "a", keyPrefix + "c"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("persisted schedules are %v, want %v", keys, want)
+	}
+
+	if _, err = New(context.Background(), WithStore(db), WithSchedules(a, a)); err == nil {
+		t.Error("a schedule was declared twice")
+	}
+}
+
+func TestClock(t *testing.T) {
+	runs := toggles(t, "clock")
+	clock := newFakeClock(now)
+	s := newScheduler(t, WithClock(clock))
+	if err := s.Every("every", 10*time.Minute, ToggleGeneral("clock"), MissedSkip); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.After("after", 25*time.Minute, ToggleGeneral("clock"), MissedSkip); err != nil {
+		t.Fatal(err)
+	}
+	settle(t, clock, 2)
+	for i, want := range []int{0, 1, 2, 4, 5} {
+		if got := runs(); got != want {
+			t.Errorf("ran %d times after %d minutes, want %d", got, i*10, want)
+		}
+		clock.advance(10 * time.Minute)
+		timers := 1
+		if i < 2 {
+			timers = 2
+		}
+		settle(t, clock, timers)
+	}
+	// the one shot schedule is gone once it ran
+	if got := s.Schedules(); len(got) != 1 || got[0].ID != "every" {
+		t.Errorf("schedules are %v, want only every", got)
+	}
+}
+
+func TestSystemClock(t *testing.T) {
+	c := SystemClock{}
+	before := time.Now()
+	timer := c.NewTimer(10 * time.Millisecond)
+	select {
+	case fired := <-timer.C():
+		if fired.Sub(before) < 10*time.Millisecond {
+			t.Errorf("timer fired after %s", fired.Sub(before))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timer didn't fire")
+	}
+	if now := c.Now(); now.Before(before) {
+		t.Errorf("now is %s, before %s", now, before)
+	}
+	if c.NewTimer(time.Hour).Stop() != true {
+		t.Error("couldn't stop a timer that didn't fire")
+	}
+}
@@ -0,0 +1,180 @@
+package scheduler
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/controller/daytime"
+)
+
+const (
+	Sunrise = "sunrise"
+	Sunset  = "sunset"
+	// Dawn and Dusk are the start of morning and the end of evening civil twilight
+	Dawn = "dawn"
+	Dusk = "dusk"
+)
+
+// zenith of the sun for each event in degrees, sunrise and sunset account
+// for refraction and the radius of the sun
+var sunZenith = map[string]float64{
+	Sunrise: 90.833,
+	Sunset:  90.833,
+	Dawn:    96,
+	Dusk:    96,
+}
+
+// Sun fires relative to a solar event, Offset can be negative. Earliest and
+// Latest are "15:04" local times the result is clamped between
+type Sun struct {
+	Event    string        `json:"event"`
+	Offset   time.Duration `json:"offset,omitempty"`
+	Earliest string        `json:"earliest,omitempty"`
+	Latest   string        `json:"latest,omitempty"`
+}
+
+// Site is where the scheduler is, it's needed for solar events and
+// to know which timezone cron expressions are in
+type Site struct {
+	Latitude  float64
+	Longitude float64
+	Location  *time.Location
+}
+
+type sunTrigger struct {
+	Sun
+	site              Site
+	earliest, latest  time.Duration
+	hasEarly, hasLate bool
+}
+
+func newSunTrigger(s Sun, site Site) (*sunTrigger, error) {
+	if _, ok := sunZenith[s.Event]; !ok {
+		return nil, fmt.Errorf("unknown solar event %s", s.Event)
+	}
+	if site.Location == nil {
+		return nil, fmt.Errorf("solar events need the site's latitude, longitude and timezone")
+	}
+	t := &sunTrigger{Sun: s, site: site}
+	var err error
+	if s.Earliest != "" {
+		if t.earliest, err = daytime.Parse(s.Earliest); err != nil {
+			return nil, err
+		}
+		t.hasEarly = true
+	}
+	if s.Latest != "" {
+		if t.latest, err = daytime.Parse(s.Latest); err != nil {
+			return nil, err
+		}
+		t.hasLate = true
+	}
+	if t.hasEarly && t.hasLate && t.earliest > t.latest {
+		return nil, fmt.Errorf("earliest %s is after latest %s", s.Earliest, s.Latest)
+	}
+	return t, nil
+}
+
+func (s *sunTrigger) next(after time.Time) (time.Time, bool) {
+	local := after.In(s.site.Location)
+	// start a day early, a negative offset can move tomorrow's event to today
+	for day := -1; day <= 366; day++ {
+		date := time.Date(local.Year(), local.Month(), local.Day()+day, 0, 0, 0, 0, s.site.Location)
+		t, ok := s.on(date)
+		if ok && t.After(after) {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// on returns when the trigger fires on date, ok is false on days the
+// event doesn't happen at all, like sunset during the polar day
+func (s *sunTrigger) on(date time.Time) (time.Time, bool) {
+	t, ok := sunEvent(date, s.site.Latitude, s.site.Longitude, s.Event)
+	if !ok {
+		return time.Time{}, false
+	}
+	t = t.Add(s.Offset)
+	midnight := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, s.site.Location)
+	if early := midnight.Add(s.earliest); s.hasEarly && t.Before(early) {
+		t = early
+	}
+	if late := midnight.Add(s.latest); s.hasLate && t.After(late) {
+		t = late
+	}
+	return t, true
+}
+
+// sunEvent calculates the time of a solar event on the local date of date,
+// it's the sunrise equation from the almanac for computers and is good to
+// about a minute away from the poles
+func sunEvent(date time.Time, latitude, longitude float64, event string) (time.Time, bool) {
+	rising := event == Sunrise || event == Dawn
+	zenith := radians(sunZenith[event])
+
+	n := float64(date.YearDay())
+	lngHour := longitude / 15
+	var t float64
+	if rising {
+		t = n + (6-lngHour)/24
+	} else {
+		t = n + (18-lngHour)/24
+	}
+
+	// mean anomaly and true longitude of the sun
+	m := 0.9856*t - 3.289
+	l := normalize(m+1.916*math.Sin(radians(m))+0.020*math.Sin(radians(2*m))+282.634, 360)
+
+	// right ascension, in the same quadrant as the longitude
+	ra := normalize(degrees(math.Atan(0.91764*math.Tan(radians(l)))), 360)
+	ra += math.Floor(l/90)*90 - math.Floor(ra/90)*90
+	ra /= 15
+
+	sinDec := 0.39782 * math.Sin(radians(l))
+	cosDec := math.Cos(math.Asin(sinDec))
+	cosH := (math.Cos(zenith) - sinDec*math.Sin(radians(latitude))) / (cosDec * math.Cos(radians(latitude)))
+	if cosH > 1 || cosH < -1 {
+		return time.Time{}, false
+	}
+	var h float64
+	if rising {
+		h = 360 - degrees(math.Acos(cosH))
+	} else {
+		h = degrees(math.Acos(cosH))
+	}
+	h /= 15
+
+	localMean := h + ra - 0.06571*t - 6.622
+	ut := normalize(localMean-lngHour, 24)
+
+	result := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC).
+		Add(time.Duration(ut * float64(time.Hour))).
+		In(date.Location())
+	// the UTC day can differ from the local one, bring it back to the asked date
+	y, mo, d := date.Date()
+	switch local := time.Date(result.Year(), result.Month(), result.Day(), 0, 0, 0, 0, time.UTC); {
+	case local.Before(time.Date(y, mo, d, 0, 0, 0, 0, time.UTC)):
+		result = result.Add(24 * time.Hour)
+	case local.After(time.Date(y, mo, d, 0, 0, 0, 0, time.UTC)):
+		result = result.Add(-24 * time.Hour)
+	}
+	return result, true
+}
+
+func radians(d float64) float64 {
+	return d * math.Pi / 180
+}
+
+func degrees(r float64) float64 {
+	return r * 180 / math.Pi
+}
+
+func normalize(v float64, max float64) float64 {
+	v = math.Mod(v, max)
+	if v < 0 {
+		v += max
+	}
+	return v
+}
@@ -0,0 +1,83 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+type place struct {
+	name                string
+	latitude, longitude float64
+}
+
+var (
+	london = place{"london", 51.5074, -0.1278}
+	tehran = place{"tehran", 35.6892, 51.3890}
+	quito  = place{"quito", -0.1807, -78.4678}
+	sydney = place{"sydney", -33.8688, 151.2093}
+	tromso = place{"tromsø", 69.6492, 18.9553}
+)
+
+// tolerance is how far off the almanac may be, it's a bit worse far north
+const tolerance = 3 * time.Minute
+
+func TestSunEvent(t *testing.T) {
+	zone := func(hours float64) *time.Location {
+		return time.FixedZone("", int(hours*3600))
+	}
+	// the published times of sunrise and sunset, "" is a day the sun
+	// doesn't rise or set at all
+	tests := []struct {
+		place
+		year          int
+		month         time.Month
+		day           int
+		zone          *time.Location
+		rise, setting string
+	}{
+		{london, 2021, time.June, 21, zone(1), "04:43", "21:21"},
+		{london, 2021, time.December, 21, zone(0), "08:04", "15:53"},
+		{tehran, 2021, time.June, 21, zone(4.5), "05:49", "20:23"},
+		{tehran, 2021, time.December, 21, zone(3.5), "07:10", "16:55"},
+		{quito, 2021, time.March, 20, zone(-5), "06:18", "18:24"},
+		{sydney, 2021, time.June, 21, zone(10), "07:00", "16:54"},
+		{sydney, 2021, time.December, 21, zone(11), "05:41", "20:05"},
+		{tromso, 2021, time.March, 20, zone(1), "05:43", "18:02"},
+		// polar day and polar night
+		{tromso, 2021, time.June, 21, zone(2), "", ""},
+		{tromso, 2021, time.December, 21, zone(1), "", ""},
+	}
+	for _, tt := range tests {
+		date := time.Date(tt.year, tt.month, tt.day, 0, 0, 0, 0, tt.zone)
+		for event, want := range map[string]string{Sunrise: tt.rise, Sunset: tt.setting} {
+			got, ok := sunEvent(date, tt.latitude, tt.longitude, event)
+			if want == "" {
+				if ok {
+					t.Errorf("%s in %s on %s is at %s, want none", event, tt.name, date.Format("2006-01-02"), got.Format("15:04"))
+				}
+				continue
+			}
+			published, err := time.ParseInLocation("2006-01-02 15:04", date.Format("2006-01-02 ")+want, tt.zone)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := got.Sub(published); !ok || diff < -tolerance || diff > tolerance {
+				t.Errorf("%s in %s on %s is at %s, %v, want %s", event, tt.name, date.Format("2006-01-02"), got.Format("15:04"), ok, want)
+			}
+		}
+	}
+}
+
+func TestSunPolarNight(t *testing.T) {
+	cet := time.FixedZone("CET", 3600)
+	s, err := newSunTrigger(Sun{Event: Sunrise}, Site{Latitude: tromso.latitude, Longitude: tromso.longitude, Location: cet})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the sun is back in tromsø around the 15th of january
+	got, ok := s.next(time.Date(2021, time.December, 21, 12, 0, 0, 0, cet))
+	from, to := time.Date(2022, time.January, 14, 0, 0, 0, 0, cet), time.Date(2022, time.January, 17, 0, 0, 0, 0, cet)
+	if !ok || got.Before(from) || got.After(to) {
+		t.Errorf("the first sunrise after the polar night is on %s, %v, want it around the 15th of january", got.Format("2006-01-02"), ok)
+	}
+}
@@ -1 +1,127 @@
 package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/database"
+	"github.com/dgraph-io/badger/v3"
+)
+
+// Store keeps the state of the controller across restarts,
+// values are encoded as json
+type Store interface {
+	Get(key string, v interface{}) error
+	Put(key string, v interface{}) error
+	Delete(key string) error
+	// Keys returns the sorted keys that start with prefix
+	Keys(prefix string) ([]string, error)
+}
+
+// DB is a Store backed by the badger database
+type DB struct {
+	db *database.DB
+}
+
+func New(db *database.DB) *DB {
+	return &DB{db: db}
+}
+
+func (d *DB) Get(key string, v interface{}) error {
+	value, err := d.db.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return NotFoundError{Key: key}
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(value), v)
+}
+
+func (d *DB) Put(key string, v interface{}) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return d.db.Set(key, string(value))
+}
+
+func (d *DB) Delete(key string) error {
+	return d.db.Delete(key)
+}
+
+func (d *DB) Keys(prefix string) ([]string, error) {
+	return d.db.Keys(prefix)
+}
+
+// Memory is a Store that forgets everything on exit, it's used when
+// there is no database
+type Memory struct {
+	values map[string][]byte
+	*sync.RWMutex
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		values:  map[string][]byte{},
+		RWMutex: &sync.RWMutex{},
+	}
+}
+
+func (m *Memory) Get(key string, v interface{}) error {
+	m.Lock()
+	value, ok := m.values[key]
+	m.Unlock()
+	if !ok {
+		return NotFoundError{Key: key}
+	}
+	return json.Unmarshal(value, v)
+}
+
+func (m *Memory) Put(key string, v interface{}) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.values[key] = value
+	return nil
+}
+
+func (m *Memory) Delete(key string) error {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.values, key)
+	return nil
+}
+
+func (m *Memory) Keys(prefix string) ([]string, error) {
+	m.Lock()
+	defer m.Unlock()
+	var keys []string
+	for key := range m.values {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+type NotFoundError struct {
+	Key string
+}
+
+func (n NotFoundError) Error() string {
+	return fmt.Sprintf("key: %s not found", n.Key)
+}
+
+// Stamp formats t so that keys ending in it sort chronologically
+func Stamp(t time.Time) string {
+	return fmt.Sprintf("%020d", t.UnixNano())
+}
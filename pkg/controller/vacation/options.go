@@ -0,0 +1,189 @@
+package vacation
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/controller/daytime"
+	"github.com/AliRostami1/baagh/pkg/controller/history"
+	"github.com/AliRostami1/baagh/pkg/controller/scheduler"
+)
+
+const (
+	// Replay repeats what the actuators did Lookback ago
+	Replay = "replay"
+	// Random turns each actuator on for random periods inside a daily window
+	Random = "random"
+)
+
+type OptionError struct {
+	Field string
+	Value interface{}
+}
+
+func (o OptionError) Error() string {
+	return fmt.Sprintf("field %s can not be: %v", o.Field, o.Value)
+}
+
+type Option interface {
+	applyOption(*Options) error
+}
+
+// Line is a single actuator
+type Line struct {
+	Chip   string
+	Offset int
+}
+
+// Window is the part of the day random mode is allowed to turn actuators on,
+// To can be before From for windows that span midnight
+type Window struct {
+	From   string
+	To     string
+	MinOn  time.Duration
+	MaxOn  time.Duration
+	MinOff time.Duration
+	MaxOff time.Duration
+}
+
+type Options struct {
+	toggle    string
+	mode      string
+	actuators []Line
+	generals  []string
+	exclude   map[Line]bool
+	lookback  time.Duration
+	jitter    time.Duration
+	window    Window
+	history   *history.History
+	clock     scheduler.Clock
+}
+
+type SwitchOption string
+
+func (s SwitchOption) applyOption(o *Options) error {
+	if s == "" {
+		return OptionError{Field: "Switch", Value: s}
+	}
+	o.toggle = string(s)
+	return nil
+}
+
+// WithSwitch sets the name of the virtual item that turns the simulation on and off
+func WithSwitch(name string) SwitchOption {
+	return SwitchOption(name)
+}
+
+type ReplayOption struct {
+	history  *history.History
+	lookback time.Duration
+	jitter   time.Duration
+}
+
+func (r ReplayOption) applyOption(o *Options) error {
+	if r.history == nil {
+		return OptionError{Field: "History", Value: r.history}
+	}
+	if r.lookback < time.Hour {
+		return OptionError{Field: "Lookback", Value: r.lookback}
+	}
+	if r.jitter < 0 {
+		return OptionError{Field: "Jitter", Value: r.jitter}
+	}
+	o.mode = Replay
+	o.history = r.history
+	o.lookback = r.lookback
+	o.jitter = r.jitter
+	return nil
+}
+
+// AsReplay replays what the actuators did lookback ago, e.g. a week,
+// moving every change by up to jitter either way
+func AsReplay(h *history.History, lookback time.Duration, jitter time.Duration) ReplayOption {
+	return ReplayOption{
+		history:  h,
+		lookback: lookback,
+		jitter:   jitter,
+	}
+}
+
+type RandomOption Window
+
+func (r RandomOption) applyOption(o *Options) error {
+	if _, err := daytime.Parse(r.From); err != nil {
+		return OptionError{Field: "From", Value: r.From}
+	}
+	if _, err := daytime.Parse(r.To); err != nil {
+		return OptionError{Field: "To", Value: r.To}
+	}
+	if r.MinOn <= 0 || r.MaxOn < r.MinOn {
+		return OptionError{Field: "On", Value: []time.Duration{r.MinOn, r.MaxOn}}
+	}
+	if r.MinOff <= 0 || r.MaxOff < r.MinOff {
+		return OptionError{Field: "Off", Value: []time.Duration{r.MinOff, r.MaxOff}}
+	}
+	o.mode = Random
+	o.window = Window(r)
+	return nil
+}
+
+// AsRandom turns each actuator on and off for random durations, but only inside window
+func AsRandom(window Window) RandomOption {
+	return RandomOption(window)
+}
+
+type ActuatorsOption struct {
+	lines    []Line
+	generals []string
+}
+
+func (a ActuatorsOption) applyOption(o *Options) error {
+	for _, l := range a.lines {
+		if l.Chip == "" {
+			return OptionError{Field: "Chip", Value: l.Chip}
+		}
+	}
+	o.actuators = append(o.actuators, a.lines...)
+	o.generals = append(o.generals, a.generals...)
+	return nil
+}
+
+// WithActuators sets which actuators are simulated, both the given lines
+// and the actuators of the given generals
+func WithActuators(lines []Line, generals []string) ActuatorsOption {
+	return ActuatorsOption{
+		lines:    lines,
+		generals: generals,
+	}
+}
+
+type ExcludeOption []Line
+
+func (e ExcludeOption) applyOption(o *Options) error {
+	for _, l := range e {
+		o.exclude[l] = true
+	}
+	return nil
+}
+
+// WithExclude marks actuators the simulation must never touch, even if
+// they are actuators of one of its generals
+func WithExclude(lines ...Line) ExcludeOption {
+	return ExcludeOption(lines)
+}
+
+type ClockOption struct {
+	scheduler.Clock
+}
+
+func (c ClockOption) applyOption(o *Options) error {
+	if c.Clock == nil {
+		return OptionError{Field: "Clock", Value: c.Clock}
+	}
+	o.clock = c.Clock
+	return nil
+}
+
+func WithClock(clock scheduler.Clock) ClockOption {
+	return ClockOption{clock}
+}
@@ -0,0 +1,285 @@
+package vacation
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/controller/core"
+	"github.com/AliRostami1/baagh/pkg/controller/daytime"
+	"github.com/AliRostami1/baagh/pkg/controller/general"
+	"github.com/AliRostami1/baagh/pkg/controller/scheduler"
+	"github.com/AliRostami1/baagh/pkg/logy"
+)
+
+// owner is who the simulator registers the actuators as
+const owner = "vacation"
+
+var logger logy.Logger = logy.DummyLogger{}
+
+func SetLogger(l logy.Logger) error {
+	if l == nil {
+		return fmt.Errorf("logger can't be nil")
+	}
+	logger = l
+	return nil
+}
+
+// Simulator makes the house look lived in while its switch is active
+type Simulator struct {
+	options   *Options
+	toggle    *core.ItemHandle
+	actuators map[Line]*core.ItemHandle
+	// on are the actuators the simulator turned on, they're turned off when it stops
+	on     map[Line]bool
+	cancel context.CancelFunc
+	ctx    context.Context
+	random *rand.Rand
+
+	mu *sync.RWMutex
+}
+
+func New(ctx context.Context, opts ...Option) (s *Simulator, err error) {
+	options := &Options{
+		toggle:  "vacation",
+		exclude: map[Line]bool{},
+		clock:   scheduler.SystemClock{},
+	}
+	for _, opt := range opts {
+		err = opt.applyOption(options)
+		if err != nil {
+			return
+		}
+	}
+	if options.mode == "" {
+		return nil, OptionError{Field: "Mode", Value: options.mode}
+	}
+	if options.history != nil {
+		// what the simulation does would be replayed again after a lookback
+		// and the jitter would add up, only real changes are replayed
+		options.history.Ignore(owner)
+	}
+
+	s = &Simulator{
+		options:   options,
+		actuators: map[Line]*core.ItemHandle{},
+		on:        map[Line]bool{},
+		ctx:       ctx,
+		random:    rand.New(rand.NewSource(time.Now().UnixNano())),
+		mu:        &sync.RWMutex{},
+	}
+
+	lines := append([]Line{}, options.actuators...)
+	for _, tag := range options.generals {
+		g, err := general.Get(tag)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range g.Actuators() {
+			lines = append(lines, Line{Chip: info.Chip, Offset: info.Offset})
+		}
+	}
+	for _, l := range lines {
+		if options.exclude[l] {
+			continue
+		}
+		if _, ok := s.actuators[l]; ok {
+			continue
+		}
+		h, err := core.RegisterItem(l.Chip, l.Offset, core.AsOutput(), core.WithOwner(owner))
+		if err != nil {
+			s.release()
+			return nil, err
+		}
+		s.actuators[l] = h
+	}
+
+	s.toggle, err = core.RegisterVirtualItem(options.toggle, core.WithOwner(owner))
+	if err != nil {
+		s.release()
+		return nil, err
+	}
+	s.toggle.AddEventListener(func(event *core.ItemEvent) {
+		if event.State == core.Active {
+			s.start()
+		} else {
+			s.stop()
+		}
+	})
+	if s.toggle.State() == core.Active {
+		s.start()
+	}
+	return s, nil
+}
+
+func (s *Simulator) release() {
+	for _, h := range s.actuators {
+		h.Release()
+	}
+	if s.toggle != nil {
+		s.toggle.Release()
+	}
+}
+
+// Close stops the simulation and releases the actuators
+func (s *Simulator) Close() {
+	s.stop()
+	s.release()
+}
+
+func (s *Simulator) Running() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cancel != nil
+}
+
+func (s *Simulator) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel != nil {
+		return
+	}
+	var ctx context.Context
+	ctx, s.cancel = context.WithCancel(s.ctx)
+	for l, h := range s.actuators {
+		if s.options.mode == Replay {
+			go s.replay(ctx, l, h)
+		} else {
+			go s.randomize(ctx, l, h)
+		}
+	}
+	logger.Infof("vacation simulation started in %s mode for %d actuators", s.options.mode, len(s.actuators))
+}
+
+func (s *Simulator) stop() {
+	s.mu.Lock()
+	if s.cancel == nil {
+		s.mu.Unlock()
+		return
+	}
+	s.cancel()
+	s.cancel = nil
+	on := s.on
+	s.on = map[Line]bool{}
+	s.mu.Unlock()
+	for l := range on {
+		s.actuators[l].SetState(core.Inactive)
+	}
+	logger.Infof("vacation simulation stopped")
+}
+
+func (s *Simulator) set(ctx context.Context, l Line, h *core.ItemHandle, state core.State) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// the simulation might have been stopped while waiting
+	if ctx.Err() != nil || s.options.exclude[l] {
+		return
+	}
+	if err := h.SetState(state); err != nil {
+		logger.Errorf("vacation couldn't set line %d of %s: %v", l.Offset, l.Chip, err)
+		return
+	}
+	if state == core.Active {
+		s.on[l] = true
+	} else {
+		delete(s.on, l)
+	}
+}
+
+// wait blocks until t or until ctx is done, it reports whether t was reached
+func (s *Simulator) wait(ctx context.Context, t time.Time) bool {
+	d := t.Sub(s.options.clock.Now())
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := s.options.clock.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// between returns a random duration in [min, max]
+func (s *Simulator) between(min, max time.Duration) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if max <= min {
+		return min
+	}
+	return min + time.Duration(s.random.Int63n(int64(max-min)+1))
+}
+
+// replay plays back the history of an actuator from Lookback ago,
+// an hour of history at a time
+func (s *Simulator) replay(ctx context.Context, l Line, h *core.ItemHandle) {
+	const step = time.Hour
+	lookback, jitter := s.options.lookback, s.options.jitter
+
+	cursor := s.options.clock.Now().Add(-lookback)
+	last, ok, err := s.options.history.Last(l.Chip, l.Offset, cursor)
+	if err != nil {
+		logger.Errorf("vacation couldn't read the history of line %d of %s: %v", l.Offset, l.Chip, err)
+	} else if ok {
+		s.set(ctx, l, h, last.State)
+	}
+
+	var previous time.Time
+	for {
+		records, err := s.options.history.Between(l.Chip, l.Offset, cursor, cursor.Add(step))
+		if err != nil {
+			logger.Errorf("vacation couldn't read the history of line %d of %s: %v", l.Offset, l.Chip, err)
+		}
+		for _, r := range records {
+			due := r.Time.Add(lookback).Add(s.between(-jitter, jitter))
+			// jitter must not swap the order of changes
+			if due.Before(previous) {
+				due = previous
+			}
+			previous = due
+			if !s.wait(ctx, due) {
+				return
+			}
+			s.set(ctx, l, h, r.State)
+		}
+		cursor = cursor.Add(step)
+		// the next records can be moved up to jitter earlier than they happened
+		if !s.wait(ctx, cursor.Add(lookback).Add(-jitter)) {
+			return
+		}
+	}
+}
+
+// randomize turns an actuator on and off at random inside the window
+func (s *Simulator) randomize(ctx context.Context, l Line, h *core.ItemHandle) {
+	w := s.options.window
+	// the window was already checked by AsRandom
+	day, _ := daytime.ParseWindow(w.From, w.To)
+	clock := s.options.clock
+	for {
+		if !s.wait(ctx, clock.Now().Add(s.between(w.MinOff, w.MaxOff))) {
+			return
+		}
+		now := clock.Now()
+		start, end := day.Next(now)
+		if now.Before(start) {
+			if !s.wait(ctx, start) {
+				return
+			}
+			continue
+		}
+		s.set(ctx, l, h, core.Active)
+		off := now.Add(s.between(w.MinOn, w.MaxOn))
+		if off.After(end) {
+			off = end
+		}
+		if !s.wait(ctx, off) {
+			return
+		}
+		s.set(ctx, l, h, core.Inactive)
+	}
+}
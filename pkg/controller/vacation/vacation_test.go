@@ -0,0 +1,178 @@
+package vacation
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/AliRostami1/baagh/pkg/controller/core"
+	"github.com/AliRostami1/baagh/pkg/controller/history"
+	"github.com/AliRostami1/baagh/pkg/controller/internal/clocktest"
+	"github.com/AliRostami1/baagh/pkg/controller/internal/coretest"
+	"github.com/AliRostami1/baagh/pkg/controller/internal/testutil"
+	"github.com/AliRostami1/baagh/pkg/controller/store"
+)
+
+// simulate starts a simulator switched by a virtual item named after chip
+// and returns the name of the switch, it's closed when the test ends
+func simulate(t *testing.T, chip string, opts ...Option) (*Simulator, string) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	toggle := chip + "-vacation"
+	s, err := New(ctx, append([]Option{WithSwitch(toggle)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return s, toggle
+}
+
+// turn flips the switch of a simulator and waits for it to follow
+func turn(t *testing.T, s *Simulator, toggle string, state core.State) {
+	t.Helper()
+	if err := core.SetVirtualState(toggle, state); err != nil {
+		t.Fatal(err)
+	}
+	testutil.Eventually(t, "the simulation turning "+state.String(), func() bool { return s.Running() == (state == core.Active) })
+}
+
+func TestRandom(t *testing.T) {
+	chip, _ := coretest.PCF8574(t)
+	clock := clocktest.New(time.Date(2021, time.June, 4, 6, 0, 0, 0, time.UTC))
+	s, toggle := simulate(t, chip,
+		WithActuators([]Line{{chip, 0}, {chip, 1}, {chip, 2}}, nil),
+		WithExclude(Line{chip, 2}),
+		WithClock(clock),
+		AsRandom(Window{From: "08:00", To: "10:00", MinOn: 20 * time.Minute, MaxOn: 40 * time.Minute, MinOff: 5 * time.Minute, MaxOff: 15 * time.Minute}),
+	)
+	if _, err := core.GetItem(chip, 2); err == nil {
+		t.Error("the excluded line was registered")
+	}
+	if s.Running() {
+		t.Fatal("the simulation runs while its switch is off")
+	}
+	turn(t, s, toggle, core.Active)
+	clock.Settle(t, 2)
+
+	lines := []Line{{chip, 0}, {chip, 1}}
+	state := func(l Line) core.State {
+		return s.actuators[l].State()
+	}
+	seen := map[Line]bool{}
+	window := func(now time.Time) bool {
+		return now.Hour() >= 8 && now.Hour() < 10
+	}
+	for clock.Now().Hour() < 11 {
+		clock.Advance(time.Minute)
+		clock.Settle(t, 2)
+		for _, l := range lines {
+			if state(l) != core.Active {
+				continue
+			}
+			seen[l] = true
+			if now := clock.Now(); !window(now) {
+				t.Fatalf("line %d is on at %s, outside of the window", l.Offset, now.Format("15:04"))
+			}
+		}
+	}
+	for _, l := range lines {
+		if !seen[l] {
+			t.Errorf("line %d was never turned on", l.Offset)
+		}
+	}
+
+	// switching the simulation off turns off what it turned on
+	for state(lines[0]) != core.Active {
+		clock.Advance(time.Minute)
+		clock.Settle(t, 2)
+	}
+	turn(t, s, toggle, core.Inactive)
+	for _, l := range lines {
+		if state(l) != core.Inactive {
+			t.Errorf("line %d is still on after the simulation stopped", l.Offset)
+		}
+	}
+	clock.Settle(t, 0)
+}
+
+func TestReplay(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	chip, _ := coretest.PCF8574(t)
+	const (
+		lookback = 24 * time.Hour
+		jitter   = 5 * time.Minute
+		step     = 30 * time.Second
+	)
+	now := time.Date(2021, time.June, 4, 12, 0, 0, 0, time.UTC)
+	clock := clocktest.New(now)
+	h := history.New(ctx, store.NewMemory(), 30*24*time.Hour)
+
+	var want []history.Record
+	for n := 0; n < 6; n++ {
+		r := history.Record{Chip: chip, Offset: 0, State: core.State((n + 1) % 2), Time: now.Add(-lookback).Add(time.Duration(10+30*n) * time.Minute)}
+		want = append(want, r)
+		if err := h.Add(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the excluded line has a history too, it must not be replayed
+	if err := h.Add(history.Record{Chip: chip, Offset: 1, State: core.Active, Time: want[0].Time}); err != nil {
+		t.Fatal(err)
+	}
+	// another owner of the line records what happens to it
+	observer, err := core.RegisterItem(chip, 0, core.AsOutput(), core.WithOwner("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	observer.AddEventListener(h.Record)
+	recording := time.Now()
+
+	s, toggle := simulate(t, chip,
+		WithActuators([]Line{{chip, 0}, {chip, 1}}, nil),
+		WithExclude(Line{chip, 1}),
+		WithClock(clock),
+		AsReplay(h, lookback, jitter),
+	)
+	turn(t, s, toggle, core.Active)
+	clock.Settle(t, 1)
+
+	previous := observer.State()
+	var changes []time.Time
+	for clock.Now().Before(now.Add(3 * time.Hour)) {
+		clock.Advance(step)
+		clock.Settle(t, 1)
+		if state := observer.State(); state != previous {
+			previous = state
+			changes = append(changes, clock.Now())
+			if n := len(changes) - 1; n < len(want) && want[n].State != state {
+				t.Errorf("change %d turned the line %s, want %s", n, state, want[n].State)
+			}
+		}
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("the line changed %d times, want %d", len(changes), len(want))
+	}
+	for n, changed := range changes {
+		due := want[n].Time.Add(lookback)
+		// a change is only seen on the step after it's made
+		if changed.Before(due.Add(-jitter)) || changed.After(due.Add(jitter+step)) {
+			t.Errorf("change %d was replayed at %s, want within %v of %s", n, changed.Format("15:04:05"), jitter, due.Format("15:04:05"))
+		}
+	}
+	if _, err = core.GetItem(chip, 1); err == nil {
+		t.Error("the excluded line was registered")
+	}
+
+	// what the simulation did isn't recorded, it'd be replayed again a
+	// lookback later
+	time.Sleep(10 * time.Millisecond)
+	records, err := h.Between(chip, 0, recording, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Errorf("the replay was recorded: %v", records)
+	}
+}
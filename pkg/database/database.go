@@ -87,3 +87,26 @@ func (d *DB) executeEvents(key string, value string) {
 		fn(key, value)
 	})
 }
+
+func (d *DB) Delete(key string) error {
+	return d.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(key))
+	})
+}
+
+// Keys returns every key that starts with prefix
+func (d *DB) Keys(prefix string) ([]string, error) {
+	var keys []string
+	err := d.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = []byte(prefix)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			keys = append(keys, string(it.Item().KeyCopy(nil)))
+		}
+		return nil
+	})
+	return keys, err
+}